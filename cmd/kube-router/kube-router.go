@@ -28,6 +28,10 @@ func main() {
 func Main() error {
 	klog.InitFlags(nil)
 
+	if len(os.Args) > 1 && os.Args[1] == cmd.PolicyCheckCommand {
+		return cmd.PolicyCheck(os.Args[2:], os.Stdout)
+	}
+
 	config := options.NewKubeRouterConfig()
 	config.AddFlags(pflag.CommandLine)
 	pflag.Parse()
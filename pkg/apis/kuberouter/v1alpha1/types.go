@@ -0,0 +1,115 @@
+// Package v1alpha1 contains the v1alpha1 version of the kube-router.io custom resources
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// GroupName is the API group of the kube-router.io custom resources
+	GroupName = "kube-router.io"
+	// Version is the API version of the custom resources in this package
+	Version = "v1alpha1"
+
+	// BGPPeerKind is the kind of the BGPPeer custom resource
+	BGPPeerKind = "BGPPeer"
+	// BGPPeerResource is the plural resource name of the BGPPeer custom resource
+	BGPPeerResource = "bgppeers"
+
+	// EgressGatewayKind is the kind of the EgressGateway custom resource
+	EgressGatewayKind = "EgressGateway"
+	// EgressGatewayResource is the plural resource name of the EgressGateway custom resource
+	EgressGatewayResource = "egressgateways"
+)
+
+var (
+	// SchemeGroupVersion is the group version of the custom resources in this package
+	SchemeGroupVersion = schema.GroupVersion{Group: GroupName, Version: Version}
+	// BGPPeerGVR identifies the BGPPeer custom resource for dynamic clients and informers
+	BGPPeerGVR = SchemeGroupVersion.WithResource(BGPPeerResource)
+	// EgressGatewayGVR identifies the EgressGateway custom resource for dynamic clients and informers
+	EgressGatewayGVR = SchemeGroupVersion.WithResource(EgressGatewayResource)
+)
+
+// BGPPolicyAction decides whether the routes that are exchanged with a BGP peer are accepted or rejected
+type BGPPolicyAction string
+
+const (
+	// BGPPolicyAccept exchanges routes with the peer as usual
+	BGPPolicyAccept BGPPolicyAction = "Accept"
+	// BGPPolicyReject doesn't exchange any routes with the peer
+	BGPPolicyReject BGPPolicyAction = "Reject"
+)
+
+// BGPPeer is an external BGP peer that the nodes selected by its node selector peer with. It is cluster scoped.
+type BGPPeer struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec BGPPeerSpec `json:"spec"`
+}
+
+// BGPPeerSpec describes the BGP session with an external peer
+type BGPPeerSpec struct {
+	// NodeSelector selects the nodes that peer with the peer, all nodes peer with it when it isn't set
+	NodeSelector *metav1.LabelSelector `json:"nodeSelector,omitempty"`
+	// PeerAddress is the IP address of the peer
+	PeerAddress string `json:"peerAddress"`
+	// PeerASN is the AS number of the peer
+	PeerASN uint32 `json:"peerASN"`
+	// PeerPort is the port that the peer accepts BGP connections on, 179 by default
+	PeerPort uint32 `json:"peerPort,omitempty"`
+	// LocalAddress is the address of the node that is used for the session, the node's IP by default
+	LocalAddress string `json:"localAddress,omitempty"`
+	// PasswordSecretRef references the key of a secret that holds the password of the session
+	PasswordSecretRef *SecretKeyReference `json:"passwordSecretRef,omitempty"`
+	// EBGPMultihopTTL allows the peer to be more than one hop away when it is greater than 1
+	EBGPMultihopTTL uint32 `json:"ebgpMultihopTTL,omitempty"`
+	// Timers overrides the BGP timers of the session
+	Timers *BGPTimers `json:"timers,omitempty"`
+	// BFD enables or disables BFD for the peer, --enable-bfd is used when it isn't set
+	BFD *bool `json:"bfd,omitempty"`
+	// ImportPolicy decides whether routes received from the peer are accepted, Accept by default
+	ImportPolicy BGPPolicyAction `json:"importPolicy,omitempty"`
+	// ExportPolicy decides whether routes are advertised to the peer, Accept by default
+	ExportPolicy BGPPolicyAction `json:"exportPolicy,omitempty"`
+}
+
+// SecretKeyReference references a key of a secret in a namespace
+type SecretKeyReference struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Key       string `json:"key"`
+}
+
+// BGPTimers are the timers of a BGP session
+type BGPTimers struct {
+	// HoldTime defaults to --bgp-holdtime
+	HoldTime *metav1.Duration `json:"holdTime,omitempty"`
+	// KeepaliveInterval defaults to a third of the hold time
+	KeepaliveInterval *metav1.Duration `json:"keepaliveInterval,omitempty"`
+	// ConnectRetry is the time between attempts to connect to the peer, gobgp's default is used when it isn't set
+	ConnectRetry *metav1.Duration `json:"connectRetry,omitempty"`
+}
+
+// EgressGateway sends the traffic of the selected pods that leaves the cluster through a gateway node, where it is
+// SNATed to a fixed egress IP. It is cluster scoped.
+type EgressGateway struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec EgressGatewaySpec `json:"spec"`
+}
+
+// EgressGatewaySpec describes the pods whose traffic leaves through the gateway and the gateway itself
+type EgressGatewaySpec struct {
+	// EgressIP is the source IP of the traffic of the selected pods when it leaves the cluster
+	EgressIP string `json:"egressIP"`
+	// GatewayNode is the name of the node that hosts the egress IP
+	GatewayNode string `json:"gatewayNode"`
+	// NamespaceSelector selects the namespaces of the pods, pods of all namespaces are selected when it isn't set
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// PodSelector selects the pods within the selected namespaces, all of their pods are selected when it isn't set
+	PodSelector *metav1.LabelSelector `json:"podSelector,omitempty"`
+}
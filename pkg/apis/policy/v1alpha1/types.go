@@ -0,0 +1,187 @@
+// Package v1alpha1 contains the subset of the v1alpha1 version of the policy.networking.k8s.io AdminNetworkPolicy and
+// BaselineAdminNetworkPolicy custom resources of the Kubernetes network policy API that kube-router implements. The
+// custom resource definitions themselves are maintained and installed by the network-policy-api project.
+package v1alpha1
+
+import (
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// GroupName is the API group of the admin network policy custom resources
+	GroupName = "policy.networking.k8s.io"
+	// Version is the API version of the custom resources in this package
+	Version = "v1alpha1"
+
+	// AdminNetworkPolicyKind is the kind of the AdminNetworkPolicy custom resource
+	AdminNetworkPolicyKind = "AdminNetworkPolicy"
+	// AdminNetworkPolicyResource is the plural resource name of the AdminNetworkPolicy custom resource
+	AdminNetworkPolicyResource = "adminnetworkpolicies"
+	// BaselineAdminNetworkPolicyKind is the kind of the BaselineAdminNetworkPolicy custom resource
+	BaselineAdminNetworkPolicyKind = "BaselineAdminNetworkPolicy"
+	// BaselineAdminNetworkPolicyResource is the plural resource name of the BaselineAdminNetworkPolicy custom resource
+	BaselineAdminNetworkPolicyResource = "baselineadminnetworkpolicies"
+
+	// BaselineAdminNetworkPolicyName is the only name that a BaselineAdminNetworkPolicy may have, it is a singleton
+	BaselineAdminNetworkPolicyName = "default"
+)
+
+var (
+	// SchemeGroupVersion is the group version of the custom resources in this package
+	SchemeGroupVersion = schema.GroupVersion{Group: GroupName, Version: Version}
+	// AdminNetworkPolicyGVR identifies the AdminNetworkPolicy custom resource for dynamic clients and informers
+	AdminNetworkPolicyGVR = SchemeGroupVersion.WithResource(AdminNetworkPolicyResource)
+	// BaselineAdminNetworkPolicyGVR identifies the BaselineAdminNetworkPolicy custom resource for dynamic clients and
+	// informers
+	BaselineAdminNetworkPolicyGVR = SchemeGroupVersion.WithResource(BaselineAdminNetworkPolicyResource)
+)
+
+// AdminNetworkPolicyRuleAction is the action that is taken for the traffic that matches a rule
+type AdminNetworkPolicyRuleAction string
+
+const (
+	// AdminNetworkPolicyRuleActionAllow allows the traffic, no lower priority policies are evaluated for it
+	AdminNetworkPolicyRuleActionAllow AdminNetworkPolicyRuleAction = "Allow"
+	// AdminNetworkPolicyRuleActionDeny drops the traffic, no lower priority policies are evaluated for it
+	AdminNetworkPolicyRuleActionDeny AdminNetworkPolicyRuleAction = "Deny"
+	// AdminNetworkPolicyRuleActionPass skips the remaining AdminNetworkPolicies, the traffic is evaluated by the
+	// NetworkPolicies and the BaselineAdminNetworkPolicy instead
+	AdminNetworkPolicyRuleActionPass AdminNetworkPolicyRuleAction = "Pass"
+)
+
+// BaselineAdminNetworkPolicyRuleAction is the action that is taken for the traffic that matches a baseline rule
+type BaselineAdminNetworkPolicyRuleAction string
+
+const (
+	// BaselineAdminNetworkPolicyRuleActionAllow allows the traffic
+	BaselineAdminNetworkPolicyRuleActionAllow BaselineAdminNetworkPolicyRuleAction = "Allow"
+	// BaselineAdminNetworkPolicyRuleActionDeny drops the traffic
+	BaselineAdminNetworkPolicyRuleActionDeny BaselineAdminNetworkPolicyRuleAction = "Deny"
+)
+
+// AdminNetworkPolicy is a cluster scoped network policy that is evaluated before the NetworkPolicies of the
+// namespaces, so that it can't be overridden by them
+type AdminNetworkPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec AdminNetworkPolicySpec `json:"spec"`
+}
+
+// AdminNetworkPolicySpec describes the pods that the policy applies to and its rules
+type AdminNetworkPolicySpec struct {
+	// Priority orders the AdminNetworkPolicies, policies with a lower value are evaluated first
+	Priority int32 `json:"priority"`
+	// Subject selects the pods that the policy applies to
+	Subject AdminNetworkPolicySubject `json:"subject"`
+	// Ingress are the rules for the traffic to the subject pods, they are evaluated in order
+	Ingress []AdminNetworkPolicyIngressRule `json:"ingress,omitempty"`
+	// Egress are the rules for the traffic from the subject pods, they are evaluated in order
+	Egress []AdminNetworkPolicyEgressRule `json:"egress,omitempty"`
+}
+
+// AdminNetworkPolicySubject selects either all of the pods of the selected namespaces or the selected pods of the
+// selected namespaces, exactly one of the fields is set
+type AdminNetworkPolicySubject struct {
+	Namespaces *metav1.LabelSelector `json:"namespaces,omitempty"`
+	Pods       *NamespacedPod        `json:"pods,omitempty"`
+}
+
+// NamespacedPod selects pods within the selected namespaces
+type NamespacedPod struct {
+	NamespaceSelector metav1.LabelSelector `json:"namespaceSelector"`
+	PodSelector       metav1.LabelSelector `json:"podSelector"`
+}
+
+// AdminNetworkPolicyIngressRule matches traffic from the peers to the given ports of the subject pods
+type AdminNetworkPolicyIngressRule struct {
+	Name   string                          `json:"name,omitempty"`
+	Action AdminNetworkPolicyRuleAction    `json:"action"`
+	From   []AdminNetworkPolicyIngressPeer `json:"from"`
+	// Ports restricts the rule to the given ports, all ports are matched when it isn't set
+	Ports *[]AdminNetworkPolicyPort `json:"ports,omitempty"`
+}
+
+// AdminNetworkPolicyEgressRule matches traffic from the subject pods to the given ports of the peers
+type AdminNetworkPolicyEgressRule struct {
+	Name   string                         `json:"name,omitempty"`
+	Action AdminNetworkPolicyRuleAction   `json:"action"`
+	To     []AdminNetworkPolicyEgressPeer `json:"to"`
+	// Ports restricts the rule to the given ports, all ports are matched when it isn't set
+	Ports *[]AdminNetworkPolicyPort `json:"ports,omitempty"`
+}
+
+// AdminNetworkPolicyIngressPeer selects the pods that traffic is received from, exactly one of the fields is set
+type AdminNetworkPolicyIngressPeer struct {
+	Namespaces *metav1.LabelSelector `json:"namespaces,omitempty"`
+	Pods       *NamespacedPod        `json:"pods,omitempty"`
+}
+
+// AdminNetworkPolicyEgressPeer selects the pods, nodes or networks that traffic is sent to, exactly one of the fields
+// is set
+type AdminNetworkPolicyEgressPeer struct {
+	Namespaces *metav1.LabelSelector `json:"namespaces,omitempty"`
+	Pods       *NamespacedPod        `json:"pods,omitempty"`
+	Nodes      *metav1.LabelSelector `json:"nodes,omitempty"`
+	Networks   []string              `json:"networks,omitempty"`
+}
+
+// AdminNetworkPolicyPort selects a port number, a named port of the destination pods or a port range, exactly one of
+// the fields is set
+type AdminNetworkPolicyPort struct {
+	PortNumber *Port      `json:"portNumber,omitempty"`
+	NamedPort  *string    `json:"namedPort,omitempty"`
+	PortRange  *PortRange `json:"portRange,omitempty"`
+}
+
+// Port is a port number of a protocol
+type Port struct {
+	Protocol v1.Protocol `json:"protocol"`
+	Port     int32       `json:"port"`
+}
+
+// PortRange is an inclusive range of port numbers of a protocol
+type PortRange struct {
+	Protocol v1.Protocol `json:"protocol,omitempty"`
+	Start    int32       `json:"start"`
+	End      int32       `json:"end"`
+}
+
+// BaselineAdminNetworkPolicy is the cluster scoped default network policy of the pods that no NetworkPolicy applies
+// to. It is a singleton that is always named default.
+type BaselineAdminNetworkPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec BaselineAdminNetworkPolicySpec `json:"spec"`
+}
+
+// BaselineAdminNetworkPolicySpec describes the pods that the baseline policy applies to and its rules
+type BaselineAdminNetworkPolicySpec struct {
+	// Subject selects the pods that the policy applies to
+	Subject AdminNetworkPolicySubject `json:"subject"`
+	// Ingress are the rules for the traffic to the subject pods, they are evaluated in order
+	Ingress []BaselineAdminNetworkPolicyIngressRule `json:"ingress,omitempty"`
+	// Egress are the rules for the traffic from the subject pods, they are evaluated in order
+	Egress []BaselineAdminNetworkPolicyEgressRule `json:"egress,omitempty"`
+}
+
+// BaselineAdminNetworkPolicyIngressRule matches traffic from the peers to the given ports of the subject pods
+type BaselineAdminNetworkPolicyIngressRule struct {
+	Name   string                               `json:"name,omitempty"`
+	Action BaselineAdminNetworkPolicyRuleAction `json:"action"`
+	From   []AdminNetworkPolicyIngressPeer      `json:"from"`
+	// Ports restricts the rule to the given ports, all ports are matched when it isn't set
+	Ports *[]AdminNetworkPolicyPort `json:"ports,omitempty"`
+}
+
+// BaselineAdminNetworkPolicyEgressRule matches traffic from the subject pods to the given ports of the peers
+type BaselineAdminNetworkPolicyEgressRule struct {
+	Name   string                               `json:"name,omitempty"`
+	Action BaselineAdminNetworkPolicyRuleAction `json:"action"`
+	To     []AdminNetworkPolicyEgressPeer       `json:"to"`
+	// Ports restricts the rule to the given ports, all ports are matched when it isn't set
+	Ports *[]AdminNetworkPolicyPort `json:"ports,omitempty"`
+}
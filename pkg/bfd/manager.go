@@ -0,0 +1,329 @@
+package bfd
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// ControlPort is the UDP port that single hop BFD control packets are sent to, see RFC 5881
+	ControlPort = 3784
+
+	// RFC 5881 requires control packets to be sent with a TTL / hop limit of 255 and packets with any other TTL to be
+	// discarded, which ensures that they were sent by a directly connected peer
+	requiredTTL = 255
+	// source ports of control packets must be in the range 49152 through 65535
+	minSourcePort = 49152
+	maxSourcePort = 65535
+
+	eventQueueSize = 64
+)
+
+// StateChangeFunc is called by the Manager whenever the state of a session changes
+type StateChangeFunc func(peer net.IP, oldState, newState State)
+
+// Manager manages the single hop BFD sessions (RFC 5881) with all peers of the node. It receives the control packets
+// of all sessions on the BFD control port and dispatches state changes to a single StateChangeFunc in the order that
+// they happened.
+type Manager struct {
+	interval      time.Duration
+	detectMult    uint8
+	onStateChange StateChangeFunc
+
+	mu             sync.Mutex
+	sessions       map[string]*managedSession
+	discriminators map[uint32]*managedSession
+	listeners      []net.PacketConn
+	started        bool
+	stopCh         chan struct{}
+	events         chan *stateChange
+}
+
+// managedSession is a session along with the socket that its control packets are sent from
+type managedSession struct {
+	*Session
+	conn *net.UDPConn
+}
+
+// NewManager returns a Manager whose sessions use the given interval as desired minimum transmit interval and required
+// minimum receive interval, and declare a peer down after detectMult intervals without a control packet
+func NewManager(interval time.Duration, detectMult uint8, onStateChange StateChangeFunc) *Manager {
+	return &Manager{
+		interval:       interval,
+		detectMult:     detectMult,
+		onStateChange:  onStateChange,
+		sessions:       make(map[string]*managedSession),
+		discriminators: make(map[uint32]*managedSession),
+		stopCh:         make(chan struct{}),
+		events:         make(chan *stateChange, eventQueueSize),
+	}
+}
+
+// AddSession starts a session with the given peer that is sent from localAddr, if there isn't one already. The BFD
+// control port is opened when the first session is added.
+func (m *Manager) AddSession(localAddr, peerAddr net.IP) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.sessions[peerAddr.String()]; ok {
+		return nil
+	}
+	if !m.started {
+		if err := m.start(); err != nil {
+			return err
+		}
+	}
+
+	conn, err := dialControlConn(localAddr, peerAddr)
+	if err != nil {
+		return err
+	}
+	localDiscr, err := m.newDiscriminator()
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	send := func(p *ControlPacket) error {
+		_, err := conn.Write(p.Marshal())
+		return err
+	}
+
+	session := &managedSession{
+		Session: newSession(localAddr, peerAddr, localDiscr, m.interval, m.detectMult, send),
+		conn:    conn,
+	}
+	m.sessions[peerAddr.String()] = session
+	m.discriminators[localDiscr] = session
+	klog.Infof("Starting BFD session with %s from %s", peerAddr, localAddr)
+	go session.run(m.events)
+	return nil
+}
+
+// RemoveSession stops the session with the given peer
+func (m *Manager) RemoveSession(peerAddr net.IP) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, ok := m.sessions[peerAddr.String()]
+	if !ok {
+		return
+	}
+	klog.Infof("Stopping BFD session with %s", peerAddr)
+	session.stop()
+	_ = session.conn.Close()
+	delete(m.sessions, peerAddr.String())
+	delete(m.discriminators, session.localDiscr)
+}
+
+// SessionState returns the state of the session with the given peer, and false if there is no session with the peer
+func (m *Manager) SessionState(peerAddr net.IP) (State, bool) {
+	m.mu.Lock()
+	session, ok := m.sessions[peerAddr.String()]
+	m.mu.Unlock()
+	if !ok {
+		return StateDown, false
+	}
+	return session.State(), true
+}
+
+// Stop stops all sessions and closes the BFD control port
+func (m *Manager) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, session := range m.sessions {
+		session.stop()
+		_ = session.conn.Close()
+	}
+	m.sessions = make(map[string]*managedSession)
+	m.discriminators = make(map[uint32]*managedSession)
+	if m.started {
+		close(m.stopCh)
+		for _, listener := range m.listeners {
+			_ = listener.Close()
+		}
+		m.listeners = nil
+		m.started = false
+	}
+}
+
+// start opens the BFD control port for IPv4 and IPv6 and starts dispatching state changes. Callers must hold m.mu.
+func (m *Manager) start() error {
+	port := strconv.Itoa(ControlPort)
+	var errs []error
+
+	if conn, err := net.ListenPacket("udp4", net.JoinHostPort("0.0.0.0", port)); err == nil {
+		pc := ipv4.NewPacketConn(conn)
+		if err = pc.SetControlMessage(ipv4.FlagTTL, true); err != nil {
+			_ = conn.Close()
+			errs = append(errs, fmt.Errorf("failed to enable TTL control messages on BFD port: %v", err))
+		} else {
+			m.listeners = append(m.listeners, conn)
+			go m.receiveIPv4(pc)
+		}
+	} else {
+		errs = append(errs, fmt.Errorf("failed to listen on IPv4 BFD port %s: %v", port, err))
+	}
+
+	if conn, err := net.ListenPacket("udp6", net.JoinHostPort("::", port)); err == nil {
+		pc := ipv6.NewPacketConn(conn)
+		if err = pc.SetControlMessage(ipv6.FlagHopLimit, true); err != nil {
+			_ = conn.Close()
+			errs = append(errs, fmt.Errorf("failed to enable hop limit control messages on BFD port: %v", err))
+		} else {
+			m.listeners = append(m.listeners, conn)
+			go m.receiveIPv6(pc)
+		}
+	} else {
+		errs = append(errs, fmt.Errorf("failed to listen on IPv6 BFD port %s: %v", port, err))
+	}
+
+	// a node may only have one of the IP families, which is fine as long as we can listen on one of them
+	if len(m.listeners) == 0 {
+		return errors.Join(errs...)
+	}
+	for _, err := range errs {
+		klog.Warningf("BFD: %v", err)
+	}
+
+	m.started = true
+	m.stopCh = make(chan struct{})
+	go m.dispatch(m.stopCh)
+	return nil
+}
+
+// dispatch passes state changes to onStateChange one at a time, so that a slow handler doesn't block the sessions
+func (m *Manager) dispatch(stopCh <-chan struct{}) {
+	for {
+		select {
+		case <-stopCh:
+			return
+		case change := <-m.events:
+			if m.onStateChange != nil {
+				m.onStateChange(change.peer, change.oldState, change.newState)
+			}
+		}
+	}
+}
+
+func (m *Manager) receiveIPv4(pc *ipv4.PacketConn) {
+	buf := make([]byte, 1500)
+	for {
+		n, cm, src, err := pc.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			klog.V(2).Infof("failed to read from IPv4 BFD port: %v", err)
+			continue
+		}
+		if cm == nil || cm.TTL != requiredTTL {
+			klog.V(3).Infof("discarding BFD packet from %s without a TTL of %d", src, requiredTTL)
+			continue
+		}
+		m.receive(buf[:n], src)
+	}
+}
+
+func (m *Manager) receiveIPv6(pc *ipv6.PacketConn) {
+	buf := make([]byte, 1500)
+	for {
+		n, cm, src, err := pc.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			klog.V(2).Infof("failed to read from IPv6 BFD port: %v", err)
+			continue
+		}
+		if cm == nil || cm.HopLimit != requiredTTL {
+			klog.V(3).Infof("discarding BFD packet from %s without a hop limit of %d", src, requiredTTL)
+			continue
+		}
+		m.receive(buf[:n], src)
+	}
+}
+
+// receive passes a control packet to the session that it belongs to
+func (m *Manager) receive(buf []byte, src net.Addr) {
+	p, err := UnmarshalControlPacket(buf)
+	if err != nil {
+		klog.V(2).Infof("discarding invalid BFD packet from %s: %v", src, err)
+		return
+	}
+
+	m.mu.Lock()
+	var session *managedSession
+	if p.YourDiscriminator != 0 {
+		session = m.discriminators[p.YourDiscriminator]
+	} else if udpAddr, ok := src.(*net.UDPAddr); ok {
+		session = m.sessions[udpAddr.IP.String()]
+	}
+	m.mu.Unlock()
+	if session == nil {
+		klog.V(2).Infof("discarding BFD packet from %s that doesn't belong to any session", src)
+		return
+	}
+
+	if change := session.receive(p, time.Now()); change != nil {
+		select {
+		case m.events <- change:
+		case <-session.stopCh:
+		}
+	}
+}
+
+// newDiscriminator returns a random local discriminator that is not used by any other session. Callers must hold
+// m.mu.
+func (m *Manager) newDiscriminator() (uint32, error) {
+	b := make([]byte, 4)
+	for {
+		if _, err := rand.Read(b); err != nil {
+			return 0, fmt.Errorf("failed to generate BFD discriminator: %v", err)
+		}
+		discr := binary.BigEndian.Uint32(b)
+		if _, ok := m.discriminators[discr]; discr != 0 && !ok {
+			return discr, nil
+		}
+	}
+}
+
+// dialControlConn opens the socket that the control packets to peerAddr are sent from, using a source port from the
+// range required by RFC 5881 and a TTL of 255
+func dialControlConn(localAddr, peerAddr net.IP) (*net.UDPConn, error) {
+	remote := &net.UDPAddr{IP: peerAddr, Port: ControlPort}
+	b := make([]byte, 2)
+	var lastErr error
+	for attempt := 0; attempt < 32; attempt++ {
+		if _, err := rand.Read(b); err != nil {
+			return nil, fmt.Errorf("failed to pick BFD source port: %v", err)
+		}
+		port := minSourcePort + int(binary.BigEndian.Uint16(b))%(maxSourcePort-minSourcePort+1)
+		conn, err := net.DialUDP("udp", &net.UDPAddr{IP: localAddr, Port: port}, remote)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if peerAddr.To4() != nil {
+			err = ipv4.NewConn(conn).SetTTL(requiredTTL)
+		} else {
+			err = ipv6.NewConn(conn).SetHopLimit(requiredTTL)
+		}
+		if err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("failed to set TTL of BFD socket for %s: %v", peerAddr, err)
+		}
+		return conn, nil
+	}
+	return nil, fmt.Errorf("failed to open BFD socket from %s to %s: %v", localAddr, peerAddr, lastErr)
+}
@@ -0,0 +1,150 @@
+package bfd
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	// Version is the version of the BFD protocol as defined in RFC 5880
+	Version = 1
+	// ControlPacketLength is the length of a BFD control packet without an authentication section
+	ControlPacketLength = 24
+
+	flagPoll                    = 0x20
+	flagFinal                   = 0x10
+	flagControlPlaneIndependent = 0x08
+	flagAuthenticationPresent   = 0x04
+	flagDemand                  = 0x02
+	flagMultipoint              = 0x01
+)
+
+// State is the state of a BFD session as defined in section 4.1 of RFC 5880
+type State uint8
+
+const (
+	StateAdminDown State = iota
+	StateDown
+	StateInit
+	StateUp
+)
+
+func (s State) String() string {
+	switch s {
+	case StateAdminDown:
+		return "AdminDown"
+	case StateDown:
+		return "Down"
+	case StateInit:
+		return "Init"
+	case StateUp:
+		return "Up"
+	}
+	return fmt.Sprintf("Unknown(%d)", uint8(s))
+}
+
+// Diagnostic is the reason for the last state change of a BFD session as defined in section 4.1 of RFC 5880
+type Diagnostic uint8
+
+const (
+	DiagNone Diagnostic = iota
+	DiagControlDetectionTimeExpired
+	DiagEchoFunctionFailed
+	DiagNeighborSignaledSessionDown
+	DiagForwardingPlaneReset
+	DiagPathDown
+	DiagConcatenatedPathDown
+	DiagAdministrativelyDown
+	DiagReverseConcatenatedPathDown
+)
+
+// ControlPacket is a BFD control packet as defined in section 4.1 of RFC 5880. Authentication is not supported, so
+// the packet never contains an authentication section. All intervals are in microseconds.
+type ControlPacket struct {
+	Diagnostic                Diagnostic
+	State                     State
+	Poll                      bool
+	Final                     bool
+	ControlPlaneIndependent   bool
+	Demand                    bool
+	DetectMult                uint8
+	MyDiscriminator           uint32
+	YourDiscriminator         uint32
+	DesiredMinTxInterval      uint32
+	RequiredMinRxInterval     uint32
+	RequiredMinEchoRxInterval uint32
+}
+
+// Marshal encodes the control packet into its wire format
+func (p *ControlPacket) Marshal() []byte {
+	b := make([]byte, ControlPacketLength)
+	b[0] = Version<<5 | byte(p.Diagnostic)&0x1f
+	b[1] = byte(p.State) << 6
+	if p.Poll {
+		b[1] |= flagPoll
+	}
+	if p.Final {
+		b[1] |= flagFinal
+	}
+	if p.ControlPlaneIndependent {
+		b[1] |= flagControlPlaneIndependent
+	}
+	if p.Demand {
+		b[1] |= flagDemand
+	}
+	b[2] = p.DetectMult
+	b[3] = ControlPacketLength
+	binary.BigEndian.PutUint32(b[4:8], p.MyDiscriminator)
+	binary.BigEndian.PutUint32(b[8:12], p.YourDiscriminator)
+	binary.BigEndian.PutUint32(b[12:16], p.DesiredMinTxInterval)
+	binary.BigEndian.PutUint32(b[16:20], p.RequiredMinRxInterval)
+	binary.BigEndian.PutUint32(b[20:24], p.RequiredMinEchoRxInterval)
+	return b
+}
+
+// UnmarshalControlPacket decodes a control packet from its wire format and validates it according to section 6.8.6
+// of RFC 5880. Packets that need to be discarded are returned as an error.
+func UnmarshalControlPacket(b []byte) (*ControlPacket, error) {
+	if len(b) < ControlPacketLength {
+		return nil, fmt.Errorf("packet is too short for a BFD control packet: %d bytes", len(b))
+	}
+	if version := b[0] >> 5; version != Version {
+		return nil, fmt.Errorf("unsupported BFD version %d", version)
+	}
+	length := int(b[3])
+	if length < ControlPacketLength || length > len(b) {
+		return nil, fmt.Errorf("invalid BFD control packet length %d for a %d byte packet", length, len(b))
+	}
+	if b[1]&flagAuthenticationPresent != 0 {
+		return nil, errors.New("BFD authentication is not supported")
+	}
+	if b[1]&flagMultipoint != 0 {
+		return nil, errors.New("multipoint bit must not be set")
+	}
+
+	p := &ControlPacket{
+		Diagnostic:                Diagnostic(b[0] & 0x1f),
+		State:                     State(b[1] >> 6),
+		Poll:                      b[1]&flagPoll != 0,
+		Final:                     b[1]&flagFinal != 0,
+		ControlPlaneIndependent:   b[1]&flagControlPlaneIndependent != 0,
+		Demand:                    b[1]&flagDemand != 0,
+		DetectMult:                b[2],
+		MyDiscriminator:           binary.BigEndian.Uint32(b[4:8]),
+		YourDiscriminator:         binary.BigEndian.Uint32(b[8:12]),
+		DesiredMinTxInterval:      binary.BigEndian.Uint32(b[12:16]),
+		RequiredMinRxInterval:     binary.BigEndian.Uint32(b[16:20]),
+		RequiredMinEchoRxInterval: binary.BigEndian.Uint32(b[20:24]),
+	}
+	if p.DetectMult == 0 {
+		return nil, errors.New("detect multiplier must not be zero")
+	}
+	if p.MyDiscriminator == 0 {
+		return nil, errors.New("my discriminator must not be zero")
+	}
+	if p.YourDiscriminator == 0 && p.State != StateDown && p.State != StateAdminDown {
+		return nil, fmt.Errorf("your discriminator must not be zero in state %s", p.State)
+	}
+	return p, nil
+}
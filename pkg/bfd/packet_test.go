@@ -0,0 +1,56 @@
+package bfd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ControlPacket(t *testing.T) {
+	t.Run("Marshalled packets are decoded to the same packet", func(t *testing.T) {
+		p := &ControlPacket{
+			Diagnostic:            DiagControlDetectionTimeExpired,
+			State:                 StateUp,
+			Poll:                  true,
+			DetectMult:            3,
+			MyDiscriminator:       0x01020304,
+			YourDiscriminator:     0x05060708,
+			DesiredMinTxInterval:  300000,
+			RequiredMinRxInterval: 300000,
+		}
+		b := p.Marshal()
+		assert.Len(t, b, ControlPacketLength)
+		assert.Equal(t, byte(0x21), b[0], "version 1 and diagnostic 1")
+		assert.Equal(t, byte(0xe0), b[1], "state up and poll bit")
+
+		decoded, err := UnmarshalControlPacket(b)
+		assert.NoError(t, err)
+		assert.Equal(t, p, decoded)
+	})
+
+	valid := (&ControlPacket{State: StateDown, DetectMult: 3, MyDiscriminator: 1}).Marshal()
+	modified := func(modify func(b []byte)) []byte {
+		b := append([]byte(nil), valid...)
+		modify(b)
+		return b
+	}
+	tcs := []struct {
+		name   string
+		packet []byte
+	}{
+		{"short packets are discarded", valid[:ControlPacketLength-1]},
+		{"other versions are discarded", modified(func(b []byte) { b[0] = 2 << 5 })},
+		{"packets that are shorter than their length field are discarded", modified(func(b []byte) { b[3] = 48 })},
+		{"authenticated packets are discarded", modified(func(b []byte) { b[1] |= flagAuthenticationPresent })},
+		{"multipoint packets are discarded", modified(func(b []byte) { b[1] |= flagMultipoint })},
+		{"packets without a detect multiplier are discarded", modified(func(b []byte) { b[2] = 0 })},
+		{"packets without my discriminator are discarded", modified(func(b []byte) { b[7] = 0 })},
+		{"up packets without your discriminator are discarded", modified(func(b []byte) { b[1] = byte(StateUp) << 6 })},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := UnmarshalControlPacket(tc.packet)
+			assert.Error(t, err)
+		})
+	}
+}
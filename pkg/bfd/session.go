@@ -0,0 +1,256 @@
+package bfd
+
+import (
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	// slowTxInterval is the minimum transmit interval that must be advertised while a session is not up, see section
+	// 6.8.3 of RFC 5880
+	slowTxInterval = time.Second
+)
+
+// stateChange is a transition of a session from one state to another
+type stateChange struct {
+	peer     net.IP
+	oldState State
+	newState State
+}
+
+// Session is a single asynchronous mode BFD session with a peer as described by section 6.8 of RFC 5880. Its state
+// is only changed by received control packets and by the expiry of the detection time.
+type Session struct {
+	localAddr net.IP
+	peerAddr  net.IP
+	send      func(p *ControlPacket) error
+
+	mu sync.Mutex
+	// state variables as described in section 6.8.1 of RFC 5880
+	state              State
+	remoteState        State
+	localDiscr         uint32
+	remoteDiscr        uint32
+	localDiag          Diagnostic
+	desiredMinTx       time.Duration
+	requiredMinRx      time.Duration
+	remoteMinRx        time.Duration
+	remoteDesiredMinTx time.Duration
+	detectMult         uint8
+	remoteDetectMult   uint8
+	// pollActive is set while a poll sequence is in progress, see section 6.5 of RFC 5880
+	pollActive bool
+	lastRx     time.Time
+
+	stopCh chan struct{}
+}
+
+func newSession(localAddr, peerAddr net.IP, localDiscr uint32, interval time.Duration, detectMult uint8,
+	send func(p *ControlPacket) error) *Session {
+	return &Session{
+		localAddr:     localAddr,
+		peerAddr:      peerAddr,
+		send:          send,
+		state:         StateDown,
+		remoteState:   StateDown,
+		localDiscr:    localDiscr,
+		desiredMinTx:  interval,
+		requiredMinRx: interval,
+		// section 6.8.1 of RFC 5880 requires the remote receive interval to be initialized to one microsecond
+		remoteMinRx: time.Microsecond,
+		detectMult:  detectMult,
+		stopCh:      make(chan struct{}),
+	}
+}
+
+// State returns the current state of the session
+func (s *Session) State() State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+// advertisedMinTx returns the desired minimum transmit interval that is advertised to the peer, which must not be
+// less than one second while the session is not up
+func (s *Session) advertisedMinTx() time.Duration {
+	if s.state != StateUp && s.desiredMinTx < slowTxInterval {
+		return slowTxInterval
+	}
+	return s.desiredMinTx
+}
+
+// txInterval returns the interval between transmitted control packets before applying jitter. Callers must hold s.mu.
+func (s *Session) txInterval() time.Duration {
+	interval := s.advertisedMinTx()
+	if s.remoteMinRx > interval {
+		interval = s.remoteMinRx
+	}
+	return interval
+}
+
+// detectionTime returns the time after which the session is declared down if no control packet was received from the
+// peer. Callers must hold s.mu.
+func (s *Session) detectionTime() time.Duration {
+	interval := s.requiredMinRx
+	if s.remoteDesiredMinTx > interval {
+		interval = s.remoteDesiredMinTx
+	}
+	return time.Duration(s.remoteDetectMult) * interval
+}
+
+// controlPacket builds the next control packet that is sent to the peer. Callers must hold s.mu.
+func (s *Session) controlPacket(final bool) *ControlPacket {
+	return &ControlPacket{
+		Diagnostic:            s.localDiag,
+		State:                 s.state,
+		Poll:                  s.pollActive && !final,
+		Final:                 final,
+		DetectMult:            s.detectMult,
+		MyDiscriminator:       s.localDiscr,
+		YourDiscriminator:     s.remoteDiscr,
+		DesiredMinTxInterval:  uint32(s.advertisedMinTx().Microseconds()),
+		RequiredMinRxInterval: uint32(s.requiredMinRx.Microseconds()),
+	}
+}
+
+// setState moves the session into a new state and returns the resulting state change, or nil if the state didn't
+// change. Callers must hold s.mu.
+func (s *Session) setState(state State, diag Diagnostic) *stateChange {
+	if s.state == state {
+		return nil
+	}
+	change := &stateChange{peer: s.peerAddr, oldState: s.state, newState: state}
+	s.state = state
+	s.localDiag = diag
+	// the advertised transmit interval changes when the session goes up or down, which requires a poll sequence
+	s.pollActive = true
+	klog.V(1).Infof("BFD session with %s changed from %s to %s", s.peerAddr, change.oldState, change.newState)
+	return change
+}
+
+// receive processes a validated control packet from the peer according to section 6.8.6 of RFC 5880 and returns the
+// resulting state change, if any
+func (s *Session) receive(p *ControlPacket, now time.Time) *stateChange {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.remoteDiscr = p.MyDiscriminator
+	s.remoteState = p.State
+	s.remoteMinRx = time.Duration(p.RequiredMinRxInterval) * time.Microsecond
+	s.remoteDesiredMinTx = time.Duration(p.DesiredMinTxInterval) * time.Microsecond
+	s.remoteDetectMult = p.DetectMult
+	s.lastRx = now
+	if p.Final {
+		s.pollActive = false
+	}
+
+	var change *stateChange
+	switch {
+	case s.state == StateAdminDown:
+		return nil
+	case p.State == StateAdminDown:
+		if s.state != StateDown {
+			change = s.setState(StateDown, DiagNeighborSignaledSessionDown)
+		}
+	case s.state == StateDown:
+		if p.State == StateDown {
+			change = s.setState(StateInit, DiagNone)
+		} else if p.State == StateInit {
+			change = s.setState(StateUp, DiagNone)
+		}
+	case s.state == StateInit:
+		if p.State == StateInit || p.State == StateUp {
+			change = s.setState(StateUp, DiagNone)
+		}
+	case s.state == StateUp:
+		if p.State == StateDown {
+			change = s.setState(StateDown, DiagNeighborSignaledSessionDown)
+		}
+	}
+
+	// a poll must be answered with a final without waiting for the next transmit interval
+	if p.Poll {
+		if err := s.send(s.controlPacket(true)); err != nil {
+			klog.Warningf("failed to send BFD final to %s: %v", s.peerAddr, err)
+		}
+	}
+	return change
+}
+
+// checkDetectionTime declares the session down if no control packet was received from the peer within the detection
+// time and returns the resulting state change, if any
+func (s *Session) checkDetectionTime(now time.Time) *stateChange {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.state != StateInit && s.state != StateUp {
+		return nil
+	}
+	if now.Sub(s.lastRx) <= s.detectionTime() {
+		return nil
+	}
+	change := s.setState(StateDown, DiagControlDetectionTimeExpired)
+	s.remoteDiscr = 0
+	return change
+}
+
+// transmit sends the periodic control packet to the peer and returns the time to wait before sending the next one,
+// with the jitter that is required by section 6.8.7 of RFC 5880 applied
+func (s *Session) transmit() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.remoteMinRx > 0 {
+		if err := s.send(s.controlPacket(false)); err != nil {
+			klog.V(2).Infof("failed to send BFD control packet to %s: %v", s.peerAddr, err)
+		}
+	}
+
+	interval := s.txInterval()
+	// the interval is reduced by 0-25%, or by 10-25% if the detect multiplier is 1
+	maxJitter := 25
+	minJitter := 0
+	if s.detectMult == 1 {
+		minJitter = 10
+	}
+	//nolint:gosec // jitter doesn't need a cryptographically secure random number
+	jitter := minJitter + rand.Intn(maxJitter-minJitter+1)
+	return interval * time.Duration(100-jitter) / 100
+}
+
+// run transmits control packets and checks the detection time until the session is stopped, state changes are passed
+// to events
+func (s *Session) run(events chan<- *stateChange) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-timer.C:
+		}
+		if change := s.checkDetectionTime(time.Now()); change != nil {
+			select {
+			case events <- change:
+			case <-s.stopCh:
+				return
+			}
+		}
+		next := s.transmit()
+		// the detection time may be shorter than the transmit interval, so check it at least as often
+		s.mu.Lock()
+		if detect := s.detectionTime(); detect > 0 && detect < next {
+			next = detect
+		}
+		s.mu.Unlock()
+		timer.Reset(next)
+	}
+}
+
+func (s *Session) stop() {
+	close(s.stopCh)
+}
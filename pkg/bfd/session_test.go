@@ -0,0 +1,99 @@
+package bfd
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestSession(sent *[]*ControlPacket) *Session {
+	return newSession(net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2"), 1, 300*time.Millisecond, 3,
+		func(p *ControlPacket) error {
+			*sent = append(*sent, p)
+			return nil
+		})
+}
+
+func peerPacket(state State) *ControlPacket {
+	return &ControlPacket{
+		State:                 state,
+		DetectMult:            3,
+		MyDiscriminator:       2,
+		YourDiscriminator:     1,
+		DesiredMinTxInterval:  300000,
+		RequiredMinRxInterval: 300000,
+	}
+}
+
+func Test_Session(t *testing.T) {
+	t.Run("Session goes up through init", func(t *testing.T) {
+		var sent []*ControlPacket
+		s := newTestSession(&sent)
+		now := time.Now()
+
+		change := s.receive(peerPacket(StateDown), now)
+		assert.Equal(t, &stateChange{peer: s.peerAddr, oldState: StateDown, newState: StateInit}, change)
+		change = s.receive(peerPacket(StateUp), now)
+		assert.Equal(t, &stateChange{peer: s.peerAddr, oldState: StateInit, newState: StateUp}, change)
+		assert.Equal(t, StateUp, s.State())
+		assert.Nil(t, s.receive(peerPacket(StateUp), now), "state must not change while the peer is up")
+	})
+
+	t.Run("Session goes up directly when the peer is in init", func(t *testing.T) {
+		var sent []*ControlPacket
+		s := newTestSession(&sent)
+
+		change := s.receive(peerPacket(StateInit), time.Now())
+		assert.Equal(t, StateUp, change.newState)
+	})
+
+	t.Run("Session goes down when the detection time expires", func(t *testing.T) {
+		var sent []*ControlPacket
+		s := newTestSession(&sent)
+		now := time.Now()
+		s.receive(peerPacket(StateInit), now)
+
+		assert.Nil(t, s.checkDetectionTime(now.Add(900*time.Millisecond)))
+		change := s.checkDetectionTime(now.Add(901 * time.Millisecond))
+		assert.Equal(t, &stateChange{peer: s.peerAddr, oldState: StateUp, newState: StateDown}, change)
+		assert.Equal(t, DiagControlDetectionTimeExpired, s.localDiag)
+		assert.Zero(t, s.remoteDiscr)
+	})
+
+	t.Run("Session goes down when the peer signals down", func(t *testing.T) {
+		var sent []*ControlPacket
+		s := newTestSession(&sent)
+		now := time.Now()
+		s.receive(peerPacket(StateInit), now)
+
+		change := s.receive(peerPacket(StateDown), now)
+		assert.Equal(t, StateDown, change.newState)
+		assert.Equal(t, DiagNeighborSignaledSessionDown, s.localDiag)
+	})
+
+	t.Run("Poll is answered with final", func(t *testing.T) {
+		var sent []*ControlPacket
+		s := newTestSession(&sent)
+		p := peerPacket(StateDown)
+		p.Poll = true
+
+		s.receive(p, time.Now())
+		assert.Len(t, sent, 1)
+		assert.True(t, sent[0].Final)
+		assert.False(t, sent[0].Poll)
+		assert.Equal(t, uint32(2), sent[0].YourDiscriminator)
+	})
+
+	t.Run("Slow transmit interval is advertised until the session is up", func(t *testing.T) {
+		var sent []*ControlPacket
+		s := newTestSession(&sent)
+		assert.Equal(t, uint32(time.Second.Microseconds()), s.controlPacket(false).DesiredMinTxInterval)
+
+		s.receive(peerPacket(StateInit), time.Now())
+		p := s.controlPacket(false)
+		assert.Equal(t, uint32(300000), p.DesiredMinTxInterval)
+		assert.True(t, p.Poll, "changing the transmit interval requires a poll sequence")
+	})
+}
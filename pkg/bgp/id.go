@@ -10,6 +10,7 @@ import (
 	"strings"
 
 	"github.com/cloudnativelabs/kube-router/v2/pkg/utils"
+	gobgpapi "github.com/osrg/gobgp/v3/api"
 	gobgp "github.com/osrg/gobgp/v3/pkg/packet/bgp"
 )
 
@@ -44,23 +45,50 @@ func GenerateRouterID(nodeIPAware utils.NodeIPAware, configRouterID string) (str
 // gobgp (internal/pkg/table/policy.go:ParseCommunity()). If it is not able to parse the community information it
 // returns an error.
 func ValidateCommunity(arg string) error {
-	_, err := strconv.ParseUint(arg, 10, CommunityMaxSize)
+	_, err := ParseCommunity(arg)
+	return err
+}
+
+// ParseCommunity takes in a string and parses a BGP community out of it in the same way as ValidateCommunity, it
+// returns the community as its 32-bit value.
+func ParseCommunity(arg string) (uint32, error) {
+	community, err := strconv.ParseUint(arg, 10, CommunityMaxSize)
 	if err == nil {
-		return nil
+		return uint32(community), nil
 	}
 
 	elem1, elem2, found := strings.Cut(arg, ":")
 	if found {
-		if _, err := strconv.ParseUint(elem1, 10, CommunityMaxPartSize); err == nil {
-			if _, err = strconv.ParseUint(elem2, 10, CommunityMaxPartSize); err == nil {
-				return nil
+		if high, err := strconv.ParseUint(elem1, 10, CommunityMaxPartSize); err == nil {
+			if low, err := strconv.ParseUint(elem2, 10, CommunityMaxPartSize); err == nil {
+				return uint32(high<<CommunityMaxPartSize | low), nil
 			}
 		}
 	}
-	for _, v := range gobgp.WellKnownCommunityNameMap {
-		if arg == v {
-			return nil
-		}
+	if v, ok := gobgp.WellKnownCommunityValueMap[arg]; ok {
+		return uint32(v), nil
+	}
+	return 0, fmt.Errorf("failed to parse %s as community", arg)
+}
+
+// ValidateLargeCommunity takes in a string and attempts to parse a BGP large community (RFC 8092) out of it in the
+// form <global admin>:<local data 1>:<local data 2>, which is the form gobgp expects in policy actions. If it is not
+// able to parse the large community information it returns an error.
+func ValidateLargeCommunity(arg string) error {
+	_, err := ParseLargeCommunity(arg)
+	return err
+}
+
+// ParseLargeCommunity takes in a string of the form <global admin>:<local data 1>:<local data 2> and parses a BGP
+// large community (RFC 8092) out of it. If it is not able to parse the large community it returns an error.
+func ParseLargeCommunity(arg string) (*gobgpapi.LargeCommunity, error) {
+	lc, err := gobgp.ParseLargeCommunity(arg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s as large community: %v", arg, err)
 	}
-	return fmt.Errorf("failed to parse %s as community", arg)
+	return &gobgpapi.LargeCommunity{
+		GlobalAdmin: lc.ASN,
+		LocalData1:  lc.LocalData1,
+		LocalData2:  lc.LocalData2,
+	}, nil
 }
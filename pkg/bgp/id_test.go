@@ -37,3 +37,52 @@ func Test_ValidateCommunity(t *testing.T) {
 		assert.Error(t, ValidateCommunity("community"))
 	})
 }
+
+func Test_ParseCommunity(t *testing.T) {
+	t.Run("BGP community specified in all forms should parse to the same value", func(t *testing.T) {
+		for _, arg := range []string{"4294967041", "65535:65281", "no-export"} {
+			community, err := ParseCommunity(arg)
+			assert.NoError(t, err)
+			assert.Equal(t, uint32(0xFFFFFF01), community, arg)
+		}
+	})
+	t.Run("Invalid BGP community should fail to parse", func(t *testing.T) {
+		_, err := ParseCommunity("65536:1")
+		assert.Error(t, err)
+	})
+}
+
+func Test_ParseLargeCommunity(t *testing.T) {
+	t.Run("BGP large community should parse into its three parts", func(t *testing.T) {
+		lc, err := ParseLargeCommunity("4200000000:1:2")
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(4200000000), lc.GlobalAdmin)
+		assert.Equal(t, uint32(1), lc.LocalData1)
+		assert.Equal(t, uint32(2), lc.LocalData2)
+	})
+	t.Run("BGP large community with missing or too large parts should fail to parse", func(t *testing.T) {
+		_, err := ParseLargeCommunity("65000:1")
+		assert.Error(t, err)
+		_, err = ParseLargeCommunity("4294967296:1:2")
+		assert.Error(t, err)
+	})
+}
+
+func Test_ValidateLargeCommunity(t *testing.T) {
+	t.Run("BGP large community with 4-byte ASN as global admin should pass validation", func(t *testing.T) {
+		assert.Nil(t, ValidateLargeCommunity("4200000000:100:200"))
+		assert.Nil(t, ValidateLargeCommunity("4294967295:4294967295:4294967295"))
+	})
+	t.Run("BGP large community that doesn't have 3 parts should fail validation", func(t *testing.T) {
+		assert.Error(t, ValidateLargeCommunity("65000:100"))
+		assert.Error(t, ValidateLargeCommunity("65000:100:200:300"))
+	})
+	t.Run("BGP large community with parts greater than 32-bit integers should fail validation", func(t *testing.T) {
+		assert.Error(t, ValidateLargeCommunity("4294967296:100:200"))
+		assert.Error(t, ValidateLargeCommunity("65000:100:4294967296"))
+	})
+	t.Run("BGP large community that is not a number should fail validation", func(t *testing.T) {
+		assert.Error(t, ValidateLargeCommunity("no-export"))
+		assert.Error(t, ValidateLargeCommunity("a:b:c"))
+	})
+}
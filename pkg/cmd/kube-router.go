@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
@@ -9,6 +10,8 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/cloudnativelabs/kube-router/v2/pkg/apis/kuberouter/v1alpha1"
+	policyv1alpha1 "github.com/cloudnativelabs/kube-router/v2/pkg/apis/policy/v1alpha1"
 	"github.com/cloudnativelabs/kube-router/v2/pkg/controllers/lballoc"
 	"github.com/cloudnativelabs/kube-router/v2/pkg/controllers/netpol"
 	"github.com/cloudnativelabs/kube-router/v2/pkg/controllers/proxy"
@@ -20,9 +23,16 @@ import (
 	"github.com/cloudnativelabs/kube-router/v2/pkg/version"
 	"k8s.io/klog/v2"
 
+	v1core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
@@ -30,8 +40,9 @@ const healthControllerChannelLength = 10
 
 // KubeRouter holds the information needed to run server
 type KubeRouter struct {
-	Client kubernetes.Interface
-	Config *options.KubeRouterConfig
+	Client        kubernetes.Interface
+	DynamicClient dynamic.Interface
+	Config        *options.KubeRouterConfig
 }
 
 // NewKubeRouterDefault returns a KubeRouter object
@@ -59,7 +70,12 @@ func NewKubeRouterDefault(config *options.KubeRouterConfig) (*KubeRouter, error)
 		return nil, fmt.Errorf("failed to create Kubernetes client: %v", err)
 	}
 
-	return &KubeRouter{Client: clientset, Config: config}, nil
+	dynamicClient, err := dynamic.NewForConfig(clientconfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dynamic Kubernetes client: %v", err)
+	}
+
+	return &KubeRouter{Client: clientset, DynamicClient: dynamicClient, Config: config}, nil
 }
 
 // CleanupConfigAndExit performs Cleanup on all three controllers
@@ -96,6 +112,19 @@ func (kr *KubeRouter) Run() error {
 	wg.Add(1)
 	go hc.RunServer(stopCh, &wg)
 
+	// with the nftables firewall backend the service proxy and the network policy controller render their rules into
+	// the same table, each replacing only its own part of it
+	var nftTable *utils.NFTablesSharedTable
+	if kr.Config.FirewallBackend == options.FirewallBackendNFTables && (kr.Config.RunServiceProxy ||
+		kr.Config.RunFirewall) {
+		nftablesHandler, err := utils.NewNFTables()
+		if err != nil {
+			return fmt.Errorf("failed to create nftables handler for --firewall-backend=%s: %v",
+				kr.Config.FirewallBackend, err)
+		}
+		nftTable = utils.NewNFTablesSharedTable(nftablesHandler, utils.NFTablesFamilyInet, utils.KubeRouterNFTable)
+	}
+
 	informerFactory := informers.NewSharedInformerFactory(kr.Client, 0)
 	svcInformer := informerFactory.Core().V1().Services().Informer()
 	epInformer := informerFactory.Core().V1().Endpoints().Informer()
@@ -104,6 +133,10 @@ func (kr *KubeRouter) Run() error {
 	nodeInformer := informerFactory.Core().V1().Nodes().Informer()
 	nsInformer := informerFactory.Core().V1().Namespaces().Informer()
 	npInformer := informerFactory.Networking().V1().NetworkPolicies().Informer()
+	var serviceCIDRInformer cache.SharedIndexInformer
+	if kr.Config.RunFirewall && kr.serviceCIDRsWatchable() {
+		serviceCIDRInformer = informerFactory.Networking().V1beta1().ServiceCIDRs().Informer()
+	}
 	informerFactory.Start(stopCh)
 
 	err = kr.CacheSyncOrTimeout(informerFactory, stopCh)
@@ -157,12 +190,50 @@ func (kr *KubeRouter) Run() error {
 	}
 
 	if kr.Config.RunRouter {
+		bgpPeerInformer, err := kr.startBGPPeerInformer(stopCh)
+		if err != nil {
+			return fmt.Errorf("failed to synchronize BGPPeer cache: %v", err)
+		}
+
+		var secretInformer cache.SharedIndexInformer
+		if bgpPeerInformer != nil && kr.Config.BGPPeerSecretsNamespace != "" {
+			secretInformer, err = kr.startSecretInformer(stopCh, kr.Config.BGPPeerSecretsNamespace)
+			if err != nil {
+				return fmt.Errorf("failed to synchronize Secret cache: %v", err)
+			}
+		}
+
+		var egressGatewayInformer cache.SharedIndexInformer
+		if kr.Config.EnableEgressGateway {
+			egressGatewayInformer, err = kr.startEgressGatewayInformer(stopCh)
+			if err != nil {
+				return fmt.Errorf("failed to synchronize EgressGateway cache: %v", err)
+			}
+		}
+
 		nrc, err := routing.NewNetworkRoutingController(kr.Client, kr.Config,
-			nodeInformer, svcInformer, epInformer, &ipsetMutex)
+			nodeInformer, svcInformer, epInformer, bgpPeerInformer, secretInformer, egressGatewayInformer, podInformer,
+			nsInformer, &ipsetMutex)
 		if err != nil {
 			return fmt.Errorf("failed to create network routing controller: %v", err)
 		}
 
+		if bgpPeerInformer != nil {
+			_, err = bgpPeerInformer.AddEventHandler(nrc.BGPPeerEventHandler)
+			if err != nil {
+				return fmt.Errorf("failed to add BGPPeerEventHandler: %v", err)
+			}
+		}
+
+		if egressGatewayInformer != nil {
+			for _, informer := range []cache.SharedIndexInformer{egressGatewayInformer, podInformer, nsInformer} {
+				_, err = informer.AddEventHandler(nrc.EgressGatewayEventHandler)
+				if err != nil {
+					return fmt.Errorf("failed to add EgressGatewayEventHandler: %v", err)
+				}
+			}
+		}
+
 		_, err = nodeInformer.AddEventHandler(nrc.NodeEventHandler)
 		if err != nil {
 			return fmt.Errorf("failed to add NodeEventHandler: %v", err)
@@ -189,7 +260,7 @@ func (kr *KubeRouter) Run() error {
 
 	if kr.Config.RunServiceProxy {
 		nsc, err := proxy.NewNetworkServicesController(kr.Client, kr.Config,
-			svcInformer, epSliceInformer, podInformer, &ipsetMutex)
+			svcInformer, epSliceInformer, podInformer, &ipsetMutex, nftTable)
 		if err != nil {
 			return fmt.Errorf("failed to create network services controller: %v", err)
 		}
@@ -202,6 +273,14 @@ func (kr *KubeRouter) Run() error {
 		if err != nil {
 			return fmt.Errorf("failed to add EndpointsEventHandler: %v", err)
 		}
+		_, err = podInformer.AddEventHandler(nsc.PodEventHandler)
+		if err != nil {
+			return fmt.Errorf("failed to add PodEventHandler: %v", err)
+		}
+		_, err = nodeInformer.AddEventHandler(nsc.NodeEventHandler)
+		if err != nil {
+			return fmt.Errorf("failed to add NodeEventHandler: %v", err)
+		}
 
 		wg.Add(1)
 		go nsc.Run(healthChan, stopCh, &wg)
@@ -215,12 +294,27 @@ func (kr *KubeRouter) Run() error {
 	}
 
 	if kr.Config.RunFirewall {
-		iptablesCmdHandlers, ipSetHandlers, err := netpol.NewIPTablesHandlers(kr.Config)
-		if err != nil {
-			return fmt.Errorf("failed to create iptables handlers: %v", err)
+		var iptablesCmdHandlers map[v1core.IPFamily]utils.IPTablesHandler
+		var ipSetHandlers map[v1core.IPFamily]utils.IPSetHandler
+		// the nftables firewall backend doesn't use iptables or ipset at all, so don't require their binaries
+		if kr.Config.FirewallBackend != options.FirewallBackendNFTables {
+			iptablesCmdHandlers, ipSetHandlers, err = netpol.NewIPTablesHandlers(kr.Config)
+			if err != nil {
+				return fmt.Errorf("failed to create iptables handlers: %v", err)
+			}
+		}
+
+		var anpInformer, banpInformer cache.SharedIndexInformer
+		if kr.Config.EnableAdminNetworkPolicy {
+			anpInformer, banpInformer, err = kr.startAdminNetworkPolicyInformers(stopCh)
+			if err != nil {
+				return fmt.Errorf("failed to synchronize admin network policy cache: %v", err)
+			}
 		}
+
 		npc, err := netpol.NewNetworkPolicyController(kr.Client,
-			kr.Config, podInformer, npInformer, nsInformer, &ipsetMutex, nil, iptablesCmdHandlers, ipSetHandlers)
+			kr.Config, podInformer, npInformer, nsInformer, serviceCIDRInformer, anpInformer, banpInformer,
+			&ipsetMutex, nil, iptablesCmdHandlers, ipSetHandlers, nftTable)
 		if err != nil {
 			return fmt.Errorf("failed to create network policy controller: %v", err)
 		}
@@ -237,6 +331,21 @@ func (kr *KubeRouter) Run() error {
 		if err != nil {
 			return fmt.Errorf("failed to add NetworkPolicyEventHandler: %v", err)
 		}
+		if serviceCIDRInformer != nil {
+			_, err = serviceCIDRInformer.AddEventHandler(npc.ServiceCIDREventHandler)
+			if err != nil {
+				return fmt.Errorf("failed to add ServiceCIDREventHandler: %v", err)
+			}
+		}
+		for _, informer := range []cache.SharedIndexInformer{anpInformer, banpInformer} {
+			if informer == nil {
+				continue
+			}
+			_, err = informer.AddEventHandler(npc.AdminNetworkPolicyEventHandler)
+			if err != nil {
+				return fmt.Errorf("failed to add AdminNetworkPolicyEventHandler: %v", err)
+			}
+		}
 
 		wg.Add(1)
 		go npc.Run(healthChan, stopCh, &wg)
@@ -286,3 +395,114 @@ func (kr *KubeRouter) CacheSyncOrTimeout(informerFactory informers.SharedInforme
 		return nil
 	}
 }
+
+// serviceCIDRsWatchable returns whether the ServiceCIDR API, which allows the cluster IP ranges to be extended at
+// runtime, is served by the API server and kube-router is allowed to list and watch it
+func (kr *KubeRouter) serviceCIDRsWatchable() bool {
+	_, err := kr.Client.NetworkingV1beta1().ServiceCIDRs().List(context.Background(), metav1.ListOptions{Limit: 1})
+	switch {
+	case err == nil:
+		return true
+	case apierrors.IsNotFound(err):
+		klog.Infof("ServiceCIDR API is not served, only using the cluster IP ranges given by " +
+			"--service-cluster-ip-range")
+	default:
+		klog.Warningf("unable to list ServiceCIDRs, only using the cluster IP ranges given by "+
+			"--service-cluster-ip-range: %v", err)
+	}
+	return false
+}
+
+// startBGPPeerInformer starts the informer of the BGPPeer custom resource and waits for its cache to be synchronized.
+// It returns a nil informer when the BGPPeer custom resource definition isn't installed in the cluster.
+func (kr *KubeRouter) startBGPPeerInformer(stopCh <-chan struct{}) (cache.SharedIndexInformer, error) {
+	informer, err := kr.startCustomResourceInformer(stopCh, v1alpha1.BGPPeerGVR)
+	if err == nil && informer == nil {
+		klog.Infof("BGPPeer custom resource definition is not installed, only peering with peers configured " +
+			"through flags and node annotations")
+	}
+	return informer, err
+}
+
+// startSecretInformer starts an informer for the Secrets of a single namespace, so that kube-router only needs access
+// to the Secrets that hold BGPPeer passwords, and waits for its cache to be synchronized
+func (kr *KubeRouter) startSecretInformer(stopCh <-chan struct{}, namespace string) (cache.SharedIndexInformer,
+	error) {
+	informerFactory := informers.NewSharedInformerFactoryWithOptions(kr.Client, 0, informers.WithNamespace(namespace))
+	informer := informerFactory.Core().V1().Secrets().Informer()
+	informerFactory.Start(stopCh)
+	if err := kr.CacheSyncOrTimeout(informerFactory, stopCh); err != nil {
+		return nil, err
+	}
+	return informer, nil
+}
+
+// startEgressGatewayInformer starts the informer of the EgressGateway custom resource and waits for its cache to be
+// synchronized. It returns a nil informer when the EgressGateway custom resource definition isn't installed.
+func (kr *KubeRouter) startEgressGatewayInformer(stopCh <-chan struct{}) (cache.SharedIndexInformer, error) {
+	informer, err := kr.startCustomResourceInformer(stopCh, v1alpha1.EgressGatewayGVR)
+	if err == nil && informer == nil {
+		klog.Warningf("--enable-egress-gateway is set but the EgressGateway custom resource definition is not " +
+			"installed, egress gateways are disabled")
+	}
+	return informer, err
+}
+
+// startAdminNetworkPolicyInformers starts the informers of the AdminNetworkPolicy and BaselineAdminNetworkPolicy
+// custom resources and waits for their caches to be synchronized. It returns a nil informer for each of the custom
+// resource definitions that isn't installed in the cluster.
+func (kr *KubeRouter) startAdminNetworkPolicyInformers(stopCh <-chan struct{}) (cache.SharedIndexInformer,
+	cache.SharedIndexInformer, error) {
+	anpInformer, err := kr.startCustomResourceInformer(stopCh, policyv1alpha1.AdminNetworkPolicyGVR)
+	if err != nil {
+		return nil, nil, err
+	}
+	banpInformer, err := kr.startCustomResourceInformer(stopCh, policyv1alpha1.BaselineAdminNetworkPolicyGVR)
+	if err != nil {
+		return nil, nil, err
+	}
+	if anpInformer == nil && banpInformer == nil {
+		klog.Warningf("--enable-admin-network-policy is set but the AdminNetworkPolicy and " +
+			"BaselineAdminNetworkPolicy custom resource definitions are not installed, admin network policies are " +
+			"disabled")
+	}
+	return anpInformer, banpInformer, nil
+}
+
+// startCustomResourceInformer starts a dynamic informer for the given kube-router custom resource and waits for its
+// cache to be synchronized. It returns a nil informer when the resource isn't served by the API server.
+func (kr *KubeRouter) startCustomResourceInformer(stopCh <-chan struct{},
+	gvr schema.GroupVersionResource) (cache.SharedIndexInformer, error) {
+	resources, err := kr.Client.Discovery().ServerResourcesForGroupVersion(gvr.GroupVersion().String())
+	if err != nil {
+		klog.V(1).Infof("failed to discover resources of %s: %v", gvr.GroupVersion().String(), err)
+		return nil, nil
+	}
+	found := false
+	for _, resource := range resources.APIResources {
+		if resource.Name == gvr.Resource {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, nil
+	}
+
+	informerFactory := dynamicinformer.NewDynamicSharedInformerFactory(kr.DynamicClient, 0)
+	informer := informerFactory.ForResource(gvr).Informer()
+	informerFactory.Start(stopCh)
+
+	syncOverCh := make(chan struct{})
+	go func() {
+		informerFactory.WaitForCacheSync(stopCh)
+		close(syncOverCh)
+	}()
+
+	select {
+	case <-time.After(kr.Config.CacheSyncTimeout):
+		return nil, fmt.Errorf("%s timeout", kr.Config.CacheSyncTimeout.String())
+	case <-syncOverCh:
+		return informer, nil
+	}
+}
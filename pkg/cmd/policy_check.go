@@ -0,0 +1,366 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	policyv1alpha1 "github.com/cloudnativelabs/kube-router/v2/pkg/apis/policy/v1alpha1"
+	"github.com/cloudnativelabs/kube-router/v2/pkg/controllers/netpol"
+	"github.com/spf13/pflag"
+	v1core "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// PolicyCheckCommand is the name of the subcommand that runs PolicyCheck
+const PolicyCheckCommand = "policy-check"
+
+// policyCheckObjects holds the objects that the network policies are evaluated against, the admin network policies
+// are kept unstructured like the network policy controller gets them from its dynamic informers
+type policyCheckObjects struct {
+	pods                         cache.Indexer
+	namespaces                   cache.Indexer
+	networkPolicies              cache.Indexer
+	adminNetworkPolicies         cache.Indexer
+	baselineAdminNetworkPolicies cache.Indexer
+}
+
+func newPolicyCheckObjects() *policyCheckObjects {
+	namespaced := cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}
+	return &policyCheckObjects{
+		pods:                         cache.NewIndexer(cache.MetaNamespaceKeyFunc, namespaced),
+		namespaces:                   cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{}),
+		networkPolicies:              cache.NewIndexer(cache.MetaNamespaceKeyFunc, namespaced),
+		adminNetworkPolicies:         cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{}),
+		baselineAdminNetworkPolicies: cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{}),
+	}
+}
+
+// PolicyCheck evaluates the network policies for the traffic between two pods or addresses and writes the verdict
+// with the network policy and rule responsible to out, reading the objects from the API server or from files
+func PolicyCheck(args []string, out io.Writer) error {
+	flags := pflag.NewFlagSet(PolicyCheckCommand, pflag.ContinueOnError)
+	flags.SetOutput(out)
+	var check netpol.NetworkPolicyCheck
+	var files []string
+	var kubeconfig, master, output string
+	flags.StringVar(&check.From, "from", "", "Source of the traffic, a pod as namespace/name or an IP address.")
+	flags.StringVar(&check.To, "to", "", "Destination of the traffic, a pod as namespace/name or an IP address.")
+	flags.StringVar(&check.Protocol, "protocol", "TCP", "Protocol of the traffic (TCP, UDP, SCTP or a protocol "+
+		"without ports like ICMP).")
+	flags.IntVar(&check.Port, "port", 0, "Destination port of the traffic, required for TCP, UDP and SCTP.")
+	flags.StringSliceVarP(&files, "filename", "f", nil,
+		"Files with the Pods, Namespaces, NetworkPolicies, AdminNetworkPolicies and BaselineAdminNetworkPolicies "+
+			"to evaluate, in YAML or JSON, - reads stdin. Read from the API server when not set.")
+	flags.StringVar(&kubeconfig, "kubeconfig", "", "Path to kubeconfig file with authorization information "+
+		"(the master location is set by the master flag).")
+	flags.StringVar(&master, "master", "", "The address of the Kubernetes API server (overrides any value in "+
+		"kubeconfig).")
+	flags.StringVarP(&output, "output", "o", "text", "Output format of the verdict (text or json).")
+	flags.Usage = func() {
+		fmt.Fprintf(out, "Usage of kube-router %s:\n", PolicyCheckCommand)
+		flags.PrintDefaults()
+		fmt.Fprintf(out, "\nThe pod firewall accepts all traffic to a pod from an address of its local node. Only the "+
+			"node addresses\nin the status of the destination pod are known to %s, traffic from other addresses "+
+			"of the\nnode, like the address of the pod bridge, is evaluated against the network policies.\n",
+			PolicyCheckCommand)
+	}
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if check.From == "" || check.To == "" {
+		return fmt.Errorf("--from and --to are required")
+	}
+	if output != "text" && output != "json" {
+		return fmt.Errorf("invalid output format %q, expected text or json", output)
+	}
+
+	objects := newPolicyCheckObjects()
+	var err error
+	if len(files) > 0 {
+		err = objects.loadFiles(files)
+	} else {
+		err = objects.loadFromAPIServer(master, kubeconfig)
+	}
+	if err != nil {
+		return err
+	}
+
+	verdict, err := netpol.CheckNetworkPolicies(objects.pods, objects.namespaces, objects.networkPolicies,
+		objects.adminNetworkPolicies, objects.baselineAdminNetworkPolicies, check)
+	if err != nil {
+		return err
+	}
+
+	if output == "json" {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(verdict)
+	}
+	writePolicyCheckVerdict(out, check, verdict)
+	return nil
+}
+
+func writePolicyCheckVerdict(out io.Writer, check netpol.NetworkPolicyCheck, verdict *netpol.NetworkPolicyVerdict) {
+	for _, direction := range []netpol.NetworkPolicyDirectionVerdict{verdict.Egress, verdict.Ingress} {
+		endpoint := direction.IP
+		if direction.Pod != "" {
+			endpoint = direction.Pod + " (" + direction.IP + ")"
+		}
+		result := "allowed"
+		if !direction.Allowed {
+			result = "denied"
+		}
+		fmt.Fprintf(out, "%s of %s: %s, %s\n", direction.Direction, endpoint, result, direction.Reason)
+		if len(direction.Policies) > 0 {
+			fmt.Fprintf(out, "  evaluated network policies: %s\n", strings.Join(direction.Policies, ", "))
+		}
+	}
+	result := "ALLOWED"
+	if !verdict.Allowed {
+		result = "DENIED"
+	}
+	traffic := strings.ToUpper(check.Protocol)
+	if check.Port != 0 {
+		traffic += "/" + strconv.Itoa(check.Port)
+	}
+	fmt.Fprintf(out, "%s from %s to %s on %s\n", result, check.From, check.To, traffic)
+}
+
+func (objects *policyCheckObjects) loadFromAPIServer(master, kubeconfig string) error {
+	var clientconfig *rest.Config
+	var err error
+	if len(master) != 0 || len(kubeconfig) != 0 {
+		clientconfig, err = clientcmd.BuildConfigFromFlags(master, kubeconfig)
+	} else {
+		clientconfig, err = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+			clientcmd.NewDefaultClientConfigLoadingRules(), &clientcmd.ConfigOverrides{}).ClientConfig()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to build client configuration: %v", err)
+	}
+	clientset, err := kubernetes.NewForConfig(clientconfig)
+	if err != nil {
+		return fmt.Errorf("failed to create Kubernetes client: %v", err)
+	}
+
+	ctx := context.Background()
+	pods, err := clientset.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list pods: %v", err)
+	}
+	namespaces, err := clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list namespaces: %v", err)
+	}
+	networkPolicies, err := clientset.NetworkingV1().NetworkPolicies(metav1.NamespaceAll).List(ctx,
+		metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list network policies: %v", err)
+	}
+	for _, obj := range []runtime.Object{pods, namespaces, networkPolicies} {
+		if err = objects.add(obj); err != nil {
+			return err
+		}
+	}
+
+	dynamicClient, err := dynamic.NewForConfig(clientconfig)
+	if err != nil {
+		return fmt.Errorf("failed to create dynamic Kubernetes client: %v", err)
+	}
+	for _, gvr := range []schema.GroupVersionResource{policyv1alpha1.AdminNetworkPolicyGVR,
+		policyv1alpha1.BaselineAdminNetworkPolicyGVR} {
+		adminPolicies, err := dynamicClient.Resource(gvr).List(ctx, metav1.ListOptions{})
+		if apierrors.IsNotFound(err) {
+			// the custom resource definition isn't installed, so there are no such admin network policies
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to list %s: %v", gvr.Resource, err)
+		}
+		for idx := range adminPolicies.Items {
+			if err = objects.add(&adminPolicies.Items[idx]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (objects *policyCheckObjects) loadFiles(files []string) error {
+	for _, file := range files {
+		if err := objects.loadFile(file); err != nil {
+			return fmt.Errorf("failed to load %s: %v", file, err)
+		}
+	}
+	return nil
+}
+
+func (objects *policyCheckObjects) loadFile(file string) error {
+	if file == "-" {
+		return objects.decode(os.Stdin)
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return objects.decode(f)
+}
+
+// decode adds the objects of a stream of YAML or JSON documents
+func (objects *policyCheckObjects) decode(reader io.Reader) error {
+	documents := yaml.NewYAMLReader(bufio.NewReader(reader))
+	for {
+		document, err := documents.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(string(document)) == "" {
+			continue
+		}
+		obj, err := decodePolicyCheckObject(document)
+		if err != nil {
+			return err
+		}
+		if err = objects.add(obj); err != nil {
+			return err
+		}
+	}
+}
+
+// decodePolicyCheckObject decodes a YAML or JSON object, the admin network policy custom resources aren't part of the
+// client-go scheme and are decoded as unstructured objects
+func decodePolicyCheckObject(data []byte) (runtime.Object, error) {
+	data, err := yaml.ToJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	u := &unstructured.Unstructured{}
+	if err = u.UnmarshalJSON(data); err == nil && u.GroupVersionKind().Group == policyv1alpha1.GroupName {
+		return u, nil
+	}
+	obj, _, err := scheme.Codecs.UniversalDeserializer().Decode(data, nil, nil)
+	return obj, err
+}
+
+// add adds the Pods, Namespaces, NetworkPolicies and admin network policies of the object, objects read from files
+// don't go through the API server, so the defaults that it would apply and that the network policies depend on are set
+// here
+func (objects *policyCheckObjects) add(obj runtime.Object) error {
+	switch obj := obj.(type) {
+	case *v1core.Pod:
+		for idx := range obj.Spec.Containers {
+			for portIdx := range obj.Spec.Containers[idx].Ports {
+				if obj.Spec.Containers[idx].Ports[portIdx].Protocol == "" {
+					obj.Spec.Containers[idx].Ports[portIdx].Protocol = v1core.ProtocolTCP
+				}
+			}
+		}
+		if obj.Namespace == "" {
+			obj.Namespace = metav1.NamespaceDefault
+		}
+		if len(obj.Status.PodIPs) == 0 && obj.Status.PodIP != "" {
+			obj.Status.PodIPs = []v1core.PodIP{{IP: obj.Status.PodIP}}
+		}
+		return objects.pods.Add(obj)
+	case *v1core.Namespace:
+		if obj.Labels == nil {
+			obj.Labels = make(map[string]string)
+		}
+		obj.Labels[v1core.LabelMetadataName] = obj.Name
+		return objects.namespaces.Add(obj)
+	case *networking.NetworkPolicy:
+		if obj.Namespace == "" {
+			obj.Namespace = metav1.NamespaceDefault
+		}
+		defaultNetworkPolicy(obj)
+		return objects.networkPolicies.Add(obj)
+	case *v1core.PodList:
+		for idx := range obj.Items {
+			if err := objects.add(&obj.Items[idx]); err != nil {
+				return err
+			}
+		}
+	case *v1core.NamespaceList:
+		for idx := range obj.Items {
+			if err := objects.add(&obj.Items[idx]); err != nil {
+				return err
+			}
+		}
+	case *networking.NetworkPolicyList:
+		for idx := range obj.Items {
+			if err := objects.add(&obj.Items[idx]); err != nil {
+				return err
+			}
+		}
+	case *unstructured.Unstructured:
+		switch obj.GetKind() {
+		case policyv1alpha1.AdminNetworkPolicyKind:
+			return objects.adminNetworkPolicies.Add(obj)
+		case policyv1alpha1.BaselineAdminNetworkPolicyKind:
+			return objects.baselineAdminNetworkPolicies.Add(obj)
+		}
+	case *v1core.List:
+		for _, item := range obj.Items {
+			itemObj, err := decodePolicyCheckObject(item.Raw)
+			if err != nil {
+				return err
+			}
+			if err = objects.add(itemObj); err != nil {
+				return err
+			}
+		}
+	}
+	// other kinds of objects have no effect on the network policies
+	return nil
+}
+
+// defaultNetworkPolicy sets the policy types and the protocols of the ports as the API server defaults them
+func defaultNetworkPolicy(policy *networking.NetworkPolicy) {
+	if len(policy.Spec.PolicyTypes) == 0 {
+		policy.Spec.PolicyTypes = []networking.PolicyType{networking.PolicyTypeIngress}
+		if len(policy.Spec.Egress) > 0 {
+			policy.Spec.PolicyTypes = append(policy.Spec.PolicyTypes, networking.PolicyTypeEgress)
+		}
+	}
+	tcp := v1core.ProtocolTCP
+	for idx := range policy.Spec.Ingress {
+		for portIdx := range policy.Spec.Ingress[idx].Ports {
+			if policy.Spec.Ingress[idx].Ports[portIdx].Protocol == nil {
+				policy.Spec.Ingress[idx].Ports[portIdx].Protocol = &tcp
+			}
+		}
+	}
+	for idx := range policy.Spec.Egress {
+		for portIdx := range policy.Spec.Egress[idx].Ports {
+			if policy.Spec.Egress[idx].Ports[portIdx].Protocol == nil {
+				policy.Spec.Egress[idx].Ports[portIdx].Protocol = &tcp
+			}
+		}
+	}
+}
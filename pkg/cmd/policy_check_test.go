@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testPolicyCheckObjects = `apiVersion: v1
+kind: Namespace
+metadata:
+  name: app
+---
+apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: Pod
+  metadata:
+    name: web
+    namespace: app
+    labels:
+      app: web
+  spec:
+    containers:
+    - name: web
+      image: web
+      ports:
+      - name: http
+        containerPort: 8080
+  status:
+    podIP: 10.1.0.5
+- apiVersion: v1
+  kind: Pod
+  metadata:
+    name: frontend
+    namespace: app
+    labels:
+      app: frontend
+  spec:
+    containers:
+    - name: frontend
+      image: frontend
+  status:
+    podIP: 10.1.0.6
+---
+apiVersion: networking.k8s.io/v1
+kind: NetworkPolicy
+metadata:
+  name: web
+  namespace: app
+spec:
+  podSelector:
+    matchLabels:
+      app: web
+  ingress:
+  - from:
+    - podSelector:
+        matchLabels:
+          app: frontend
+    ports:
+    - port: http
+`
+
+func TestPolicyCheck(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "objects.yaml")
+	assert.NoError(t, os.WriteFile(file, []byte(testPolicyCheckObjects), 0o600))
+
+	var out bytes.Buffer
+	assert.NoError(t, PolicyCheck([]string{"-f", file, "--from", "app/frontend", "--to", "app/web", "--port",
+		"8080"}, &out))
+	assert.Equal(t, "egress of app/frontend (10.1.0.6): allowed, no network policy selects the pod for egress\n"+
+		"ingress of app/web (10.1.0.5): allowed, allowed by ingress rule 0 of network policy app/web\n"+
+		"ALLOWED from app/frontend to app/web on TCP/8080\n", out.String())
+
+	out.Reset()
+	assert.NoError(t, PolicyCheck([]string{"-f", file, "--from", "192.168.0.1", "--to", "10.1.0.5", "--port",
+		"8080", "-o", "json"}, &out))
+	assert.Contains(t, out.String(), `"allowed": false`)
+	assert.Contains(t, out.String(), `"policies": [
+      "app/web"
+    ]`)
+
+	out.Reset()
+	assert.NoError(t, PolicyCheck([]string{"-f", file, "--from", "app/frontend", "--to", "app/web", "--protocol",
+		"icmp"}, &out))
+	assert.Contains(t, out.String(), "DENIED from app/frontend to app/web on ICMP\n")
+
+	assert.Error(t, PolicyCheck([]string{"-f", file, "--from", "app/frontend"}, &out))
+	assert.Error(t, PolicyCheck([]string{"-f", file, "--from", "app/frontend", "--to", "app/web"}, &out))
+}
+
+const testPolicyCheckAdminPolicies = `---
+apiVersion: policy.networking.k8s.io/v1alpha1
+kind: AdminNetworkPolicy
+metadata:
+  name: deny-frontend
+spec:
+  priority: 10
+  subject:
+    namespaces: {}
+  ingress:
+  - name: frontend
+    action: Deny
+    from:
+    - pods:
+        namespaceSelector: {}
+        podSelector:
+          matchLabels:
+            app: frontend
+---
+apiVersion: v1
+kind: List
+items:
+- apiVersion: policy.networking.k8s.io/v1alpha1
+  kind: BaselineAdminNetworkPolicy
+  metadata:
+    name: default
+  spec:
+    subject:
+      namespaces: {}
+    ingress:
+    - name: web
+      action: Deny
+      from:
+      - pods:
+          namespaceSelector: {}
+          podSelector:
+            matchLabels:
+              app: web
+`
+
+func TestPolicyCheckAdminPolicies(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "objects.yaml")
+	assert.NoError(t, os.WriteFile(file, []byte(testPolicyCheckObjects+testPolicyCheckAdminPolicies), 0o600))
+
+	var out bytes.Buffer
+	assert.NoError(t, PolicyCheck([]string{"-f", file, "--from", "app/frontend", "--to", "app/web", "--port",
+		"8080"}, &out))
+	assert.Equal(t, "egress of app/frontend (10.1.0.6): allowed, no network policy selects the pod for egress\n"+
+		"ingress of app/web (10.1.0.5): denied, denied by ingress rule 0 (frontend) of admin network policy "+
+		"deny-frontend\n"+
+		"DENIED from app/frontend to app/web on TCP/8080\n", out.String())
+
+	out.Reset()
+	assert.NoError(t, PolicyCheck([]string{"-f", file, "--from", "app/web", "--to", "app/frontend", "--port",
+		"8080", "-o", "json"}, &out))
+	assert.Contains(t, out.String(), `"kind": "baseline admin network policy"`)
+	assert.Contains(t, out.String(), `"allowed": false`)
+}
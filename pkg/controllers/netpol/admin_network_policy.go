@@ -0,0 +1,665 @@
+package netpol
+
+import (
+	"crypto/sha256"
+	"encoding/base32"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cloudnativelabs/kube-router/v2/pkg/apis/policy/v1alpha1"
+	"github.com/cloudnativelabs/kube-router/v2/pkg/utils"
+	api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+	netutils "k8s.io/utils/net"
+)
+
+// AdminNetworkPolicies (ANPs) and the BaselineAdminNetworkPolicy (BANP) of the policy.networking.k8s.io API are cluster
+// scoped policies that are evaluated around the NetworkPolicies of the namespaces. Each of them gets a chain per
+// direction and IP family. The pod firewall chain of a pod that an ANP applies to jumps to the chains of the ANPs in
+// priority order before it jumps to the NetworkPolicy chains. The first matching ANP rule decides:
+//   - Allow marks the traffic as permitted by network policies and as decided, so that no further ANP is evaluated
+//   - Deny rejects the traffic
+//   - Pass marks the traffic as decided without permitting it, so that no further ANP is evaluated and the traffic is
+//     evaluated by the NetworkPolicies instead
+//
+// The BANP is only evaluated for the directions that no NetworkPolicy applies to the pod for, right before the default
+// network policy chain that permits all traffic, and only if no ANP allowed the traffic already.
+
+const (
+	// kubeAdminPolicyDecided marks traffic that an ANP rule allowed or passed, so the remaining ANPs are skipped
+	kubeAdminPolicyDecided = "0x40000"
+	// kubeAdminPolicyAllowed marks traffic that an admin policy rule allowed, it is the combination of the network
+	// policy match mark and the decided mark
+	kubeAdminPolicyAllowed = "0x50000"
+
+	adminPolicyIngress = "ingress"
+	adminPolicyEgress  = "egress"
+)
+
+// internal structure to represent an AdminNetworkPolicy or the BaselineAdminNetworkPolicy
+type adminNetworkPolicyInfo struct {
+	name     string
+	baseline bool
+	priority int32
+
+	// set of pods matching the subject of the policy
+	targetPods map[string]podInfo
+
+	ingressRules []adminPolicyRule
+	egressRules  []adminPolicyRule
+}
+
+// internal structure to represent an ingress or egress rule of an admin policy, the actions of the baseline policy
+// are represented by the equivalent AdminNetworkPolicy actions
+type adminPolicyRule struct {
+	name          string
+	action        v1alpha1.AdminNetworkPolicyRuleAction
+	peerPods      []podInfo
+	peerNetworks  map[api.IPFamily][]string
+	matchAllPorts bool
+	ports         []protocolAndPort
+	namedPorts    []endPoints
+}
+
+// adminPolicyChain is the chain of one direction of an admin policy for an IP family, along with the sets that its
+// rules reference. It is rendered by both firewall backends.
+type adminPolicyChain struct {
+	name  string
+	sets  []adminPolicySet
+	rules []adminPolicyChainRule
+}
+
+type adminPolicySet struct {
+	name    string
+	hashNet bool
+	entries []string
+}
+
+// adminPolicyChainRule is a rule of an admin policy chain, ruleIdx is the index of the ingress or egress rule of the
+// policy that it was rendered for
+type adminPolicyChainRule struct {
+	ruleIdx      int
+	comment      string
+	srcSetName   string
+	dstSetName   string
+	portProtocol protocolAndPort
+	action       v1alpha1.AdminNetworkPolicyRuleAction
+}
+
+func (npc *NetworkPolicyController) newAdminNetworkPolicyEventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			npc.OnAdminNetworkPolicyUpdate(obj)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			npc.OnAdminNetworkPolicyUpdate(newObj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			npc.OnAdminNetworkPolicyUpdate(obj)
+		},
+	}
+}
+
+// OnAdminNetworkPolicyUpdate handles updates to AdminNetworkPolicies and BaselineAdminNetworkPolicies from the
+// kubernetes api server
+func (npc *NetworkPolicyController) OnAdminNetworkPolicyUpdate(obj interface{}) {
+	if u, ok := obj.(*unstructured.Unstructured); ok {
+		klog.V(2).Infof("Received update for %s: %s", u.GetKind(), u.GetName())
+	}
+
+	npc.RequestFullSync()
+}
+
+// buildAdminNetworkPoliciesInfo returns the AdminNetworkPolicies ordered by their priority followed by the
+// BaselineAdminNetworkPolicy, if there is one
+func (npc *NetworkPolicyController) buildAdminNetworkPoliciesInfo() ([]adminNetworkPolicyInfo, error) {
+	adminPolicies := make([]adminNetworkPolicyInfo, 0)
+
+	if npc.anpLister != nil {
+		for _, obj := range npc.anpLister.List() {
+			anp := &v1alpha1.AdminNetworkPolicy{}
+			if err := fromUnstructured(obj, anp); err != nil {
+				return nil, err
+			}
+			policy := adminNetworkPolicyInfo{name: anp.Name, priority: anp.Spec.Priority}
+			targetPods, err := npc.evalAdminPolicySubject(anp.Spec.Subject)
+			if err != nil {
+				return nil, err
+			}
+			policy.targetPods = podInfoMap(targetPods)
+
+			for _, rule := range anp.Spec.Ingress {
+				policy.ingressRules = append(policy.ingressRules,
+					npc.evalAdminPolicyIngressRule(rule.Name, rule.Action, rule.From, rule.Ports, targetPods))
+			}
+			for _, rule := range anp.Spec.Egress {
+				policy.egressRules = append(policy.egressRules,
+					npc.evalAdminPolicyEgressRule(anp.Name, rule.Name, rule.Action, rule.To, rule.Ports))
+			}
+			adminPolicies = append(adminPolicies, policy)
+		}
+	}
+	// policies of the same priority are evaluated in an undefined order, order them by name so that the rendered
+	// chains don't change from sync to sync
+	sort.SliceStable(adminPolicies, func(i, j int) bool {
+		if adminPolicies[i].priority != adminPolicies[j].priority {
+			return adminPolicies[i].priority < adminPolicies[j].priority
+		}
+		return adminPolicies[i].name < adminPolicies[j].name
+	})
+
+	if npc.banpLister != nil {
+		for _, obj := range npc.banpLister.List() {
+			banp := &v1alpha1.BaselineAdminNetworkPolicy{}
+			if err := fromUnstructured(obj, banp); err != nil {
+				return nil, err
+			}
+			if banp.Name != v1alpha1.BaselineAdminNetworkPolicyName {
+				klog.Warningf("Ignoring BaselineAdminNetworkPolicy %s, the baseline policy must be named %s",
+					banp.Name, v1alpha1.BaselineAdminNetworkPolicyName)
+				continue
+			}
+			policy := adminNetworkPolicyInfo{name: banp.Name, baseline: true}
+			targetPods, err := npc.evalAdminPolicySubject(banp.Spec.Subject)
+			if err != nil {
+				return nil, err
+			}
+			policy.targetPods = podInfoMap(targetPods)
+
+			for _, rule := range banp.Spec.Ingress {
+				policy.ingressRules = append(policy.ingressRules, npc.evalAdminPolicyIngressRule(rule.Name,
+					v1alpha1.AdminNetworkPolicyRuleAction(rule.Action), rule.From, rule.Ports, targetPods))
+			}
+			for _, rule := range banp.Spec.Egress {
+				policy.egressRules = append(policy.egressRules, npc.evalAdminPolicyEgressRule(banp.Name, rule.Name,
+					v1alpha1.AdminNetworkPolicyRuleAction(rule.Action), rule.To, rule.Ports))
+			}
+			adminPolicies = append(adminPolicies, policy)
+		}
+	}
+
+	return adminPolicies, nil
+}
+
+func (npc *NetworkPolicyController) evalAdminPolicySubject(subject v1alpha1.AdminNetworkPolicySubject) ([]*api.Pod,
+	error) {
+	return npc.evalNamespacedPods(subject.Namespaces, subject.Pods)
+}
+
+func (npc *NetworkPolicyController) evalAdminPolicyIngressRule(name string,
+	action v1alpha1.AdminNetworkPolicyRuleAction, peers []v1alpha1.AdminNetworkPolicyIngressPeer,
+	ports *[]v1alpha1.AdminNetworkPolicyPort, targetPods []*api.Pod) adminPolicyRule {
+	rule := adminPolicyRule{name: name, action: action}
+	for _, peer := range peers {
+		peerPods, err := npc.evalNamespacedPods(peer.Namespaces, peer.Pods)
+		if err != nil {
+			klog.Errorf("Failed to evaluate the peers of admin network policy rule %s: %v", name, err)
+			continue
+		}
+		rule.peerPods = append(rule.peerPods, podInfoList(peerPods)...)
+	}
+	// named ports of ingress rules are the named ports of the subject pods, as they are the destination
+	rule.matchAllPorts, rule.ports, rule.namedPorts = npc.evalAdminPolicyPorts(ports, targetPods)
+	return rule
+}
+
+func (npc *NetworkPolicyController) evalAdminPolicyEgressRule(policyName, name string,
+	action v1alpha1.AdminNetworkPolicyRuleAction, peers []v1alpha1.AdminNetworkPolicyEgressPeer,
+	ports *[]v1alpha1.AdminNetworkPolicyPort) adminPolicyRule {
+	rule := adminPolicyRule{name: name, action: action, peerNetworks: make(map[api.IPFamily][]string)}
+	var peerPods []*api.Pod
+	for _, peer := range peers {
+		if peer.Nodes != nil {
+			klog.Warningf("Ignoring the nodes peer of admin network policy %s rule %s, nodes peers are not "+
+				"supported", policyName, name)
+		}
+		for _, network := range peer.Networks {
+			for ipFamily, cidrs := range adminPolicyNetworkEntries(network) {
+				rule.peerNetworks[ipFamily] = append(rule.peerNetworks[ipFamily], cidrs...)
+			}
+		}
+		if peer.Namespaces == nil && peer.Pods == nil {
+			continue
+		}
+		pods, err := npc.evalNamespacedPods(peer.Namespaces, peer.Pods)
+		if err != nil {
+			klog.Errorf("Failed to evaluate the peers of admin network policy %s rule %s: %v", policyName, name, err)
+			continue
+		}
+		peerPods = append(peerPods, pods...)
+	}
+	rule.peerPods = podInfoList(peerPods)
+	// named ports of egress rules are the named ports of the peer pods, as they are the destination
+	rule.matchAllPorts, rule.ports, rule.namedPorts = npc.evalAdminPolicyPorts(ports, peerPods)
+	return rule
+}
+
+// evalNamespacedPods returns the actionable pods of either the namespaces selected by the namespaces selector or the
+// pods selected by the namespaced pod selector
+func (npc *NetworkPolicyController) evalNamespacedPods(namespaces *metav1.LabelSelector,
+	pods *v1alpha1.NamespacedPod) ([]*api.Pod, error) {
+	namespaceSelector, podSelector := labels.Nothing(), labels.Everything()
+	var err error
+	switch {
+	case namespaces != nil:
+		if namespaceSelector, err = metav1.LabelSelectorAsSelector(namespaces); err != nil {
+			return nil, err
+		}
+	case pods != nil:
+		if namespaceSelector, err = metav1.LabelSelectorAsSelector(&pods.NamespaceSelector); err != nil {
+			return nil, err
+		}
+		if podSelector, err = metav1.LabelSelectorAsSelector(&pods.PodSelector); err != nil {
+			return nil, err
+		}
+	}
+
+	matchedNamespaces, err := npc.ListNamespaceByLabels(namespaceSelector)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(matchedNamespaces, func(i, j int) bool { return matchedNamespaces[i].Name < matchedNamespaces[j].Name })
+	matchingPods := make([]*api.Pod, 0)
+	for _, namespace := range matchedNamespaces {
+		namespacePods, err := npc.ListPodsByNamespaceAndLabels(namespace.Name, podSelector)
+		if err != nil {
+			return nil, err
+		}
+		sort.Slice(namespacePods, func(i, j int) bool { return namespacePods[i].Name < namespacePods[j].Name })
+		for _, pod := range namespacePods {
+			if isNetPolActionable(pod) {
+				matchingPods = append(matchingPods, pod)
+			}
+		}
+	}
+	return matchingPods, nil
+}
+
+// evalAdminPolicyPorts converts the ports of an admin policy rule, named ports are resolved against the given
+// destination pods
+func (npc *NetworkPolicyController) evalAdminPolicyPorts(ports *[]v1alpha1.AdminNetworkPolicyPort,
+	dstPods []*api.Pod) (matchAllPorts bool, numericPorts []protocolAndPort, namedPorts []endPoints) {
+	if ports == nil {
+		return true, nil, nil
+	}
+
+	var namedPort2eps namedPort2eps
+	for _, port := range *ports {
+		switch {
+		case port.PortNumber != nil:
+			numericPorts = append(numericPorts, protocolAndPort{
+				protocol: adminPolicyProtocol(port.PortNumber.Protocol),
+				port:     strconv.Itoa(int(port.PortNumber.Port)),
+			})
+		case port.PortRange != nil:
+			numericPorts = append(numericPorts, protocolAndPort{
+				protocol: adminPolicyProtocol(port.PortRange.Protocol),
+				port:     strconv.Itoa(int(port.PortRange.Start)),
+				endport:  strconv.Itoa(int(port.PortRange.End)),
+			})
+		case port.NamedPort != nil:
+			if namedPort2eps == nil {
+				namedPort2eps = make(map[string]protocol2eps)
+				for _, pod := range dstPods {
+					npc.grabNamedPortFromPod(pod, &namedPort2eps)
+				}
+			}
+			// named ports of admin policies match the named port of any protocol
+			protocols := make([]string, 0, len(namedPort2eps[*port.NamedPort]))
+			for protocol := range namedPort2eps[*port.NamedPort] {
+				protocols = append(protocols, protocol)
+			}
+			sort.Strings(protocols)
+			for _, protocol := range protocols {
+				numericPorts := make([]string, 0, len(namedPort2eps[*port.NamedPort][protocol]))
+				for numericPort := range namedPort2eps[*port.NamedPort][protocol] {
+					numericPorts = append(numericPorts, numericPort)
+				}
+				sort.Strings(numericPorts)
+				for _, numericPort := range numericPorts {
+					eps := namedPort2eps[*port.NamedPort][protocol][numericPort]
+					eps.protocol = adminPolicyProtocol(api.Protocol(eps.protocol))
+					namedPorts = append(namedPorts, *eps)
+				}
+			}
+		}
+	}
+	return false, numericPorts, namedPorts
+}
+
+// adminPolicyProtocol returns the protocol of an admin policy port, which is TCP if it isn't given
+func adminPolicyProtocol(protocol api.Protocol) string {
+	if protocol == "" {
+		return string(api.ProtocolTCP)
+	}
+	return string(protocol)
+}
+
+// adminPolicyNetworkEntries splits a CIDR of a networks peer by IP family, as /0 CIDRs can't be added to hash:net
+// ipsets they are split into two halves
+func adminPolicyNetworkEntries(network string) map[api.IPFamily][]string {
+	_, ipNet, err := net.ParseCIDR(strings.TrimSpace(network))
+	if err != nil {
+		klog.Warningf("Ignoring invalid network %q of admin network policy: %v", network, err)
+		return nil
+	}
+	ipFamily := api.IPv4Protocol
+	if netutils.IsIPv6CIDR(ipNet) {
+		ipFamily = api.IPv6Protocol
+	}
+	if ones, _ := ipNet.Mask.Size(); ones == 0 {
+		if ipFamily == api.IPv4Protocol {
+			return map[api.IPFamily][]string{ipFamily: {"0.0.0.0/1", "128.0.0.0/1"}}
+		}
+		return map[api.IPFamily][]string{ipFamily: {"::/1", "8000::/1"}}
+	}
+	return map[api.IPFamily][]string{ipFamily: {ipNet.String()}}
+}
+
+// adminPolicyChains returns the chains of the directions of the policy that have rules for the IP family
+func (policy adminNetworkPolicyInfo) adminPolicyChains(version string, ipFamily api.IPFamily) []adminPolicyChain {
+	chains := make([]adminPolicyChain, 0, 2)
+	if len(policy.ingressRules) > 0 {
+		chains = append(chains, policy.adminPolicyChain(adminPolicyIngress, policy.ingressRules, version, ipFamily))
+	}
+	if len(policy.egressRules) > 0 {
+		chains = append(chains, policy.adminPolicyChain(adminPolicyEgress, policy.egressRules, version, ipFamily))
+	}
+	return chains
+}
+
+func (policy adminNetworkPolicyInfo) adminPolicyChain(direction string, rules []adminPolicyRule, version string,
+	ipFamily api.IPFamily) adminPolicyChain {
+	chain := adminPolicyChain{name: adminNetworkPolicyChainName(policy.name, policy.baseline, direction, version,
+		ipFamily)}
+
+	for ruleIdx, rule := range rules {
+		comment := fmt.Sprintf("rule %d (%s) of %s %s: %s", ruleIdx, rule.name, policy.kind(), policy.name, rule.action)
+		appendRules := func(srcSetName, dstSetName string) {
+			if rule.matchAllPorts {
+				chain.rules = append(chain.rules, adminPolicyChainRule{ruleIdx: ruleIdx, comment: comment,
+					srcSetName: srcSetName, dstSetName: dstSetName, action: rule.action})
+				return
+			}
+			for _, portProtocol := range rule.ports {
+				chain.rules = append(chain.rules, adminPolicyChainRule{ruleIdx: ruleIdx, comment: comment,
+					srcSetName: srcSetName, dstSetName: dstSetName, portProtocol: portProtocol, action: rule.action})
+			}
+		}
+
+		// the pod firewall chain only jumps to the chain for the traffic of the direction, so the subject pods don't
+		// need to be matched and only the peers have to be
+		peerIPs := getIPsFromPods(rule.peerPods, ipFamily)
+		if direction == adminPolicyIngress {
+			if len(peerIPs) == 0 {
+				continue
+			}
+			srcSetName := adminPolicyRuleIPSetName(policy, direction, ruleIdx, "pod", ipFamily)
+			chain.sets = append(chain.sets, adminPolicySet{name: srcSetName, entries: peerIPs})
+			appendRules(srcSetName, "")
+			for epIdx, endPoints := range rule.namedPorts {
+				namedPortSetName := adminPolicyRuleIPSetName(policy, direction, ruleIdx,
+					"namedport"+strconv.Itoa(epIdx), ipFamily)
+				chain.sets = append(chain.sets, adminPolicySet{name: namedPortSetName,
+					entries: endPoints.ips[ipFamily]})
+				chain.rules = append(chain.rules, adminPolicyChainRule{ruleIdx: ruleIdx, comment: comment,
+					srcSetName: srcSetName, dstSetName: namedPortSetName, portProtocol: endPoints.protocolAndPort,
+					action: rule.action})
+			}
+			continue
+		}
+
+		if len(peerIPs) > 0 {
+			dstSetName := adminPolicyRuleIPSetName(policy, direction, ruleIdx, "pod", ipFamily)
+			chain.sets = append(chain.sets, adminPolicySet{name: dstSetName, entries: peerIPs})
+			appendRules("", dstSetName)
+			for epIdx, endPoints := range rule.namedPorts {
+				namedPortSetName := adminPolicyRuleIPSetName(policy, direction, ruleIdx,
+					"namedport"+strconv.Itoa(epIdx), ipFamily)
+				chain.sets = append(chain.sets, adminPolicySet{name: namedPortSetName,
+					entries: endPoints.ips[ipFamily]})
+				chain.rules = append(chain.rules, adminPolicyChainRule{ruleIdx: ruleIdx, comment: comment,
+					dstSetName: namedPortSetName, portProtocol: endPoints.protocolAndPort, action: rule.action})
+			}
+		}
+		if len(rule.peerNetworks[ipFamily]) > 0 {
+			dstSetName := adminPolicyRuleIPSetName(policy, direction, ruleIdx, "networks", ipFamily)
+			chain.sets = append(chain.sets, adminPolicySet{name: dstSetName, hashNet: true,
+				entries: rule.peerNetworks[ipFamily]})
+			appendRules("", dstSetName)
+		}
+	}
+	return chain
+}
+
+func (policy adminNetworkPolicyInfo) kind() string {
+	if policy.baseline {
+		return "baseline admin network policy"
+	}
+	return "admin network policy"
+}
+
+// adminPolicyJumpMark returns the mark that must not be set for the pod firewall chain to jump to the chains of the
+// policy. AdminNetworkPolicies are skipped once an earlier one decided, the baseline policy once the traffic was
+// permitted.
+func (policy adminNetworkPolicyInfo) adminPolicyJumpMark() string {
+	if policy.baseline {
+		return nftMarkNetpolMatch
+	}
+	return kubeAdminPolicyDecided
+}
+
+// adminPolicyActionMark returns the mark that a rule with the action sets, Deny rules don't set a mark
+func adminPolicyActionMark(action v1alpha1.AdminNetworkPolicyRuleAction) string {
+	switch action {
+	case v1alpha1.AdminNetworkPolicyRuleActionAllow:
+		return kubeAdminPolicyAllowed
+	case v1alpha1.AdminNetworkPolicyRuleActionPass:
+		return kubeAdminPolicyDecided
+	}
+	return ""
+}
+
+// syncAdminNetworkPolicyChains creates the chains and ipsets of the admin policies and returns their names
+func (npc *NetworkPolicyController) syncAdminNetworkPolicyChains(adminPoliciesInfo []adminNetworkPolicyInfo,
+	version string) (map[string]bool, map[string]bool, error) {
+	activePolicyChains := make(map[string]bool)
+	activePolicyIPSets := make(map[string]bool)
+	if len(adminPoliciesInfo) == 0 {
+		return activePolicyChains, activePolicyIPSets, nil
+	}
+
+	klog.V(1).Infof("Attempting to attain ipset mutex lock")
+	npc.ipsetMutex.Lock()
+	klog.V(1).Infof("Attained ipset mutex lock, continuing...")
+	defer func() {
+		npc.ipsetMutex.Unlock()
+		klog.V(1).Infof("Returned ipset mutex lock")
+	}()
+
+	for _, ipset := range npc.ipSetHandlers {
+		if err := ipset.Save(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for _, policy := range adminPoliciesInfo {
+		for ipFamily := range npc.ipSetHandlers {
+			for _, chain := range policy.adminPolicyChains(version, ipFamily) {
+				npc.filterTableRules[ipFamily].WriteString(":" + chain.name + "\n")
+				activePolicyChains[chain.name] = true
+
+				for _, set := range chain.sets {
+					hashType := utils.TypeHashIP
+					if set.hashNet {
+						hashType = utils.TypeHashNet
+					}
+					npc.createPolicyIndexedIPSet(activePolicyIPSets, set.name, hashType, set.entries, ipFamily)
+				}
+				for _, rule := range chain.rules {
+					npc.appendRuleToAdminPolicyChain(chain.name, rule, ipFamily)
+				}
+			}
+		}
+	}
+
+	for ipFamily, ipset := range npc.ipSetHandlers {
+		if err := ipset.Restore(); err != nil {
+			return nil, nil, fmt.Errorf("failed to perform ipset restore for %s admin network policies: %w",
+				ipFamily, err)
+		}
+	}
+
+	return activePolicyChains, activePolicyIPSets, nil
+}
+
+func (npc *NetworkPolicyController) appendRuleToAdminPolicyChain(chainName string, rule adminPolicyChainRule,
+	ipFamily api.IPFamily) {
+	args := []string{"-A", chainName, "-m", "comment", "--comment", "\"" + rule.comment + "\""}
+	if rule.srcSetName != "" {
+		args = append(args, "-m", "set", "--match-set", ipSetName(rule.srcSetName, ipFamily), "src")
+	}
+	if rule.dstSetName != "" {
+		args = append(args, "-m", "set", "--match-set", ipSetName(rule.dstSetName, ipFamily), "dst")
+	}
+	if rule.portProtocol.protocol != "" {
+		args = append(args, "-p", rule.portProtocol.protocol)
+	}
+	if rule.portProtocol.port != "" {
+		if rule.portProtocol.endport != "" {
+			args = append(args, "--dport", rule.portProtocol.port+":"+rule.portProtocol.endport)
+		} else {
+			args = append(args, "--dport", rule.portProtocol.port)
+		}
+	}
+
+	filterTableRules := npc.filterTableRules[ipFamily]
+	if mark := adminPolicyActionMark(rule.action); mark != "" {
+		//nolint:gocritic // we want to append to a separate array here so that we can re-use args below
+		markArgs := append(args, "-j", "MARK", "--set-xmark", mark+"/"+mark, "\n")
+		filterTableRules.WriteString(strings.Join(markArgs, " "))
+		args = append(args, "-m", "mark", "--mark", mark+"/"+mark, "-j", "RETURN", "\n")
+		filterTableRules.WriteString(strings.Join(args, " "))
+		return
+	}
+
+	//nolint:gocritic // we want to append to a separate array here so that we can re-use args below
+	logArgs := append(append(args, npc.nflogLimitArgs()...),
+		"-j", "NFLOG", "--nflog-group", "100", "--nflog-prefix", chainName, "\n")
+	filterTableRules.WriteString(strings.Join(logArgs, " "))
+	args = append(args, "-j", "REJECT", "\n")
+	filterTableRules.WriteString(strings.Join(args, " "))
+}
+
+// insertAdminPolicyJumps inserts the rules that jump to the chains of the admin policies that apply to the pod at the
+// top of the pod firewall chain, the AdminNetworkPolicies are evaluated in priority order
+func (npc *NetworkPolicyController) insertAdminPolicyJumps(pod podInfo, podFwChainName string,
+	adminPoliciesInfo []adminNetworkPolicyInfo, version string, ipFamily api.IPFamily, ip string) {
+	for idx := len(adminPoliciesInfo) - 1; idx >= 0; idx-- {
+		policy := adminPoliciesInfo[idx]
+		if !policy.baseline {
+			npc.insertAdminPolicyJump(pod, podFwChainName, policy, adminPolicyEgress, version, ipFamily, ip)
+			npc.insertAdminPolicyJump(pod, podFwChainName, policy, adminPolicyIngress, version, ipFamily, ip)
+		}
+	}
+}
+
+// insertAdminPolicyJump inserts the rule that jumps to the chain of the direction of the policy at the top of the pod
+// firewall chain, if the policy applies to the pod and has rules for the direction
+func (npc *NetworkPolicyController) insertAdminPolicyJump(pod podInfo, podFwChainName string,
+	policy adminNetworkPolicyInfo, direction string, version string, ipFamily api.IPFamily, ip string) {
+	if !policy.appliesTo(pod, direction) {
+		return
+	}
+	addrMatch := "-d"
+	if direction == adminPolicyEgress {
+		addrMatch = "-s"
+	}
+	mark := policy.adminPolicyJumpMark()
+	comment := "\"run through " + direction + " rules of " + policy.kind() + " " + policy.name + "\""
+	args := []string{"-I", podFwChainName, "1", addrMatch, ip, "-m", "mark", "!", "--mark", mark + "/" + mark,
+		"-m", "comment", "--comment", comment,
+		"-j", adminNetworkPolicyChainName(policy.name, policy.baseline, direction, version, ipFamily), "\n"}
+	npc.filterTableRules[ipFamily].WriteString(strings.Join(args, " "))
+}
+
+func (policy adminNetworkPolicyInfo) appliesTo(pod podInfo, direction string) bool {
+	if _, ok := policy.targetPods[pod.ip]; !ok {
+		return false
+	}
+	if direction == adminPolicyIngress {
+		return len(policy.ingressRules) > 0
+	}
+	return len(policy.egressRules) > 0
+}
+
+// baselineAdminPolicy returns the BaselineAdminNetworkPolicy, which is ordered last, if there is one
+func baselineAdminPolicy(adminPoliciesInfo []adminNetworkPolicyInfo) *adminNetworkPolicyInfo {
+	if len(adminPoliciesInfo) > 0 && adminPoliciesInfo[len(adminPoliciesInfo)-1].baseline {
+		return &adminPoliciesInfo[len(adminPoliciesInfo)-1]
+	}
+	return nil
+}
+
+func adminNetworkPolicyChainName(policyName string, baseline bool, direction string, version string,
+	ipFamily api.IPFamily) string {
+	kind := "adminnetworkpolicy"
+	if baseline {
+		kind = "baselineadminnetworkpolicy"
+	}
+	hash := sha256.Sum256([]byte(kind + policyName + direction + version + string(ipFamily)))
+	encoded := base32.StdEncoding.EncodeToString(hash[:])
+	return kubeNetworkPolicyChainPrefix + encoded[:16]
+}
+
+func adminPolicyRuleIPSetName(policy adminNetworkPolicyInfo, direction string, ruleNo int, setType string,
+	ipFamily api.IPFamily) string {
+	prefix := kubeDestinationIPSetPrefix
+	if direction == adminPolicyIngress && setType == "pod" {
+		prefix = kubeSourceIPSetPrefix
+	}
+	hash := sha256.Sum256([]byte(policy.kind() + policy.name + direction + "rule" + strconv.Itoa(ruleNo) +
+		string(ipFamily) + setType))
+	encoded := base32.StdEncoding.EncodeToString(hash[:])
+	return ipSetName(prefix+encoded[:16], ipFamily)
+}
+
+func podInfoList(pods []*api.Pod) []podInfo {
+	podInfos := make([]podInfo, 0, len(pods))
+	for _, pod := range pods {
+		podInfos = append(podInfos, podInfo{ip: pod.Status.PodIP, ips: pod.Status.PodIPs, name: pod.Name,
+			namespace: pod.Namespace, labels: pod.Labels})
+	}
+	return podInfos
+}
+
+func podInfoMap(pods []*api.Pod) map[string]podInfo {
+	podInfos := make(map[string]podInfo, len(pods))
+	for _, pod := range podInfoList(pods) {
+		podInfos[pod.ip] = pod
+	}
+	return podInfos
+}
+
+// fromUnstructured converts an object of a dynamic informer into the given custom resource type
+func fromUnstructured(obj interface{}, into interface{}) error {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return fmt.Errorf("unexpected object type: %T", obj)
+	}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, into); err != nil {
+		return fmt.Errorf("failed to convert %s %s: %v", u.GetKind(), u.GetName(), err)
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+package netpol
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/cloudnativelabs/kube-router/v2/pkg/apis/policy/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/utils/ptr"
+)
+
+func tAddAdminPolicy(t *testing.T, lister cache.Indexer, obj interface{}) {
+	u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	assert.NoError(t, err)
+	assert.NoError(t, lister.Add(&unstructured.Unstructured{Object: u}))
+}
+
+func newTestAdminPolicyNPC(t *testing.T) *NetworkPolicyController {
+	client := fake.NewSimpleClientset(&v1.NodeList{Items: []v1.Node{*newFakeNode("node", []string{"10.10.10.10"})}})
+	informerFactory, podInformer, nsInformer, netpolInformer := newFakeInformersFromClient(client)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	informerFactory.Start(ctx.Done())
+	cache.WaitForCacheSync(ctx.Done(), podInformer.HasSynced)
+	npc := newUneventfulNetworkPolicyController(podInformer, netpolInformer, nsInformer)
+	npc.ipsetMutex = &sync.Mutex{}
+	npc.anpLister = cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	npc.banpLister = cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+
+	for _, ns := range []string{"app", "monitoring"} {
+		tAddToInformerStore(t, nsInformer, &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: ns,
+			Labels: map[string]string{"kubernetes.io/metadata.name": ns}}})
+	}
+	tAddToInformerStore(t, podInformer, &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "app", Labels: map[string]string{"app": "web"}},
+		Spec: v1.PodSpec{Containers: []v1.Container{{Ports: []v1.ContainerPort{
+			{Name: "metrics", ContainerPort: 9090, Protocol: v1.ProtocolTCP}}}}},
+		Status: v1.PodStatus{HostIP: "10.10.10.10", PodIP: "10.1.0.5", PodIPs: []v1.PodIP{{IP: "10.1.0.5"}},
+			Phase: v1.PodRunning},
+	})
+	tAddToInformerStore(t, podInformer, &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "prometheus", Namespace: "monitoring"},
+		Status: v1.PodStatus{HostIP: "10.10.10.11", PodIP: "10.1.1.5", PodIPs: []v1.PodIP{{IP: "10.1.1.5"}},
+			Phase: v1.PodRunning},
+	})
+
+	allNamespaces := &metav1.LabelSelector{}
+	monitoring := &metav1.LabelSelector{MatchLabels: map[string]string{"kubernetes.io/metadata.name": "monitoring"}}
+	tAddAdminPolicy(t, npc.anpLister, &v1alpha1.AdminNetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "deny-internet"},
+		Spec: v1alpha1.AdminNetworkPolicySpec{
+			Priority: 20,
+			Subject:  v1alpha1.AdminNetworkPolicySubject{Namespaces: allNamespaces},
+			Egress: []v1alpha1.AdminNetworkPolicyEgressRule{{Name: "deny-all", Action: "Deny",
+				To: []v1alpha1.AdminNetworkPolicyEgressPeer{{Networks: []string{"0.0.0.0/0"}}}}},
+		},
+	})
+	tAddAdminPolicy(t, npc.anpLister, &v1alpha1.AdminNetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "allow-monitoring"},
+		Spec: v1alpha1.AdminNetworkPolicySpec{
+			Priority: 10,
+			Subject:  v1alpha1.AdminNetworkPolicySubject{Namespaces: allNamespaces},
+			Ingress: []v1alpha1.AdminNetworkPolicyIngressRule{{Name: "scrape", Action: "Allow",
+				From:  []v1alpha1.AdminNetworkPolicyIngressPeer{{Namespaces: monitoring}},
+				Ports: &[]v1alpha1.AdminNetworkPolicyPort{{NamedPort: ptr.To("metrics")}}}},
+		},
+	})
+	tAddAdminPolicy(t, npc.banpLister, &v1alpha1.BaselineAdminNetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "default"},
+		Spec: v1alpha1.BaselineAdminNetworkPolicySpec{
+			Subject: v1alpha1.AdminNetworkPolicySubject{Namespaces: allNamespaces},
+			Ingress: []v1alpha1.BaselineAdminNetworkPolicyIngressRule{{Name: "deny-all", Action: "Deny",
+				From: []v1alpha1.AdminNetworkPolicyIngressPeer{{Namespaces: allNamespaces}}}},
+		},
+	})
+	return npc
+}
+
+func TestNetworkPolicyController_buildAdminNetworkPoliciesInfo(t *testing.T) {
+	npc := newTestAdminPolicyNPC(t)
+	tAddAdminPolicy(t, npc.banpLister, &v1alpha1.BaselineAdminNetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "not-default"},
+	})
+
+	adminPolicies, err := npc.buildAdminNetworkPoliciesInfo()
+	assert.NoError(t, err)
+	// admin network policies are ordered by priority and followed by the only valid baseline policy
+	if !assert.Len(t, adminPolicies, 3) {
+		return
+	}
+	assert.Equal(t, "allow-monitoring", adminPolicies[0].name)
+	assert.Equal(t, "deny-internet", adminPolicies[1].name)
+	assert.True(t, adminPolicies[2].baseline)
+	assert.Same(t, &adminPolicies[2], baselineAdminPolicy(adminPolicies))
+
+	assert.Len(t, adminPolicies[0].targetPods, 2)
+	scrape := adminPolicies[0].ingressRules[0]
+	if assert.Len(t, scrape.peerPods, 1) {
+		assert.Equal(t, "prometheus", scrape.peerPods[0].name)
+	}
+	// named ports of ingress rules are resolved against the subject pods
+	assert.False(t, scrape.matchAllPorts)
+	if assert.Len(t, scrape.namedPorts, 1) {
+		assert.Equal(t, protocolAndPort{protocol: "TCP", port: "9090"}, scrape.namedPorts[0].protocolAndPort)
+		assert.Equal(t, []string{"10.1.0.5"}, scrape.namedPorts[0].ips[v1.IPv4Protocol])
+	}
+
+	denyAll := adminPolicies[1].egressRules[0]
+	assert.True(t, denyAll.matchAllPorts)
+	assert.Empty(t, denyAll.peerPods)
+	assert.Equal(t, []string{"0.0.0.0/1", "128.0.0.0/1"}, denyAll.peerNetworks[v1.IPv4Protocol])
+}
+
+func TestNetworkPolicyController_syncAdminNetworkPolicyChains(t *testing.T) {
+	npc := newTestAdminPolicyNPC(t)
+	adminPolicies, err := npc.buildAdminNetworkPoliciesInfo()
+	assert.NoError(t, err)
+
+	activePolicyChains, activePolicyIPSets, err := npc.syncAdminNetworkPolicyChains(adminPolicies, "1")
+	assert.NoError(t, err)
+	assert.Len(t, activePolicyChains, 3)
+	assert.Len(t, activePolicyIPSets, 4)
+
+	rules := npc.filterTableRules[v1.IPv4Protocol].String()
+	allowChain := adminNetworkPolicyChainName("allow-monitoring", false, adminPolicyIngress, "1", v1.IPv4Protocol)
+	denyChain := adminNetworkPolicyChainName("deny-internet", false, adminPolicyEgress, "1", v1.IPv4Protocol)
+	for _, expected := range []string{
+		":" + allowChain + "\n",
+		"-A " + allowChain + " -m comment --comment \"rule 0 (scrape) of admin network policy allow-monitoring: " +
+			"Allow\" -m set --match-set " +
+			adminPolicyRuleIPSetName(adminPolicies[0], adminPolicyIngress, 0, "pod", v1.IPv4Protocol) +
+			" src -m set --match-set " +
+			adminPolicyRuleIPSetName(adminPolicies[0], adminPolicyIngress, 0, "namedport0", v1.IPv4Protocol) +
+			" dst -p TCP --dport 9090 -j MARK --set-xmark 0x50000/0x50000",
+		"-m mark --mark 0x50000/0x50000 -j RETURN",
+		"-A " + denyChain + " -m comment --comment \"rule 0 (deny-all) of admin network policy deny-internet: " +
+			"Deny\" -m set --match-set " +
+			adminPolicyRuleIPSetName(adminPolicies[1], adminPolicyEgress, 0, "networks", v1.IPv4Protocol) +
+			" dst -m limit --limit 10/minute --limit-burst 10 -j NFLOG --nflog-group 100",
+		" dst -j REJECT",
+	} {
+		assert.Contains(t, rules, expected)
+	}
+}
+
+func TestNetworkPolicyController_setupPodNetpolRulesWithAdminPolicies(t *testing.T) {
+	npc := newTestAdminPolicyNPC(t)
+	adminPolicies, err := npc.buildAdminNetworkPoliciesInfo()
+	assert.NoError(t, err)
+
+	pod := podInfo{ip: "10.1.0.5", ips: []v1.PodIP{{IP: "10.1.0.5"}}, name: "web", namespace: "app"}
+	podFwChainName := podFirewallChainName(pod.namespace, pod.name, "1")
+	npc.filterTableRules[v1.IPv4Protocol] = &bytes.Buffer{}
+	npc.setupPodNetpolRules(pod, podFwChainName, nil, adminPolicies, "1")
+
+	// rules are inserted at the top of the chain, so the last inserted rule is evaluated first
+	var inserted []string
+	for _, rule := range strings.Split(strings.TrimSpace(npc.filterTableRules[v1.IPv4Protocol].String()), "\n") {
+		inserted = append([]string{rule}, inserted...)
+	}
+	jumps := make([]string, 0)
+	for _, rule := range inserted {
+		jumps = append(jumps, strings.TrimSpace(rule[strings.LastIndex(rule, "-j ")+3:]))
+	}
+	assert.Equal(t, []string{
+		"ACCEPT",
+		"DROP",
+		"ACCEPT",
+		adminNetworkPolicyChainName("allow-monitoring", false, adminPolicyIngress, "1", v1.IPv4Protocol),
+		adminNetworkPolicyChainName("deny-internet", false, adminPolicyEgress, "1", v1.IPv4Protocol),
+		adminNetworkPolicyChainName("default", true, adminPolicyIngress, "1", v1.IPv4Protocol),
+		kubeDefaultNetpolChain,
+		kubeDefaultNetpolChain,
+	}, jumps)
+	assert.Contains(t, inserted[3], "-d 10.1.0.5 -m mark ! --mark 0x40000/0x40000")
+	assert.Contains(t, inserted[4], "-s 10.1.0.5 -m mark ! --mark 0x40000/0x40000")
+	assert.Contains(t, inserted[5], "-d 10.1.0.5 -m mark ! --mark 0x10000/0x10000")
+}
+
+func TestNetworkPolicyController_renderNFTableWithAdminPolicies(t *testing.T) {
+	npc := newTestAdminPolicyNPC(t)
+	npc.serviceNodePortRange = "30000:32767"
+	adminPolicies, err := npc.buildAdminNetworkPoliciesInfo()
+	assert.NoError(t, err)
+
+	table := npc.renderNFTable(nil, adminPolicies, "1")
+	var buf bytes.Buffer
+	table.Render(&buf)
+	rendered := buf.String()
+
+	allowChain := adminNetworkPolicyChainName("allow-monitoring", false, adminPolicyIngress, "1", v1.IPv4Protocol)
+	baselineChain := adminNetworkPolicyChainName("default", true, adminPolicyIngress, "1", v1.IPv4Protocol)
+	networksSet := nftSetName(adminPolicyRuleIPSetName(adminPolicies[1], adminPolicyEgress, 0, "networks",
+		v1.IPv4Protocol))
+	assert.True(t, table.HasChain(allowChain), "missing admin network policy chain")
+	assert.True(t, table.HasSet(networksSet), "missing networks set")
+
+	for _, expected := range []string{
+		"meta l4proto tcp th dport 9090 meta mark set meta mark | 0x50000 return",
+		"ip daddr @" + networksSet + " limit rate 10/minute burst 10 packets log prefix \"" +
+			adminNetworkPolicyChainName("deny-internet", false, adminPolicyEgress, "1", v1.IPv4Protocol) +
+			"\" group 100",
+		"ip daddr @" + networksSet + " reject",
+		"meta mark & 0x40000 != 0x40000 ip daddr 10.1.0.5 jump " + allowChain,
+		"meta mark & 0x10000 != 0x10000 ip daddr 10.1.0.5 jump " + baselineChain,
+		"meta mark set meta mark & 0xfffbffff",
+	} {
+		if !strings.Contains(rendered, expected) {
+			t.Errorf("expected rendered nftables table to contain %q, got:\n%s", expected, rendered)
+		}
+	}
+
+	// the baseline policy is evaluated after the admin network policies and before the default chain
+	podChain := podFirewallChainName("app", "web", "1")
+	podChainRules := rendered[strings.Index(rendered, "chain "+podChain):]
+	assert.Less(t, strings.Index(podChainRules, "jump "+allowChain), strings.Index(podChainRules, "jump "+baselineChain))
+	assert.Less(t, strings.Index(podChainRules, "jump "+baselineChain),
+		strings.Index(podChainRules, "jump "+kubeDefaultNetpolChain))
+}
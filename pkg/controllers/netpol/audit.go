@@ -0,0 +1,123 @@
+package netpol
+
+import (
+	"bytes"
+	"strings"
+
+	api "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	"k8s.io/klog/v2"
+)
+
+// NetworkPolicies in audit mode are evaluated like enforced ones, but the traffic of the pods they select that they
+// would drop is logged to NFLOG with the auditLogPrefix in front of the pod firewall chain name and then let through.
+// Audit mode is set per direction of a pod: as soon as an enforced NetworkPolicy applies to a direction, the
+// NetworkPolicies in audit mode are not evaluated for it, since promoting them could only allow more traffic.
+
+const (
+	// netpolModeAnnotation sets the mode of a NetworkPolicy, or of all NetworkPolicies of a Namespace
+	netpolModeAnnotation = "kube-router.io/netpol.mode"
+	netpolModeAudit      = "audit"
+	netpolModeEnforce    = "enforce"
+
+	auditLogPrefix = "AUDIT:"
+)
+
+// isNetworkPolicyAudited returns whether the NetworkPolicy is in audit mode, the annotation of the NetworkPolicy takes
+// precedence over the one of its Namespace
+func (npc *NetworkPolicyController) isNetworkPolicyAudited(policy *networking.NetworkPolicy) bool {
+	if mode, ok := netpolMode(policy.Annotations, "NetworkPolicy "+policy.Namespace+"/"+policy.Name); ok {
+		return mode == netpolModeAudit
+	}
+
+	obj, exists, err := npc.nsLister.GetByKey(policy.Namespace)
+	if err != nil || !exists {
+		return false
+	}
+	namespace, ok := obj.(*api.Namespace)
+	if !ok {
+		return false
+	}
+	mode, _ := netpolMode(namespace.Annotations, "Namespace "+namespace.Name)
+	return mode == netpolModeAudit
+}
+
+// netpolMode returns the mode that the annotations set and whether they set one, unknown modes are enforced
+func netpolMode(annotations map[string]string, object string) (string, bool) {
+	mode, ok := annotations[netpolModeAnnotation]
+	if !ok {
+		return "", false
+	}
+	switch mode = strings.ToLower(strings.TrimSpace(mode)); mode {
+	case netpolModeAudit, netpolModeEnforce:
+		return mode, true
+	default:
+		klog.Warningf("%s has an invalid %s annotation %q, expected %q or %q, enforcing its network policies",
+			object, netpolModeAnnotation, annotations[netpolModeAnnotation], netpolModeAudit, netpolModeEnforce)
+		return netpolModeEnforce, true
+	}
+}
+
+// appliesTo returns whether the network policy selects the ingress and egress traffic of the pod
+func (policy networkPolicyInfo) appliesTo(pod podInfo) (ingress bool, egress bool) {
+	if _, ok := policy.targetPods[pod.ip]; !ok {
+		return false, false
+	}
+	ingress = policy.policyType == kubeBothPolicyType || policy.policyType == kubeIngressPolicyType
+	egress = policy.policyType == kubeBothPolicyType || policy.policyType == kubeEgressPolicyType
+	return ingress, egress
+}
+
+// podAuditedDirections returns whether the ingress and egress traffic of the pod is only selected by network policies
+// in audit mode
+func podAuditedDirections(pod podInfo, networkPoliciesInfo []networkPolicyInfo) (ingress bool, egress bool) {
+	auditedIngress, auditedEgress := false, false
+	enforcedIngress, enforcedEgress := false, false
+	for _, policy := range networkPoliciesInfo {
+		policyIngress, policyEgress := policy.appliesTo(pod)
+		if policy.audit {
+			auditedIngress = auditedIngress || policyIngress
+			auditedEgress = auditedEgress || policyEgress
+		} else {
+			enforcedIngress = enforcedIngress || policyIngress
+			enforcedEgress = enforcedEgress || policyEgress
+		}
+	}
+	return auditedIngress && !enforcedIngress, auditedEgress && !enforcedEgress
+}
+
+// evaluatedDirections returns whether the network policy is evaluated for the ingress and egress traffic of the pod,
+// given the directions of the pod that are audited
+func (policy networkPolicyInfo) evaluatedDirections(pod podInfo, auditIngress, auditEgress bool) (ingress bool,
+	egress bool) {
+	ingress, egress = policy.appliesTo(pod)
+	return ingress && policy.audit == auditIngress, egress && policy.audit == auditEgress
+}
+
+// auditUnmarkedTrafficRules appends the rules that log the traffic of the audited directions of the pod that no
+// network policy permitted, and then mark it as permitted. The NFLOG rules are rate limited by limitArgs.
+func auditUnmarkedTrafficRules(filterTableRules *bytes.Buffer, pod podInfo, podFwChainName, ip string,
+	auditIngress, auditEgress bool, limitArgs []string) {
+	for _, direction := range []struct {
+		audited bool
+		match   string
+		name    string
+	}{{auditIngress, "-d", kubeIngressPolicyType}, {auditEgress, "-s", kubeEgressPolicyType}} {
+		if !direction.audited {
+			continue
+		}
+		comment := "\"rule to log " + direction.name + " traffic that network policies in audit mode would drop " +
+			"POD name:" + pod.name + " namespace: " + pod.namespace + "\""
+		args := []string{"-A", podFwChainName, direction.match, ip, "-m", "comment", "--comment", comment,
+			"-m", "mark", "!", "--mark", "0x10000/0x10000", "-j", "NFLOG",
+			"--nflog-group", "100", "--nflog-prefix", auditLogPrefix + podFwChainName}
+		args = append(append(args, limitArgs...), "\n")
+		filterTableRules.WriteString(strings.Join(args, " "))
+
+		comment = "\"rule to permit " + direction.name + " traffic that network policies in audit mode would drop " +
+			"POD name:" + pod.name + " namespace: " + pod.namespace + "\""
+		args = []string{"-A", podFwChainName, direction.match, ip, "-m", "comment", "--comment", comment,
+			"-m", "mark", "!", "--mark", "0x10000/0x10000", "-j", "MARK", "--set-xmark", "0x10000/0x10000", "\n"}
+		filterTableRules.WriteString(strings.Join(args, " "))
+	}
+}
@@ -0,0 +1,140 @@
+package netpol
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestNetworkPolicyController_isNetworkPolicyAudited(t *testing.T) {
+	npc := &NetworkPolicyController{nsLister: cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})}
+	for _, ns := range []*v1.Namespace{
+		{ObjectMeta: metav1.ObjectMeta{Name: "audited", Annotations: map[string]string{netpolModeAnnotation: "audit"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "enforced"}},
+	} {
+		assert.NoError(t, npc.nsLister.Add(ns))
+	}
+
+	testCases := []struct {
+		name       string
+		namespace  string
+		annotation string
+		expected   bool
+	}{
+		{"namespace without annotation", "enforced", "", false},
+		{"namespace in audit mode", "audited", "", true},
+		{"policy in audit mode", "enforced", "Audit", true},
+		{"policy enforced in namespace in audit mode", "audited", "enforce", false},
+		{"invalid mode is enforced", "audited", "dry-run", false},
+		{"unknown namespace", "missing", "", false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			policy := &networking.NetworkPolicy{ObjectMeta: metav1.ObjectMeta{Name: "policy", Namespace: tc.namespace}}
+			if tc.annotation != "" {
+				policy.Annotations = map[string]string{netpolModeAnnotation: tc.annotation}
+			}
+			assert.Equal(t, tc.expected, npc.isNetworkPolicyAudited(policy))
+		})
+	}
+}
+
+func Test_podAuditedDirections(t *testing.T) {
+	pod := podInfo{ip: "10.1.0.5", ips: []v1.PodIP{{IP: "10.1.0.5"}}, name: "web", namespace: "app"}
+	targetPods := map[string]podInfo{pod.ip: pod}
+	auditBoth := networkPolicyInfo{name: "audit-both", policyType: kubeBothPolicyType, targetPods: targetPods,
+		audit: true}
+	enforceEgress := networkPolicyInfo{name: "enforce-egress", policyType: kubeEgressPolicyType,
+		targetPods: targetPods}
+	otherPod := networkPolicyInfo{name: "other", policyType: kubeIngressPolicyType,
+		targetPods: map[string]podInfo{"10.1.0.6": {}}}
+
+	auditIngress, auditEgress := podAuditedDirections(pod, []networkPolicyInfo{auditBoth, enforceEgress, otherPod})
+	assert.True(t, auditIngress)
+	assert.False(t, auditEgress)
+
+	// the policy in audit mode is only evaluated for the ingress traffic, as the egress traffic is enforced
+	ingress, egress := auditBoth.evaluatedDirections(pod, auditIngress, auditEgress)
+	assert.Equal(t, []bool{true, false}, []bool{ingress, egress})
+	ingress, egress = enforceEgress.evaluatedDirections(pod, auditIngress, auditEgress)
+	assert.Equal(t, []bool{false, true}, []bool{ingress, egress})
+	ingress, egress = otherPod.evaluatedDirections(pod, auditIngress, auditEgress)
+	assert.Equal(t, []bool{false, false}, []bool{ingress, egress})
+
+	auditIngress, auditEgress = podAuditedDirections(pod, []networkPolicyInfo{enforceEgress})
+	assert.False(t, auditIngress)
+	assert.False(t, auditEgress)
+}
+
+func TestNetworkPolicyController_syncPodFirewallChainsAudit(t *testing.T) {
+	npc := newTestAdminPolicyNPC(t)
+	npc.filterTableRules = map[v1.IPFamily]*bytes.Buffer{v1.IPv4Protocol: {}}
+	targetPods := map[string]podInfo{"10.1.0.5": {}}
+	networkPolicies := []networkPolicyInfo{
+		{name: "audit-both", namespace: "app", policyType: kubeBothPolicyType, targetPods: targetPods, audit: true},
+		{name: "enforce-egress", namespace: "app", policyType: kubeEgressPolicyType, targetPods: targetPods},
+	}
+
+	npc.syncPodFirewallChains(networkPolicies, nil, "1")
+
+	podFwChainName := podFirewallChainName("app", "web", "1")
+	auditChain := networkPolicyChainName("app", "audit-both", "1", v1.IPv4Protocol)
+	enforceChain := networkPolicyChainName("app", "enforce-egress", "1", v1.IPv4Protocol)
+	rules := npc.filterTableRules[v1.IPv4Protocol].String()
+	for _, expected := range []string{
+		"-I " + podFwChainName + " 1 -d 10.1.0.5 -m comment --comment \"run through nw policy audit-both\" -j " +
+			auditChain + " \n",
+		"-I " + podFwChainName + " 1 -s 10.1.0.5 -m comment --comment \"run through nw policy enforce-egress\" -j " +
+			enforceChain + " \n",
+		"-A " + podFwChainName + " -d 10.1.0.5 -m comment --comment \"rule to log ingress traffic that network " +
+			"policies in audit mode would drop POD name:web namespace: app\" -m mark ! --mark 0x10000/0x10000 " +
+			"-j NFLOG --nflog-group 100 --nflog-prefix AUDIT:" + podFwChainName,
+		"-A " + podFwChainName + " -d 10.1.0.5 -m comment --comment \"rule to permit ingress traffic that network " +
+			"policies in audit mode would drop POD name:web namespace: app\" -m mark ! --mark 0x10000/0x10000 " +
+			"-j MARK --set-xmark 0x10000/0x10000 \n",
+	} {
+		assert.Contains(t, rules, expected)
+	}
+	assert.NotContains(t, rules, "-s 10.1.0.5 -m comment --comment \"rule to log egress traffic")
+	assert.NotContains(t, rules, "default ingress network policy chain")
+	// the audit rules come before the rules that log and reject the traffic that is still unmarked
+	assert.Less(t, strings.Index(rules, "AUDIT:"), strings.Index(rules, "-j REJECT"))
+}
+
+func Test_dropLoggerLogAudit(t *testing.T) {
+	pod := podInfo{ip: "10.1.0.5", ips: []v1.PodIP{{IP: "10.1.0.5"}}, name: "web", namespace: "app"}
+	var out bytes.Buffer
+	l := newDropLogger(1, false)
+	l.out = &out
+	l.update(map[string]dropLogChain{"KUBE-POD-FW-WEB": {pod: &pod, ingressPolicies: []string{"app/web"}}},
+		map[string]podInfo{"10.1.0.5": pod})
+
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	pkt, _ := decodeDroppedPacket(tIPv4TCPPacket("10.1.1.5", "10.1.0.5", 200, 80))
+	pkt.prefix = "KUBE-POD-FW-WEB"
+	l.log(pkt, now)
+	// audited packets are rate limited separately from the dropped ones
+	pkt.prefix = auditLogPrefix + "KUBE-POD-FW-WEB"
+	l.log(pkt, now)
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if !assert.Len(t, lines, 2) {
+		return
+	}
+	var entry dropLogEntry
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+	assert.False(t, entry.Audit)
+	entry = dropLogEntry{}
+	assert.NoError(t, json.Unmarshal([]byte(lines[1]), &entry))
+	assert.Equal(t, dropLogEntry{Time: "2024-01-02T03:04:05Z", Direction: "ingress", Namespace: "app", Pod: "web",
+		Chain: "KUBE-POD-FW-WEB", Audit: true, Policies: []string{"app/web"}, Protocol: "TCP", SrcIP: "10.1.1.5",
+		SrcPort: 200, DstIP: "10.1.0.5", DstPort: 80}, entry)
+}
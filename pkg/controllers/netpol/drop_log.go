@@ -0,0 +1,487 @@
+package netpol
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/v2/pkg/metrics"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+	api "k8s.io/api/core/v1"
+	"k8s.io/client-go/util/flowcontrol"
+	"k8s.io/klog/v2"
+)
+
+// The NFLOG rules that precede the rules that reject traffic carry the name of their chain as prefix. When
+// --netpol-drop-log is set, the controller binds to their NFLOG group over netlink, decodes the headers of every
+// packet that is logged and writes one JSON line per packet to stdout, attributed to the pod and the policies that
+// the chain was rendered for.
+
+const (
+	nflogGroup = 100
+	// nflogCopyRange is the number of bytes of each packet that the kernel copies to us, it covers the IP header and
+	// the ports of the transport header
+	nflogCopyRange = 128
+
+	// from linux/netfilter/nfnetlink.h and linux/netfilter/nfnetlink_log.h
+	nfnlSubsysULOG   = 4
+	nfulnlMsgPacket  = 0
+	nfulnlMsgConfig  = 1
+	nfulaPayload     = 9
+	nfulaPrefix      = 10
+	nfulaCfgCmd      = 1
+	nfulaCfgMode     = 2
+	nfulnlCfgCmdBind = 1
+	nfulnlCopyPacket = 2
+)
+
+// dropLogChain describes a chain whose NFLOG rule logs dropped packets. Pod firewall chains belong to a single pod and
+// name the network policies that apply to it for each direction, admin network policy chains are shared by all of the
+// pods the policy applies to, so the pod is found by the address of the packet.
+type dropLogChain struct {
+	pod             *podInfo
+	ingressPolicies []string
+	egressPolicies  []string
+
+	direction   string
+	adminPolicy string
+}
+
+type droppedPacket struct {
+	prefix   string
+	protocol string
+	src      net.IP
+	dst      net.IP
+	srcPort  uint16
+	dstPort  uint16
+}
+
+type dropLogEntry struct {
+	Time      string   `json:"time"`
+	Direction string   `json:"direction,omitempty"`
+	Namespace string   `json:"namespace,omitempty"`
+	Pod       string   `json:"pod,omitempty"`
+	Chain     string   `json:"chain"`
+	Audit     bool     `json:"audit,omitempty"`
+	Policies  []string `json:"policies,omitempty"`
+	Protocol  string   `json:"protocol"`
+	SrcIP     string   `json:"srcIP"`
+	SrcPort   uint16   `json:"srcPort,omitempty"`
+	DstIP     string   `json:"dstIP"`
+	DstPort   uint16   `json:"dstPort,omitempty"`
+}
+
+// dropLogger attributes the packets logged to NFLOG and logs them with a rate limit per pod and direction
+type dropLogger struct {
+	mu       sync.Mutex
+	chains   map[string]dropLogChain
+	pods     map[string]podInfo
+	limiters map[string]flowcontrol.RateLimiter
+
+	rate           float64
+	metricsEnabled bool
+	out            io.Writer
+}
+
+func newDropLogger(rate float64, metricsEnabled bool) *dropLogger {
+	return &dropLogger{
+		chains:         make(map[string]dropLogChain),
+		pods:           make(map[string]podInfo),
+		limiters:       make(map[string]flowcontrol.RateLimiter),
+		rate:           rate,
+		metricsEnabled: metricsEnabled,
+		out:            os.Stdout,
+	}
+}
+
+// update replaces the chains and the local pods by IP that logged packets are attributed to
+func (l *dropLogger) update(chains map[string]dropLogChain, pods map[string]podInfo) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.metricsEnabled {
+		// delete the series of the pods that are gone, so that the metrics don't pile up either
+		current := dropLogPods(chains, pods)
+		for pod := range dropLogPods(l.chains, l.pods) {
+			if current[pod] {
+				continue
+			}
+			for _, direction := range []string{adminPolicyIngress, adminPolicyEgress} {
+				metrics.ControllerPolicyDroppedPackets.DeleteLabelValues(pod.namespace, pod.name, direction)
+				metrics.ControllerPolicyAuditedPackets.DeleteLabelValues(pod.namespace, pod.name, direction)
+			}
+		}
+	}
+	l.chains, l.pods = chains, pods
+	// forget the rate limits of the pods that are gone, so that the limiters don't pile up
+	l.limiters = make(map[string]flowcontrol.RateLimiter, len(l.limiters))
+}
+
+type dropLogPod struct {
+	namespace string
+	name      string
+}
+
+// dropLogPods returns the pods that packets logged by the chains can be attributed to
+func dropLogPods(chains map[string]dropLogChain, pods map[string]podInfo) map[dropLogPod]bool {
+	result := make(map[dropLogPod]bool, len(pods))
+	for _, pod := range pods {
+		result[dropLogPod{namespace: pod.namespace, name: pod.name}] = true
+	}
+	for _, chain := range chains {
+		if chain.pod != nil {
+			result[dropLogPod{namespace: chain.pod.namespace, name: chain.pod.name}] = true
+		}
+	}
+	return result
+}
+
+// nflogLimitArgs returns the iptables match that limits the packets that NFLOG rules log. The kernel limit is only
+// used without --netpol-drop-log, the drop logger needs every packet to count them and rate limits the log lines
+// itself.
+func (npc *NetworkPolicyController) nflogLimitArgs() []string {
+	if npc.dropLogger != nil {
+		return nil
+	}
+	return []string{"-m", "limit", "--limit", "10/minute", "--limit-burst", "10"}
+}
+
+// nftLogLimit is the nftables counterpart of nflogLimitArgs
+func (npc *NetworkPolicyController) nftLogLimit() []string {
+	if npc.dropLogger != nil {
+		return nil
+	}
+	return []string{"limit rate 10/minute burst 10 packets"}
+}
+
+// run logs the packets of the NFLOG group until stopCh is closed
+func (l *dropLogger) run(stopCh <-chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	sock, err := nflogSubscribe(nflogGroup)
+	if err != nil {
+		klog.Errorf("Failed to listen on NFLOG group %d, packets dropped by network policies are not logged: %v",
+			nflogGroup, err)
+		return
+	}
+	go func() {
+		<-stopCh
+		sock.Close()
+	}()
+
+	klog.Infof("Logging packets dropped by network policies from NFLOG group %d", nflogGroup)
+	for {
+		msgs, _, err := sock.Receive()
+		if err != nil {
+			select {
+			case <-stopCh:
+				klog.Info("Shutting down network policy drop logger")
+				return
+			default:
+			}
+			if errors.Is(err, unix.ENOBUFS) {
+				klog.Warning("NFLOG socket buffer overflowed, some dropped packets were not logged")
+				continue
+			}
+			klog.Errorf("Failed to receive from NFLOG group %d, no longer logging dropped packets: %v",
+				nflogGroup, err)
+			return
+		}
+		for _, msg := range msgs {
+			pkt, err := parseNFLogMessage(msg)
+			if err != nil {
+				klog.V(2).Infof("Failed to decode NFLOG message: %v", err)
+				continue
+			}
+			if pkt != nil {
+				l.log(pkt, time.Now())
+			}
+		}
+	}
+}
+
+func (l *dropLogger) log(pkt *droppedPacket, now time.Time) {
+	entry := l.attribute(pkt)
+	entry.Time = now.UTC().Format(time.RFC3339Nano)
+
+	if l.metricsEnabled {
+		counter := metrics.ControllerPolicyDroppedPackets
+		if entry.Audit {
+			counter = metrics.ControllerPolicyAuditedPackets
+		}
+		counter.WithLabelValues(entry.Namespace, entry.Pod, entry.Direction).Inc()
+	}
+	if !l.allow(entry.Namespace + "/" + entry.Pod + "/" + entry.Direction + "/" + strconv.FormatBool(entry.Audit)) {
+		return
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		klog.Errorf("Failed to marshal dropped packet: %v", err)
+		return
+	}
+	if _, err = l.out.Write(append(line, '\n')); err != nil {
+		klog.Errorf("Failed to log dropped packet: %v", err)
+	}
+}
+
+// attribute finds the pod, the direction and the policies of a dropped packet from the chain that logged it, packets
+// that network policies in audit mode would drop are logged by pod firewall chains with the audit prefix
+func (l *dropLogger) attribute(pkt *droppedPacket) dropLogEntry {
+	chainName, audit := strings.CutPrefix(pkt.prefix, auditLogPrefix)
+	entry := dropLogEntry{Chain: chainName, Audit: audit, Protocol: pkt.protocol, SrcIP: pkt.src.String(),
+		SrcPort: pkt.srcPort, DstIP: pkt.dst.String(), DstPort: pkt.dstPort}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var pod podInfo
+	var found bool
+	chain, ok := l.chains[chainName]
+	switch {
+	case ok && chain.pod != nil:
+		pod, found = *chain.pod, true
+		entry.Direction, entry.Policies = adminPolicyEgress, chain.egressPolicies
+		for _, ip := range pod.ips {
+			if ip.IP == entry.DstIP {
+				entry.Direction, entry.Policies = adminPolicyIngress, chain.ingressPolicies
+			}
+		}
+	case ok:
+		entry.Direction, entry.Policies = chain.direction, []string{chain.adminPolicy}
+		if chain.direction == adminPolicyIngress {
+			pod, found = l.pods[entry.DstIP]
+		} else {
+			pod, found = l.pods[entry.SrcIP]
+		}
+	default:
+		// the packet was logged by a chain of a previous sync or one that we don't know about
+		if pod, found = l.pods[entry.DstIP]; found {
+			entry.Direction = adminPolicyIngress
+		} else if pod, found = l.pods[entry.SrcIP]; found {
+			entry.Direction = adminPolicyEgress
+		}
+	}
+	if found {
+		entry.Namespace, entry.Pod = pod.namespace, pod.name
+	}
+	return entry
+}
+
+// allow returns whether a dropped packet of the pod and direction that the key identifies may be logged
+func (l *dropLogger) allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	limiter, ok := l.limiters[key]
+	if !ok {
+		burst := int(l.rate)
+		if burst < 1 {
+			burst = 1
+		}
+		limiter = flowcontrol.NewTokenBucketRateLimiter(float32(l.rate), burst)
+		l.limiters[key] = limiter
+	}
+	return limiter.TryAccept()
+}
+
+// dropLogChains returns the chains with NFLOG rules that the drop logger attributes packets to and the local pods by
+// their IPs
+func (npc *NetworkPolicyController) dropLogChains(networkPoliciesInfo []networkPolicyInfo,
+	adminPoliciesInfo []adminNetworkPolicyInfo, version string) (map[string]dropLogChain, map[string]podInfo) {
+	chains := make(map[string]dropLogChain)
+	pods := make(map[string]podInfo)
+
+	allLocalPods := make(map[string]podInfo)
+	for _, nodeIP := range npc.krNode.GetNodeIPAddrs() {
+		npc.getLocalPods(allLocalPods, nodeIP.String())
+	}
+	for _, pod := range allLocalPods {
+		chain := dropLogChain{pod: &pod}
+		auditIngress, auditEgress := podAuditedDirections(pod, networkPoliciesInfo)
+		for _, policy := range networkPoliciesInfo {
+			ingress, egress := policy.evaluatedDirections(pod, auditIngress, auditEgress)
+			name := policy.namespace + "/" + policy.name
+			if ingress {
+				chain.ingressPolicies = append(chain.ingressPolicies, name)
+			}
+			if egress {
+				chain.egressPolicies = append(chain.egressPolicies, name)
+			}
+		}
+		sort.Strings(chain.ingressPolicies)
+		sort.Strings(chain.egressPolicies)
+		chains[podFirewallChainName(pod.namespace, pod.name, version)] = chain
+		for _, ip := range pod.ips {
+			pods[ip.IP] = pod
+		}
+	}
+
+	for _, policy := range adminPoliciesInfo {
+		for ipFamily := range npc.filterTableRules {
+			for _, direction := range []string{adminPolicyIngress, adminPolicyEgress} {
+				chainName := adminNetworkPolicyChainName(policy.name, policy.baseline, direction, version, ipFamily)
+				chains[chainName] = dropLogChain{direction: direction, adminPolicy: policy.kind() + " " + policy.name}
+			}
+		}
+	}
+
+	return chains, pods
+}
+
+// nflogSubscribe binds a netfilter netlink socket to the NFLOG group and asks for the packet headers to be copied
+func nflogSubscribe(group uint16) (*nl.NetlinkSocket, error) {
+	sock, err := nl.Subscribe(unix.NETLINK_NETFILTER)
+	if err != nil {
+		return nil, err
+	}
+
+	mode := make([]byte, 6)
+	binary.BigEndian.PutUint32(mode, nflogCopyRange)
+	mode[4] = nfulnlCopyPacket
+	for _, attr := range []*nl.RtAttr{
+		nl.NewRtAttr(nfulaCfgCmd, []byte{nfulnlCfgCmdBind}),
+		nl.NewRtAttr(nfulaCfgMode, mode),
+	} {
+		req := nl.NewNetlinkRequest(nfnlSubsysULOG<<8|nfulnlMsgConfig, unix.NLM_F_ACK)
+		// the resource id of the nfgenmsg header is the group in network byte order
+		req.AddRawData([]byte{unix.AF_UNSPEC, nl.NFNETLINK_V0, byte(group >> 8), byte(group)})
+		req.AddData(attr)
+		if err = sock.Send(req); err == nil {
+			err = nflogReceiveAck(sock)
+		}
+		if err != nil {
+			sock.Close()
+			return nil, fmt.Errorf("failed to configure NFLOG group %d: %w", group, err)
+		}
+	}
+	return sock, nil
+}
+
+func nflogReceiveAck(sock *nl.NetlinkSocket) error {
+	for {
+		msgs, _, err := sock.Receive()
+		if err != nil {
+			return err
+		}
+		for _, msg := range msgs {
+			if msg.Header.Type != unix.NLMSG_ERROR {
+				continue
+			}
+			if len(msg.Data) < 4 {
+				return fmt.Errorf("short netlink error message")
+			}
+			if code := int32(nl.NativeEndian().Uint32(msg.Data[0:4])); code != 0 {
+				return syscall.Errno(-code)
+			}
+			return nil
+		}
+	}
+}
+
+// parseNFLogMessage decodes a packet message of the NFLOG group, it returns nil for other messages
+func parseNFLogMessage(msg syscall.NetlinkMessage) (*droppedPacket, error) {
+	if msg.Header.Type != nfnlSubsysULOG<<8|nfulnlMsgPacket {
+		return nil, nil
+	}
+	if len(msg.Data) < nl.SizeofNfgenmsg {
+		return nil, fmt.Errorf("short NFLOG message")
+	}
+	attrs, err := nl.ParseRouteAttr(msg.Data[nl.SizeofNfgenmsg:])
+	if err != nil {
+		return nil, err
+	}
+
+	var prefix string
+	var payload []byte
+	for _, attr := range attrs {
+		switch attr.Attr.Type & nl.NLA_TYPE_MASK {
+		case nfulaPrefix:
+			prefix = string(nullTerminated(attr.Value))
+		case nfulaPayload:
+			payload = attr.Value
+		}
+	}
+	if payload == nil {
+		return nil, fmt.Errorf("NFLOG message without payload")
+	}
+	pkt, err := decodeDroppedPacket(payload)
+	if err != nil {
+		return nil, err
+	}
+	pkt.prefix = prefix
+	return pkt, nil
+}
+
+// decodeDroppedPacket decodes the addresses, the protocol and the ports from the IP header and the start of the
+// transport header of a packet
+func decodeDroppedPacket(payload []byte) (*droppedPacket, error) {
+	if len(payload) == 0 {
+		return nil, fmt.Errorf("empty packet")
+	}
+	pkt := &droppedPacket{}
+	var protocol uint8
+	var transport []byte
+	switch payload[0] >> 4 {
+	case 4:
+		headerLen := int(payload[0]&0x0f) * 4
+		if len(payload) < 20 || headerLen < 20 || len(payload) < headerLen {
+			return nil, fmt.Errorf("short IPv4 packet")
+		}
+		protocol = payload[9]
+		pkt.src = append(net.IP{}, payload[12:16]...)
+		pkt.dst = append(net.IP{}, payload[16:20]...)
+		transport = payload[headerLen:]
+	case 6:
+		if len(payload) < 40 {
+			return nil, fmt.Errorf("short IPv6 packet")
+		}
+		protocol = payload[6]
+		pkt.src = append(net.IP{}, payload[8:24]...)
+		pkt.dst = append(net.IP{}, payload[24:40]...)
+		transport = payload[40:]
+	default:
+		return nil, fmt.Errorf("unknown IP version %d", payload[0]>>4)
+	}
+
+	switch protocol {
+	case unix.IPPROTO_TCP, unix.IPPROTO_UDP, unix.IPPROTO_SCTP:
+		if len(transport) >= 4 {
+			pkt.srcPort = binary.BigEndian.Uint16(transport[0:2])
+			pkt.dstPort = binary.BigEndian.Uint16(transport[2:4])
+		}
+	}
+	switch protocol {
+	case unix.IPPROTO_ICMP:
+		pkt.protocol = "ICMP"
+	case unix.IPPROTO_ICMPV6:
+		pkt.protocol = "ICMPv6"
+	case unix.IPPROTO_TCP:
+		pkt.protocol = string(api.ProtocolTCP)
+	case unix.IPPROTO_UDP:
+		pkt.protocol = string(api.ProtocolUDP)
+	case unix.IPPROTO_SCTP:
+		pkt.protocol = string(api.ProtocolSCTP)
+	default:
+		pkt.protocol = strconv.Itoa(int(protocol))
+	}
+	return pkt, nil
+}
+
+func nullTerminated(b []byte) []byte {
+	for i, c := range b {
+		if c == 0 {
+			return b[:i]
+		}
+	}
+	return b
+}
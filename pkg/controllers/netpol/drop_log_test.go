@@ -0,0 +1,172 @@
+package netpol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/v2/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink/nl"
+	v1 "k8s.io/api/core/v1"
+)
+
+func tIPv4TCPPacket(src, dst string, srcPort, dstPort uint16) []byte {
+	pkt := make([]byte, 24)
+	pkt[0] = 0x45
+	pkt[9] = syscall.IPPROTO_TCP
+	copy(pkt[12:16], net.ParseIP(src).To4())
+	copy(pkt[16:20], net.ParseIP(dst).To4())
+	binary.BigEndian.PutUint16(pkt[20:22], srcPort)
+	binary.BigEndian.PutUint16(pkt[22:24], dstPort)
+	return pkt
+}
+
+func Test_decodeDroppedPacket(t *testing.T) {
+	pkt, err := decodeDroppedPacket(tIPv4TCPPacket("10.1.1.5", "10.1.0.5", 200, 80))
+	assert.NoError(t, err)
+	assert.Equal(t, &droppedPacket{protocol: "TCP", src: net.ParseIP("10.1.1.5").To4(),
+		dst: net.ParseIP("10.1.0.5").To4(), srcPort: 200, dstPort: 80}, pkt)
+
+	ipv6 := make([]byte, 48)
+	ipv6[0] = 0x60
+	ipv6[6] = syscall.IPPROTO_UDP
+	copy(ipv6[8:24], net.ParseIP("fd00::1"))
+	copy(ipv6[24:40], net.ParseIP("fd00::2"))
+	ipv6[43] = 53
+	pkt, err = decodeDroppedPacket(ipv6)
+	assert.NoError(t, err)
+	assert.Equal(t, "UDP", pkt.protocol)
+	assert.Equal(t, "fd00::1", pkt.src.String())
+	assert.Equal(t, uint16(53), pkt.dstPort)
+
+	_, err = decodeDroppedPacket([]byte{0x45, 0, 0})
+	assert.Error(t, err)
+}
+
+func Test_parseNFLogMessage(t *testing.T) {
+	data := []byte{syscall.AF_INET, nl.NFNETLINK_V0, 0, nflogGroup}
+	data = append(data, nl.NewRtAttr(nfulaPrefix, nl.ZeroTerminated("KUBE-POD-FW-TEST")).Serialize()...)
+	data = append(data, nl.NewRtAttr(nfulaPayload, tIPv4TCPPacket("10.1.1.5", "10.1.0.5", 200, 80)).Serialize()...)
+	msg := syscall.NetlinkMessage{Header: syscall.NlMsghdr{Type: nfnlSubsysULOG<<8 | nfulnlMsgPacket}, Data: data}
+
+	pkt, err := parseNFLogMessage(msg)
+	assert.NoError(t, err)
+	if assert.NotNil(t, pkt) {
+		assert.Equal(t, "KUBE-POD-FW-TEST", pkt.prefix)
+		assert.Equal(t, uint16(80), pkt.dstPort)
+	}
+
+	msg.Header.Type = nfnlSubsysULOG<<8 | nfulnlMsgConfig
+	pkt, err = parseNFLogMessage(msg)
+	assert.NoError(t, err)
+	assert.Nil(t, pkt)
+}
+
+func TestNetworkPolicyController_dropLogChains(t *testing.T) {
+	npc := newTestAdminPolicyNPC(t)
+	adminPolicies, err := npc.buildAdminNetworkPoliciesInfo()
+	assert.NoError(t, err)
+	networkPolicies := []networkPolicyInfo{{name: "web", namespace: "app", policyType: kubeIngressPolicyType,
+		targetPods: map[string]podInfo{"10.1.0.5": {ip: "10.1.0.5"}}}}
+
+	chains, pods := npc.dropLogChains(networkPolicies, adminPolicies, "1")
+	// only the pods of the node are attributed to, the prometheus pod runs on another node
+	assert.Len(t, pods, 1)
+	podChain := chains[podFirewallChainName("app", "web", "1")]
+	if assert.NotNil(t, podChain.pod) {
+		assert.Equal(t, "web", podChain.pod.name)
+	}
+	assert.Equal(t, []string{"app/web"}, podChain.ingressPolicies)
+	assert.Empty(t, podChain.egressPolicies)
+	assert.Equal(t, dropLogChain{direction: adminPolicyEgress, adminPolicy: "admin network policy deny-internet"},
+		chains[adminNetworkPolicyChainName("deny-internet", false, adminPolicyEgress, "1", v1.IPv4Protocol)])
+}
+
+func Test_dropLoggerLog(t *testing.T) {
+	pod := podInfo{ip: "10.1.0.5", ips: []v1.PodIP{{IP: "10.1.0.5"}}, name: "web", namespace: "app"}
+	var out bytes.Buffer
+	l := newDropLogger(1, false)
+	l.out = &out
+	l.update(map[string]dropLogChain{
+		"KUBE-POD-FW-WEB":      {pod: &pod, ingressPolicies: []string{"app/web"}},
+		"KUBE-NWPLCY-DENYALL":  {direction: adminPolicyEgress, adminPolicy: "admin network policy deny-internet"},
+		"KUBE-NWPLCY-UNRELATE": {direction: adminPolicyIngress, adminPolicy: "admin network policy unrelated"},
+	}, map[string]podInfo{"10.1.0.5": pod})
+
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	ingress, _ := decodeDroppedPacket(tIPv4TCPPacket("10.1.1.5", "10.1.0.5", 200, 80))
+	ingress.prefix = "KUBE-POD-FW-WEB"
+	l.log(ingress, now)
+	// the second packet of the same pod and direction within a second is rate limited
+	l.log(ingress, now)
+	egress, _ := decodeDroppedPacket(tIPv4TCPPacket("10.1.0.5", "1.1.1.1", 200, 443))
+	egress.prefix = "KUBE-NWPLCY-DENYALL"
+	l.log(egress, now)
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if !assert.Len(t, lines, 2) {
+		return
+	}
+	var entry dropLogEntry
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+	assert.Equal(t, dropLogEntry{Time: "2024-01-02T03:04:05Z", Direction: "ingress", Namespace: "app", Pod: "web",
+		Chain: "KUBE-POD-FW-WEB", Policies: []string{"app/web"}, Protocol: "TCP", SrcIP: "10.1.1.5", SrcPort: 200,
+		DstIP: "10.1.0.5", DstPort: 80}, entry)
+	assert.NoError(t, json.Unmarshal([]byte(lines[1]), &entry))
+	assert.Equal(t, "egress", entry.Direction)
+	assert.Equal(t, "web", entry.Pod)
+	assert.Equal(t, []string{"admin network policy deny-internet"}, entry.Policies)
+}
+
+func Test_dropLoggerUpdateDeletesMetrics(t *testing.T) {
+	web := podInfo{ip: "10.1.0.5", name: "web", namespace: "app"}
+	db := podInfo{ip: "10.1.0.6", name: "db", namespace: "app"}
+	l := newDropLogger(1, true)
+	l.update(map[string]dropLogChain{"KUBE-POD-FW-WEB": {pod: &web}, "KUBE-POD-FW-DB": {pod: &db}},
+		map[string]podInfo{"10.1.0.5": web, "10.1.0.6": db})
+	metrics.ControllerPolicyDroppedPackets.WithLabelValues("app", "web", adminPolicyIngress).Inc()
+	metrics.ControllerPolicyAuditedPackets.WithLabelValues("app", "web", adminPolicyEgress).Inc()
+	metrics.ControllerPolicyDroppedPackets.WithLabelValues("app", "db", adminPolicyIngress).Inc()
+	t.Cleanup(func() {
+		metrics.ControllerPolicyDroppedPackets.Reset()
+		metrics.ControllerPolicyAuditedPackets.Reset()
+	})
+
+	// the series of the web pod go away with its chain, the ones of the db pod are kept
+	l.update(map[string]dropLogChain{"KUBE-POD-FW-DB": {pod: &db}}, map[string]podInfo{"10.1.0.6": db})
+	assert.Equal(t, 1, tCountSeries(metrics.ControllerPolicyDroppedPackets))
+	assert.Equal(t, 0, tCountSeries(metrics.ControllerPolicyAuditedPackets))
+	assert.False(t, metrics.ControllerPolicyDroppedPackets.DeleteLabelValues("app", "web", adminPolicyIngress))
+	assert.True(t, metrics.ControllerPolicyDroppedPackets.DeleteLabelValues("app", "db", adminPolicyIngress))
+}
+
+func tCountSeries(collector prometheus.Collector) int {
+	ch := make(chan prometheus.Metric, 16)
+	collector.Collect(ch)
+	close(ch)
+	return len(ch)
+}
+
+func TestNetworkPolicyController_nflogLimitArgs(t *testing.T) {
+	npc := &NetworkPolicyController{}
+	assert.Equal(t, []string{"-m", "limit", "--limit", "10/minute", "--limit-burst", "10"}, npc.nflogLimitArgs())
+	assert.Equal(t, []string{"limit rate 10/minute burst 10 packets"}, npc.nftLogLimit())
+
+	// the drop logger counts every dropped packet and only rate limits the lines that it logs
+	npc.dropLogger = newDropLogger(1, false)
+	assert.Empty(t, npc.nflogLimitArgs())
+	assert.Empty(t, npc.nftLogLimit())
+
+	var rules bytes.Buffer
+	auditUnmarkedTrafficRules(&rules, podInfo{name: "web", namespace: "app"}, "KUBE-POD-FW-WEB", "10.1.0.5", true,
+		false, npc.nflogLimitArgs())
+	assert.NotContains(t, rules.String(), "limit")
+	assert.Contains(t, rules.String(), "--nflog-prefix AUDIT:KUBE-POD-FW-WEB \n")
+}
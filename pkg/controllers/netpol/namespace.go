@@ -39,16 +39,22 @@ func (npc *NetworkPolicyController) handleNamespaceAdd(obj *api.Namespace) {
 	}
 	klog.V(2).Infof("Received update for namespace: %s", obj.Name)
 
-	npc.RequestFullSync()
+	npc.handleNamespaceChange(nil, obj.Labels)
 }
 
 func (npc *NetworkPolicyController) handleNamespaceUpdate(oldObj, newObj *api.Namespace) {
-	if reflect.DeepEqual(oldObj.Labels, newObj.Labels) {
+	if reflect.DeepEqual(oldObj.Labels, newObj.Labels) &&
+		oldObj.Annotations[netpolModeAnnotation] == newObj.Annotations[netpolModeAnnotation] {
 		return
 	}
 	klog.V(2).Infof("Received update for namespace: %s", newObj.Name)
 
-	npc.RequestFullSync()
+	// the mode of the network policies of the namespace changes which pod firewall chains evaluate them
+	if oldObj.Annotations[netpolModeAnnotation] != newObj.Annotations[netpolModeAnnotation] {
+		npc.RequestFullSync()
+		return
+	}
+	npc.handleNamespaceChange(oldObj.Labels, newObj.Labels)
 }
 
 func (npc *NetworkPolicyController) handleNamespaceDelete(obj *api.Namespace) {
@@ -57,5 +63,5 @@ func (npc *NetworkPolicyController) handleNamespaceDelete(obj *api.Namespace) {
 	}
 	klog.V(2).Infof("Received namespace: %s delete event", obj.Name)
 
-	npc.RequestFullSync()
+	npc.handleNamespaceChange(obj.Labels, nil)
 }
@@ -40,6 +40,8 @@ const (
 	kubeBothPolicyType    = "both"
 
 	syncVersionBase = 10
+
+	clusterIPRangeComment = "allow traffic to primary/secondary cluster IP range"
 )
 
 var (
@@ -75,18 +77,33 @@ type NetworkPolicyController struct {
 	fullSyncRequestChan         chan struct{}
 	ipsetMutex                  *sync.Mutex
 
+	// targeted syncs of network policies, see targeted_sync.go. syncVersion and podFwChainDeps describe the chains
+	// of the last full sync and are only set while they are installed.
+	policySyncRequestChan chan struct{}
+	pendingPolicySyncMu   sync.Mutex
+	pendingPolicySync     map[string]bool
+	syncVersion           string
+	podFwChainDeps        map[string]string
+
 	iptablesCmdHandlers map[v1core.IPFamily]utils.IPTablesHandler
 	iptablesSaveRestore map[v1core.IPFamily]utils.IPTablesSaveRestorer
 	filterTableRules    map[v1core.IPFamily]*bytes.Buffer
 	ipSetHandlers       map[v1core.IPFamily]utils.IPSetHandler
-
-	podLister cache.Indexer
-	npLister  cache.Indexer
-	nsLister  cache.Indexer
-
-	PodEventHandler           cache.ResourceEventHandler
-	NamespaceEventHandler     cache.ResourceEventHandler
-	NetworkPolicyEventHandler cache.ResourceEventHandler
+	nftTable            *utils.NFTablesSharedTable
+	dropLogger          *dropLogger
+
+	podLister         cache.Indexer
+	npLister          cache.Indexer
+	nsLister          cache.Indexer
+	serviceCIDRLister cache.Indexer
+	anpLister         cache.Indexer
+	banpLister        cache.Indexer
+
+	PodEventHandler                cache.ResourceEventHandler
+	NamespaceEventHandler          cache.ResourceEventHandler
+	NetworkPolicyEventHandler      cache.ResourceEventHandler
+	ServiceCIDREventHandler        cache.ResourceEventHandler
+	AdminNetworkPolicyEventHandler cache.ResourceEventHandler
 }
 
 // internal structure to represent a network policy
@@ -106,6 +123,9 @@ type networkPolicyInfo struct {
 
 	// policy type "ingress" or "egress" or "both" as defined by PolicyType in the spec
 	policyType string
+
+	// whether the traffic that the policy would drop is only logged, as set by the netpol mode annotation
+	audit bool
 }
 
 // internal structure to represent Pod
@@ -162,18 +182,28 @@ func (npc *NetworkPolicyController) Run(healthChan chan<- *healthcheck.Controlle
 	klog.Info("Starting network policy controller")
 	npc.healthChan = healthChan
 
-	// setup kube-router specific top level custom chains (KUBE-ROUTER-INPUT, KUBE-ROUTER-FORWARD, KUBE-ROUTER-OUTPUT)
-	npc.ensureTopLevelChains()
+	// when the nftables backend is used the top level chains and the default network policy chain are part of the
+	// kube-router table and are rendered as part of every full sync
+	if npc.nftTable == nil {
+		// setup kube-router specific top level custom chains (KUBE-ROUTER-INPUT, KUBE-ROUTER-FORWARD,
+		// KUBE-ROUTER-OUTPUT)
+		npc.ensureTopLevelChains()
 
-	// setup default network policy chain that is applied to traffic from/to the pods that does not match any network
-	// policy
-	npc.ensureDefaultNetworkPolicyChain()
+		// setup default network policy chain that is applied to traffic from/to the pods that does not match any
+		// network policy
+		npc.ensureDefaultNetworkPolicyChain()
+	}
+
+	if npc.dropLogger != nil {
+		wg.Add(1)
+		go npc.dropLogger.run(stopCh, wg)
+	}
 
 	// Full syncs of the network policy controller take a lot of time and can only be processed one at a time,
 	// therefore, we start it in it's own goroutine and request a sync through a single item channel
 	klog.Info("Starting network policy controller full sync goroutine")
 	wg.Add(1)
-	go func(fullSyncRequest <-chan struct{}, stopCh <-chan struct{}, wg *sync.WaitGroup) {
+	go func(fullSyncRequest, policySyncRequest <-chan struct{}, stopCh <-chan struct{}, wg *sync.WaitGroup) {
 		defer wg.Done()
 		for {
 			// Add an additional non-blocking select to ensure that if the stopCh channel is closed it is handled first
@@ -190,9 +220,12 @@ func (npc *NetworkPolicyController) Run(healthChan chan<- *healthcheck.Controlle
 			case <-fullSyncRequest:
 				klog.V(3).Info("Received request for a full sync, processing")
 				npc.fullPolicySync() // fullPolicySync() is a blocking request here
+			case <-policySyncRequest:
+				klog.V(3).Info("Received request for a targeted policy sync, processing")
+				npc.targetedPolicySync()
 			}
 		}
-	}(npc.fullSyncRequestChan, stopCh, wg)
+	}(npc.fullSyncRequestChan, npc.policySyncRequestChan, stopCh, wg)
 
 	// loop forever till notified to stop on stopCh
 	for {
@@ -225,20 +258,19 @@ func (npc *NetworkPolicyController) fullPolicySync() {
 	npc.mu.Lock()
 	defer npc.mu.Unlock()
 
-	for ipFamily := range npc.ipSetHandlers {
-		// Ensure that we start with clean handlers that don't contain previous save data
-		var err error
-		//nolint:exhaustive // we don't need a default condition here because we control this ourselves
-		switch ipFamily {
-		case v1core.IPv4Protocol:
-			npc.ipSetHandlers[ipFamily], err = utils.NewIPSet(false)
-		case v1core.IPv6Protocol:
-			npc.ipSetHandlers[ipFamily], err = utils.NewIPSet(true)
-		}
-		if err != nil {
-			klog.Errorf("failed to create ipset handler: %v", err)
-			return
-		}
+	// a full sync covers the network policies pending a targeted sync, the chains of the previous full sync are
+	// replaced so no targeted sync can happen until this one completed
+	npc.takePendingPolicySync()
+	npc.syncVersion = ""
+
+	if npc.nftTable != nil {
+		npc.fullPolicySyncNFTables()
+		return
+	}
+
+	if err := npc.resetIPSetHandlers(); err != nil {
+		klog.Errorf("failed to create ipset handler: %v", err)
+		return
 	}
 
 	healthcheck.SendHeartBeat(npc.healthChan, healthcheck.NetworkPolicyController)
@@ -267,6 +299,12 @@ func (npc *NetworkPolicyController) fullPolicySync() {
 		return
 	}
 
+	adminPoliciesInfo, err := npc.buildAdminNetworkPoliciesInfo()
+	if err != nil {
+		klog.Errorf("Aborting sync. Failed to build admin network policies: %v", err.Error())
+		return
+	}
+
 	for ipFamily, iptablesSaveRestore := range npc.iptablesSaveRestore {
 		npc.filterTableRules[ipFamily].Reset()
 		saveStart := time.Now()
@@ -295,7 +333,19 @@ func (npc *NetworkPolicyController) fullPolicySync() {
 		return
 	}
 
-	activePodFwChains := npc.syncPodFirewallChains(networkPoliciesInfo, syncVersion)
+	adminPolicyChains, adminPolicyIPSets, err := npc.syncAdminNetworkPolicyChains(adminPoliciesInfo, syncVersion)
+	if err != nil {
+		klog.Errorf("Aborting sync. Failed to sync admin network policy chains: %v", err.Error())
+		return
+	}
+	for chain := range adminPolicyChains {
+		activePolicyChains[chain] = true
+	}
+	for ipSet := range adminPolicyIPSets {
+		activePolicyIPSets[ipSet] = true
+	}
+
+	activePodFwChains := npc.syncPodFirewallChains(networkPoliciesInfo, adminPoliciesInfo, syncVersion)
 
 	// Makes sure that the ACCEPT rules for packets marked with "0x20000" are added to the end of each of kube-router's
 	// top level chains
@@ -329,6 +379,13 @@ func (npc *NetworkPolicyController) fullPolicySync() {
 		}
 	}
 
+	npc.syncVersion = syncVersion
+	npc.podFwChainDeps = npc.podFwChainDependencies(networkPoliciesInfo)
+
+	if npc.dropLogger != nil {
+		npc.dropLogger.update(npc.dropLogChains(networkPoliciesInfo, adminPoliciesInfo, syncVersion))
+	}
+
 	err = npc.cleanupStaleIPSets(activePolicyIPSets)
 	if err != nil {
 		klog.Errorf("Failed to cleanup stale ipsets: %v", err.Error())
@@ -336,6 +393,24 @@ func (npc *NetworkPolicyController) fullPolicySync() {
 	}
 }
 
+// resetIPSetHandlers replaces the ipset handlers with clean ones that don't contain previous save data
+func (npc *NetworkPolicyController) resetIPSetHandlers() error {
+	for ipFamily := range npc.ipSetHandlers {
+		var err error
+		//nolint:exhaustive // we don't need a default condition here because we control this ourselves
+		switch ipFamily {
+		case v1core.IPv4Protocol:
+			npc.ipSetHandlers[ipFamily], err = utils.NewIPSet(false)
+		case v1core.IPv6Protocol:
+			npc.ipSetHandlers[ipFamily], err = utils.NewIPSet(true)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (npc *NetworkPolicyController) iptablesCmdHandlerForCIDR(cidr *net.IPNet) (utils.IPTablesHandler, error) {
 	if netutils.IsIPv4CIDR(cidr) {
 		return npc.iptablesCmdHandlers[v1core.IPv4Protocol], nil
@@ -353,7 +428,7 @@ func (npc *NetworkPolicyController) allowTrafficToClusterIPRange(
 	addUUIDForRuleSpec func(chain string, ruleSpec *[]string) (string, error),
 	ensureRuleAtPosition func(iptablesCmdHandler utils.IPTablesHandler,
 		chain string, ruleSpec []string, uuid string, position int),
-	comment string) {
+	comment string) string {
 	whitelistServiceVips := []string{"-m", "comment", "--comment", comment,
 		"-d", serviceClusterIPRange.String(), "-j", "RETURN"}
 	uuid, err := addUUIDForRuleSpec(kubeInputChainName, &whitelistServiceVips)
@@ -366,6 +441,42 @@ func (npc *NetworkPolicyController) allowTrafficToClusterIPRange(
 	}
 	ensureRuleAtPosition(iptablesCmdHandler,
 		kubeInputChainName, whitelistServiceVips, uuid, serviceVIPPosition)
+	return uuid
+}
+
+// deleteStaleClusterIPRangeRules deletes the rules that allow traffic to cluster IP ranges which aren't in use anymore,
+// like the range of a ServiceCIDR that was deleted, from the top level INPUT chain
+func (npc *NetworkPolicyController) deleteStaleClusterIPRangeRules(activeUUIDs map[string]bool) {
+	for family, handler := range npc.iptablesCmdHandlers {
+		rules, err := handler.List("filter", kubeInputChainName)
+		if err != nil {
+			klog.Errorf("failed to list rules in filter table %s chain due to %s", kubeInputChainName, err.Error())
+			continue
+		}
+
+		var staleRuleNos []int
+		var ruleIndexOffset int
+		for i, rule := range rules {
+			if strings.HasPrefix(rule, "-P") || strings.HasPrefix(rule, "-N") {
+				ruleIndexOffset++
+				continue
+			}
+			_, uuid, found := strings.Cut(strings.ReplaceAll(rule, "\"", ""), clusterIPRangeComment+" - ")
+			if !found || len(uuid) < 16 || activeUUIDs[uuid[:16]] {
+				continue
+			}
+			staleRuleNos = append(staleRuleNos, i+1-ruleIndexOffset)
+		}
+
+		// delete from the bottom up so that the numbers of the remaining stale rules don't change
+		for i := len(staleRuleNos) - 1; i >= 0; i-- {
+			klog.V(2).Infof("Deleting stale rule #%d allowing traffic to a cluster IP range for family: %s",
+				staleRuleNos[i], family)
+			if err = handler.Delete("filter", kubeInputChainName, strconv.Itoa(staleRuleNos[i])); err != nil {
+				klog.Errorf("failed to delete stale rule in %s chain due to %s", kubeInputChainName, err.Error())
+			}
+		}
+	}
 }
 
 // Creates custom chains KUBE-ROUTER-INPUT, KUBE-ROUTER-FORWARD, KUBE-ROUTER-OUTPUT
@@ -466,7 +577,9 @@ func (npc *NetworkPolicyController) ensureTopLevelChains() {
 	}
 
 	if len(npc.serviceClusterIPRanges) > 0 {
-		for idx, serviceRange := range npc.serviceClusterIPRanges {
+		clusterIPRanges := npc.clusterIPRanges()
+		activeUUIDs := make(map[string]bool, len(clusterIPRanges))
+		for idx, serviceRange := range clusterIPRanges {
 			var family v1core.IPFamily
 			if serviceRange.IP.To4() != nil {
 				family = v1core.IPv4Protocol
@@ -475,10 +588,12 @@ func (npc *NetworkPolicyController) ensureTopLevelChains() {
 			}
 			klog.V(2).Infof("Allow traffic to ingress towards Cluster IP Range: %s for family: %s",
 				serviceRange.String(), family)
-			npc.allowTrafficToClusterIPRange(rulePosition[family], &npc.serviceClusterIPRanges[idx],
-				addUUIDForRuleSpec, ensureRuleAtPosition, "allow traffic to primary/secondary cluster IP range")
+			uuid := npc.allowTrafficToClusterIPRange(rulePosition[family], &clusterIPRanges[idx],
+				addUUIDForRuleSpec, ensureRuleAtPosition, clusterIPRangeComment)
+			activeUUIDs[uuid] = true
 			rulePosition[family]++
 		}
+		npc.deleteStaleClusterIPRangeRules(activeUUIDs)
 	} else {
 		klog.Fatalf("Primary service cluster IP range is not configured")
 	}
@@ -509,6 +624,19 @@ func (npc *NetworkPolicyController) ensureTopLevelChains() {
 		ensureRuleAtPosition(handler,
 			kubeInputChainName, whitelistUDPNodeports, uuid, rulePosition[family])
 		rulePosition[family]++
+
+		whitelistSCTPNodeports := []string{"-p", "sctp", "-m", "comment", "--comment",
+			"allow LOCAL SCTP traffic to node ports", "-m", "addrtype", "--dst-type", "LOCAL",
+			"-m", "multiport", "--dports", npc.serviceNodePortRange, "-j", "RETURN"}
+		uuid, err = addUUIDForRuleSpec(kubeInputChainName, &whitelistSCTPNodeports)
+		if err != nil {
+			klog.Fatalf("Failed to get uuid for rule: %s", err.Error())
+		}
+		klog.V(2).Infof("Allow SCTP traffic to ingress towards node port range: %s for family: %s",
+			npc.serviceNodePortRange, family)
+		ensureRuleAtPosition(handler,
+			kubeInputChainName, whitelistSCTPNodeports, uuid, rulePosition[family])
+		rulePosition[family]++
 	}
 
 	for idx, externalIPRange := range npc.serviceExternalIPRanges {
@@ -738,6 +866,14 @@ func (npc *NetworkPolicyController) cleanupStaleIPSets(activePolicyIPSets map[st
 func (npc *NetworkPolicyController) Cleanup() {
 	klog.Info("Cleaning up NetworkPolicyController configurations...")
 
+	// Remove the kube-router table in case the nftables firewall backend was used, nodes that never had nft installed
+	// can't have the table so a missing binary isn't an error here
+	if nftablesHandler, err := utils.NewNFTables(); err == nil {
+		if err = nftablesHandler.DeleteTable(utils.NFTablesFamilyInet, utils.KubeRouterNFTable); err != nil {
+			klog.Errorf("error encountered attempting to delete nftables table %s: %v", utils.KubeRouterNFTable, err)
+		}
+	}
+
 	if len(npc.iptablesCmdHandlers) < 1 {
 		iptablesCmdHandlers, ipSetHandlers, err := NewIPTablesHandlers(nil)
 		if err != nil {
@@ -830,15 +966,19 @@ func NewIPTablesHandlers(config *options.KubeRouterConfig) (
 func NewNetworkPolicyController(clientset kubernetes.Interface,
 	config *options.KubeRouterConfig, podInformer cache.SharedIndexInformer,
 	npInformer cache.SharedIndexInformer, nsInformer cache.SharedIndexInformer,
-	ipsetMutex *sync.Mutex, linkQ utils.LocalLinkQuerier,
+	serviceCIDRInformer cache.SharedIndexInformer, anpInformer cache.SharedIndexInformer,
+	banpInformer cache.SharedIndexInformer, ipsetMutex *sync.Mutex, linkQ utils.LocalLinkQuerier,
 	iptablesCmdHandlers map[v1core.IPFamily]utils.IPTablesHandler,
-	ipSetHandlers map[v1core.IPFamily]utils.IPSetHandler) (*NetworkPolicyController, error) {
+	ipSetHandlers map[v1core.IPFamily]utils.IPSetHandler,
+	nftTable *utils.NFTablesSharedTable) (*NetworkPolicyController, error) {
 	npc := NetworkPolicyController{ipsetMutex: ipsetMutex}
 
 	// Creating a single-item buffered channel to ensure that we only keep a single full sync request at a time,
 	// additional requests would be pointless to queue since after the first one was processed the system would already
 	// be up to date with all of the policy changes from any enqueued request after that
 	npc.fullSyncRequestChan = make(chan struct{}, 1)
+	npc.policySyncRequestChan = make(chan struct{}, 1)
+	npc.pendingPolicySync = make(map[string]bool)
 
 	// Validate and parse ClusterIP service range
 	if len(config.ClusterIPCIDRs) == 0 {
@@ -896,6 +1036,20 @@ func NewNetworkPolicyController(clientset kubernetes.Interface,
 		return nil, err
 	}
 
+	// Validate the firewall backend
+	switch config.FirewallBackend {
+	case options.FirewallBackendIPTables, "":
+	case options.FirewallBackendNFTables:
+		if nftTable == nil {
+			return nil, fmt.Errorf("--firewall-backend=%s requires the shared kube-router nftables table",
+				config.FirewallBackend)
+		}
+		npc.nftTable = nftTable
+	default:
+		return nil, fmt.Errorf("failed to parse --firewall-backend parameter: '%s' is not one of %s or %s",
+			config.FirewallBackend, options.FirewallBackendIPTables, options.FirewallBackendNFTables)
+	}
+
 	// Validate and parse ExternalIP service range
 	for _, externalIPRange := range config.ExternalIPCIDRs {
 		_, ipnet, err := net.ParseCIDR(externalIPRange)
@@ -928,9 +1082,20 @@ func NewNetworkPolicyController(clientset kubernetes.Interface,
 		metrics.DefaultRegisterer.MustRegister(metrics.ControllerPolicyIpsetV6RestoreTime)
 		metrics.DefaultRegisterer.MustRegister(metrics.ControllerPolicyChains)
 		metrics.DefaultRegisterer.MustRegister(metrics.ControllerPolicyIpsets)
+		if config.NetpolDropLog {
+			metrics.DefaultRegisterer.MustRegister(metrics.ControllerPolicyDroppedPackets,
+				metrics.ControllerPolicyAuditedPackets)
+		}
 		npc.MetricsEnabled = true
 	}
 
+	if config.NetpolDropLog {
+		if config.NetpolDropLogRate <= 0 {
+			return nil, fmt.Errorf("failed to parse --netpol-drop-log-rate parameter: the rate must be positive")
+		}
+		npc.dropLogger = newDropLogger(config.NetpolDropLogRate, npc.MetricsEnabled)
+	}
+
 	npc.syncPeriod = config.IPTablesSyncPeriod
 
 	node, err := utils.GetNodeObject(clientset, config.HostnameOverride)
@@ -976,5 +1141,18 @@ func NewNetworkPolicyController(clientset kubernetes.Interface,
 	npc.npLister = npInformer.GetIndexer()
 	npc.NetworkPolicyEventHandler = npc.newNetworkPolicyEventHandler()
 
+	if serviceCIDRInformer != nil {
+		npc.serviceCIDRLister = serviceCIDRInformer.GetIndexer()
+		npc.ServiceCIDREventHandler = npc.newServiceCIDREventHandler()
+	}
+
+	if anpInformer != nil {
+		npc.anpLister = anpInformer.GetIndexer()
+	}
+	if banpInformer != nil {
+		npc.banpLister = banpInformer.GetIndexer()
+	}
+	npc.AdminNetworkPolicyEventHandler = npc.newAdminNetworkPolicyEventHandler()
+
 	return &npc, nil
 }
@@ -953,8 +953,8 @@ func TestNetworkPolicyController(t *testing.T) {
 			iptablesHandlers[v1.IPv4Protocol] = newFakeIPTables(iptables.ProtocolIPv4)
 			ipSetHandlers := make(map[v1.IPFamily]utils.IPSetHandler, 1)
 			ipSetHandlers[v1.IPv4Protocol] = &fakeIPSet{}
-			_, err := NewNetworkPolicyController(client, test.config, podInformer, netpolInformer, nsInformer,
-				&sync.Mutex{}, fakeLinkQuerier, iptablesHandlers, ipSetHandlers)
+			_, err := NewNetworkPolicyController(client, test.config, podInformer, netpolInformer, nsInformer, nil, nil,
+				nil, &sync.Mutex{}, fakeLinkQuerier, iptablesHandlers, ipSetHandlers, nil)
 			if err == nil && test.expectError {
 				t.Error("This config should have failed, but it was successful instead")
 			} else if err != nil {
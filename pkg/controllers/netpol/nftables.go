@@ -0,0 +1,515 @@
+package netpol
+
+import (
+	"bytes"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/v2/pkg/healthcheck"
+	"github.com/cloudnativelabs/kube-router/v2/pkg/metrics"
+	"github.com/cloudnativelabs/kube-router/v2/pkg/utils"
+	api "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	netutils "k8s.io/utils/net"
+)
+
+// When the nftables firewall backend is selected, the network policy controller renders exactly the same top level,
+// per-pod firewall and per-policy chains that it would otherwise render with iptables-restore, but places all of them
+// in its part of the kube-router inet table that it shares with the service proxy. Instead of ipsets, the pod and
+// ipBlock groupings are expressed as nft sets within that table. The part is re-declared on every sync and the table is
+// loaded with a single nft script, so the kernel swaps the old rule set for the new one in one atomic transaction.
+
+const (
+	// nftTableOwner identifies the part of the shared kube-router table that holds the rules of the NPC
+	nftTableOwner = "network-policy"
+
+	// nftExceptSetSuffix is appended to the name of an ipBlock set to name the set that holds the except CIDRs of the
+	// ipBlock, nft sets have no equivalent of the ipset nomatch option
+	nftExceptSetSuffix = "-EXCEPT"
+
+	nftMarkNetpolMatch  = "0x10000"
+	nftMarkNetpolAccept = "0x20000"
+)
+
+// fullPolicySyncNFTables is the nftables equivalent of fullPolicySync, it expects to be called with npc.mu held
+func (npc *NetworkPolicyController) fullPolicySyncNFTables() {
+	healthcheck.SendHeartBeat(npc.healthChan, healthcheck.NetworkPolicyController)
+	start := time.Now()
+	syncVersion := strconv.FormatInt(start.UnixNano(), syncVersionBase)
+	defer func() {
+		endTime := time.Since(start)
+		if npc.MetricsEnabled {
+			metrics.ControllerIptablesSyncTime.Observe(endTime.Seconds())
+		}
+		klog.V(1).Infof("sync nftables took %v", endTime)
+	}()
+
+	klog.V(1).Infof("Starting sync of nftables with version: %s", syncVersion)
+
+	networkPoliciesInfo, err := npc.buildNetworkPoliciesInfo()
+	if err != nil {
+		klog.Errorf("Aborting sync. Failed to build network policies: %v", err.Error())
+		return
+	}
+
+	adminPoliciesInfo, err := npc.buildAdminNetworkPoliciesInfo()
+	if err != nil {
+		klog.Errorf("Aborting sync. Failed to build admin network policies: %v", err.Error())
+		return
+	}
+
+	table := npc.renderNFTable(networkPoliciesInfo, adminPoliciesInfo, syncVersion)
+
+	if err = npc.nftTable.Apply(nftTableOwner, table); err != nil {
+		var script bytes.Buffer
+		table.Render(&script)
+		klog.Errorf("Aborting sync. Failed to apply nftables rules: %v\n%s", err, script.String())
+		return
+	}
+
+	if npc.dropLogger != nil {
+		npc.dropLogger.update(npc.dropLogChains(networkPoliciesInfo, adminPoliciesInfo, syncVersion))
+	}
+}
+
+// renderNFTable builds the in-memory representation of the NPC's complete part of the kube-router nftables table
+func (npc *NetworkPolicyController) renderNFTable(networkPoliciesInfo []networkPolicyInfo,
+	adminPoliciesInfo []adminNetworkPolicyInfo, version string) *utils.NFTablesTable {
+	table := utils.NewNFTablesTable(utils.NFTablesFamilyInet, utils.KubeRouterNFTable)
+
+	npc.nftEnsureTopLevelChains(table)
+	npc.nftEnsureDefaultNetworkPolicyChain(table)
+
+	activePolicyChains, activePolicySets := npc.nftSyncNetworkPolicyChains(table, networkPoliciesInfo, version)
+	for chain := range npc.nftSyncAdminNetworkPolicyChains(table, adminPoliciesInfo, version) {
+		activePolicyChains[chain] = true
+	}
+	for _, set := range table.Sets() {
+		activePolicySets[set] = true
+	}
+	if npc.MetricsEnabled {
+		metrics.ControllerPolicyChains.Set(float64(len(activePolicyChains)))
+		metrics.ControllerPolicyIpsets.Set(float64(len(activePolicySets)))
+	}
+
+	npc.nftSyncPodFirewallChains(table, networkPoliciesInfo, adminPoliciesInfo, version)
+
+	// Makes sure that the ACCEPT rules for packets marked with "0x20000" are added to the end of each of kube-router's
+	// top level chains
+	for _, chain := range []string{kubeInputChainName, kubeForwardChainName, kubeOutputChainName} {
+		table.Chain(chain).Append("meta mark &", nftMarkNetpolAccept, "==", nftMarkNetpolAccept, "accept",
+			utils.NFTablesComment("rule to explicitly ACCEPT traffic that comply to network policies"))
+	}
+
+	return table
+}
+
+// nftIPFamilies returns the IP families that the controller is enabled for in a stable order
+func (npc *NetworkPolicyController) nftIPFamilies() []api.IPFamily {
+	families := make([]api.IPFamily, 0, len(npc.filterTableRules))
+	for ipFamily := range npc.filterTableRules {
+		families = append(families, ipFamily)
+	}
+	sort.Slice(families, func(i, j int) bool { return families[i] < families[j] })
+	return families
+}
+
+// nftEnsureTopLevelChains is the nftables equivalent of ensureTopLevelChains, since the chains are part of the
+// kube-router table they are attached to the netfilter hooks directly instead of being jumped to from builtin chains
+func (npc *NetworkPolicyController) nftEnsureTopLevelChains(table *utils.NFTablesTable) {
+	input := table.BaseChain(kubeInputChainName, "filter", "input", "filter")
+	table.BaseChain(kubeForwardChainName, "filter", "forward", "filter")
+	table.BaseChain(kubeOutputChainName, "filter", "output", "filter")
+
+	for _, serviceRange := range npc.clusterIPRanges() {
+		input.Append(nftAddrFamilyForCIDR(serviceRange), "daddr", serviceRange.String(), "return",
+			utils.NFTablesComment(clusterIPRangeComment))
+	}
+
+	nodePortRange := strings.ReplaceAll(npc.serviceNodePortRange, ":", "-")
+	for _, protocol := range []string{"tcp", "udp", "sctp"} {
+		input.Append("fib daddr type local", protocol, "dport", nodePortRange, "return",
+			utils.NFTablesComment("allow LOCAL "+strings.ToUpper(protocol)+" traffic to node ports"))
+	}
+
+	for _, externalIPRange := range npc.serviceExternalIPRanges {
+		input.Append(nftAddrFamilyForCIDR(externalIPRange), "daddr", externalIPRange.String(), "return",
+			utils.NFTablesComment("allow traffic to external IP range: "+externalIPRange.String()))
+	}
+
+	for _, loadBalancerIPRange := range npc.serviceLoadBalancerIPRanges {
+		input.Append(nftAddrFamilyForCIDR(loadBalancerIPRange), "daddr", loadBalancerIPRange.String(), "return",
+			utils.NFTablesComment("allow traffic to load balancer IP range: "+loadBalancerIPRange.String()))
+	}
+}
+
+// nftEnsureDefaultNetworkPolicyChain is the nftables equivalent of ensureDefaultNetworkPolicyChain
+func (npc *NetworkPolicyController) nftEnsureDefaultNetworkPolicyChain(table *utils.NFTablesTable) {
+	chain := table.Chain(kubeDefaultNetpolChain)
+	for _, ipFamily := range npc.nftIPFamilies() {
+		for _, icmpRule := range utils.CommonICMPRules(ipFamily) {
+			chain.Append(utils.NFTablesICMPMatch(icmpRule), "accept", utils.NFTablesComment(icmpRule.Comment))
+		}
+	}
+	chain.Append("meta mark set meta mark |", nftMarkNetpolMatch,
+		utils.NFTablesComment("rule to mark traffic matching a network policy"))
+}
+
+// nftSyncNetworkPolicyChains is the nftables equivalent of syncNetworkPolicyChains
+func (npc *NetworkPolicyController) nftSyncNetworkPolicyChains(table *utils.NFTablesTable,
+	networkPoliciesInfo []networkPolicyInfo, version string) (map[string]bool, map[string]bool) {
+	activePolicyChains := make(map[string]bool)
+
+	for _, policy := range networkPoliciesInfo {
+		currentPodIPs := make(map[api.IPFamily][]string)
+		for _, pod := range policy.targetPods {
+			for _, ip := range pod.ips {
+				if netutils.IsIPv4String(ip.IP) {
+					currentPodIPs[api.IPv4Protocol] = append(currentPodIPs[api.IPv4Protocol], ip.IP)
+				}
+				if netutils.IsIPv6String(ip.IP) {
+					currentPodIPs[api.IPv6Protocol] = append(currentPodIPs[api.IPv6Protocol], ip.IP)
+				}
+			}
+		}
+
+		for _, ipFamily := range npc.nftIPFamilies() {
+			// ensure there is a unique chain per network policy in the table
+			policyChainName := networkPolicyChainName(policy.namespace, policy.name, version, ipFamily)
+			table.Chain(policyChainName)
+			activePolicyChains[policyChainName] = true
+
+			if policy.policyType == kubeBothPolicyType || policy.policyType == kubeIngressPolicyType {
+				// create a set for all destination pod ip's matched by the policy spec PodSelector
+				targetDestPodIPSetName := policyDestinationPodIPSetName(policy.namespace, policy.name, ipFamily)
+				nftAddIPSet(table, nftSetName(targetDestPodIPSetName), currentPodIPs[ipFamily], ipFamily)
+				nftRenderNetworkPolicyChain(table, policyChainName, policy.ingressChain(targetDestPodIPSetName,
+					ipFamily), ipFamily)
+			}
+			if policy.policyType == kubeBothPolicyType || policy.policyType == kubeEgressPolicyType {
+				// create a set for all source pod ip's matched by the policy spec PodSelector
+				targetSourcePodIPSetName := policySourcePodIPSetName(policy.namespace, policy.name, ipFamily)
+				nftAddIPSet(table, nftSetName(targetSourcePodIPSetName), currentPodIPs[ipFamily], ipFamily)
+				nftRenderNetworkPolicyChain(table, policyChainName, policy.egressChain(targetSourcePodIPSetName,
+					ipFamily), ipFamily)
+			}
+		}
+	}
+
+	activePolicySets := make(map[string]bool)
+	for _, set := range table.Sets() {
+		activePolicySets[set] = true
+	}
+
+	return activePolicyChains, activePolicySets
+}
+
+// nftRenderNetworkPolicyChain is the nftables equivalent of renderNetworkPolicyChain
+func nftRenderNetworkPolicyChain(table *utils.NFTablesTable, policyChainName string, policyChain networkPolicyChain,
+	ipFamily api.IPFamily) {
+	chain := table.Chain(policyChainName)
+	for _, set := range policyChain.sets {
+		if set.setType == utils.TypeHashNet {
+			nftAddIPBlockSet(table, nftSetName(set.name), set.entries, ipFamily)
+			continue
+		}
+		ips := make([]string, 0, len(set.entries))
+		for _, entry := range set.entries {
+			ips = append(ips, entry[0])
+		}
+		nftAddIPSet(table, nftSetName(set.name), ips, ipFamily)
+	}
+	for _, rule := range policyChain.rules {
+		srcSetName, dstSetName := rule.srcSetName, rule.dstSetName
+		if srcSetName != "" {
+			srcSetName = nftSetName(srcSetName)
+		}
+		if dstSetName != "" {
+			dstSetName = nftSetName(dstSetName)
+		}
+		nftAppendPolicyRule(table, chain, rule.comment, srcSetName, dstSetName, rule.portProtocol, ipFamily)
+	}
+}
+
+// nftAppendPolicyRule is the nftables equivalent of appendRuleToPolicyChain, since nft rules can carry more than one
+// statement the mark and the return are combined into a single rule
+func nftAppendPolicyRule(table *utils.NFTablesTable, chain *utils.NFTablesChain, comment, srcSetName,
+	dstSetName string, portProtocol protocolAndPort, ipFamily api.IPFamily) {
+	rule := nftPolicyRuleMatch(table, srcSetName, dstSetName, portProtocol, ipFamily)
+	rule = append(rule, "meta mark set meta mark |", nftMarkNetpolMatch, "return", utils.NFTablesComment(comment))
+	chain.Append(rule...)
+}
+
+// nftPolicyRuleMatch returns the match expressions of a policy rule for the source and destination sets and the port
+func nftPolicyRuleMatch(table *utils.NFTablesTable, srcSetName, dstSetName string, portProtocol protocolAndPort,
+	ipFamily api.IPFamily) []string {
+	addrFamily := utils.NFTablesAddrFamily(ipFamily)
+	rule := make([]string, 0)
+
+	if srcSetName != "" {
+		rule = append(rule, addrFamily, "saddr", "@"+srcSetName)
+		if table.HasSet(srcSetName + nftExceptSetSuffix) {
+			rule = append(rule, addrFamily, "saddr", "!=", "@"+srcSetName+nftExceptSetSuffix)
+		}
+	}
+	if dstSetName != "" {
+		rule = append(rule, addrFamily, "daddr", "@"+dstSetName)
+		if table.HasSet(dstSetName + nftExceptSetSuffix) {
+			rule = append(rule, addrFamily, "daddr", "!=", "@"+dstSetName+nftExceptSetSuffix)
+		}
+	}
+	if portProtocol.protocol != "" {
+		rule = append(rule, "meta l4proto", strings.ToLower(portProtocol.protocol))
+	} else if portProtocol.port != "" {
+		// a port without a protocol needs an explicit transport protocol dependency for the th expression
+		rule = append(rule, "meta l4proto { tcp, udp, sctp }")
+	}
+	if portProtocol.port != "" {
+		if portProtocol.endport != "" {
+			rule = append(rule, "th dport", portProtocol.port+"-"+portProtocol.endport)
+		} else {
+			rule = append(rule, "th dport", portProtocol.port)
+		}
+	}
+	return rule
+}
+
+// nftSyncAdminNetworkPolicyChains is the nftables equivalent of syncAdminNetworkPolicyChains
+func (npc *NetworkPolicyController) nftSyncAdminNetworkPolicyChains(table *utils.NFTablesTable,
+	adminPoliciesInfo []adminNetworkPolicyInfo, version string) map[string]bool {
+	activePolicyChains := make(map[string]bool)
+
+	for _, policy := range adminPoliciesInfo {
+		for _, ipFamily := range npc.nftIPFamilies() {
+			for _, adminChain := range policy.adminPolicyChains(version, ipFamily) {
+				chain := table.Chain(adminChain.name)
+				activePolicyChains[adminChain.name] = true
+
+				for _, set := range adminChain.sets {
+					table.AddSet(nftSetName(set.name), utils.NFTablesAddrType(ipFamily), set.hashNet, set.entries)
+				}
+				for _, rule := range adminChain.rules {
+					match := nftPolicyRuleMatch(table, nftSetName(rule.srcSetName), nftSetName(rule.dstSetName),
+						rule.portProtocol, ipFamily)
+					if mark := adminPolicyActionMark(rule.action); mark != "" {
+						chain.Append(append(match, "meta mark set meta mark |", mark, "return",
+							utils.NFTablesComment(rule.comment))...)
+						continue
+					}
+					chain.Append(append(append(match, npc.nftLogLimit()...), "log prefix",
+						"\""+adminChain.name+"\"", "group 100", utils.NFTablesComment(rule.comment))...)
+					chain.Append(append(match, "reject", utils.NFTablesComment(rule.comment))...)
+				}
+			}
+		}
+	}
+
+	return activePolicyChains
+}
+
+// nftInsertAdminPolicyJump is the nftables equivalent of insertAdminPolicyJump
+func nftInsertAdminPolicyJump(podFwChain *utils.NFTablesChain, pod podInfo, policy adminNetworkPolicyInfo,
+	direction string, version string, ipFamily api.IPFamily, ip string) {
+	if !policy.appliesTo(pod, direction) {
+		return
+	}
+	addrMatch := "daddr"
+	if direction == adminPolicyEgress {
+		addrMatch = "saddr"
+	}
+	mark := policy.adminPolicyJumpMark()
+	podFwChain.Insert("meta mark &", mark, "!=", mark, utils.NFTablesAddrFamily(ipFamily), addrMatch, ip, "jump",
+		adminNetworkPolicyChainName(policy.name, policy.baseline, direction, version, ipFamily),
+		utils.NFTablesComment("run through "+direction+" rules of "+policy.kind()+" "+policy.name))
+}
+
+// nftSyncPodFirewallChains is the nftables equivalent of syncPodFirewallChains
+func (npc *NetworkPolicyController) nftSyncPodFirewallChains(table *utils.NFTablesTable,
+	networkPoliciesInfo []networkPolicyInfo, adminPoliciesInfo []adminNetworkPolicyInfo,
+	version string) map[string]bool {
+	activePodFwChains := make(map[string]bool)
+
+	// loop through the pods running on the node
+	allLocalPods := make(map[string]podInfo)
+	for _, nodeIP := range npc.krNode.GetNodeIPAddrs() {
+		npc.getLocalPods(allLocalPods, nodeIP.String())
+	}
+	podIPs := make([]string, 0, len(allLocalPods))
+	for podIP := range allLocalPods {
+		podIPs = append(podIPs, podIP)
+	}
+	sort.Strings(podIPs)
+
+	for _, podIP := range podIPs {
+		pod := allLocalPods[podIP]
+		podFwChainName := podFirewallChainName(pod.namespace, pod.name, version)
+		podFwChain := table.Chain(podFwChainName)
+		activePodFwChains[podFwChainName] = true
+		auditIngress, auditEgress := podAuditedDirections(pod, networkPoliciesInfo)
+
+		hasPodIP := false
+		for _, ipFamily := range npc.nftIPFamilies() {
+			ip, err := getPodIPForFamily(pod, ipFamily)
+			if err != nil {
+				klog.V(2).Infof("unable to get address for pod: %s -- skipping nftables rules for pod "+
+					"(this is normal for pods that are not dual-stack)", err.Error())
+				continue
+			}
+			addrFamily := utils.NFTablesAddrFamily(ipFamily)
+
+			// setup rules to jump to applicable network policy chains for the traffic from/to the pod, these are
+			// inserted in the same order as setupPodNetpolRules does so that the resulting chain is identical
+			hasIngressPolicy, hasEgressPolicy := false, false
+			for _, policy := range networkPoliciesInfo {
+				ingress, egress := policy.evaluatedDirections(pod, auditIngress, auditEgress)
+				comment := utils.NFTablesComment("run through nw policy " + policy.name)
+				policyChainName := networkPolicyChainName(policy.namespace, policy.name, version, ipFamily)
+				switch {
+				case ingress && egress:
+					hasIngressPolicy, hasEgressPolicy = true, true
+					podFwChain.Insert("jump", policyChainName, comment)
+				case ingress:
+					hasIngressPolicy = true
+					podFwChain.Insert(addrFamily, "daddr", ip, "jump", policyChainName, comment)
+				case egress:
+					hasEgressPolicy = true
+					podFwChain.Insert(addrFamily, "saddr", ip, "jump", policyChainName, comment)
+				}
+			}
+			if !hasIngressPolicy {
+				podFwChain.Insert(addrFamily, "daddr", ip, "jump", kubeDefaultNetpolChain,
+					utils.NFTablesComment("run through default ingress network policy chain"))
+			}
+			if !hasEgressPolicy {
+				podFwChain.Insert(addrFamily, "saddr", ip, "jump", kubeDefaultNetpolChain,
+					utils.NFTablesComment("run through default egress network policy chain"))
+			}
+			if baselinePolicy := baselineAdminPolicy(adminPoliciesInfo); baselinePolicy != nil {
+				if !hasEgressPolicy {
+					nftInsertAdminPolicyJump(podFwChain, pod, *baselinePolicy, adminPolicyEgress, version, ipFamily, ip)
+				}
+				if !hasIngressPolicy {
+					nftInsertAdminPolicyJump(podFwChain, pod, *baselinePolicy, adminPolicyIngress, version, ipFamily,
+						ip)
+				}
+			}
+			for idx := len(adminPoliciesInfo) - 1; idx >= 0; idx-- {
+				if policy := adminPoliciesInfo[idx]; !policy.baseline {
+					nftInsertAdminPolicyJump(podFwChain, pod, policy, adminPolicyEgress, version, ipFamily, ip)
+					nftInsertAdminPolicyJump(podFwChain, pod, policy, adminPolicyIngress, version, ipFamily, ip)
+				}
+			}
+			hasPodIP = true
+
+			// ensure there are rules in the top level chains to jump to the pod specific firewall chain. Unlike with
+			// iptables, there is no need for separate physdev rules for switched traffic, bridged traffic that is
+			// passed to netfilter hits the same inet forward hook as routed traffic does.
+			inboundComment := utils.NFTablesComment("rule to jump traffic destined to POD name:" + pod.name +
+				" namespace: " + pod.namespace + " to chain " + podFwChainName)
+			table.Chain(kubeForwardChainName).Append(addrFamily, "daddr", ip, "jump", podFwChainName, inboundComment)
+			table.Chain(kubeOutputChainName).Append(addrFamily, "daddr", ip, "jump", podFwChainName, inboundComment)
+
+			outboundComment := utils.NFTablesComment("rule to jump traffic from POD name:" + pod.name +
+				" namespace: " + pod.namespace + " to chain " + podFwChainName)
+			for _, chain := range []string{kubeInputChainName, kubeForwardChainName, kubeOutputChainName} {
+				table.Chain(chain).Append(addrFamily, "saddr", ip, "jump", podFwChainName, outboundComment)
+			}
+
+			// the traffic that network policies in audit mode would drop is logged and let through instead, these are
+			// appended ahead of the rules that log and reject the unmarked traffic below
+			nftAuditUnmarkedTrafficRules(podFwChain, pod, podFwChainName, addrFamily, ip, auditIngress, auditEgress,
+				npc.nftLogLimit())
+		}
+
+		// the chain is shared by both families of a dual-stack pod, so the rules that don't depend on the address of
+		// the pod are only inserted once. The chain is only jumped to for traffic from or to the pod and the pod's
+		// addresses are never local to the node, so the source being local implies that the pod is the destination.
+		if hasPodIP {
+			podFwChain.Insert("fib saddr type local accept",
+				utils.NFTablesComment("rule to permit the traffic traffic to pods when source is the pod's local node"))
+			podFwChain.Insert("ct state invalid drop", utils.NFTablesComment("rule to drop invalid state for pod"))
+			podFwChain.Insert("ct state related,established accept",
+				utils.NFTablesComment("rule for stateful firewall for pod"))
+		}
+
+		unmarked := "meta mark & " + nftMarkNetpolMatch + " != " + nftMarkNetpolMatch
+		podFwChain.Append(append(append([]string{unmarked}, npc.nftLogLimit()...), "log prefix",
+			"\""+podFwChainName+"\"", "group 100", utils.NFTablesComment("rule to log dropped traffic POD name:"+
+				pod.name+" namespace: "+pod.namespace))...)
+		podFwChain.Append(unmarked, "reject",
+			utils.NFTablesComment("rule to REJECT traffic destined for POD name:"+pod.name+" namespace: "+
+				pod.namespace))
+		// reset mark to let traffic pass through rest of the chains
+		podFwChain.Append("meta mark set meta mark & 0xfffeffff")
+		if len(adminPoliciesInfo) > 0 {
+			podFwChain.Append("meta mark set meta mark & 0xfffbffff")
+		}
+		// set mark to indicate traffic from/to the pod passed network policies
+		podFwChain.Append("meta mark set meta mark |", nftMarkNetpolAccept,
+			utils.NFTablesComment("set mark to ACCEPT traffic that comply to network policies"))
+	}
+
+	return activePodFwChains
+}
+
+// nftAuditUnmarkedTrafficRules is the nftables equivalent of auditUnmarkedTrafficRules
+func nftAuditUnmarkedTrafficRules(podFwChain *utils.NFTablesChain, pod podInfo, podFwChainName, addrFamily, ip string,
+	auditIngress, auditEgress bool, logLimit []string) {
+	unmarked := "meta mark & " + nftMarkNetpolMatch + " != " + nftMarkNetpolMatch
+	for _, direction := range []struct {
+		audited bool
+		match   string
+		name    string
+	}{{auditIngress, "daddr", kubeIngressPolicyType}, {auditEgress, "saddr", kubeEgressPolicyType}} {
+		if !direction.audited {
+			continue
+		}
+		podFwChain.Append(append(append([]string{addrFamily, direction.match, ip, unmarked}, logLimit...),
+			"log prefix", "\""+auditLogPrefix+podFwChainName+"\"", "group 100",
+			utils.NFTablesComment("rule to log "+direction.name+" traffic that network policies in audit mode would "+
+				"drop POD name:"+pod.name+" namespace: "+pod.namespace))...)
+		podFwChain.Append(addrFamily, direction.match, ip, unmarked, "meta mark set meta mark |", nftMarkNetpolMatch,
+			utils.NFTablesComment("rule to permit "+direction.name+" traffic that network policies in audit mode "+
+				"would drop POD name:"+pod.name+" namespace: "+pod.namespace))
+	}
+}
+
+// nftSetName converts an ipset name into a valid nft set name, as all sets live in the same inet table the family
+// prefix of IPv6 ipset names is kept, but the colon is not allowed in nft identifiers
+func nftSetName(ipSetName string) string {
+	return strings.ReplaceAll(ipSetName, ":", "-")
+}
+
+func nftAddIPSet(table *utils.NFTablesTable, setName string, ips []string, ipFamily api.IPFamily) {
+	table.AddSet(setName, utils.NFTablesAddrType(ipFamily), false, ips)
+}
+
+// nftAddIPBlockSet creates a set for the CIDRs of an ipBlock, entries are given in the ipset entry format that
+// evalIPBlockPeer produces, entries carrying the nomatch option are placed in a separate except set
+func nftAddIPBlockSet(table *utils.NFTablesTable, setName string, entries [][]string, ipFamily api.IPFamily) {
+	cidrs, excepts := make([]string, 0), make([]string, 0)
+	for _, entry := range entries {
+		if len(entry) == 0 {
+			continue
+		}
+		if entry[len(entry)-1] == utils.OptionNoMatch {
+			excepts = append(excepts, entry[0])
+		} else {
+			cidrs = append(cidrs, entry[0])
+		}
+	}
+	table.AddSet(setName, utils.NFTablesAddrType(ipFamily), true, cidrs)
+	if len(excepts) > 0 {
+		table.AddSet(setName+nftExceptSetSuffix, utils.NFTablesAddrType(ipFamily), true, excepts)
+	}
+}
+
+func nftAddrFamilyForCIDR(cidr net.IPNet) string {
+	if netutils.IsIPv6CIDR(&cidr) {
+		return utils.NFTablesFamilyIPv6
+	}
+	return utils.NFTablesFamilyIPv4
+}
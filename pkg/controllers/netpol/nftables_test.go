@@ -0,0 +1,142 @@
+package netpol
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestNetworkPolicyController_renderNFTable(t *testing.T) {
+	client := fake.NewSimpleClientset(&v1.NodeList{Items: []v1.Node{*newFakeNode("node", []string{"10.10.10.10"})}})
+	informerFactory, podInformer, nsInformer, netpolInformer := newFakeInformersFromClient(client)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	informerFactory.Start(ctx.Done())
+	cache.WaitForCacheSync(ctx.Done(), podInformer.HasSynced)
+	npc := newUneventfulNetworkPolicyController(podInformer, netpolInformer, nsInformer)
+	_, clusterIPRange, _ := net.ParseCIDR("10.96.0.0/12")
+	npc.serviceClusterIPRanges = []net.IPNet{*clusterIPRange}
+	npc.serviceCIDRLister = cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	assert.NoError(t, npc.serviceCIDRLister.Add(newTestServiceCIDR("extra", "10.128.0.0/16")))
+	npc.serviceNodePortRange = "30000:32767"
+
+	tAddToInformerStore(t, nsInformer, &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "nsA"}})
+	tAddToInformerStore(t, podInformer, &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "nsA", Labels: map[string]string{"app": "web"}},
+		Status: v1.PodStatus{HostIP: "10.10.10.10", PodIP: "10.1.0.5", PodIPs: []v1.PodIP{{IP: "10.1.0.5"}},
+			Phase: v1.PodRunning},
+	})
+
+	tcp := v1.ProtocolTCP
+	sctp := v1.ProtocolSCTP
+	port := intstr.FromInt(80)
+	sctpPort := intstr.FromInt(3868)
+	netpol := tNetpol{name: "allow-http", namespace: "nsA",
+		podSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+		ingress: []netv1.NetworkPolicyIngressRule{
+			{
+				From: []netv1.NetworkPolicyPeer{
+					{IPBlock: &netv1.IPBlock{CIDR: "192.168.0.0/16", Except: []string{"192.168.1.0/24"}}},
+				},
+				Ports: []netv1.NetworkPolicyPort{{Protocol: &tcp, Port: &port}, {Protocol: &sctp, Port: &sctpPort}},
+			},
+		},
+	}
+	netpol.createFakeNetpol(t, netpolInformer)
+
+	netpols, err := npc.buildNetworkPoliciesInfo()
+	if err != nil {
+		t.Fatalf("Problems building policies: %s", err)
+	}
+	table := npc.renderNFTable(netpols, nil, "1")
+	var buf bytes.Buffer
+	table.Render(&buf)
+	rendered := buf.String()
+
+	policyChain := networkPolicyChainName("nsA", "allow-http", "1", v1.IPv4Protocol)
+	podChain := podFirewallChainName("nsA", "web", "1")
+	dstSet := nftSetName(policyDestinationPodIPSetName("nsA", "allow-http", v1.IPv4Protocol))
+	blockSet := nftSetName(policyIndexedSourceIPBlockIPSetName("nsA", "allow-http", 0, v1.IPv4Protocol))
+
+	assert.True(t, table.HasChain(policyChain), "missing policy chain")
+	assert.True(t, table.HasChain(podChain), "missing pod firewall chain")
+	assert.True(t, table.HasSet(blockSet+nftExceptSetSuffix), "missing ipBlock except set")
+
+	for _, expected := range []string{
+		"ip daddr 10.96.0.0/12 return",
+		"ip daddr 10.128.0.0/16 return",
+		"fib daddr type local tcp dport 30000-32767 return",
+		"fib daddr type local sctp dport 30000-32767 return",
+		"ip saddr @" + blockSet + " ip saddr != @" + blockSet + nftExceptSetSuffix + " ip daddr @" + dstSet +
+			" meta l4proto tcp th dport 80 meta mark set meta mark | 0x10000 return",
+		"ip saddr @" + blockSet + " ip saddr != @" + blockSet + nftExceptSetSuffix + " ip daddr @" + dstSet +
+			" meta l4proto sctp th dport 3868 meta mark set meta mark | 0x10000 return",
+		"ip daddr 10.1.0.5 jump " + policyChain,
+		"ip saddr 10.1.0.5 jump " + kubeDefaultNetpolChain,
+		"ip daddr 10.1.0.5 jump " + podChain,
+		"meta mark & 0x10000 != 0x10000 reject",
+		"meta mark & 0x20000 == 0x20000 accept",
+	} {
+		if !strings.Contains(rendered, expected) {
+			t.Errorf("expected rendered nftables table to contain %q, got:\n%s", expected, rendered)
+		}
+	}
+
+	// the stateful rules need to be evaluated before any policy in the pod firewall chain
+	podChainRules := rendered[strings.Index(rendered, "chain "+podChain):]
+	assert.Less(t, strings.Index(podChainRules, "ct state related,established accept"),
+		strings.Index(podChainRules, "jump "+policyChain))
+}
+
+func TestNetworkPolicyController_renderNFTableDualStackPod(t *testing.T) {
+	client := fake.NewSimpleClientset(&v1.NodeList{Items: []v1.Node{*newFakeNode("node", []string{"10.10.10.10"})}})
+	informerFactory, podInformer, nsInformer, netpolInformer := newFakeInformersFromClient(client)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	informerFactory.Start(ctx.Done())
+	cache.WaitForCacheSync(ctx.Done(), podInformer.HasSynced)
+	npc := newUneventfulNetworkPolicyController(podInformer, netpolInformer, nsInformer)
+	npc.filterTableRules[v1.IPv6Protocol] = &bytes.Buffer{}
+	npc.serviceNodePortRange = "30000:32767"
+
+	tAddToInformerStore(t, podInformer, &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "nsA"},
+		Status: v1.PodStatus{HostIP: "10.10.10.10", PodIP: "10.1.0.5",
+			PodIPs: []v1.PodIP{{IP: "10.1.0.5"}, {IP: "fd00::5"}}, Phase: v1.PodRunning},
+	})
+
+	table := npc.renderNFTable(nil, nil, "1")
+	podChain := table.Chain(podFirewallChainName("nsA", "web", "1"))
+
+	// the family independent rules are only rendered once into the inet chain, ahead of the rules of both families
+	for _, rule := range []string{
+		"ct state related,established accept comment \"rule for stateful firewall for pod\"",
+		"ct state invalid drop comment \"rule to drop invalid state for pod\"",
+		"fib saddr type local accept comment \"rule to permit the traffic traffic to pods when source is the pod's " +
+			"local node\"",
+	} {
+		count := 0
+		for _, podChainRule := range podChain.Rules {
+			if podChainRule == rule {
+				count++
+			}
+		}
+		assert.Equal(t, 1, count, "expected rule %q once in the pod firewall chain", rule)
+	}
+	assert.Equal(t, "ct state related,established accept comment \"rule for stateful firewall for pod\"",
+		podChain.Rules[0])
+	assert.Contains(t, podChain.Rules, "ip daddr 10.1.0.5 jump "+kubeDefaultNetpolChain+
+		" comment \"run through default ingress network policy chain\"")
+	assert.Contains(t, podChain.Rules, "ip6 daddr fd00::5 jump "+kubeDefaultNetpolChain+
+		" comment \"run through default ingress network policy chain\"")
+}
@@ -38,7 +38,8 @@ func (npc *NetworkPolicyController) newPodEventHandler() cache.ResourceEventHand
 			// host. For the network policies, we are only interested in some changes, most pod changes aren't relevant
 			// to network policy
 			if isPodUpdateNetPolRelevant(oldPodObj, newPodObj) {
-				npc.OnPodUpdate(newObj)
+				klog.V(2).Infof("Received update to pod: %s/%s", newPodObj.Namespace, newPodObj.Name)
+				npc.handlePodChange(oldPodObj, newPodObj)
 			}
 		},
 		DeleteFunc: func(obj interface{}) {
@@ -52,7 +53,7 @@ func (npc *NetworkPolicyController) OnPodUpdate(obj interface{}) {
 	pod := obj.(*api.Pod)
 	klog.V(2).Infof("Received update to pod: %s/%s", pod.Namespace, pod.Name)
 
-	npc.RequestFullSync()
+	npc.handlePodChange(nil, pod)
 }
 
 func (npc *NetworkPolicyController) handlePodDelete(obj interface{}) {
@@ -70,17 +71,18 @@ func (npc *NetworkPolicyController) handlePodDelete(obj interface{}) {
 	}
 	klog.V(2).Infof("Received pod: %s/%s delete event", pod.Namespace, pod.Name)
 
-	npc.RequestFullSync()
+	npc.handlePodChange(pod, nil)
 }
 
 func (npc *NetworkPolicyController) syncPodFirewallChains(networkPoliciesInfo []networkPolicyInfo,
-	version string) map[string]bool {
+	adminPoliciesInfo []adminNetworkPolicyInfo, version string) map[string]bool {
 
 	activePodFwChains := make(map[string]bool)
 
 	dropUnmarkedTrafficRules := func(pod podInfo, podFwChainName string) {
+		auditIngress, auditEgress := podAuditedDirections(pod, networkPoliciesInfo)
 		for ipFamily, filterTableRules := range npc.filterTableRules {
-			_, err := getPodIPForFamily(pod, ipFamily)
+			ip, err := getPodIPForFamily(pod, ipFamily)
 			if err != nil {
 				klog.V(2).Infof("unable to get address for pod: %s -- skipping drop rules for pod "+
 					"(this is normal for pods that are not dual-stack)", err.Error())
@@ -91,12 +93,17 @@ func (npc *NetworkPolicyController) syncPodFirewallChains(networkPoliciesInfo []
 			comment := "\"rule to log dropped traffic POD name:" + pod.name + " namespace: " + pod.namespace + "\""
 			args := []string{"-A", podFwChainName, "-m", "comment", "--comment", comment,
 				"-m", "mark", "!", "--mark", "0x10000/0x10000", "-j", "NFLOG",
-				"--nflog-group", "100", "-m", "limit", "--limit", "10/minute", "--limit-burst", "10", "\n"}
+				"--nflog-group", "100", "--nflog-prefix", podFwChainName}
+			args = append(append(args, npc.nflogLimitArgs()...), "\n")
 			// This used to be AppendUnique when we were using iptables directly, this checks to make sure we didn't drop
 			// unmarked for this chain already
 			if strings.Contains(filterTableRules.String(), strings.Join(args, " ")) {
 				continue
 			}
+
+			// the traffic that network policies in audit mode would drop is logged and let through instead
+			auditUnmarkedTrafficRules(filterTableRules, pod, podFwChainName, ip, auditIngress, auditEgress,
+				npc.nflogLimitArgs())
 			filterTableRules.WriteString(strings.Join(args, " "))
 
 			// add rule to DROP if no applicable network policy permits the traffic
@@ -109,6 +116,10 @@ func (npc *NetworkPolicyController) syncPodFirewallChains(networkPoliciesInfo []
 			// reset mark to let traffic pass through rest of the chains
 			args = []string{"-A", podFwChainName, "-j", "MARK", "--set-mark", "0/0x10000", "\n"}
 			filterTableRules.WriteString(strings.Join(args, " "))
+			if len(adminPoliciesInfo) > 0 {
+				args = []string{"-A", podFwChainName, "-j", "MARK", "--set-mark", "0/" + kubeAdminPolicyDecided, "\n"}
+				filterTableRules.WriteString(strings.Join(args, " "))
+			}
 		}
 	}
 
@@ -138,7 +149,7 @@ func (npc *NetworkPolicyController) syncPodFirewallChains(networkPoliciesInfo []
 		activePodFwChains[podFwChainName] = true
 
 		// setup rules to run through applicable ingress/egress network policies for the pod
-		npc.setupPodNetpolRules(pod, podFwChainName, networkPoliciesInfo, version)
+		npc.setupPodNetpolRules(pod, podFwChainName, networkPoliciesInfo, adminPoliciesInfo, version)
 
 		// setup rules to intercept inbound traffic to the pods
 		npc.interceptPodInboundTraffic(pod, podFwChainName)
@@ -170,10 +181,11 @@ func (npc *NetworkPolicyController) syncPodFirewallChains(networkPoliciesInfo []
 
 // setup rules to jump to applicable network policy chains for the traffic from/to the pod
 func (npc *NetworkPolicyController) setupPodNetpolRules(pod podInfo, podFwChainName string,
-	networkPoliciesInfo []networkPolicyInfo, version string) {
+	networkPoliciesInfo []networkPolicyInfo, adminPoliciesInfo []adminNetworkPolicyInfo, version string) {
 
 	hasIngressPolicy := false
 	hasEgressPolicy := false
+	auditIngress, auditEgress := podAuditedDirections(pod, networkPoliciesInfo)
 
 	for ipFamily, filterTableRules := range npc.filterTableRules {
 		ip, err := getPodIPForFamily(pod, ipFamily)
@@ -184,27 +196,29 @@ func (npc *NetworkPolicyController) setupPodNetpolRules(pod podInfo, podFwChainN
 		}
 
 		// add entries in pod firewall to run through applicable network policies
+		// network policies in audit mode are only evaluated for the directions of the pod that no enforced network
+		// policy applies to
 		for _, policy := range networkPoliciesInfo {
-			if _, ok := policy.targetPods[pod.ip]; !ok {
-				continue
-			}
+			ingress, egress := policy.evaluatedDirections(pod, auditIngress, auditEgress)
 			comment := "\"run through nw policy " + policy.name + "\""
 			policyChainName := networkPolicyChainName(policy.namespace, policy.name, version, ipFamily)
 			var args []string
-			switch policy.policyType {
-			case kubeBothPolicyType:
+			switch {
+			case ingress && egress:
 				hasIngressPolicy = true
 				hasEgressPolicy = true
 				args = []string{"-I", podFwChainName, "1", "-m", "comment", "--comment", comment,
 					"-j", policyChainName, "\n"}
-			case kubeIngressPolicyType:
+			case ingress:
 				hasIngressPolicy = true
 				args = []string{"-I", podFwChainName, "1", "-d", ip, "-m", "comment", "--comment", comment,
 					"-j", policyChainName, "\n"}
-			case kubeEgressPolicyType:
+			case egress:
 				hasEgressPolicy = true
 				args = []string{"-I", podFwChainName, "1", "-s", ip, "-m", "comment", "--comment", comment,
 					"-j", policyChainName, "\n"}
+			default:
+				continue
 			}
 			filterTableRules.WriteString(strings.Join(args, " "))
 		}
@@ -227,6 +241,19 @@ func (npc *NetworkPolicyController) setupPodNetpolRules(pod podInfo, podFwChainN
 			filterTableRules.WriteString(strings.Join(args, " "))
 		}
 
+		// the baseline admin network policy is evaluated right before the default network policy chain for the
+		// directions that no network policy applies to, the admin network policies before all network policies
+		if baselinePolicy := baselineAdminPolicy(adminPoliciesInfo); baselinePolicy != nil {
+			if !hasEgressPolicy {
+				npc.insertAdminPolicyJump(pod, podFwChainName, *baselinePolicy, adminPolicyEgress, version, ipFamily, ip)
+			}
+			if !hasIngressPolicy {
+				npc.insertAdminPolicyJump(pod, podFwChainName, *baselinePolicy, adminPolicyIngress, version, ipFamily,
+					ip)
+			}
+		}
+		npc.insertAdminPolicyJumps(pod, podFwChainName, adminPoliciesInfo, version, ipFamily, ip)
+
 		comment := "\"rule to permit the traffic traffic to pods when source is the pod's local node\""
 		args := []string{"-I", podFwChainName, "1", "-m", "comment", "--comment", comment,
 			"-m", "addrtype", "--src-type", "LOCAL", "-d", ip, "-j", "ACCEPT", "\n"}
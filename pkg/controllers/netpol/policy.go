@@ -105,7 +105,41 @@ func (npc *NetworkPolicyController) syncNetworkPolicyChains(networkPoliciesInfo
 		}
 	}
 
-	// run through all network policies
+	if err := npc.renderNetworkPolicyChains(networkPoliciesInfo, version, activePolicyChains,
+		activePolicyIPSets); err != nil {
+		return nil, nil, err
+	}
+
+	for ipFamily, ipset := range npc.ipSetHandlers {
+		restoreStart := time.Now()
+		err := ipset.Restore()
+		restoreEndTime := time.Since(restoreStart)
+
+		if npc.MetricsEnabled {
+			//nolint:exhaustive // we don't need exhaustive searching for IP Families
+			switch ipFamily {
+			case api.IPv4Protocol:
+				metrics.ControllerPolicyIpsetV4RestoreTime.Observe(restoreEndTime.Seconds())
+			case api.IPv6Protocol:
+				metrics.ControllerPolicyIpsetV6RestoreTime.Observe(restoreEndTime.Seconds())
+			}
+		}
+		klog.V(1).Infof("Restoring %v ipset took %v", ipFamily, restoreEndTime)
+
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to perform ipset restore: %w", err)
+		}
+	}
+
+	klog.V(2).Infof("Iptables chains in the filter table are synchronized with the network policies.")
+
+	return activePolicyChains, activePolicyIPSets, nil
+}
+
+// renderNetworkPolicyChains writes the chains of the network policies to the filter table rules and refreshes their
+// ipsets in the ipset handlers, recording both as active
+func (npc *NetworkPolicyController) renderNetworkPolicyChains(networkPoliciesInfo []networkPolicyInfo, version string,
+	activePolicyChains, activePolicyIPSets map[string]bool) error {
 	for _, policy := range networkPoliciesInfo {
 		currentPodIPs := make(map[api.IPFamily][]string)
 		for _, pod := range policy.targetPods {
@@ -134,7 +168,7 @@ func (npc *NetworkPolicyController) syncNetworkPolicyChains(networkPoliciesInfo
 
 				if err := npc.processIngressRules(policy,
 					targetDestPodIPSetName, activePolicyIPSets, version, ipFamily); err != nil {
-					return nil, nil, err
+					return err
 				}
 				activePolicyIPSets[targetDestPodIPSetName] = true
 			}
@@ -145,109 +179,133 @@ func (npc *NetworkPolicyController) syncNetworkPolicyChains(networkPoliciesInfo
 
 				if err := npc.processEgressRules(policy,
 					targetSourcePodIPSetName, activePolicyIPSets, version, ipFamily); err != nil {
-					return nil, nil, err
+					return err
 				}
 				activePolicyIPSets[targetSourcePodIPSetName] = true
 			}
 		}
 	}
 
-	for ipFamily, ipset := range npc.ipSetHandlers {
-		restoreStart := time.Now()
-		err := ipset.Restore()
-		restoreEndTime := time.Since(restoreStart)
+	return nil
+}
 
-		if npc.MetricsEnabled {
-			//nolint:exhaustive // we don't need exhaustive searching for IP Families
-			switch ipFamily {
-			case api.IPv4Protocol:
-				metrics.ControllerPolicyIpsetV4RestoreTime.Observe(restoreEndTime.Seconds())
-			case api.IPv6Protocol:
-				metrics.ControllerPolicyIpsetV6RestoreTime.Observe(restoreEndTime.Seconds())
-			}
-		}
-		klog.V(1).Infof("Restoring %v ipset took %v", ipFamily, restoreEndTime)
+func (npc *NetworkPolicyController) processIngressRules(policy networkPolicyInfo,
+	targetDestPodIPSetName string, activePolicyIPSets map[string]bool, version string,
+	ipFamily api.IPFamily) error {
+	policyChainName := networkPolicyChainName(policy.namespace, policy.name, version, ipFamily)
+	return npc.renderNetworkPolicyChain(policyChainName, policy.ingressChain(targetDestPodIPSetName, ipFamily),
+		activePolicyIPSets, ipFamily)
+}
 
-		if err != nil {
-			return nil, nil, fmt.Errorf("failed to perform ipset restore: %w", err)
+func (npc *NetworkPolicyController) processEgressRules(policy networkPolicyInfo,
+	targetSourcePodIPSetName string, activePolicyIPSets map[string]bool, version string,
+	ipFamily api.IPFamily) error {
+	policyChainName := networkPolicyChainName(policy.namespace, policy.name, version, ipFamily)
+	return npc.renderNetworkPolicyChain(policyChainName, policy.egressChain(targetSourcePodIPSetName, ipFamily),
+		activePolicyIPSets, ipFamily)
+}
+
+// renderNetworkPolicyChain refreshes the ipsets of the chain and appends its rules to the chain of the network policy
+func (npc *NetworkPolicyController) renderNetworkPolicyChain(policyChainName string, chain networkPolicyChain,
+	activePolicyIPSets map[string]bool, ipFamily api.IPFamily) error {
+	for _, set := range chain.sets {
+		activePolicyIPSets[set.name] = true
+		npc.ipSetHandlers[ipFamily].RefreshSet(set.name, set.entries, set.setType)
+	}
+	for _, rule := range chain.rules {
+		if err := npc.appendRuleToPolicyChain(policyChainName, rule.comment, rule.srcSetName, rule.dstSetName,
+			rule.portProtocol.protocol, rule.portProtocol.port, rule.portProtocol.endport, ipFamily); err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	klog.V(2).Infof("Iptables chains in the filter table are synchronized with the network policies.")
+// networkPolicyChain is the ingress or egress part of the chain of a network policy for an IP family, along with the
+// ipsets that its rules reference. It is rendered by both firewall backends and evaluated by CheckNetworkPolicies.
+type networkPolicyChain struct {
+	sets  []networkPolicySet
+	rules []networkPolicyChainRule
+}
 
-	return activePolicyChains, activePolicyIPSets, nil
+// networkPolicySet is an ipset of a network policy chain, its entries are in the format of the ipset handler
+type networkPolicySet struct {
+	name    string
+	setType string
+	entries [][]string
 }
 
-func (npc *NetworkPolicyController) processIngressRules(policy networkPolicyInfo,
-	targetDestPodIPSetName string, activePolicyIPSets map[string]bool, version string,
-	ipFamily api.IPFamily) error {
+// networkPolicyChainRule is a rule of a network policy chain that marks the traffic that it matches as permitted,
+// ruleIdx is the index of the ingress or egress rule of the network policy that it was rendered for
+type networkPolicyChainRule struct {
+	ruleIdx      int
+	comment      string
+	srcSetName   string
+	dstSetName   string
+	portProtocol protocolAndPort
+}
 
-	// From network policy spec: "If field 'Ingress' is empty then this NetworkPolicy does not allow any traffic "
-	// so no whitelist rules to be added to the network policy
-	if policy.ingressRules == nil {
-		return nil
-	}
+func (chain *networkPolicyChain) addSet(name, setType string, entries [][]string) {
+	chain.sets = append(chain.sets, networkPolicySet{name: name, setType: setType, entries: entries})
+}
 
-	policyChainName := networkPolicyChainName(policy.namespace, policy.name, version, ipFamily)
+func (chain *networkPolicyChain) addRule(ruleIdx int, comment, srcSetName, dstSetName string,
+	portProtocol protocolAndPort) {
+	chain.rules = append(chain.rules, networkPolicyChainRule{ruleIdx: ruleIdx, comment: comment,
+		srcSetName: srcSetName, dstSetName: dstSetName, portProtocol: portProtocol})
+}
+
+// ipSetEntries returns the entries of a hash:ip ipset for the IPs
+func ipSetEntries(ips []string) [][]string {
+	setEntries := make([][]string, 0, len(ips))
+	for _, ip := range ips {
+		setEntries = append(setEntries, []string{ip, utils.OptionTimeout, "0"})
+	}
+	return setEntries
+}
 
-	// run through all the ingress rules in the spec and create iptables rules
-	// in the chain for the network policy
+// ingressChain returns the ingress part of the chain of the network policy for the IP family, the traffic to the pods
+// that the policy selects is matched by the ipset named targetDestPodIPSetName
+func (policy networkPolicyInfo) ingressChain(targetDestPodIPSetName string, ipFamily api.IPFamily) networkPolicyChain {
+	var chain networkPolicyChain
+	fromPodsComment := "rule to ACCEPT traffic from source pods to dest pods selected by policy name " +
+		policy.name + " namespace " + policy.namespace
+	fromAllComment := "rule to ACCEPT traffic from all sources to dest pods selected by policy name: " +
+		policy.name + " namespace " + policy.namespace
+	fromIPBlockComment := "rule to ACCEPT traffic from specified ipBlocks to dest pods selected by policy name: " +
+		policy.name + " namespace " + policy.namespace
+
+	// run through all the ingress rules in the spec and create rules in the chain for the network policy. From network
+	// policy spec: "If field 'Ingress' is empty then this NetworkPolicy does not allow any traffic", so a policy without
+	// ingress rules gets no rules at all.
 	for ruleIdx, ingressRule := range policy.ingressRules {
+		namedPortIPSetName := func(epIdx int) string {
+			return policyIndexedIngressNamedPortIPSetName(policy.namespace, policy.name, ruleIdx, epIdx, ipFamily)
+		}
 
 		if len(ingressRule.srcPods) != 0 {
 			srcPodIPSetName := policyIndexedSourcePodIPSetName(policy.namespace, policy.name, ruleIdx, ipFamily)
-			activePolicyIPSets[srcPodIPSetName] = true
-			setEntries := make([][]string, 0)
-			ips := getIPsFromPods(ingressRule.srcPods, ipFamily)
-			for _, ip := range ips {
-				setEntries = append(setEntries, []string{ip, utils.OptionTimeout, "0"})
-			}
-			npc.ipSetHandlers[ipFamily].RefreshSet(srcPodIPSetName, setEntries, utils.TypeHashIP)
-
-			// Create policy based ipset with source pod IPs
-			npc.createPolicyIndexedIPSet(activePolicyIPSets, srcPodIPSetName, utils.TypeHashIP,
-				getIPsFromPods(ingressRule.srcPods, ipFamily), ipFamily)
+			chain.addSet(srcPodIPSetName, utils.TypeHashIP, ipSetEntries(getIPsFromPods(ingressRule.srcPods,
+				ipFamily)))
 
 			// If the ingress policy contains port declarations, we need to make sure that we match on pod IP and port
-			if len(ingressRule.ports) != 0 {
-				if err := npc.createPodWithPortPolicyRule(ingressRule.ports, policy, policyChainName,
-					srcPodIPSetName, targetDestPodIPSetName, ipFamily); err != nil {
-					return err
-				}
+			for _, portProtocol := range ingressRule.ports {
+				chain.addRule(ruleIdx, fromPodsComment, srcPodIPSetName, targetDestPodIPSetName, portProtocol)
 			}
 
 			// If the ingress policy contains named port declarations, we need to make sure that we match on pod IP and
 			// the resolved port number
-			if len(ingressRule.namedPorts) != 0 {
-				for epIdx, endPoints := range ingressRule.namedPorts {
-					namedPortIPSetName := policyIndexedIngressNamedPortIPSetName(policy.namespace,
-						policy.name, ruleIdx, epIdx, ipFamily)
-					activePolicyIPSets[namedPortIPSetName] = true
-					setEntries := make([][]string, 0)
-					for _, ip := range endPoints.ips[ipFamily] {
-						setEntries = append(setEntries, []string{ip, utils.OptionTimeout, "0"})
-					}
-					npc.ipSetHandlers[ipFamily].RefreshSet(namedPortIPSetName, setEntries, utils.TypeHashIP)
-
-					comment := "rule to ACCEPT traffic from source pods to dest pods selected by policy name " +
-						policy.name + " namespace " + policy.namespace
-					if err := npc.appendRuleToPolicyChain(policyChainName, comment, srcPodIPSetName, namedPortIPSetName,
-						endPoints.protocol, endPoints.port, endPoints.endport, ipFamily); err != nil {
-						return err
-					}
-				}
+			for epIdx, endPoints := range ingressRule.namedPorts {
+				chain.addSet(namedPortIPSetName(epIdx), utils.TypeHashIP, ipSetEntries(endPoints.ips[ipFamily]))
+				chain.addRule(ruleIdx, fromPodsComment, srcPodIPSetName, namedPortIPSetName(epIdx),
+					endPoints.protocolAndPort)
 			}
 
 			// If the ingress policy contains no ports at all create the policy based only on IP
 			if len(ingressRule.ports) == 0 && len(ingressRule.namedPorts) == 0 {
 				// case where no 'ports' details specified in the ingress rule but 'from' details specified
 				// so match on specified source and destination ip with all port and protocol
-				comment := "rule to ACCEPT traffic from source pods to dest pods selected by policy name " +
-					policy.name + " namespace " + policy.namespace
-				if err := npc.appendRuleToPolicyChain(policyChainName,
-					comment, srcPodIPSetName, targetDestPodIPSetName, "", "", "", ipFamily); err != nil {
-					return err
-				}
+				chain.addRule(ruleIdx, fromPodsComment, srcPodIPSetName, targetDestPodIPSetName, protocolAndPort{})
 			}
 		}
 
@@ -255,215 +313,119 @@ func (npc *NetworkPolicyController) processIngressRules(policy networkPolicyInfo
 		// with specified port (if any) and protocol
 		if ingressRule.matchAllSource && !ingressRule.matchAllPorts {
 			for _, portProtocol := range ingressRule.ports {
-				comment := "rule to ACCEPT traffic from all sources to dest pods selected by policy name: " +
-					policy.name + " namespace " + policy.namespace
-				if err := npc.appendRuleToPolicyChain(policyChainName, comment, "", targetDestPodIPSetName,
-					portProtocol.protocol, portProtocol.port, portProtocol.endport, ipFamily); err != nil {
-					return err
-				}
+				chain.addRule(ruleIdx, fromAllComment, "", targetDestPodIPSetName, portProtocol)
 			}
-
 			for epIdx, endPoints := range ingressRule.namedPorts {
-				namedPortIPSetName := policyIndexedIngressNamedPortIPSetName(policy.namespace,
-					policy.name, ruleIdx, epIdx, ipFamily)
-				activePolicyIPSets[namedPortIPSetName] = true
-				setEntries := make([][]string, 0)
-				for _, ip := range endPoints.ips[ipFamily] {
-					setEntries = append(setEntries, []string{ip, utils.OptionTimeout, "0"})
-				}
-				npc.ipSetHandlers[ipFamily].RefreshSet(namedPortIPSetName, setEntries, utils.TypeHashIP)
-
-				comment := "rule to ACCEPT traffic from all sources to dest pods selected by policy name: " +
-					policy.name + " namespace " + policy.namespace
-				if err := npc.appendRuleToPolicyChain(policyChainName,
-					comment, "", namedPortIPSetName, endPoints.protocol, endPoints.port, endPoints.endport, ipFamily); err != nil {
-					return err
-				}
+				chain.addSet(namedPortIPSetName(epIdx), utils.TypeHashIP, ipSetEntries(endPoints.ips[ipFamily]))
+				chain.addRule(ruleIdx, fromAllComment, "", namedPortIPSetName(epIdx), endPoints.protocolAndPort)
 			}
 		}
 
 		// case where neither ports nor from details are specified in the ingress rule so match on all ports, protocol,
 		// source IP's
 		if ingressRule.matchAllSource && ingressRule.matchAllPorts {
-			comment := "rule to ACCEPT traffic from all sources to dest pods selected by policy name: " +
-				policy.name + " namespace " + policy.namespace
-			if err := npc.appendRuleToPolicyChain(policyChainName, comment, "", targetDestPodIPSetName,
-				"", "", "", ipFamily); err != nil {
-				return err
-			}
+			chain.addRule(ruleIdx, fromAllComment, "", targetDestPodIPSetName, protocolAndPort{})
 		}
 
 		if len(ingressRule.srcIPBlocks[ipFamily]) != 0 {
 			srcIPBlockIPSetName := policyIndexedSourceIPBlockIPSetName(policy.namespace, policy.name, ruleIdx, ipFamily)
-			activePolicyIPSets[srcIPBlockIPSetName] = true
-			npc.ipSetHandlers[ipFamily].RefreshSet(srcIPBlockIPSetName, ingressRule.srcIPBlocks[ipFamily], utils.TypeHashNet)
-
-			if !ingressRule.matchAllPorts {
-				for _, portProtocol := range ingressRule.ports {
-					comment := "rule to ACCEPT traffic from specified ipBlocks to dest pods selected by policy name: " +
-						policy.name + " namespace " + policy.namespace
-					if err := npc.appendRuleToPolicyChain(policyChainName, comment, srcIPBlockIPSetName,
-						targetDestPodIPSetName, portProtocol.protocol, portProtocol.port,
-						portProtocol.endport, ipFamily); err != nil {
-						return err
-					}
-				}
+			chain.addSet(srcIPBlockIPSetName, utils.TypeHashNet, ingressRule.srcIPBlocks[ipFamily])
 
-				for epIdx, endPoints := range ingressRule.namedPorts {
-					namedPortIPSetName := policyIndexedIngressNamedPortIPSetName(policy.namespace,
-						policy.name, ruleIdx, epIdx, ipFamily)
-					activePolicyIPSets[namedPortIPSetName] = true
-					setEntries := make([][]string, 0)
-					for _, ip := range endPoints.ips[ipFamily] {
-						setEntries = append(setEntries, []string{ip, utils.OptionTimeout, "0"})
-					}
-					npc.ipSetHandlers[ipFamily].RefreshSet(namedPortIPSetName, setEntries, utils.TypeHashNet)
-					comment := "rule to ACCEPT traffic from specified ipBlocks to dest pods selected by policy name: " +
-						policy.name + " namespace " + policy.namespace
-					if err := npc.appendRuleToPolicyChain(policyChainName, comment, srcIPBlockIPSetName, namedPortIPSetName,
-						endPoints.protocol, endPoints.port, endPoints.endport, ipFamily); err != nil {
-						return err
-					}
-				}
-			}
 			if ingressRule.matchAllPorts {
-				comment := "rule to ACCEPT traffic from specified ipBlocks to dest pods selected by policy name: " +
-					policy.name + " namespace " + policy.namespace
-				if err := npc.appendRuleToPolicyChain(policyChainName, comment, srcIPBlockIPSetName,
-					targetDestPodIPSetName, "", "", "", ipFamily); err != nil {
-					return err
-				}
+				chain.addRule(ruleIdx, fromIPBlockComment, srcIPBlockIPSetName, targetDestPodIPSetName,
+					protocolAndPort{})
+				continue
+			}
+			for _, portProtocol := range ingressRule.ports {
+				chain.addRule(ruleIdx, fromIPBlockComment, srcIPBlockIPSetName, targetDestPodIPSetName, portProtocol)
+			}
+			for epIdx, endPoints := range ingressRule.namedPorts {
+				chain.addSet(namedPortIPSetName(epIdx), utils.TypeHashNet, ipSetEntries(endPoints.ips[ipFamily]))
+				chain.addRule(ruleIdx, fromIPBlockComment, srcIPBlockIPSetName, namedPortIPSetName(epIdx),
+					endPoints.protocolAndPort)
 			}
 		}
 	}
 
-	return nil
+	return chain
 }
 
-func (npc *NetworkPolicyController) processEgressRules(policy networkPolicyInfo,
-	targetSourcePodIPSetName string, activePolicyIPSets map[string]bool, version string,
-	ipFamily api.IPFamily) error {
-
-	// From network policy spec: "If field 'Ingress' is empty then this NetworkPolicy does not allow any traffic "
-	// so no whitelist rules to be added to the network policy
-	if policy.egressRules == nil {
-		return nil
-	}
-
-	policyChainName := networkPolicyChainName(policy.namespace, policy.name, version, ipFamily)
-
-	// run through all the egress rules in the spec and create iptables rules
-	// in the chain for the network policy
+// egressChain returns the egress part of the chain of the network policy for the IP family, the traffic from the pods
+// that the policy selects is matched by the ipset named targetSourcePodIPSetName
+func (policy networkPolicyInfo) egressChain(targetSourcePodIPSetName string, ipFamily api.IPFamily) networkPolicyChain {
+	var chain networkPolicyChain
+	toPodsComment := "rule to ACCEPT traffic from source pods to dest pods selected by policy name " +
+		policy.name + " namespace " + policy.namespace
+	toAllComment := "rule to ACCEPT traffic from source pods to all destinations selected by policy name: " +
+		policy.name + " namespace " + policy.namespace
+	toIPBlockComment := "rule to ACCEPT traffic from source pods to specified ipBlocks selected by policy name: " +
+		policy.name + " namespace " + policy.namespace
+
+	// run through all the egress rules in the spec and create rules in the chain for the network policy. From network
+	// policy spec: "If field 'Egress' is empty then this NetworkPolicy does not allow any traffic", so a policy without
+	// egress rules gets no rules at all.
 	for ruleIdx, egressRule := range policy.egressRules {
-
 		if len(egressRule.dstPods) != 0 {
 			dstPodIPSetName := policyIndexedDestinationPodIPSetName(policy.namespace, policy.name, ruleIdx, ipFamily)
-			activePolicyIPSets[dstPodIPSetName] = true
-			setEntries := make([][]string, 0)
-			ips := getIPsFromPods(egressRule.dstPods, ipFamily)
-			for _, ip := range ips {
-				setEntries = append(setEntries, []string{ip, utils.OptionTimeout, "0"})
-			}
-			npc.ipSetHandlers[ipFamily].RefreshSet(dstPodIPSetName, setEntries, utils.TypeHashIP)
-			if len(egressRule.ports) != 0 {
-				if err := npc.createPodWithPortPolicyRule(egressRule.ports, policy, policyChainName,
-					targetSourcePodIPSetName, dstPodIPSetName, ipFamily); err != nil {
-					return err
-				}
+			chain.addSet(dstPodIPSetName, utils.TypeHashIP, ipSetEntries(getIPsFromPods(egressRule.dstPods,
+				ipFamily)))
+
+			for _, portProtocol := range egressRule.ports {
+				chain.addRule(ruleIdx, toPodsComment, targetSourcePodIPSetName, dstPodIPSetName, portProtocol)
 			}
 
 			// If the egress policy contains named port declarations, we need to make sure that we match on pod IP and
 			// the resolved port number
-			if len(egressRule.namedPorts) != 0 {
-				for epIdx, endPoints := range egressRule.namedPorts {
-					namedPortIPSetName := policyIndexedEgressNamedPortIPSetName(policy.namespace,
-						policy.name, ruleIdx, epIdx, ipFamily)
-					activePolicyIPSets[namedPortIPSetName] = true
-					setEntries := make([][]string, 0)
-					for _, ip := range endPoints.ips[ipFamily] {
-						setEntries = append(setEntries, []string{ip, utils.OptionTimeout, "0"})
-					}
-					npc.ipSetHandlers[ipFamily].RefreshSet(namedPortIPSetName, setEntries, utils.TypeHashIP)
-					comment := "rule to ACCEPT traffic from source pods to dest pods selected by policy name " +
-						policy.name + " namespace " + policy.namespace
-					if err := npc.appendRuleToPolicyChain(policyChainName, comment, targetSourcePodIPSetName,
-						namedPortIPSetName, endPoints.protocol, endPoints.port, endPoints.endport, ipFamily); err != nil {
-						return err
-					}
-				}
+			for epIdx, endPoints := range egressRule.namedPorts {
+				namedPortIPSetName := policyIndexedEgressNamedPortIPSetName(policy.namespace, policy.name, ruleIdx,
+					epIdx, ipFamily)
+				chain.addSet(namedPortIPSetName, utils.TypeHashIP, ipSetEntries(endPoints.ips[ipFamily]))
+				chain.addRule(ruleIdx, toPodsComment, targetSourcePodIPSetName, namedPortIPSetName,
+					endPoints.protocolAndPort)
 			}
 
 			// If the egress policy contains no ports at all create the policy based only on IP
 			if len(egressRule.ports) == 0 && len(egressRule.namedPorts) == 0 {
-				// case where no 'ports' details specified in the ingress rule but 'from' details specified
+				// case where no 'ports' details specified in the egress rule but 'to' details specified
 				// so match on specified source and destination ip with all port and protocol
-				comment := "rule to ACCEPT traffic from source pods to dest pods selected by policy name " +
-					policy.name + " namespace " + policy.namespace
-				if err := npc.appendRuleToPolicyChain(policyChainName, comment, targetSourcePodIPSetName,
-					dstPodIPSetName, "", "", "", ipFamily); err != nil {
-					return err
-				}
+				chain.addRule(ruleIdx, toPodsComment, targetSourcePodIPSetName, dstPodIPSetName, protocolAndPort{})
 			}
 		}
 
-		// case where only 'ports' details specified but no 'to' details in the egress rule so match on all sources,
-		// with specified port (if any) and protocol
+		// case where only 'ports' details specified but no 'to' details in the egress rule so match on all
+		// destinations, with specified port (if any) and protocol
 		if egressRule.matchAllDestinations && !egressRule.matchAllPorts {
 			for _, portProtocol := range egressRule.ports {
-				comment := "rule to ACCEPT traffic from source pods to all destinations selected by policy name: " +
-					policy.name + " namespace " + policy.namespace
-				if err := npc.appendRuleToPolicyChain(policyChainName, comment, targetSourcePodIPSetName,
-					"", portProtocol.protocol, portProtocol.port, portProtocol.endport, ipFamily); err != nil {
-					return err
-				}
+				chain.addRule(ruleIdx, toAllComment, targetSourcePodIPSetName, "", portProtocol)
 			}
-			for _, portProtocol := range egressRule.namedPorts {
-				comment := "rule to ACCEPT traffic from source pods to all destinations selected by policy name: " +
-					policy.name + " namespace " + policy.namespace
-				if err := npc.appendRuleToPolicyChain(policyChainName, comment, targetSourcePodIPSetName,
-					"", portProtocol.protocol, portProtocol.port, portProtocol.endport, ipFamily); err != nil {
-					return err
-				}
+			for _, endPoints := range egressRule.namedPorts {
+				chain.addRule(ruleIdx, toAllComment, targetSourcePodIPSetName, "", endPoints.protocolAndPort)
 			}
 		}
 
-		// case where neither ports nor from details are specified in the egress rule so match on all ports, protocol,
-		// source IP's
+		// case where neither ports nor to details are specified in the egress rule so match on all ports, protocol,
+		// destination IP's
 		if egressRule.matchAllDestinations && egressRule.matchAllPorts {
-			comment := "rule to ACCEPT traffic from source pods to all destinations selected by policy name: " +
-				policy.name + " namespace " + policy.namespace
-			if err := npc.appendRuleToPolicyChain(policyChainName, comment, targetSourcePodIPSetName,
-				"", "", "", "", ipFamily); err != nil {
-				return err
-			}
+			chain.addRule(ruleIdx, toAllComment, targetSourcePodIPSetName, "", protocolAndPort{})
 		}
 
 		if len(egressRule.dstIPBlocks[ipFamily]) != 0 {
-			dstIPBlockIPSetName := policyIndexedDestinationIPBlockIPSetName(policy.namespace, policy.name, ruleIdx, ipFamily)
-			activePolicyIPSets[dstIPBlockIPSetName] = true
-			npc.ipSetHandlers[ipFamily].RefreshSet(dstIPBlockIPSetName, egressRule.dstIPBlocks[ipFamily], utils.TypeHashNet)
-			if !egressRule.matchAllPorts {
-				for _, portProtocol := range egressRule.ports {
-					comment := "rule to ACCEPT traffic from source pods to specified ipBlocks selected by policy name: " +
-						policy.name + " namespace " + policy.namespace
-					if err := npc.appendRuleToPolicyChain(policyChainName, comment, targetSourcePodIPSetName,
-						dstIPBlockIPSetName, portProtocol.protocol, portProtocol.port,
-						portProtocol.endport, ipFamily); err != nil {
-						return err
-					}
-				}
-			}
+			dstIPBlockIPSetName := policyIndexedDestinationIPBlockIPSetName(policy.namespace, policy.name, ruleIdx,
+				ipFamily)
+			chain.addSet(dstIPBlockIPSetName, utils.TypeHashNet, egressRule.dstIPBlocks[ipFamily])
+
 			if egressRule.matchAllPorts {
-				comment := "rule to ACCEPT traffic from source pods to specified ipBlocks selected by policy name: " +
-					policy.name + " namespace " + policy.namespace
-				if err := npc.appendRuleToPolicyChain(policyChainName, comment, targetSourcePodIPSetName,
-					dstIPBlockIPSetName, "", "", "", ipFamily); err != nil {
-					return err
-				}
+				chain.addRule(ruleIdx, toIPBlockComment, targetSourcePodIPSetName, dstIPBlockIPSetName,
+					protocolAndPort{})
+				continue
+			}
+			for _, portProtocol := range egressRule.ports {
+				chain.addRule(ruleIdx, toIPBlockComment, targetSourcePodIPSetName, dstIPBlockIPSetName, portProtocol)
 			}
 		}
 	}
-	return nil
+
+	return chain
 }
 
 func (npc *NetworkPolicyController) appendRuleToPolicyChain(policyChainName, comment, srcIPSetName, dstIPSetName,
@@ -506,209 +468,216 @@ func (npc *NetworkPolicyController) appendRuleToPolicyChain(policyChainName, com
 func (npc *NetworkPolicyController) buildNetworkPoliciesInfo() ([]networkPolicyInfo, error) {
 
 	NetworkPolicies := make([]networkPolicyInfo, 0)
-	_, isIPv4Enabled := npc.ipSetHandlers[api.IPv4Protocol]
-	_, isIPv6Enabled := npc.ipSetHandlers[api.IPv6Protocol]
 
 	for _, policyObj := range npc.npLister.List() {
 
 		policy, ok := policyObj.(*networking.NetworkPolicy)
-		podSelector, _ := v1.LabelSelectorAsSelector(&policy.Spec.PodSelector)
 		if !ok {
 			return nil, fmt.Errorf("failed to convert")
 		}
-		newPolicy := networkPolicyInfo{
-			name:        policy.Name,
-			namespace:   policy.Namespace,
-			podSelector: podSelector,
-			policyType:  kubeIngressPolicyType,
-		}
+		NetworkPolicies = append(NetworkPolicies, npc.buildNetworkPolicyInfo(policy))
+	}
 
-		ingressType, egressType := false, false
-		for _, policyType := range policy.Spec.PolicyTypes {
-			if policyType == networking.PolicyTypeIngress {
-				ingressType = true
-			}
-			if policyType == networking.PolicyTypeEgress {
-				egressType = true
-			}
+	return NetworkPolicies, nil
+}
+
+// buildNetworkPolicyInfo evaluates the selectors of the network policy into the pods and ip blocks that its rules match
+func (npc *NetworkPolicyController) buildNetworkPolicyInfo(policy *networking.NetworkPolicy) networkPolicyInfo {
+	_, isIPv4Enabled := npc.filterTableRules[api.IPv4Protocol]
+	_, isIPv6Enabled := npc.filterTableRules[api.IPv6Protocol]
+	podSelector, _ := v1.LabelSelectorAsSelector(&policy.Spec.PodSelector)
+
+	newPolicy := networkPolicyInfo{
+		name:        policy.Name,
+		namespace:   policy.Namespace,
+		podSelector: podSelector,
+		policyType:  kubeIngressPolicyType,
+		audit:       npc.isNetworkPolicyAudited(policy),
+	}
+
+	ingressType, egressType := false, false
+	for _, policyType := range policy.Spec.PolicyTypes {
+		if policyType == networking.PolicyTypeIngress {
+			ingressType = true
 		}
-		switch {
-		case ingressType && egressType:
-			newPolicy.policyType = kubeBothPolicyType
-		case egressType:
-			newPolicy.policyType = kubeEgressPolicyType
-		case ingressType:
-			newPolicy.policyType = kubeIngressPolicyType
+		if policyType == networking.PolicyTypeEgress {
+			egressType = true
 		}
+	}
+	switch {
+	case ingressType && egressType:
+		newPolicy.policyType = kubeBothPolicyType
+	case egressType:
+		newPolicy.policyType = kubeEgressPolicyType
+	case ingressType:
+		newPolicy.policyType = kubeIngressPolicyType
+	}
 
-		matchingPods, err := npc.ListPodsByNamespaceAndLabels(policy.Namespace, podSelector)
-		newPolicy.targetPods = make(map[string]podInfo)
-		namedPort2IngressEps := make(namedPort2eps)
-		if err == nil {
-			for _, matchingPod := range matchingPods {
-				if !isNetPolActionable(matchingPod) {
-					continue
-				}
-				newPolicy.targetPods[matchingPod.Status.PodIP] = podInfo{ips: matchingPod.Status.PodIPs,
-					name:      matchingPod.Name,
-					namespace: matchingPod.Namespace,
-					labels:    matchingPod.Labels}
-				npc.grabNamedPortFromPod(matchingPod, &namedPort2IngressEps)
+	matchingPods, err := npc.ListPodsByNamespaceAndLabels(policy.Namespace, podSelector)
+	newPolicy.targetPods = make(map[string]podInfo)
+	namedPort2IngressEps := make(namedPort2eps)
+	if err == nil {
+		for _, matchingPod := range matchingPods {
+			if !isNetPolActionable(matchingPod) {
+				continue
 			}
+			newPolicy.targetPods[matchingPod.Status.PodIP] = podInfo{ips: matchingPod.Status.PodIPs,
+				name:      matchingPod.Name,
+				namespace: matchingPod.Namespace,
+				labels:    matchingPod.Labels}
+			npc.grabNamedPortFromPod(matchingPod, &namedPort2IngressEps)
 		}
+	}
 
-		if policy.Spec.Ingress == nil {
-			newPolicy.ingressRules = nil
-		} else {
-			newPolicy.ingressRules = make([]ingressRule, 0)
-		}
+	if policy.Spec.Ingress == nil {
+		newPolicy.ingressRules = nil
+	} else {
+		newPolicy.ingressRules = make([]ingressRule, 0)
+	}
 
-		if policy.Spec.Egress == nil {
-			newPolicy.egressRules = nil
-		} else {
-			newPolicy.egressRules = make([]egressRule, 0)
-		}
+	if policy.Spec.Egress == nil {
+		newPolicy.egressRules = nil
+	} else {
+		newPolicy.egressRules = make([]egressRule, 0)
+	}
 
-		for _, specIngressRule := range policy.Spec.Ingress {
-			ingressRule := ingressRule{}
-			ingressRule.srcPods = make([]podInfo, 0)
-			ingressRule.srcIPBlocks = make(map[api.IPFamily][][]string, 0)
+	for _, specIngressRule := range policy.Spec.Ingress {
+		ingressRule := ingressRule{}
+		ingressRule.srcPods = make([]podInfo, 0)
+		ingressRule.srcIPBlocks = make(map[api.IPFamily][][]string, 0)
 
-			// If this field is empty or missing in the spec, this rule matches all sources
-			if len(specIngressRule.From) == 0 {
-				ingressRule.matchAllSource = true
-			} else {
-				ingressRule.matchAllSource = false
-				for _, peer := range specIngressRule.From {
-					if peerPods, err := npc.evalPodPeer(policy, peer); err == nil {
-						for _, peerPod := range peerPods {
-							if !isNetPolActionable(peerPod) {
-								continue
-							}
-							ingressRule.srcPods = append(ingressRule.srcPods,
-								podInfo{ips: peerPod.Status.PodIPs,
-									name:      peerPod.Name,
-									namespace: peerPod.Namespace,
-									labels:    peerPod.Labels})
+		// If this field is empty or missing in the spec, this rule matches all sources
+		if len(specIngressRule.From) == 0 {
+			ingressRule.matchAllSource = true
+		} else {
+			ingressRule.matchAllSource = false
+			for _, peer := range specIngressRule.From {
+				if peerPods, err := npc.evalPodPeer(policy, peer); err == nil {
+					for _, peerPod := range peerPods {
+						if !isNetPolActionable(peerPod) {
+							continue
 						}
+						ingressRule.srcPods = append(ingressRule.srcPods,
+							podInfo{ips: peerPod.Status.PodIPs,
+								name:      peerPod.Name,
+								namespace: peerPod.Namespace,
+								labels:    peerPod.Labels})
 					}
-					peerIPBlock := npc.evalIPBlockPeer(peer)
-
-					_, foundIPv4Addresses := peerIPBlock[api.IPv4Protocol]
-					_, foundIPv6Addresses := peerIPBlock[api.IPv6Protocol]
-					if foundIPv4Addresses && !isIPv4Enabled {
-						klog.Warningf("Ignoring IPv4 source IP blocks %s from policy %s because we are not IPv4 "+
-							"Enabled!", peerIPBlock[api.IPv4Protocol], policy.Name)
-					}
-					if foundIPv6Addresses && !isIPv6Enabled {
-						klog.Warningf("Ignoring IPv6 source IP blocks %s from policy %s because we are not IPv6 "+
-							"Enabled!", peerIPBlock[api.IPv6Protocol], policy.Name)
-					}
+				}
+				peerIPBlock := npc.evalIPBlockPeer(peer)
 
-					ingressRule.srcIPBlocks[api.IPv4Protocol] = append(
-						ingressRule.srcIPBlocks[api.IPv4Protocol],
-						peerIPBlock[api.IPv4Protocol]...,
-					)
-					ingressRule.srcIPBlocks[api.IPv6Protocol] = append(
-						ingressRule.srcIPBlocks[api.IPv6Protocol],
-						peerIPBlock[api.IPv6Protocol]...,
-					)
+				_, foundIPv4Addresses := peerIPBlock[api.IPv4Protocol]
+				_, foundIPv6Addresses := peerIPBlock[api.IPv6Protocol]
+				if foundIPv4Addresses && !isIPv4Enabled {
+					klog.Warningf("Ignoring IPv4 source IP blocks %s from policy %s because we are not IPv4 "+
+						"Enabled!", peerIPBlock[api.IPv4Protocol], policy.Name)
+				}
+				if foundIPv6Addresses && !isIPv6Enabled {
+					klog.Warningf("Ignoring IPv6 source IP blocks %s from policy %s because we are not IPv6 "+
+						"Enabled!", peerIPBlock[api.IPv6Protocol], policy.Name)
 				}
-			}
 
-			ingressRule.ports = make([]protocolAndPort, 0)
-			ingressRule.namedPorts = make([]endPoints, 0)
-			// If this field is empty or missing in the spec, this rule matches all ports
-			if len(specIngressRule.Ports) == 0 {
-				ingressRule.matchAllPorts = true
-			} else {
-				ingressRule.matchAllPorts = false
-				ingressRule.ports, ingressRule.namedPorts = npc.processNetworkPolicyPorts(
-					specIngressRule.Ports, namedPort2IngressEps)
+				ingressRule.srcIPBlocks[api.IPv4Protocol] = append(
+					ingressRule.srcIPBlocks[api.IPv4Protocol],
+					peerIPBlock[api.IPv4Protocol]...,
+				)
+				ingressRule.srcIPBlocks[api.IPv6Protocol] = append(
+					ingressRule.srcIPBlocks[api.IPv6Protocol],
+					peerIPBlock[api.IPv6Protocol]...,
+				)
 			}
+		}
 
-			newPolicy.ingressRules = append(newPolicy.ingressRules, ingressRule)
+		ingressRule.ports = make([]protocolAndPort, 0)
+		ingressRule.namedPorts = make([]endPoints, 0)
+		// If this field is empty or missing in the spec, this rule matches all ports
+		if len(specIngressRule.Ports) == 0 {
+			ingressRule.matchAllPorts = true
+		} else {
+			ingressRule.matchAllPorts = false
+			ingressRule.ports, ingressRule.namedPorts = npc.processNetworkPolicyPorts(
+				specIngressRule.Ports, namedPort2IngressEps)
 		}
 
-		for _, specEgressRule := range policy.Spec.Egress {
-			egressRule := egressRule{}
-			egressRule.dstPods = make([]podInfo, 0)
-			egressRule.dstIPBlocks = make(map[api.IPFamily][][]string, 0)
-			namedPort2EgressEps := make(namedPort2eps)
+		newPolicy.ingressRules = append(newPolicy.ingressRules, ingressRule)
+	}
 
-			// If this field is empty or missing in the spec, this rule matches all sources
-			if len(specEgressRule.To) == 0 {
-				egressRule.matchAllDestinations = true
-				// if rule.To is empty but rule.Ports not, we must try to grab NamedPort from pods that in same
-				// namespace, so that we can design iptables rule to describe "match all dst but match some named
-				// dst-port" egress rule
-				if policyRulePortsHasNamedPort(specEgressRule.Ports) {
-					matchingPeerPods, _ := npc.ListPodsByNamespaceAndLabels(policy.Namespace, labels.Everything())
-					for _, peerPod := range matchingPeerPods {
+	for _, specEgressRule := range policy.Spec.Egress {
+		egressRule := egressRule{}
+		egressRule.dstPods = make([]podInfo, 0)
+		egressRule.dstIPBlocks = make(map[api.IPFamily][][]string, 0)
+		namedPort2EgressEps := make(namedPort2eps)
+
+		// If this field is empty or missing in the spec, this rule matches all sources
+		if len(specEgressRule.To) == 0 {
+			egressRule.matchAllDestinations = true
+			// if rule.To is empty but rule.Ports not, we must try to grab NamedPort from pods that in same
+			// namespace, so that we can design iptables rule to describe "match all dst but match some named
+			// dst-port" egress rule
+			if policyRulePortsHasNamedPort(specEgressRule.Ports) {
+				matchingPeerPods, _ := npc.ListPodsByNamespaceAndLabels(policy.Namespace, labels.Everything())
+				for _, peerPod := range matchingPeerPods {
+					if !isNetPolActionable(peerPod) {
+						continue
+					}
+					npc.grabNamedPortFromPod(peerPod, &namedPort2EgressEps)
+				}
+			}
+		} else {
+			egressRule.matchAllDestinations = false
+			for _, peer := range specEgressRule.To {
+				if peerPods, err := npc.evalPodPeer(policy, peer); err == nil {
+					for _, peerPod := range peerPods {
 						if !isNetPolActionable(peerPod) {
 							continue
 						}
+						egressRule.dstPods = append(egressRule.dstPods,
+							podInfo{ips: peerPod.Status.PodIPs,
+								name:      peerPod.Name,
+								namespace: peerPod.Namespace,
+								labels:    peerPod.Labels})
 						npc.grabNamedPortFromPod(peerPod, &namedPort2EgressEps)
 					}
-				}
-			} else {
-				egressRule.matchAllDestinations = false
-				for _, peer := range specEgressRule.To {
-					if peerPods, err := npc.evalPodPeer(policy, peer); err == nil {
-						for _, peerPod := range peerPods {
-							if !isNetPolActionable(peerPod) {
-								continue
-							}
-							egressRule.dstPods = append(egressRule.dstPods,
-								podInfo{ips: peerPod.Status.PodIPs,
-									name:      peerPod.Name,
-									namespace: peerPod.Namespace,
-									labels:    peerPod.Labels})
-							npc.grabNamedPortFromPod(peerPod, &namedPort2EgressEps)
-						}
-
-					}
-					peerIPBlock := npc.evalIPBlockPeer(peer)
 
-					_, foundIPv4Addresses := peerIPBlock[api.IPv4Protocol]
-					_, foundIPv6Addresses := peerIPBlock[api.IPv6Protocol]
-					if foundIPv4Addresses && !isIPv4Enabled {
-						klog.Warningf("Ignoring IPv4 dest IP blocks %s from policy %s because we are not IPv4 "+
-							"Enabled!", peerIPBlock[api.IPv4Protocol], policy.Name)
-					}
-					if foundIPv6Addresses && !isIPv6Enabled {
-						klog.Warningf("Ignoring IPv6 dest IP blocks %s from policy %s because we are not IPv6 "+
-							"Enabled!", peerIPBlock[api.IPv6Protocol], policy.Name)
-					}
+				}
+				peerIPBlock := npc.evalIPBlockPeer(peer)
 
-					egressRule.dstIPBlocks[api.IPv4Protocol] = append(
-						egressRule.dstIPBlocks[api.IPv4Protocol],
-						peerIPBlock[api.IPv4Protocol]...,
-					)
-					egressRule.dstIPBlocks[api.IPv6Protocol] = append(
-						egressRule.dstIPBlocks[api.IPv6Protocol],
-						peerIPBlock[api.IPv6Protocol]...,
-					)
+				_, foundIPv4Addresses := peerIPBlock[api.IPv4Protocol]
+				_, foundIPv6Addresses := peerIPBlock[api.IPv6Protocol]
+				if foundIPv4Addresses && !isIPv4Enabled {
+					klog.Warningf("Ignoring IPv4 dest IP blocks %s from policy %s because we are not IPv4 "+
+						"Enabled!", peerIPBlock[api.IPv4Protocol], policy.Name)
+				}
+				if foundIPv6Addresses && !isIPv6Enabled {
+					klog.Warningf("Ignoring IPv6 dest IP blocks %s from policy %s because we are not IPv6 "+
+						"Enabled!", peerIPBlock[api.IPv6Protocol], policy.Name)
 				}
-			}
 
-			egressRule.ports = make([]protocolAndPort, 0)
-			egressRule.namedPorts = make([]endPoints, 0)
-			// If this field is empty or missing in the spec, this rule matches all ports
-			if len(specEgressRule.Ports) == 0 {
-				egressRule.matchAllPorts = true
-			} else {
-				egressRule.matchAllPorts = false
-				egressRule.ports, egressRule.namedPorts = npc.processNetworkPolicyPorts(
-					specEgressRule.Ports, namedPort2EgressEps)
+				egressRule.dstIPBlocks[api.IPv4Protocol] = append(
+					egressRule.dstIPBlocks[api.IPv4Protocol],
+					peerIPBlock[api.IPv4Protocol]...,
+				)
+				egressRule.dstIPBlocks[api.IPv6Protocol] = append(
+					egressRule.dstIPBlocks[api.IPv6Protocol],
+					peerIPBlock[api.IPv6Protocol]...,
+				)
 			}
+		}
 
-			newPolicy.egressRules = append(newPolicy.egressRules, egressRule)
+		egressRule.ports = make([]protocolAndPort, 0)
+		egressRule.namedPorts = make([]endPoints, 0)
+		// If this field is empty or missing in the spec, this rule matches all ports
+		if len(specEgressRule.Ports) == 0 {
+			egressRule.matchAllPorts = true
+		} else {
+			egressRule.matchAllPorts = false
+			egressRule.ports, egressRule.namedPorts = npc.processNetworkPolicyPorts(
+				specEgressRule.Ports, namedPort2EgressEps)
 		}
-		NetworkPolicies = append(NetworkPolicies, newPolicy)
-	}
 
-	return NetworkPolicies, nil
+		newPolicy.egressRules = append(newPolicy.egressRules, egressRule)
+	}
+	return newPolicy
 }
 
 func (npc *NetworkPolicyController) evalPodPeer(policy *networking.NetworkPolicy,
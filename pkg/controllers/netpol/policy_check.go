@@ -0,0 +1,481 @@
+package netpol
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cloudnativelabs/kube-router/v2/pkg/apis/policy/v1alpha1"
+	"github.com/cloudnativelabs/kube-router/v2/pkg/utils"
+	api "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	netutils "k8s.io/utils/net"
+)
+
+// NetworkPolicyCheck describes the traffic that CheckNetworkPolicies evaluates the network policies for, the source
+// and destination are either a pod as namespace/name or an IP address. Port is 0 for protocols without ports like ICMP.
+type NetworkPolicyCheck struct {
+	From     string
+	To       string
+	Protocol string
+	Port     int
+}
+
+// NetworkPolicyVerdict is the result of evaluating the network policies for the traffic of a NetworkPolicyCheck, the
+// traffic is allowed if both the egress policies of its source and the ingress policies of its destination allow it
+type NetworkPolicyVerdict struct {
+	Allowed bool                          `json:"allowed"`
+	Egress  NetworkPolicyDirectionVerdict `json:"egress"`
+	Ingress NetworkPolicyDirectionVerdict `json:"ingress"`
+}
+
+// NetworkPolicyDirectionVerdict is the verdict of the network policies of the source pod for egress or of the
+// destination pod for ingress. Policy, Kind and Rule name the network policy, admin network policy or baseline admin
+// network policy and the index of its rule that decided the traffic, Policies lists the network policies that were
+// evaluated when none did.
+type NetworkPolicyDirectionVerdict struct {
+	Direction string   `json:"direction"`
+	Pod       string   `json:"pod,omitempty"`
+	IP        string   `json:"ip"`
+	Allowed   bool     `json:"allowed"`
+	Audit     bool     `json:"audit,omitempty"`
+	Policies  []string `json:"policies,omitempty"`
+	Policy    string   `json:"policy,omitempty"`
+	Kind      string   `json:"kind,omitempty"`
+	Rule      int      `json:"rule"`
+	Reason    string   `json:"reason"`
+}
+
+type policyCheckEndpoint struct {
+	pod     *podInfo
+	ip      string
+	hostIPs []string
+}
+
+// policyCheckTraffic is the traffic of a NetworkPolicyCheck between the addresses of its source and destination
+type policyCheckTraffic struct {
+	srcIP    string
+	dstIP    string
+	protocol string
+	port     int
+	ipFamily api.IPFamily
+}
+
+// CheckNetworkPolicies evaluates the admin network policies and network policies of the listers for the traffic of
+// the check the same way that the rules rendered by the network policy controller do, without touching the firewall
+// of the host. The listers of the AdminNetworkPolicies and the BaselineAdminNetworkPolicy hold unstructured objects
+// like the ones of the dynamic informers, they may be nil when the custom resources aren't installed.
+func CheckNetworkPolicies(podLister, nsLister, npLister, anpLister, banpLister cache.Indexer,
+	check NetworkPolicyCheck) (*NetworkPolicyVerdict, error) {
+	npc := &NetworkPolicyController{
+		podLister:  podLister,
+		nsLister:   nsLister,
+		npLister:   npLister,
+		anpLister:  anpLister,
+		banpLister: banpLister,
+		filterTableRules: map[api.IPFamily]*bytes.Buffer{
+			api.IPv4Protocol: {},
+			api.IPv6Protocol: {},
+		},
+	}
+
+	check.Protocol = strings.ToUpper(check.Protocol)
+	if check.Protocol == "" {
+		check.Protocol = string(api.ProtocolTCP)
+	}
+	if check.Port < 0 || check.Port > 65535 {
+		return nil, fmt.Errorf("invalid port %d", check.Port)
+	}
+	hasPorts := slices.Contains([]string{string(api.ProtocolTCP), string(api.ProtocolUDP), string(api.ProtocolSCTP)},
+		check.Protocol)
+	if hasPorts && check.Port == 0 {
+		return nil, fmt.Errorf("a port is required for protocol %s", check.Protocol)
+	}
+	if !hasPorts && check.Port != 0 {
+		return nil, fmt.Errorf("protocol %s has no ports", check.Protocol)
+	}
+
+	src, err := npc.policyCheckEndpoint(check.From)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source: %v", err)
+	}
+	dst, err := npc.policyCheckEndpoint(check.To)
+	if err != nil {
+		return nil, fmt.Errorf("invalid destination: %v", err)
+	}
+	srcIP, dstIP, err := policyCheckAddresses(src, dst)
+	if err != nil {
+		return nil, err
+	}
+
+	networkPoliciesInfo, err := npc.buildNetworkPoliciesInfo()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build network policies info: %v", err)
+	}
+	sort.Slice(networkPoliciesInfo, func(i, j int) bool {
+		if networkPoliciesInfo[i].namespace != networkPoliciesInfo[j].namespace {
+			return networkPoliciesInfo[i].namespace < networkPoliciesInfo[j].namespace
+		}
+		return networkPoliciesInfo[i].name < networkPoliciesInfo[j].name
+	})
+
+	adminPoliciesInfo, err := npc.buildAdminNetworkPoliciesInfo()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build admin network policies info: %v", err)
+	}
+
+	traffic := policyCheckTraffic{srcIP: srcIP, dstIP: dstIP, protocol: check.Protocol, port: check.Port,
+		ipFamily: api.IPv4Protocol}
+	if netutils.IsIPv6String(srcIP) {
+		traffic.ipFamily = api.IPv6Protocol
+	}
+	verdict := &NetworkPolicyVerdict{
+		Egress:  checkEndpointPolicies(networkPoliciesInfo, adminPoliciesInfo, src, kubeEgressPolicyType, traffic),
+		Ingress: checkEndpointPolicies(networkPoliciesInfo, adminPoliciesInfo, dst, kubeIngressPolicyType, traffic),
+	}
+	verdict.Allowed = verdict.Egress.Allowed && verdict.Ingress.Allowed
+	return verdict, nil
+}
+
+// policyCheckEndpoint resolves a namespace/name or an IP address to the pod that network policies can select, an
+// address that no such pod has is an endpoint outside of the pod network
+func (npc *NetworkPolicyController) policyCheckEndpoint(endpoint string) (policyCheckEndpoint, error) {
+	podLister := listers.NewPodLister(npc.podLister)
+	if namespace, name, found := strings.Cut(endpoint, "/"); found {
+		pod, err := podLister.Pods(namespace).Get(name)
+		if err != nil {
+			return policyCheckEndpoint{}, err
+		}
+		if pod.Status.PodIP == "" {
+			return policyCheckEndpoint{}, fmt.Errorf("pod %s has no IP address", endpoint)
+		}
+		if !isNetPolActionable(pod) {
+			// network policies don't apply to host network pods, nor to pods that have finished
+			return policyCheckEndpoint{ip: pod.Status.PodIP}, nil
+		}
+		return policyCheckEndpoint{pod: policyCheckPodInfo(pod), hostIPs: policyCheckHostIPs(pod)}, nil
+	}
+
+	if net.ParseIP(endpoint) == nil {
+		return policyCheckEndpoint{}, fmt.Errorf("%q is neither a namespace/pod nor an IP address", endpoint)
+	}
+	pods, err := podLister.List(labels.Everything())
+	if err != nil {
+		return policyCheckEndpoint{}, err
+	}
+	for _, pod := range pods {
+		if !isNetPolActionable(pod) {
+			continue
+		}
+		for _, ip := range pod.Status.PodIPs {
+			if net.ParseIP(ip.IP).Equal(net.ParseIP(endpoint)) {
+				return policyCheckEndpoint{pod: policyCheckPodInfo(pod), hostIPs: policyCheckHostIPs(pod)}, nil
+			}
+		}
+	}
+	return policyCheckEndpoint{ip: endpoint}, nil
+}
+
+// policyCheckHostIPs returns the addresses of the node of the pod that the API server knows of, the node may have
+// further local addresses
+func policyCheckHostIPs(pod *api.Pod) []string {
+	hostIPs := make([]string, 0, len(pod.Status.HostIPs)+1)
+	for _, ip := range pod.Status.HostIPs {
+		hostIPs = append(hostIPs, ip.IP)
+	}
+	if len(hostIPs) == 0 && pod.Status.HostIP != "" {
+		hostIPs = append(hostIPs, pod.Status.HostIP)
+	}
+	return hostIPs
+}
+
+func policyCheckPodInfo(pod *api.Pod) *podInfo {
+	return &podInfo{ip: pod.Status.PodIP, ips: pod.Status.PodIPs, name: pod.Name, namespace: pod.Namespace,
+		labels: pod.Labels}
+}
+
+// policyCheckAddresses picks the addresses of the source and destination of the same family, preferring the primary
+// address of the source
+func policyCheckAddresses(src, dst policyCheckEndpoint) (string, string, error) {
+	for _, srcIP := range src.addresses() {
+		for _, dstIP := range dst.addresses() {
+			if netutils.IsIPv4String(srcIP) == netutils.IsIPv4String(dstIP) {
+				return srcIP, dstIP, nil
+			}
+		}
+	}
+	return "", "", fmt.Errorf("source and destination have no addresses of the same IP family")
+}
+
+func (endpoint policyCheckEndpoint) addresses() []string {
+	if endpoint.pod == nil {
+		return []string{endpoint.ip}
+	}
+	addresses := []string{endpoint.pod.ip}
+	for _, ip := range endpoint.pod.ips {
+		if ip.IP != endpoint.pod.ip {
+			addresses = append(addresses, ip.IP)
+		}
+	}
+	return addresses
+}
+
+// checkEndpointPolicies evaluates the policies that select the pod of the endpoint for the direction in the order of
+// the pod firewall chain: the admin network policies in priority order until one of their rules matches, then the
+// network policies, or the baseline admin network policy when no network policy selects the pod for the direction.
+// Network policies in audit mode are evaluated as they would be enforced.
+func checkEndpointPolicies(networkPoliciesInfo []networkPolicyInfo, adminPoliciesInfo []adminNetworkPolicyInfo,
+	endpoint policyCheckEndpoint, direction string, traffic policyCheckTraffic) NetworkPolicyDirectionVerdict {
+	verdict := NetworkPolicyDirectionVerdict{Direction: direction, IP: traffic.dstIP, Rule: -1}
+	if direction == kubeEgressPolicyType {
+		verdict.IP = traffic.srcIP
+	}
+	if endpoint.pod == nil {
+		verdict.Allowed = true
+		verdict.Reason = "not a pod that network policies can select"
+		return verdict
+	}
+	pod := *endpoint.pod
+	verdict.Pod = pod.namespace + "/" + pod.name
+
+	// the pod firewall chain accepts all traffic from the local node ahead of any policy
+	if direction == kubeIngressPolicyType && slices.ContainsFunc(endpoint.hostIPs, func(ip string) bool {
+		return net.ParseIP(ip).Equal(net.ParseIP(traffic.srcIP))
+	}) {
+		verdict.Allowed = true
+		verdict.Reason = "traffic from the pod's local node is always allowed"
+		return verdict
+	}
+
+	// a Pass rule hands the traffic over to the network policies and the baseline admin network policy
+	passedBy := ""
+	for _, policy := range adminPoliciesInfo {
+		if policy.baseline || !policy.appliesTo(pod, direction) {
+			continue
+		}
+		rule, matched := policy.checkRules(direction, traffic)
+		if !matched {
+			continue
+		}
+		if rule.action == v1alpha1.AdminNetworkPolicyRuleActionPass {
+			passedBy = policy.ruleDescription(direction, rule)
+			break
+		}
+		return verdict.decidedByAdminPolicy(policy, rule)
+	}
+
+	verdict = checkEndpointNetworkPolicies(verdict, networkPoliciesInfo, adminPoliciesInfo, pod, traffic)
+	if passedBy != "" {
+		verdict.Reason += ", after " + passedBy + " passed it"
+	}
+	return verdict
+}
+
+// checkEndpointNetworkPolicies evaluates the network policies that select the pod for the direction of the verdict,
+// or the baseline admin network policy if there are none
+func checkEndpointNetworkPolicies(verdict NetworkPolicyDirectionVerdict, networkPoliciesInfo []networkPolicyInfo,
+	adminPoliciesInfo []adminNetworkPolicyInfo, pod podInfo, traffic policyCheckTraffic) NetworkPolicyDirectionVerdict {
+	direction := verdict.Direction
+	auditIngress, auditEgress := podAuditedDirections(pod, networkPoliciesInfo)
+	verdict.Audit = auditIngress && direction == kubeIngressPolicyType ||
+		auditEgress && direction == kubeEgressPolicyType
+
+	for _, policy := range networkPoliciesInfo {
+		ingress, egress := policy.evaluatedDirections(pod, auditIngress, auditEgress)
+		if direction == kubeIngressPolicyType && !ingress || direction == kubeEgressPolicyType && !egress {
+			continue
+		}
+		name := policy.namespace + "/" + policy.name
+		verdict.Policies = append(verdict.Policies, name)
+		rules, sets := policyCheckChain(policy, traffic.ipFamily)
+		for _, rule := range rules {
+			if rule.matches(sets, traffic.srcIP, traffic.dstIP, traffic.protocol, traffic.port) {
+				verdict.Allowed, verdict.Policy, verdict.Rule = true, name, rule.ruleIdx
+				return verdict.allowedBy(rule.direction)
+			}
+		}
+	}
+
+	if len(verdict.Policies) == 0 {
+		if policy := baselineAdminPolicy(adminPoliciesInfo); policy != nil && policy.appliesTo(pod, direction) {
+			if rule, matched := policy.checkRules(direction, traffic); matched {
+				return verdict.decidedByAdminPolicy(*policy, rule)
+			}
+		}
+		verdict.Allowed = true
+		verdict.Reason = "no network policy selects the pod for " + direction
+		return verdict
+	}
+	verdict.Reason = "none of the network policies that select the pod for " + direction + " allow the traffic"
+	if verdict.Audit {
+		verdict.Reason += ", it is logged and let through as the network policies are in audit mode"
+	}
+	return verdict
+}
+
+func (verdict NetworkPolicyDirectionVerdict) allowedBy(ruleDirection string) NetworkPolicyDirectionVerdict {
+	verdict.Policies = nil
+	verdict.Kind = "network policy"
+	verdict.Reason = "allowed by " + ruleDirection + " rule " + strconv.Itoa(verdict.Rule) +
+		" of network policy " + verdict.Policy
+	if ruleDirection != verdict.Direction {
+		verdict.Reason += ", the " + verdict.Direction + " traffic of the pod goes through the rules of both " +
+			"directions as they share the chain of the network policy"
+	}
+	return verdict
+}
+
+// decidedByAdminPolicy returns the verdict of an Allow or Deny rule of an admin policy, the traffic that such a rule
+// denies is rejected right away, even if the network policies of the pod are in audit mode
+func (verdict NetworkPolicyDirectionVerdict) decidedByAdminPolicy(policy adminNetworkPolicyInfo,
+	rule adminPolicyChainRule) NetworkPolicyDirectionVerdict {
+	verdict.Allowed = rule.action == v1alpha1.AdminNetworkPolicyRuleActionAllow
+	verdict.Audit = false
+	verdict.Policies = nil
+	verdict.Policy, verdict.Kind, verdict.Rule = policy.name, policy.kind(), rule.ruleIdx
+	verdict.Reason = "allowed by " + policy.ruleDescription(verdict.Direction, rule)
+	if !verdict.Allowed {
+		verdict.Reason = "denied by " + policy.ruleDescription(verdict.Direction, rule)
+	}
+	return verdict
+}
+
+func (policy adminNetworkPolicyInfo) ruleDescription(direction string, rule adminPolicyChainRule) string {
+	rules := policy.ingressRules
+	if direction == adminPolicyEgress {
+		rules = policy.egressRules
+	}
+	return fmt.Sprintf("%s rule %d (%s) of %s %s", direction, rule.ruleIdx, rules[rule.ruleIdx].name, policy.kind(),
+		policy.name)
+}
+
+// checkRules returns the first rule of the chain of the admin policy for the direction that matches the traffic, the
+// pod firewall chain only jumps to the chain for the traffic of the pod in that direction
+func (policy adminNetworkPolicyInfo) checkRules(direction string,
+	traffic policyCheckTraffic) (adminPolicyChainRule, bool) {
+	rules := policy.ingressRules
+	if direction == adminPolicyEgress {
+		rules = policy.egressRules
+	}
+	chain := policy.adminPolicyChain(direction, rules, "", traffic.ipFamily)
+	sets := make(map[string]networkPolicySet, len(chain.sets))
+	for _, set := range chain.sets {
+		setType := utils.TypeHashIP
+		if set.hashNet {
+			setType = utils.TypeHashNet
+		}
+		sets[set.name] = networkPolicySet{name: set.name, setType: setType, entries: ipSetEntries(set.entries)}
+	}
+	for _, rule := range chain.rules {
+		chainRule := networkPolicyChainRule{srcSetName: rule.srcSetName, dstSetName: rule.dstSetName,
+			portProtocol: rule.portProtocol}
+		if chainRule.matches(sets, traffic.srcIP, traffic.dstIP, traffic.protocol, traffic.port) {
+			return rule, true
+		}
+	}
+	return adminPolicyChainRule{}, false
+}
+
+// policyCheckRule is a rule of the chain of a network policy along with the direction it was rendered for
+type policyCheckRule struct {
+	networkPolicyChainRule
+	direction string
+}
+
+// policyCheckChain returns the rules of the chain of the network policy for the IP family in the order that the
+// firewall backends render them, along with the ipsets that they reference by name. The ingress and egress rules of a
+// network policy share its chain, so the traffic of either direction is matched against both.
+func policyCheckChain(policy networkPolicyInfo,
+	ipFamily api.IPFamily) ([]policyCheckRule, map[string]networkPolicySet) {
+	targetPodIPs := make([]string, 0, len(policy.targetPods))
+	for _, pod := range policy.targetPods {
+		targetPodIPs = append(targetPodIPs, getIPsFromPods([]podInfo{pod}, ipFamily)...)
+	}
+
+	var rules []policyCheckRule
+	sets := make(map[string]networkPolicySet)
+	addChain := func(direction, targetPodIPSetName string, chain networkPolicyChain) {
+		sets[targetPodIPSetName] = networkPolicySet{name: targetPodIPSetName, setType: utils.TypeHashIP,
+			entries: ipSetEntries(targetPodIPs)}
+		// an ipset that is refreshed more than once ends up with the entries of its last refresh
+		for _, set := range chain.sets {
+			sets[set.name] = set
+		}
+		for _, rule := range chain.rules {
+			rules = append(rules, policyCheckRule{networkPolicyChainRule: rule, direction: direction})
+		}
+	}
+	if policy.policyType == kubeBothPolicyType || policy.policyType == kubeIngressPolicyType {
+		targetDestPodIPSetName := policyDestinationPodIPSetName(policy.namespace, policy.name, ipFamily)
+		addChain(kubeIngressPolicyType, targetDestPodIPSetName, policy.ingressChain(targetDestPodIPSetName, ipFamily))
+	}
+	if policy.policyType == kubeBothPolicyType || policy.policyType == kubeEgressPolicyType {
+		targetSourcePodIPSetName := policySourcePodIPSetName(policy.namespace, policy.name, ipFamily)
+		addChain(kubeEgressPolicyType, targetSourcePodIPSetName,
+			policy.egressChain(targetSourcePodIPSetName, ipFamily))
+	}
+	return rules, sets
+}
+
+// matches returns whether the rule matches the traffic, the same way as the rule that the firewall backends render
+func (rule networkPolicyChainRule) matches(sets map[string]networkPolicySet, srcIP, dstIP, protocol string,
+	port int) bool {
+	return (rule.srcSetName == "" || sets[rule.srcSetName].contains(srcIP)) &&
+		(rule.dstSetName == "" || sets[rule.dstSetName].contains(dstIP)) &&
+		rule.portProtocol.matches(protocol, port)
+}
+
+// contains returns whether the ipset contains the address. hash:net ipsets are matched the way the kernel does, the
+// most specific entry that contains the address decides and nomatch entries exclude it.
+func (set networkPolicySet) contains(ip string) bool {
+	addr := net.ParseIP(ip)
+	bestPrefix, matched := -1, false
+	for _, entry := range set.entries {
+		if len(entry) == 0 {
+			continue
+		}
+		if set.setType != utils.TypeHashNet || !strings.Contains(entry[0], "/") {
+			if addr.Equal(net.ParseIP(entry[0])) {
+				return true
+			}
+			continue
+		}
+		_, cidr, err := net.ParseCIDR(entry[0])
+		if err != nil || !cidr.Contains(addr) {
+			continue
+		}
+		if prefix, _ := cidr.Mask.Size(); prefix > bestPrefix {
+			bestPrefix = prefix
+			matched = entry[len(entry)-1] != utils.OptionNoMatch
+		}
+	}
+	return matched
+}
+
+// matches returns whether the protocol and destination port match, a rule without protocol matches all protocols and
+// one without port all ports, just like the rendered rule
+func (portProtocol protocolAndPort) matches(protocol string, port int) bool {
+	if portProtocol.protocol != "" && !strings.EqualFold(portProtocol.protocol, protocol) {
+		return false
+	}
+	if portProtocol.port == "" {
+		return true
+	}
+	start, err := strconv.Atoi(portProtocol.port)
+	if err != nil {
+		return false
+	}
+	end := start
+	if portProtocol.endport != "" {
+		if end, err = strconv.Atoi(portProtocol.endport); err != nil {
+			return false
+		}
+	}
+	return port >= start && port <= end
+}
@@ -0,0 +1,385 @@
+package netpol
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/cloudnativelabs/kube-router/v2/pkg/apis/policy/v1alpha1"
+	"github.com/cloudnativelabs/kube-router/v2/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/utils/ptr"
+)
+
+func Test_networkPolicySetContains(t *testing.T) {
+	ipBlock := networkPolicySet{setType: utils.TypeHashNet, entries: [][]string{
+		{"10.0.0.0/8", utils.OptionTimeout, "0"},
+		{"10.1.0.0/16", utils.OptionTimeout, "0", utils.OptionNoMatch},
+		{"10.1.2.0/24", utils.OptionTimeout, "0"},
+	}}
+	assert.True(t, ipBlock.contains("10.2.0.1"))
+	assert.False(t, ipBlock.contains("10.1.1.1"))
+	assert.True(t, ipBlock.contains("10.1.2.1"))
+	assert.False(t, ipBlock.contains("192.168.0.1"))
+	assert.False(t, networkPolicySet{setType: utils.TypeHashNet}.contains("10.2.0.1"))
+
+	pods := networkPolicySet{setType: utils.TypeHashIP, entries: ipSetEntries([]string{"10.1.0.5", "fd00::5"})}
+	assert.True(t, pods.contains("10.1.0.5"))
+	assert.True(t, pods.contains("fd00:0::5"))
+	assert.False(t, pods.contains("10.1.0.6"))
+}
+
+func Test_protocolAndPortMatches(t *testing.T) {
+	assert.True(t, protocolAndPort{protocol: "TCP", port: "8080"}.matches("TCP", 8080))
+	assert.False(t, protocolAndPort{protocol: "UDP", port: "8080"}.matches("TCP", 8080))
+	assert.True(t, protocolAndPort{protocol: "TCP", port: "8000", endport: "8100"}.matches("TCP", 8080))
+	assert.False(t, protocolAndPort{protocol: "TCP", port: "8000", endport: "8010"}.matches("TCP", 8080))
+	assert.True(t, protocolAndPort{protocol: "UDP"}.matches("UDP", 53))
+	// rules without protocol and port match all traffic
+	assert.True(t, protocolAndPort{}.matches("SCTP", 9))
+}
+
+func newTestPolicyCheckListers(t *testing.T) (podLister, nsLister, npLister cache.Indexer) {
+	namespaced := cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}
+	podLister = cache.NewIndexer(cache.MetaNamespaceKeyFunc, namespaced)
+	nsLister = cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	npLister = cache.NewIndexer(cache.MetaNamespaceKeyFunc, namespaced)
+
+	for _, ns := range []string{"app", "monitoring"} {
+		assert.NoError(t, nsLister.Add(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: ns,
+			Labels: map[string]string{"kubernetes.io/metadata.name": ns}}}))
+	}
+	for _, pod := range []*v1.Pod{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "app", Labels: map[string]string{"app": "web"}},
+			Spec: v1.PodSpec{Containers: []v1.Container{{Ports: []v1.ContainerPort{
+				{Name: "http", ContainerPort: 8080, Protocol: v1.ProtocolTCP}}}}},
+			Status: v1.PodStatus{PodIP: "10.1.0.5", PodIPs: []v1.PodIP{{IP: "10.1.0.5"}}, Phase: v1.PodRunning,
+				HostIP: "192.168.1.10", HostIPs: []v1.HostIP{{IP: "192.168.1.10"}}},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "frontend", Namespace: "app",
+				Labels: map[string]string{"app": "frontend"}},
+			Status: v1.PodStatus{PodIP: "10.1.0.6", PodIPs: []v1.PodIP{{IP: "10.1.0.6"}}, Phase: v1.PodRunning},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "prometheus", Namespace: "monitoring"},
+			Status:     v1.PodStatus{PodIP: "10.1.1.5", PodIPs: []v1.PodIP{{IP: "10.1.1.5"}}, Phase: v1.PodRunning},
+		},
+	} {
+		assert.NoError(t, podLister.Add(pod))
+	}
+	tcp := v1.ProtocolTCP
+	assert.NoError(t, npLister.Add(&networking.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "app"},
+		Spec: networking.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+			PolicyTypes: []networking.PolicyType{networking.PolicyTypeIngress},
+			Ingress: []networking.NetworkPolicyIngressRule{
+				{
+					From: []networking.NetworkPolicyPeer{{NamespaceSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"kubernetes.io/metadata.name": "monitoring"}}}},
+					Ports: []networking.NetworkPolicyPort{{Protocol: &tcp, Port: ptr.To(intstr.FromInt32(9090))}},
+				},
+				{
+					From: []networking.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "frontend"}}}},
+					Ports: []networking.NetworkPolicyPort{{Protocol: &tcp, Port: ptr.To(intstr.FromString("http"))}},
+				},
+			},
+		},
+	}))
+	return podLister, nsLister, npLister
+}
+
+func TestCheckNetworkPolicies(t *testing.T) {
+	podLister, nsLister, npLister := newTestPolicyCheckListers(t)
+
+	verdict, err := CheckNetworkPolicies(podLister, nsLister, npLister, nil, nil,
+		NetworkPolicyCheck{From: "app/frontend", To: "app/web", Protocol: "tcp", Port: 8080})
+	assert.NoError(t, err)
+	assert.True(t, verdict.Allowed)
+	assert.Equal(t, NetworkPolicyDirectionVerdict{Direction: "egress", Pod: "app/frontend", IP: "10.1.0.6",
+		Allowed: true, Rule: -1, Reason: "no network policy selects the pod for egress"}, verdict.Egress)
+	assert.Equal(t, NetworkPolicyDirectionVerdict{Direction: "ingress", Pod: "app/web", IP: "10.1.0.5",
+		Allowed: true, Policy: "app/web", Kind: "network policy", Rule: 1,
+		Reason: "allowed by ingress rule 1 of network policy app/web"},
+		verdict.Ingress)
+
+	// the prometheus pod is only allowed on the metrics port and is resolved from its address
+	verdict, err = CheckNetworkPolicies(podLister, nsLister, npLister, nil, nil,
+		NetworkPolicyCheck{From: "10.1.1.5", To: "app/web", Port: 8080})
+	assert.NoError(t, err)
+	assert.False(t, verdict.Allowed)
+	assert.Equal(t, "monitoring/prometheus", verdict.Egress.Pod)
+	assert.Equal(t, []string{"app/web"}, verdict.Ingress.Policies)
+	assert.False(t, verdict.Ingress.Audit)
+
+	// once the namespace is in audit mode the verdict is the same, but the traffic is let through
+	assert.NoError(t, nsLister.Update(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "app",
+		Labels:      map[string]string{"kubernetes.io/metadata.name": "app"},
+		Annotations: map[string]string{netpolModeAnnotation: netpolModeAudit}}}))
+	verdict, err = CheckNetworkPolicies(podLister, nsLister, npLister, nil, nil,
+		NetworkPolicyCheck{From: "monitoring/prometheus", To: "app/web", Port: 8080})
+	assert.NoError(t, err)
+	assert.False(t, verdict.Allowed)
+	assert.True(t, verdict.Ingress.Audit)
+
+	_, err = CheckNetworkPolicies(podLister, nsLister, npLister, nil, nil,
+		NetworkPolicyCheck{From: "app/missing", To: "app/web", Port: 8080})
+	assert.Error(t, err)
+	_, err = CheckNetworkPolicies(podLister, nsLister, npLister, nil, nil,
+		NetworkPolicyCheck{From: "app/frontend", To: "app/web", Protocol: "UDP"})
+	assert.Error(t, err)
+	_, err = CheckNetworkPolicies(podLister, nsLister, npLister, nil, nil,
+		NetworkPolicyCheck{From: "app/frontend", To: "app/web", Protocol: "ICMP", Port: 8080})
+	assert.Error(t, err)
+	_, err = CheckNetworkPolicies(podLister, nsLister, npLister, nil, nil,
+		NetworkPolicyCheck{From: "fd00::1", To: "app/web", Port: 8080})
+	assert.Error(t, err)
+}
+
+func TestCheckNetworkPoliciesICMP(t *testing.T) {
+	podLister, nsLister, npLister := newTestPolicyCheckListers(t)
+
+	// the rules of the network policy all have ports, so they don't match traffic without ports
+	verdict, err := CheckNetworkPolicies(podLister, nsLister, npLister, nil, nil,
+		NetworkPolicyCheck{From: "app/frontend", To: "app/web", Protocol: "icmp"})
+	assert.NoError(t, err)
+	assert.False(t, verdict.Allowed)
+	assert.Equal(t, []string{"app/web"}, verdict.Ingress.Policies)
+}
+
+func TestCheckNetworkPoliciesLocalNode(t *testing.T) {
+	podLister, nsLister, npLister := newTestPolicyCheckListers(t)
+
+	// the pod firewall chain accepts the traffic from the pod's node ahead of the network policies
+	verdict, err := CheckNetworkPolicies(podLister, nsLister, npLister, nil, nil,
+		NetworkPolicyCheck{From: "192.168.1.10", To: "app/web", Port: 8080})
+	assert.NoError(t, err)
+	assert.True(t, verdict.Allowed)
+	assert.Equal(t, NetworkPolicyDirectionVerdict{Direction: "ingress", Pod: "app/web", IP: "10.1.0.5",
+		Allowed: true, Rule: -1, Reason: "traffic from the pod's local node is always allowed"}, verdict.Ingress)
+
+	// other nodes are subject to the network policies
+	verdict, err = CheckNetworkPolicies(podLister, nsLister, npLister, nil, nil,
+		NetworkPolicyCheck{From: "192.168.1.11", To: "app/web", Port: 8080})
+	assert.NoError(t, err)
+	assert.False(t, verdict.Allowed)
+}
+
+func TestCheckNetworkPoliciesAdminPolicies(t *testing.T) {
+	podLister, nsLister, npLister := newTestPolicyCheckListers(t)
+	anpLister := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	banpLister := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+
+	allNamespaces := &metav1.LabelSelector{}
+	tAddAdminPolicy(t, anpLister, &v1alpha1.AdminNetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "pass-frontend"},
+		Spec: v1alpha1.AdminNetworkPolicySpec{
+			Priority: 5,
+			Subject:  v1alpha1.AdminNetworkPolicySubject{Namespaces: allNamespaces},
+			Ingress: []v1alpha1.AdminNetworkPolicyIngressRule{{Name: "frontend", Action: "Pass",
+				From: []v1alpha1.AdminNetworkPolicyIngressPeer{{Pods: &v1alpha1.NamespacedPod{
+					PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "frontend"}}}}}}},
+		},
+	})
+	tAddAdminPolicy(t, anpLister, &v1alpha1.AdminNetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "allow-monitoring"},
+		Spec: v1alpha1.AdminNetworkPolicySpec{
+			Priority: 10,
+			Subject:  v1alpha1.AdminNetworkPolicySubject{Namespaces: allNamespaces},
+			Ingress: []v1alpha1.AdminNetworkPolicyIngressRule{{Name: "scrape", Action: "Allow",
+				From: []v1alpha1.AdminNetworkPolicyIngressPeer{{Namespaces: &metav1.LabelSelector{
+					MatchLabels: map[string]string{"kubernetes.io/metadata.name": "monitoring"}}}},
+				Ports: &[]v1alpha1.AdminNetworkPolicyPort{{PortNumber: &v1alpha1.Port{Port: 8443}}}}},
+			Egress: []v1alpha1.AdminNetworkPolicyEgressRule{{Name: "deny-dns", Action: "Deny",
+				To: []v1alpha1.AdminNetworkPolicyEgressPeer{{Networks: []string{"8.8.0.0/16"}}}}},
+		},
+	})
+	tAddAdminPolicy(t, banpLister, &v1alpha1.BaselineAdminNetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "default"},
+		Spec: v1alpha1.BaselineAdminNetworkPolicySpec{
+			Subject: v1alpha1.AdminNetworkPolicySubject{Namespaces: allNamespaces},
+			Ingress: []v1alpha1.BaselineAdminNetworkPolicyIngressRule{{Name: "deny-app", Action: "Deny",
+				From: []v1alpha1.AdminNetworkPolicyIngressPeer{{Namespaces: &metav1.LabelSelector{
+					MatchLabels: map[string]string{"kubernetes.io/metadata.name": "app"}}}}}},
+		},
+	})
+
+	// admin network policies are evaluated before the network policies of the pod
+	verdict, err := CheckNetworkPolicies(podLister, nsLister, npLister, anpLister, banpLister,
+		NetworkPolicyCheck{From: "monitoring/prometheus", To: "app/web", Port: 8443})
+	assert.NoError(t, err)
+	assert.True(t, verdict.Allowed)
+	assert.Equal(t, NetworkPolicyDirectionVerdict{Direction: "ingress", Pod: "app/web", IP: "10.1.0.5",
+		Allowed: true, Policy: "allow-monitoring", Kind: "admin network policy", Rule: 0,
+		Reason: "allowed by ingress rule 0 (scrape) of admin network policy allow-monitoring"}, verdict.Ingress)
+
+	verdict, err = CheckNetworkPolicies(podLister, nsLister, npLister, anpLister, banpLister,
+		NetworkPolicyCheck{From: "monitoring/prometheus", To: "8.8.8.8", Protocol: "udp", Port: 53})
+	assert.NoError(t, err)
+	assert.False(t, verdict.Allowed)
+	assert.Equal(t, "denied by egress rule 0 (deny-dns) of admin network policy allow-monitoring",
+		verdict.Egress.Reason)
+
+	// the traffic that a Pass rule matches is decided by the network policies
+	verdict, err = CheckNetworkPolicies(podLister, nsLister, npLister, anpLister, banpLister,
+		NetworkPolicyCheck{From: "app/frontend", To: "app/web", Port: 8080})
+	assert.NoError(t, err)
+	assert.True(t, verdict.Allowed)
+	assert.Equal(t, "app/web", verdict.Ingress.Policy)
+	assert.Equal(t, "allowed by ingress rule 1 of network policy app/web, after ingress rule 0 (frontend) of admin "+
+		"network policy pass-frontend passed it", verdict.Ingress.Reason)
+	verdict, err = CheckNetworkPolicies(podLister, nsLister, npLister, anpLister, banpLister,
+		NetworkPolicyCheck{From: "app/frontend", To: "app/web", Port: 8443})
+	assert.NoError(t, err)
+	assert.False(t, verdict.Allowed)
+	assert.Equal(t, []string{"app/web"}, verdict.Ingress.Policies)
+
+	// the baseline admin network policy is only evaluated when no network policy selects the pod
+	verdict, err = CheckNetworkPolicies(podLister, nsLister, npLister, anpLister, banpLister,
+		NetworkPolicyCheck{From: "app/web", To: "app/frontend", Port: 8080})
+	assert.NoError(t, err)
+	assert.False(t, verdict.Allowed)
+	assert.Equal(t, NetworkPolicyDirectionVerdict{Direction: "ingress", Pod: "app/frontend", IP: "10.1.0.6",
+		Policy: "default", Kind: "baseline admin network policy", Rule: 0,
+		Reason: "denied by ingress rule 0 (deny-app) of baseline admin network policy default"}, verdict.Ingress)
+	verdict, err = CheckNetworkPolicies(podLister, nsLister, npLister, anpLister, banpLister,
+		NetworkPolicyCheck{From: "monitoring/prometheus", To: "app/frontend", Port: 8080})
+	assert.NoError(t, err)
+	assert.True(t, verdict.Allowed)
+	assert.Equal(t, "no network policy selects the pod for ingress", verdict.Ingress.Reason)
+}
+
+// tRecordingIPSet records the ipsets that the network policy chains are rendered with
+type tRecordingIPSet struct {
+	fakeIPSet
+	sets map[string]networkPolicySet
+}
+
+func (ips *tRecordingIPSet) RefreshSet(setName string, entriesWithOptions [][]string, setType string) {
+	ips.sets[setName] = networkPolicySet{name: setName, setType: setType, entries: entriesWithOptions}
+}
+
+// tRenderedChainPermits evaluates the MARK rules of the rendered network policy chain for the traffic
+func tRenderedChainPermits(rules, chainName string, sets map[string]networkPolicySet, srcIP, dstIP, protocol string,
+	port int) bool {
+	for _, rule := range strings.Split(rules, "\n") {
+		if !strings.HasPrefix(rule, "-A "+chainName+" ") || !strings.Contains(rule, "-j MARK") {
+			continue
+		}
+		args, matches := strings.Fields(rule), true
+		for idx := 0; idx < len(args)-1; idx++ {
+			switch args[idx] {
+			case "--match-set":
+				ip := srcIP
+				if args[idx+2] == "dst" {
+					ip = dstIP
+				}
+				matches = matches && sets[args[idx+1]].contains(ip)
+			case "-p":
+				matches = matches && strings.EqualFold(args[idx+1], protocol)
+			case "--dport":
+				start, end, _ := strings.Cut(args[idx+1], ":")
+				if end == "" {
+					end = start
+				}
+				matches = matches && port >= tAtoi(start) && port <= tAtoi(end)
+			}
+		}
+		if matches {
+			return true
+		}
+	}
+	return false
+}
+
+func tAtoi(s string) int {
+	i, _ := strconv.Atoi(s)
+	return i
+}
+
+func TestCheckNetworkPoliciesMatchesRenderedRules(t *testing.T) {
+	podLister, nsLister, npLister := newTestPolicyCheckListers(t)
+	udp := v1.ProtocolUDP
+	assert.NoError(t, npLister.Add(&networking.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "frontend", Namespace: "app"},
+		Spec: networking.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "frontend"}},
+			PolicyTypes: []networking.PolicyType{networking.PolicyTypeIngress, networking.PolicyTypeEgress},
+			Ingress: []networking.NetworkPolicyIngressRule{{From: []networking.NetworkPolicyPeer{
+				{IPBlock: &networking.IPBlock{CIDR: "10.0.0.0/8", Except: []string{"10.1.1.0/24"}}}}}},
+			Egress: []networking.NetworkPolicyEgressRule{
+				{Ports: []networking.NetworkPolicyPort{{Protocol: &udp, Port: ptr.To(intstr.FromInt32(53)),
+					EndPort: ptr.To[int32](60)}}},
+				{To: []networking.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{"app": "web"}}}}},
+			},
+		},
+	}))
+
+	npc := &NetworkPolicyController{podLister: podLister, nsLister: nsLister, npLister: npLister,
+		filterTableRules: map[v1.IPFamily]*bytes.Buffer{v1.IPv4Protocol: {}},
+		ipSetHandlers: map[v1.IPFamily]utils.IPSetHandler{
+			v1.IPv4Protocol: &tRecordingIPSet{sets: make(map[string]networkPolicySet)}}}
+	networkPoliciesInfo, err := npc.buildNetworkPoliciesInfo()
+	assert.NoError(t, err)
+	assert.NoError(t, npc.renderNetworkPolicyChains(networkPoliciesInfo, "1", make(map[string]bool),
+		make(map[string]bool)))
+	rules := npc.filterTableRules[v1.IPv4Protocol].String()
+	sets := npc.ipSetHandlers[v1.IPv4Protocol].(*tRecordingIPSet).sets
+
+	// renderedPermits returns whether the rendered chains of the network policies that select the pod for the
+	// direction permit the traffic, traffic is permitted when no network policy selects the pod
+	renderedPermits := func(ip, policyType, srcIP, dstIP, protocol string, port int) bool {
+		selected := false
+		for _, policy := range networkPoliciesInfo {
+			if _, ok := policy.targetPods[ip]; !ok ||
+				policy.policyType != policyType && policy.policyType != kubeBothPolicyType {
+				continue
+			}
+			selected = true
+			chainName := networkPolicyChainName(policy.namespace, policy.name, "1", v1.IPv4Protocol)
+			if tRenderedChainPermits(rules, chainName, sets, srcIP, dstIP, protocol, port) {
+				return true
+			}
+		}
+		return !selected
+	}
+
+	endpoints := []string{"app/web", "app/frontend", "monitoring/prometheus", "10.1.1.9", "10.2.0.1", "8.8.8.8"}
+	for _, from := range endpoints {
+		for _, to := range endpoints {
+			for _, traffic := range []struct {
+				protocol string
+				port     int
+			}{{"TCP", 8080}, {"TCP", 9090}, {"UDP", 53}, {"UDP", 61}} {
+				check := NetworkPolicyCheck{From: from, To: to, Protocol: traffic.protocol, Port: traffic.port}
+				verdict, err := CheckNetworkPolicies(podLister, nsLister, npLister, nil, nil, check)
+				if !assert.NoError(t, err) {
+					continue
+				}
+				srcIP, dstIP := verdict.Egress.IP, verdict.Ingress.IP
+				assert.Equal(t, renderedPermits(srcIP, kubeEgressPolicyType, srcIP, dstIP, traffic.protocol,
+					traffic.port), verdict.Egress.Allowed, "egress of %+v", check)
+				assert.Equal(t, renderedPermits(dstIP, kubeIngressPolicyType, srcIP, dstIP, traffic.protocol,
+					traffic.port), verdict.Ingress.Allowed, "ingress of %+v", check)
+			}
+		}
+	}
+
+	// the egress traffic of the pod goes through the ingress rules as well, as they share the chain of the policy
+	verdict, err := CheckNetworkPolicies(podLister, nsLister, npLister, nil, nil,
+		NetworkPolicyCheck{From: "app/frontend", To: "app/frontend", Port: 8080})
+	assert.NoError(t, err)
+	assert.True(t, verdict.Egress.Allowed)
+	assert.Equal(t, 0, verdict.Egress.Rule)
+	assert.Contains(t, verdict.Egress.Reason, "allowed by ingress rule 0 of network policy app/frontend")
+}
@@ -0,0 +1,72 @@
+package netpol
+
+import (
+	"net"
+	"reflect"
+
+	"github.com/cloudnativelabs/kube-router/v2/pkg/utils"
+	v1core "k8s.io/api/core/v1"
+	networkingv1beta1 "k8s.io/api/networking/v1beta1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+func (npc *NetworkPolicyController) newServiceCIDREventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			npc.handleServiceCIDRUpdate(nil, obj)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			npc.handleServiceCIDRUpdate(oldObj, newObj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			npc.handleServiceCIDRUpdate(obj, nil)
+		},
+	}
+}
+
+// handleServiceCIDRUpdate requests a full sync when the CIDRs of a ServiceCIDR were added, changed or removed, so that
+// traffic to the cluster IPs allocated from them is allowed without a restart
+func (npc *NetworkPolicyController) handleServiceCIDRUpdate(oldObj, newObj interface{}) {
+	var oldCIDRs, newCIDRs []string
+	var name string
+	if serviceCIDR, ok := oldObj.(*networkingv1beta1.ServiceCIDR); ok {
+		oldCIDRs, name = serviceCIDR.Spec.CIDRs, serviceCIDR.Name
+	}
+	if serviceCIDR, ok := newObj.(*networkingv1beta1.ServiceCIDR); ok {
+		newCIDRs, name = serviceCIDR.Spec.CIDRs, serviceCIDR.Name
+	}
+	if reflect.DeepEqual(oldCIDRs, newCIDRs) {
+		return
+	}
+	klog.V(2).Infof("Received update for ServiceCIDR: %s, CIDRs changed from %v to %v", name, oldCIDRs, newCIDRs)
+
+	npc.RequestFullSync()
+}
+
+// clusterIPRanges returns the cluster IP ranges to allow traffic to, which are the ranges given by
+// --service-cluster-ip-range and the ranges of the ServiceCIDR objects of the IP families that the controller is
+// enabled for
+func (npc *NetworkPolicyController) clusterIPRanges() []net.IPNet {
+	ranges := make([]net.IPNet, 0, len(npc.serviceClusterIPRanges))
+	seen := make(map[string]bool)
+	for _, serviceRange := range npc.serviceClusterIPRanges {
+		seen[serviceRange.String()] = true
+		ranges = append(ranges, serviceRange)
+	}
+	for _, serviceRange := range utils.ServiceCIDRs(npc.serviceCIDRLister) {
+		family := v1core.IPv4Protocol
+		if serviceRange.IP.To4() == nil {
+			family = v1core.IPv6Protocol
+		}
+		if _, ok := npc.filterTableRules[family]; !ok || seen[serviceRange.String()] {
+			continue
+		}
+		seen[serviceRange.String()] = true
+		ranges = append(ranges, serviceRange)
+	}
+	return ranges
+}
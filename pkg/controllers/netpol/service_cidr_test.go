@@ -0,0 +1,71 @@
+package netpol
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	networkingv1beta1 "k8s.io/api/networking/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func newTestServiceCIDR(name string, cidrs ...string) *networkingv1beta1.ServiceCIDR {
+	return &networkingv1beta1.ServiceCIDR{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       networkingv1beta1.ServiceCIDRSpec{CIDRs: cidrs},
+	}
+}
+
+func newTestServiceCIDRNPC(t *testing.T, serviceCIDRs ...*networkingv1beta1.ServiceCIDR) *NetworkPolicyController {
+	serviceCIDRLister := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, serviceCIDR := range serviceCIDRs {
+		assert.NoError(t, serviceCIDRLister.Add(serviceCIDR))
+	}
+	_, clusterIPRange, _ := net.ParseCIDR("10.96.0.0/12")
+	return &NetworkPolicyController{
+		serviceClusterIPRanges: []net.IPNet{*clusterIPRange},
+		filterTableRules:       map[v1.IPFamily]*bytes.Buffer{v1.IPv4Protocol: {}},
+		serviceCIDRLister:      serviceCIDRLister,
+		fullSyncRequestChan:    make(chan struct{}, 1),
+	}
+}
+
+func TestNetworkPolicyController_clusterIPRanges(t *testing.T) {
+	npc := newTestServiceCIDRNPC(t,
+		newTestServiceCIDR("kubernetes", "10.96.0.0/12", "fd00:10:96::/112"),
+		newTestServiceCIDR("extra", "10.128.0.0/16", "invalid"),
+	)
+
+	var ranges []string
+	for _, serviceRange := range npc.clusterIPRanges() {
+		ranges = append(ranges, serviceRange.String())
+	}
+	// the configured range comes first, duplicates, invalid CIDRs and disabled IP families are skipped
+	assert.Equal(t, []string{"10.96.0.0/12", "10.128.0.0/16"}, ranges)
+
+	npc.serviceCIDRLister = nil
+	assert.Len(t, npc.clusterIPRanges(), 1)
+}
+
+func TestNetworkPolicyController_handleServiceCIDRUpdate(t *testing.T) {
+	npc := newTestServiceCIDRNPC(t)
+	handler := npc.newServiceCIDREventHandler()
+	serviceCIDR := newTestServiceCIDR("extra", "10.128.0.0/16")
+
+	handler.OnUpdate(serviceCIDR, serviceCIDR)
+	assert.Empty(t, npc.fullSyncRequestChan, "unchanged CIDRs don't need a sync")
+
+	handler.OnAdd(serviceCIDR, false)
+	assert.Len(t, npc.fullSyncRequestChan, 1)
+	<-npc.fullSyncRequestChan
+
+	handler.OnUpdate(serviceCIDR, newTestServiceCIDR("extra", "10.128.0.0/16", "fd00:128::/112"))
+	assert.Len(t, npc.fullSyncRequestChan, 1)
+	<-npc.fullSyncRequestChan
+
+	handler.OnDelete(cache.DeletedFinalStateUnknown{Key: "extra", Obj: serviceCIDR})
+	assert.Len(t, npc.fullSyncRequestChan, 1)
+}
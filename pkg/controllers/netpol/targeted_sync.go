@@ -0,0 +1,347 @@
+package netpol
+
+import (
+	"bytes"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	api "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// Most pod and namespace events only change which pods the selectors of a few network policies match, which only
+// changes the ipsets of those network policies and the rules of their chains. Instead of a full sync, these events
+// request a targeted sync of the network policies whose selectors the pod or namespace matches before or after the
+// change. The targeted sync re-renders the chains of these network policies under the version of the last full sync
+// with iptables-restore --noflush and restores only their ipsets. The pod firewall chains, and everything else, are
+// left alone, so whenever they may be affected a full sync is requested instead:
+//   - for pods running on this node, as they have a pod firewall chain of their own
+//   - when admin network policies exist, as their subjects and peers are evaluated by the pod firewall chains
+//   - when the network policies that select the local pods, or their mode, changed
+//   - with the nftables backend, which always replaces the whole table
+// fullPolicySync still runs periodically and on network policy events, and reconciles anything else.
+
+// handlePodChange requests a sync of the network policies whose selectors matched the pod before or after the change,
+// either of which is nil when the pod was added or deleted
+func (npc *NetworkPolicyController) handlePodChange(oldPod, newPod *api.Pod) {
+	if npc.requiresFullSync() || npc.isLocalPod(oldPod) || npc.isLocalPod(newPod) {
+		npc.RequestFullSync()
+		return
+	}
+
+	policyKeys := npc.policiesAffectedByPod(oldPod)
+	for key := range npc.policiesAffectedByPod(newPod) {
+		policyKeys[key] = true
+	}
+	if len(policyKeys) == 0 {
+		klog.V(2).Info("No network policy selects the pod, skipping sync")
+		return
+	}
+	npc.requestPolicySync(policyKeys)
+}
+
+// handleNamespaceChange requests a sync of the network policies whose namespace selectors matched the labels of the
+// namespace before or after the change
+func (npc *NetworkPolicyController) handleNamespaceChange(oldLabels, newLabels map[string]string) {
+	if npc.requiresFullSync() {
+		npc.RequestFullSync()
+		return
+	}
+
+	policyKeys := npc.policiesAffectedByNamespace(oldLabels)
+	for key := range npc.policiesAffectedByNamespace(newLabels) {
+		policyKeys[key] = true
+	}
+	if len(policyKeys) == 0 {
+		klog.V(2).Info("No network policy selects the namespace, skipping sync")
+		return
+	}
+	npc.requestPolicySync(policyKeys)
+}
+
+// requiresFullSync returns whether pod and namespace events can't be handled by a targeted sync
+func (npc *NetworkPolicyController) requiresFullSync() bool {
+	if npc.nftTable != nil {
+		return true
+	}
+	for _, lister := range []cache.Indexer{npc.anpLister, npc.banpLister} {
+		if lister != nil && len(lister.ListKeys()) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (npc *NetworkPolicyController) isLocalPod(pod *api.Pod) bool {
+	if pod == nil {
+		return false
+	}
+	for _, nodeIP := range npc.krNode.GetNodeIPAddrs() {
+		if pod.Status.HostIP == nodeIP.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// policiesAffectedByPod returns the keys of the network policies that select the pod, either as target or as peer,
+// or that resolve named ports from it
+func (npc *NetworkPolicyController) policiesAffectedByPod(pod *api.Pod) map[string]bool {
+	policyKeys := make(map[string]bool)
+	if pod == nil {
+		return policyKeys
+	}
+
+	var namespaceLabels labels.Set
+	if obj, exists, err := npc.nsLister.GetByKey(pod.Namespace); err == nil && exists {
+		if namespace, ok := obj.(*api.Namespace); ok {
+			namespaceLabels = namespace.Labels
+		}
+	}
+	podLabels := labels.Set(pod.Labels)
+
+	peersMatch := func(policy *networking.NetworkPolicy, peers []networking.NetworkPolicyPeer) bool {
+		for _, peer := range peers {
+			switch {
+			case peer.NamespaceSelector != nil:
+				if selectorMatches(peer.NamespaceSelector, namespaceLabels) &&
+					(peer.PodSelector == nil || selectorMatches(peer.PodSelector, podLabels)) {
+					return true
+				}
+			case peer.PodSelector != nil:
+				if policy.Namespace == pod.Namespace && selectorMatches(peer.PodSelector, podLabels) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	for _, obj := range npc.npLister.List() {
+		policy, ok := obj.(*networking.NetworkPolicy)
+		if !ok {
+			continue
+		}
+		affected := policy.Namespace == pod.Namespace && selectorMatches(&policy.Spec.PodSelector, podLabels)
+		for _, rule := range policy.Spec.Ingress {
+			affected = affected || peersMatch(policy, rule.From)
+		}
+		for _, rule := range policy.Spec.Egress {
+			// egress rules without peers resolve their named ports from all of the pods of the namespace
+			affected = affected || peersMatch(policy, rule.To) ||
+				len(rule.To) == 0 && policy.Namespace == pod.Namespace && policyRulePortsHasNamedPort(rule.Ports)
+		}
+		if affected {
+			policyKeys[policy.Namespace+"/"+policy.Name] = true
+		}
+	}
+	return policyKeys
+}
+
+// policiesAffectedByNamespace returns the keys of the network policies with peers whose namespace selector matches
+// the labels of a namespace
+func (npc *NetworkPolicyController) policiesAffectedByNamespace(namespaceLabels map[string]string) map[string]bool {
+	policyKeys := make(map[string]bool)
+	if namespaceLabels == nil {
+		return policyKeys
+	}
+
+	for _, obj := range npc.npLister.List() {
+		policy, ok := obj.(*networking.NetworkPolicy)
+		if !ok {
+			continue
+		}
+		var peers []networking.NetworkPolicyPeer
+		for _, rule := range policy.Spec.Ingress {
+			peers = append(peers, rule.From...)
+		}
+		for _, rule := range policy.Spec.Egress {
+			peers = append(peers, rule.To...)
+		}
+		for _, peer := range peers {
+			if peer.NamespaceSelector != nil && selectorMatches(peer.NamespaceSelector, namespaceLabels) {
+				policyKeys[policy.Namespace+"/"+policy.Name] = true
+				break
+			}
+		}
+	}
+	return policyKeys
+}
+
+// selectorMatches returns whether the label selector matches the labels, invalid selectors match anything so that
+// the network policies that use them are synced rather than skipped
+func selectorMatches(selector *metav1.LabelSelector, set labels.Set) bool {
+	labelSelector, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return true
+	}
+	return labelSelector.Matches(set)
+}
+
+// requestPolicySync queues the network policies for a targeted sync without blocking the callee
+func (npc *NetworkPolicyController) requestPolicySync(policyKeys map[string]bool) {
+	npc.pendingPolicySyncMu.Lock()
+	for key := range policyKeys {
+		npc.pendingPolicySync[key] = true
+	}
+	npc.pendingPolicySyncMu.Unlock()
+
+	select {
+	case npc.policySyncRequestChan <- struct{}{}:
+		klog.V(3).Info("Policy sync request queue was empty so a policy sync request was successfully sent")
+	default: // the queued request will sync the network policies that were just added to the pending ones
+		klog.V(3).Info("Policy sync request queue was full, the pending policies will be synced by the queued request")
+	}
+}
+
+// takePendingPolicySync returns the keys of the network policies pending a targeted sync and clears them
+func (npc *NetworkPolicyController) takePendingPolicySync() []string {
+	npc.pendingPolicySyncMu.Lock()
+	defer npc.pendingPolicySyncMu.Unlock()
+	policyKeys := make([]string, 0, len(npc.pendingPolicySync))
+	for key := range npc.pendingPolicySync {
+		policyKeys = append(policyKeys, key)
+	}
+	npc.pendingPolicySync = make(map[string]bool)
+	sort.Strings(policyKeys)
+	return policyKeys
+}
+
+// podFwChainDependencies returns, for each network policy, what the pod firewall chains rendered for it depend on:
+// its type, its mode and the local pods that it selects
+func (npc *NetworkPolicyController) podFwChainDependencies(networkPoliciesInfo []networkPolicyInfo) map[string]string {
+	localPods := make(map[string]podInfo)
+	for _, nodeIP := range npc.krNode.GetNodeIPAddrs() {
+		npc.getLocalPods(localPods, nodeIP.String())
+	}
+
+	dependencies := make(map[string]string, len(networkPoliciesInfo))
+	for _, policy := range networkPoliciesInfo {
+		localTargets := make([]string, 0)
+		for ip := range policy.targetPods {
+			if _, ok := localPods[ip]; ok {
+				localTargets = append(localTargets, ip)
+			}
+		}
+		sort.Strings(localTargets)
+		dependencies[policy.namespace+"/"+policy.name] = policy.policyType + "/" + strconv.FormatBool(policy.audit) +
+			"/" + strings.Join(localTargets, ",")
+	}
+	return dependencies
+}
+
+// targetedPolicySync re-renders the chains and ipsets of the network policies pending a targeted sync
+func (npc *NetworkPolicyController) targetedPolicySync() {
+	npc.mu.Lock()
+	defer npc.mu.Unlock()
+
+	policyKeys := npc.takePendingPolicySync()
+	if len(policyKeys) == 0 {
+		return
+	}
+	if npc.syncVersion == "" {
+		klog.V(1).Info("No full sync of the network policies has completed yet, requesting one")
+		npc.RequestFullSync()
+		return
+	}
+
+	start := time.Now()
+	defer func() {
+		klog.V(1).Infof("Targeted sync of %d network policies took %v", len(policyKeys), time.Since(start))
+	}()
+
+	networkPoliciesInfo := make([]networkPolicyInfo, 0, len(policyKeys))
+	for _, key := range policyKeys {
+		obj, exists, err := npc.npLister.GetByKey(key)
+		if err != nil || !exists {
+			// the chains of deleted network policies are removed by the full sync that their delete event requests
+			continue
+		}
+		policy, ok := obj.(*networking.NetworkPolicy)
+		if !ok {
+			continue
+		}
+		networkPoliciesInfo = append(networkPoliciesInfo, npc.buildNetworkPolicyInfo(policy))
+	}
+	for key, dependencies := range npc.podFwChainDependencies(networkPoliciesInfo) {
+		if recorded, ok := npc.podFwChainDeps[key]; !ok || recorded != dependencies {
+			klog.V(1).Infof("The pod firewall chains depend on changes to network policy %s, requesting a full sync",
+				key)
+			npc.RequestFullSync()
+			return
+		}
+	}
+	if len(networkPoliciesInfo) == 0 {
+		return
+	}
+
+	if err := npc.resetIPSetHandlers(); err != nil {
+		klog.Errorf("Aborting targeted sync. Failed to create ipset handler: %v", err)
+		return
+	}
+	for ipFamily := range npc.iptablesSaveRestore {
+		npc.filterTableRules[ipFamily].Reset()
+	}
+
+	// the ipset handlers start out empty, so only the ipsets of these network policies are restored
+	if err := npc.renderTargetedPolicies(networkPoliciesInfo); err != nil {
+		klog.Errorf("Aborting targeted sync. Failed to sync network policy ipsets: %v", err)
+		npc.RequestFullSync()
+		return
+	}
+
+	for ipFamily, iptablesSaveRestore := range npc.iptablesSaveRestore {
+		if err := iptablesSaveRestore.RestoreNoFlush("filter",
+			policyChainsRestoreData(npc.filterTableRules[ipFamily])); err != nil {
+			klog.Errorf("Aborting targeted sync. Failed to run iptables-restore: %v\n%s", err,
+				npc.filterTableRules[ipFamily].String())
+			npc.RequestFullSync()
+			return
+		}
+	}
+}
+
+// renderTargetedPolicies renders the chains of the network policies under the version of the last full sync and
+// restores their ipsets
+func (npc *NetworkPolicyController) renderTargetedPolicies(networkPoliciesInfo []networkPolicyInfo) error {
+	npc.ipsetMutex.Lock()
+	defer npc.ipsetMutex.Unlock()
+
+	if err := npc.renderNetworkPolicyChains(networkPoliciesInfo, npc.syncVersion, make(map[string]bool),
+		make(map[string]bool)); err != nil {
+		return err
+	}
+	for _, ipset := range npc.ipSetHandlers {
+		if err := ipset.Restore(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// policyChainsRestoreData turns the rendered chains into iptables-restore input, with --noflush only the chains it
+// declares are flushed before their rules are added
+func policyChainsRestoreData(rules *bytes.Buffer) []byte {
+	var chains, chainRules bytes.Buffer
+	for _, rule := range strings.Split(rules.String(), "\n") {
+		if strings.HasPrefix(rule, ":") {
+			chains.WriteString(rule + " - [0:0]\n")
+		}
+		if strings.HasPrefix(rule, "-") {
+			chainRules.WriteString(rule + "\n")
+		}
+	}
+
+	var data bytes.Buffer
+	data.WriteString("*filter\n")
+	data.Write(chains.Bytes())
+	data.Write(chainRules.Bytes())
+	data.WriteString("COMMIT\n")
+	return data.Bytes()
+}
@@ -0,0 +1,140 @@
+package netpol
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/utils/ptr"
+)
+
+func newTestTargetedSyncNPC(t *testing.T) *NetworkPolicyController {
+	client := fake.NewSimpleClientset(&v1.NodeList{Items: []v1.Node{*newFakeNode("node", []string{"10.10.10.10"})}})
+	informerFactory, podInformer, nsInformer, netpolInformer := newFakeInformersFromClient(client)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	informerFactory.Start(ctx.Done())
+	cache.WaitForCacheSync(ctx.Done(), podInformer.HasSynced)
+	npc := newUneventfulNetworkPolicyController(podInformer, netpolInformer, nsInformer)
+	npc.fullSyncRequestChan = make(chan struct{}, 1)
+	npc.policySyncRequestChan = make(chan struct{}, 1)
+	npc.pendingPolicySync = make(map[string]bool)
+
+	for _, ns := range []string{"app", "monitoring"} {
+		tAddToInformerStore(t, nsInformer, &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: ns,
+			Labels: map[string]string{"kubernetes.io/metadata.name": ns}}})
+	}
+	tAddToInformerStore(t, podInformer, &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "app", Labels: map[string]string{"app": "web"}},
+		Status: v1.PodStatus{HostIP: "10.10.10.10", PodIP: "10.1.0.5", PodIPs: []v1.PodIP{{IP: "10.1.0.5"}},
+			Phase: v1.PodRunning},
+	})
+
+	monitoring := &metav1.LabelSelector{MatchLabels: map[string]string{"kubernetes.io/metadata.name": "monitoring"}}
+	frontend := &metav1.LabelSelector{MatchLabels: map[string]string{"app": "frontend"}}
+	for _, policy := range []*networking.NetworkPolicy{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "app"},
+			Spec: networking.NetworkPolicySpec{
+				PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+				PolicyTypes: []networking.PolicyType{networking.PolicyTypeIngress},
+				Ingress: []networking.NetworkPolicyIngressRule{
+					{From: []networking.NetworkPolicyPeer{{NamespaceSelector: monitoring}}},
+					{From: []networking.NetworkPolicyPeer{{PodSelector: frontend}}},
+				},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "dns", Namespace: "app"},
+			Spec: networking.NetworkPolicySpec{
+				PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+				PolicyTypes: []networking.PolicyType{networking.PolicyTypeEgress},
+				Egress: []networking.NetworkPolicyEgressRule{{Ports: []networking.NetworkPolicyPort{
+					{Port: ptr.To(intstr.FromString("dns"))}}}},
+			},
+		},
+	} {
+		tAddToInformerStore(t, netpolInformer, policy)
+	}
+	return npc
+}
+
+func TestNetworkPolicyController_policiesAffectedByPod(t *testing.T) {
+	npc := newTestTargetedSyncNPC(t)
+
+	frontend := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "frontend", Namespace: "app",
+		Labels: map[string]string{"app": "frontend"}}}
+	// the frontend pod is an ingress peer of the web policy and resolves the named port of the dns policy
+	assert.Equal(t, map[string]bool{"app/web": true, "app/dns": true}, npc.policiesAffectedByPod(frontend))
+
+	prometheus := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "prometheus", Namespace: "monitoring"}}
+	assert.Equal(t, map[string]bool{"app/web": true}, npc.policiesAffectedByPod(prometheus))
+
+	// pod selectors without a namespace selector only select pods in the namespace of the policy
+	other := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "frontend", Namespace: "other",
+		Labels: map[string]string{"app": "frontend"}}}
+	assert.Empty(t, npc.policiesAffectedByPod(other))
+	assert.Empty(t, npc.policiesAffectedByPod(nil))
+}
+
+func TestNetworkPolicyController_policiesAffectedByNamespace(t *testing.T) {
+	npc := newTestTargetedSyncNPC(t)
+
+	assert.Equal(t, map[string]bool{"app/web": true},
+		npc.policiesAffectedByNamespace(map[string]string{"kubernetes.io/metadata.name": "monitoring"}))
+	assert.Empty(t, npc.policiesAffectedByNamespace(map[string]string{"kubernetes.io/metadata.name": "other"}))
+	assert.Empty(t, npc.policiesAffectedByNamespace(nil))
+}
+
+func TestNetworkPolicyController_handlePodChange(t *testing.T) {
+	npc := newTestTargetedSyncNPC(t)
+
+	// pods on other nodes only queue the network policies that select them
+	remote := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "prometheus", Namespace: "monitoring"},
+		Status: v1.PodStatus{HostIP: "10.10.10.11"}}
+	npc.handlePodChange(nil, remote)
+	assert.Len(t, npc.policySyncRequestChan, 1)
+	assert.Empty(t, npc.fullSyncRequestChan)
+	assert.Equal(t, []string{"app/web"}, npc.takePendingPolicySync())
+	assert.Empty(t, npc.takePendingPolicySync())
+
+	// pods on this node have a pod firewall chain of their own
+	local := remote.DeepCopy()
+	local.Status.HostIP = "10.10.10.10"
+	npc.handlePodChange(remote, local)
+	assert.Len(t, npc.fullSyncRequestChan, 1)
+	<-npc.fullSyncRequestChan
+
+	// admin network policies are evaluated in the pod firewall chains
+	npc.anpLister = cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	assert.NoError(t, npc.anpLister.Add(&metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: "anp"}}))
+	npc.handlePodChange(remote, nil)
+	assert.Len(t, npc.fullSyncRequestChan, 1)
+}
+
+func TestNetworkPolicyController_podFwChainDependencies(t *testing.T) {
+	npc := newTestTargetedSyncNPC(t)
+
+	dependencies := npc.podFwChainDependencies([]networkPolicyInfo{
+		{name: "web", namespace: "app", policyType: kubeIngressPolicyType,
+			targetPods: map[string]podInfo{"10.1.0.5": {}, "10.1.1.5": {}}},
+		{name: "dns", namespace: "app", policyType: kubeEgressPolicyType, audit: true},
+	})
+	// only the local pods that the network policies select are part of the dependencies
+	assert.Equal(t, map[string]string{"app/web": "ingress/false/10.1.0.5", "app/dns": "egress/true/"}, dependencies)
+}
+
+func Test_policyChainsRestoreData(t *testing.T) {
+	rules := bytes.NewBufferString(":KUBE-NWPLCY-A\n-A KUBE-NWPLCY-A -j MARK --set-xmark 0x10000/0x10000 \n" +
+		":KUBE-NWPLCY-B\n-A KUBE-NWPLCY-B -j RETURN \n")
+	assert.Equal(t, "*filter\n:KUBE-NWPLCY-A - [0:0]\n:KUBE-NWPLCY-B - [0:0]\n"+
+		"-A KUBE-NWPLCY-A -j MARK --set-xmark 0x10000/0x10000 \n-A KUBE-NWPLCY-B -j RETURN \nCOMMIT\n",
+		string(policyChainsRestoreData(rules)))
+}
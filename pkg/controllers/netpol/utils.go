@@ -97,11 +97,7 @@ func getIPsFromPods(pods []podInfo, family api.IPFamily) []string {
 
 func (npc *NetworkPolicyController) createGenericHashIPSet(
 	ipsetName, hashType string, ips []string, ipFamily api.IPFamily) {
-	setEntries := make([][]string, 0)
-	for _, ip := range ips {
-		setEntries = append(setEntries, []string{ip, utils.OptionTimeout, "0"})
-	}
-	npc.ipSetHandlers[ipFamily].RefreshSet(ipsetName, setEntries, hashType)
+	npc.ipSetHandlers[ipFamily].RefreshSet(ipsetName, ipSetEntries(ips), hashType)
 }
 
 // createPolicyIndexedIPSet creates a policy based ipset and indexes it as an active ipset
@@ -111,21 +107,6 @@ func (npc *NetworkPolicyController) createPolicyIndexedIPSet(
 	npc.createGenericHashIPSet(ipsetName, hashType, ips, ipFamily)
 }
 
-// createPodWithPortPolicyRule handles the case where port details are provided by the ingress/egress rule and creates
-// an iptables rule that matches on both the source/dest IPs and the port
-func (npc *NetworkPolicyController) createPodWithPortPolicyRule(ports []protocolAndPort,
-	policy networkPolicyInfo, policyName string, srcSetName string, dstSetName string, ipFamily api.IPFamily) error {
-	for _, portProtocol := range ports {
-		comment := "rule to ACCEPT traffic from source pods to dest pods selected by policy name " +
-			policy.name + " namespace " + policy.namespace
-		if err := npc.appendRuleToPolicyChain(policyName, comment, srcSetName, dstSetName, portProtocol.protocol,
-			portProtocol.port, portProtocol.endport, ipFamily); err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
 func getPodIPv6Address(pod podInfo) (string, error) {
 	for _, ip := range pod.ips {
 		if netutils.IsIPv6String(ip.IP) {
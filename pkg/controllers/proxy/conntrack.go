@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"fmt"
+	"syscall"
+
+	"github.com/ccoveille/go-safecast"
+	"github.com/cloudnativelabs/kube-router/v2/pkg/metrics"
+	"github.com/moby/ipvs"
+	"github.com/vishvananda/netlink"
+	"k8s.io/klog/v2"
+)
+
+const (
+	conntrackReasonEndpoint = "endpoint"
+	conntrackReasonService  = "service"
+)
+
+// conntrackDeleter deletes conntrack entries over netlink, it is implemented by netlink.Handle
+type conntrackDeleter interface {
+	ConntrackDeleteFilters(table netlink.ConntrackTableType, family netlink.InetFamily,
+		filters ...netlink.CustomConntrackFilter) (uint, error)
+}
+
+// newConntrackFilter returns a filter that matches the conntrack entries of flows whose original destination is the
+// IPVS service and, when dst isn't nil, whose reply source is the IPVS destination
+func newConntrackFilter(svc *ipvs.Service, dst *ipvs.Destination) (*netlink.ConntrackFilter, error) {
+	protocol, err := safecast.ToUint8(svc.Protocol)
+	if err != nil {
+		return nil, err
+	}
+	filter := &netlink.ConntrackFilter{}
+	if err = filter.AddProtocol(protocol); err != nil {
+		return nil, err
+	}
+	if err = filter.AddIP(netlink.ConntrackOrigDstIP, svc.Address); err != nil {
+		return nil, err
+	}
+	if err = filter.AddPort(netlink.ConntrackOrigDstPort, svc.Port); err != nil {
+		return nil, err
+	}
+	if dst != nil {
+		if err = filter.AddIP(netlink.ConntrackReplySrcIP, dst.Address); err != nil {
+			return nil, err
+		}
+	}
+	return filter, nil
+}
+
+// deleteConntrackEntries deletes the conntrack entries of UDP flows to the IPVS service, or only those of flows that
+// were sent to the given destination when dst isn't nil, so that clients don't keep sending their traffic to endpoints
+// or services that are gone. Conntrack entries of TCP connections don't need to be deleted as the connections are
+// reset, and FWMark services are skipped as they don't have an address.
+func (nsc *NetworkServicesController) deleteConntrackEntries(svc *ipvs.Service, dst *ipvs.Destination) error {
+	if svc.Protocol != syscall.IPPROTO_UDP || svc.Address == nil {
+		return nil
+	}
+
+	reason, target := conntrackReasonService, ipvsServiceString(svc)
+	if dst != nil {
+		reason, target = conntrackReasonEndpoint, ipvsDestinationString(dst)+" of "+ipvsServiceString(svc)
+	}
+	filter, err := newConntrackFilter(svc, dst)
+	if err != nil {
+		return fmt.Errorf("failed to build conntrack filter for %s: %v", target, err)
+	}
+	family := netlink.InetFamily(syscall.AF_INET)
+	if svc.Address.To4() == nil {
+		family = netlink.InetFamily(syscall.AF_INET6)
+	}
+	deleted, err := nsc.conntrack.ConntrackDeleteFilters(netlink.ConntrackTable, family, filter)
+	if err != nil {
+		return fmt.Errorf("failed to delete conntrack entries for %s: %v", target, err)
+	}
+	if nsc.MetricsEnabled {
+		metrics.ControllerIpvsConntrackEntriesRemoved.WithLabelValues(reason).Add(float64(deleted))
+	}
+	klog.V(1).Infof("Deleted %d conntrack entries for %s", deleted, target)
+	return nil
+}
@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/moby/ipvs"
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+)
+
+type fakeConntrackDeleter struct {
+	families []netlink.InetFamily
+	filters  []netlink.CustomConntrackFilter
+}
+
+func (f *fakeConntrackDeleter) ConntrackDeleteFilters(_ netlink.ConntrackTableType, family netlink.InetFamily,
+	filters ...netlink.CustomConntrackFilter) (uint, error) {
+	f.families = append(f.families, family)
+	f.filters = append(f.filters, filters...)
+	return uint(len(filters)), nil
+}
+
+func newTestConntrackFlow(protocol uint8, clientIP, vip string, vipPort uint16, endpointIP string,
+	endpointPort uint16) *netlink.ConntrackFlow {
+	return &netlink.ConntrackFlow{
+		Forward: netlink.IPTuple{Protocol: protocol, SrcIP: net.ParseIP(clientIP), SrcPort: 40000,
+			DstIP: net.ParseIP(vip), DstPort: vipPort},
+		Reverse: netlink.IPTuple{Protocol: protocol, SrcIP: net.ParseIP(endpointIP), SrcPort: endpointPort,
+			DstIP: net.ParseIP(clientIP), DstPort: 40000},
+	}
+}
+
+func TestNetworkServicesController_deleteConntrackEntries(t *testing.T) {
+	deleter := &fakeConntrackDeleter{}
+	nsc := &NetworkServicesController{conntrack: deleter}
+	udpSvc := &ipvs.Service{Address: net.ParseIP("10.96.0.10"), Protocol: syscall.IPPROTO_UDP, Port: 53}
+	dst := &ipvs.Destination{Address: net.ParseIP("10.242.0.5"), Port: 5353}
+
+	// TCP services and FWMark services are skipped
+	assert.NoError(t, nsc.deleteConntrackEntries(&ipvs.Service{Address: net.ParseIP("10.96.0.1"),
+		Protocol: syscall.IPPROTO_TCP, Port: 443}, nil))
+	assert.NoError(t, nsc.deleteConntrackEntries(&ipvs.Service{FWMark: 1, Protocol: syscall.IPPROTO_UDP}, nil))
+	assert.Empty(t, deleter.filters)
+
+	assert.NoError(t, nsc.deleteConntrackEntries(udpSvc, dst))
+	assert.Len(t, deleter.filters, 1)
+	assert.Equal(t, netlink.InetFamily(syscall.AF_INET), deleter.families[0])
+	endpointFilter := deleter.filters[0]
+	assert.True(t, endpointFilter.MatchConntrackFlow(
+		newTestConntrackFlow(syscall.IPPROTO_UDP, "10.242.1.2", "10.96.0.10", 53, "10.242.0.5", 5353)))
+	assert.False(t, endpointFilter.MatchConntrackFlow(
+		newTestConntrackFlow(syscall.IPPROTO_UDP, "10.242.1.2", "10.96.0.10", 53, "10.242.0.6", 5353)))
+	assert.False(t, endpointFilter.MatchConntrackFlow(
+		newTestConntrackFlow(syscall.IPPROTO_UDP, "10.242.1.2", "10.96.0.10", 54, "10.242.0.5", 5353)))
+	assert.False(t, endpointFilter.MatchConntrackFlow(
+		newTestConntrackFlow(syscall.IPPROTO_TCP, "10.242.1.2", "10.96.0.10", 53, "10.242.0.5", 5353)))
+
+	assert.NoError(t, nsc.deleteConntrackEntries(udpSvc, nil))
+	assert.Len(t, deleter.filters, 2)
+	serviceFilter := deleter.filters[1]
+	assert.True(t, serviceFilter.MatchConntrackFlow(
+		newTestConntrackFlow(syscall.IPPROTO_UDP, "10.242.1.2", "10.96.0.10", 53, "10.242.0.6", 5353)))
+	assert.False(t, serviceFilter.MatchConntrackFlow(
+		newTestConntrackFlow(syscall.IPPROTO_UDP, "10.242.1.2", "10.96.0.11", 53, "10.242.0.6", 5353)))
+
+	assert.NoError(t, nsc.deleteConntrackEntries(&ipvs.Service{Address: net.ParseIP("2001:db8::10"),
+		Protocol: syscall.IPPROTO_UDP, Port: 53}, nil))
+	assert.Equal(t, netlink.InetFamily(syscall.AF_INET6), deleter.families[2])
+}
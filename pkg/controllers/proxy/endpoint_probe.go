@@ -0,0 +1,281 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/v2/pkg/metrics"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	endpointProbeTCP  = "tcp"
+	endpointProbeHTTP = "http"
+
+	endpointProbeInterval = 5 * time.Second
+	endpointProbeTimeout  = 2 * time.Second
+	// number of consecutive failed probes after which an endpoint is considered unhealthy
+	endpointProbeFailureThreshold = 3
+)
+
+// endpointProbe is the active health probe that the kube-router.io/service.probe annotations configure for the
+// endpoints of a service, the endpoints aren't probed when protocol is blank
+type endpointProbe struct {
+	protocol string
+	path     string
+}
+
+// endpointProbeTarget is a service endpoint that is actively probed
+type endpointProbeTarget struct {
+	namespace string
+	service   string
+	ip        string
+	port      int
+	probe     endpointProbe
+}
+
+type endpointProbeState struct {
+	healthy  bool
+	failures int
+	stopCh   chan struct{}
+}
+
+// endpointProbeController runs the active health probes of the endpoints of services that enable them. Endpoints
+// that fail endpointProbeFailureThreshold consecutive probes are unhealthy until a probe succeeds again and every
+// change of the health of an endpoint calls onStateChange, so that the weights of the IPVS destinations are synced.
+type endpointProbeController struct {
+	mu             sync.Mutex
+	probes         map[endpointProbeTarget]*endpointProbeState
+	probeFunc      func(target endpointProbeTarget) error
+	interval       time.Duration
+	onStateChange  func()
+	metricsEnabled bool
+	wg             *sync.WaitGroup
+	stopCh         chan struct{}
+}
+
+// parseEndpointProbe parses the kube-router.io/service.probe and kube-router.io/service.probe.path annotations of the
+// service, invalid probes are logged and ignored
+func parseEndpointProbe(svc *v1.Service) endpointProbe {
+	value, ok := svc.Annotations[svcProbeAnnotation]
+	if !ok {
+		return endpointProbe{}
+	}
+	switch protocol := strings.ToLower(strings.TrimSpace(value)); protocol {
+	case endpointProbeTCP:
+		return endpointProbe{protocol: protocol}
+	case endpointProbeHTTP:
+		path := strings.TrimSpace(svc.Annotations[svcProbePathAnnotation])
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+		return endpointProbe{protocol: protocol, path: path}
+	default:
+		klog.Warningf("Ignoring the %s annotation of service %s/%s as %q is not a supported probe, expected %s or %s",
+			svcProbeAnnotation, svc.Namespace, svc.Name, value, endpointProbeTCP, endpointProbeHTTP)
+		return endpointProbe{}
+	}
+}
+
+func newEndpointProbeTarget(svc *serviceInfo, endpoint endpointSliceInfo) endpointProbeTarget {
+	return endpointProbeTarget{
+		namespace: svc.namespace,
+		service:   svc.name,
+		ip:        endpoint.ip,
+		port:      endpoint.port,
+		probe:     svc.probe,
+	}
+}
+
+func (target endpointProbeTarget) endpoint() string {
+	return net.JoinHostPort(target.ip, strconv.Itoa(target.port))
+}
+
+func (target endpointProbeTarget) labelValues() []string {
+	return []string{target.namespace, target.service, target.endpoint(), target.probe.protocol}
+}
+
+// probeEndpoint runs a single probe against the endpoint, TCP probes succeed when a connection can be established and
+// HTTP probes succeed when the endpoint responds with a 2xx or 3xx status code
+func probeEndpoint(target endpointProbeTarget) error {
+	switch target.probe.protocol {
+	case endpointProbeTCP:
+		conn, err := net.DialTimeout("tcp", target.endpoint(), endpointProbeTimeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case endpointProbeHTTP:
+		client := &http.Client{
+			Timeout:   endpointProbeTimeout,
+			Transport: &http.Transport{DisableKeepAlives: true},
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+		url := "http://" + target.endpoint() + target.probe.path
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusBadRequest {
+			return fmt.Errorf("%s responded with status code %d", url, resp.StatusCode)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported probe %q", target.probe.protocol)
+	}
+}
+
+// UpdateServicesInfo starts the probes of the endpoints of services that enable them and stops the probes of
+// endpoints that are gone. Only TCP services are probed.
+func (epc *endpointProbeController) UpdateServicesInfo(serviceInfoMap serviceInfoMap,
+	endpointsInfoMap endpointSliceInfoMap) {
+	desired := make(map[endpointProbeTarget]bool)
+	for svcID, svc := range serviceInfoMap {
+		if svc.probe.protocol == "" || svc.protocol != tcpProtocol {
+			continue
+		}
+		for _, endpoint := range endpointsInfoMap[svcID] {
+			desired[newEndpointProbeTarget(svc, endpoint)] = true
+		}
+	}
+
+	epc.mu.Lock()
+	defer epc.mu.Unlock()
+
+	for target := range desired {
+		if _, ok := epc.probes[target]; ok {
+			continue
+		}
+		klog.V(1).Infof("Starting %s probe of endpoint %s of service %s/%s", target.probe.protocol, target.endpoint(),
+			target.namespace, target.service)
+		state := &endpointProbeState{healthy: true, stopCh: make(chan struct{})}
+		epc.probes[target] = state
+		if epc.metricsEnabled {
+			metrics.ServiceEndpointProbeHealthy.WithLabelValues(target.labelValues()...).Set(1)
+		}
+		epc.wg.Add(1)
+		go epc.run(target, state)
+	}
+
+	for target, state := range epc.probes {
+		if desired[target] {
+			continue
+		}
+		klog.V(1).Infof("Stopping %s probe of endpoint %s of service %s/%s", target.probe.protocol, target.endpoint(),
+			target.namespace, target.service)
+		close(state.stopCh)
+		delete(epc.probes, target)
+		if epc.metricsEnabled {
+			metrics.ServiceEndpointProbeHealthy.DeleteLabelValues(target.labelValues()...)
+			metrics.ServiceEndpointProbeFailures.DeleteLabelValues(target.labelValues()...)
+		}
+	}
+}
+
+// isHealthy returns false when the endpoint is probed and failed its recent probes
+func (epc *endpointProbeController) isHealthy(target endpointProbeTarget) bool {
+	epc.mu.Lock()
+	defer epc.mu.Unlock()
+
+	if state, ok := epc.probes[target]; ok {
+		return state.healthy
+	}
+	return true
+}
+
+func (epc *endpointProbeController) run(target endpointProbeTarget, state *endpointProbeState) {
+	defer epc.wg.Done()
+	t := time.NewTicker(epc.interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-state.stopCh:
+			return
+		case <-epc.stopCh:
+			return
+		case <-t.C:
+			epc.probe(target, state)
+		}
+	}
+}
+
+// probe probes the endpoint once and records the result
+func (epc *endpointProbeController) probe(target endpointProbeTarget, state *endpointProbeState) {
+	err := epc.probeFunc(target)
+
+	epc.mu.Lock()
+	// the probe may have been stopped while it was running
+	if epc.probes[target] != state {
+		epc.mu.Unlock()
+		return
+	}
+	changed := false
+	if err != nil {
+		klog.V(2).Infof("%s probe of endpoint %s of service %s/%s failed: %v", target.probe.protocol,
+			target.endpoint(), target.namespace, target.service, err)
+		state.failures++
+		if epc.metricsEnabled {
+			metrics.ServiceEndpointProbeFailures.WithLabelValues(target.labelValues()...).Inc()
+		}
+		if state.healthy && state.failures >= endpointProbeFailureThreshold {
+			klog.Warningf("Endpoint %s of service %s/%s failed %d %s probes, no longer sending it new connections: %v",
+				target.endpoint(), target.namespace, target.service, state.failures, target.probe.protocol, err)
+			state.healthy = false
+			changed = true
+		}
+	} else {
+		state.failures = 0
+		if !state.healthy {
+			klog.Infof("Endpoint %s of service %s/%s passed its %s probe again", target.endpoint(),
+				target.namespace, target.service, target.probe.protocol)
+			state.healthy = true
+			changed = true
+		}
+	}
+	if epc.metricsEnabled {
+		healthy := 0.0
+		if state.healthy {
+			healthy = 1
+		}
+		metrics.ServiceEndpointProbeHealthy.WithLabelValues(target.labelValues()...).Set(healthy)
+	}
+	epc.mu.Unlock()
+
+	if changed {
+		epc.onStateChange()
+	}
+}
+
+func (epc *endpointProbeController) StopAll() {
+	klog.Info("Stopping all endpoint probes")
+	close(epc.stopCh)
+	epc.wg.Wait()
+	klog.Info("All endpoint probes are shut down")
+}
+
+func NewEndpointProbeController(onStateChange func(), metricsEnabled bool) *endpointProbeController {
+	return &endpointProbeController{
+		probes:         make(map[endpointProbeTarget]*endpointProbeState),
+		probeFunc:      probeEndpoint,
+		interval:       endpointProbeInterval,
+		onStateChange:  onStateChange,
+		metricsEnabled: metricsEnabled,
+		wg:             &sync.WaitGroup{},
+		stopCh:         make(chan struct{}),
+	}
+}
@@ -0,0 +1,145 @@
+package proxy
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseEndpointProbe(t *testing.T) {
+	testcases := []struct {
+		name        string
+		annotations map[string]string
+		expected    endpointProbe
+	}{
+		{
+			name: "no probe",
+		},
+		{
+			name:        "tcp probe",
+			annotations: map[string]string{svcProbeAnnotation: "TCP"},
+			expected:    endpointProbe{protocol: endpointProbeTCP},
+		},
+		{
+			name:        "http probe with the default path",
+			annotations: map[string]string{svcProbeAnnotation: "http"},
+			expected:    endpointProbe{protocol: endpointProbeHTTP, path: "/"},
+		},
+		{
+			name:        "http probe with a path",
+			annotations: map[string]string{svcProbeAnnotation: "http", svcProbePathAnnotation: "healthz"},
+			expected:    endpointProbe{protocol: endpointProbeHTTP, path: "/healthz"},
+		},
+		{
+			name:        "unsupported probe",
+			annotations: map[string]string{svcProbeAnnotation: "grpc"},
+		},
+	}
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			svc := &v1.Service{ObjectMeta: metav1.ObjectMeta{
+				Namespace:   "default",
+				Name:        "svc-1",
+				Annotations: testcase.annotations,
+			}}
+			assert.Equal(t, testcase.expected, parseEndpointProbe(svc))
+		})
+	}
+}
+
+func TestProbeEndpoint(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+	host, port, err := net.SplitHostPort(srv.Listener.Addr().String())
+	assert.NoError(t, err)
+	target := endpointProbeTarget{ip: host}
+	target.port, err = strconv.Atoi(port)
+	assert.NoError(t, err)
+
+	target.probe = endpointProbe{protocol: endpointProbeTCP}
+	assert.NoError(t, probeEndpoint(target))
+	target.probe = endpointProbe{protocol: endpointProbeHTTP, path: "/healthz"}
+	assert.NoError(t, probeEndpoint(target))
+	target.probe = endpointProbe{protocol: endpointProbeHTTP, path: "/"}
+	assert.Error(t, probeEndpoint(target))
+
+	srv.Close()
+	target.probe = endpointProbe{protocol: endpointProbeTCP}
+	assert.Error(t, probeEndpoint(target))
+}
+
+func TestEndpointProbeController(t *testing.T) {
+	svc := &serviceInfo{namespace: "default", name: "svc-1", protocol: tcpProtocol,
+		probe: endpointProbe{protocol: endpointProbeTCP}}
+	svcID := generateServiceID("default", "svc-1", "http")
+	endpoints := endpointSliceInfoMap{svcID: {
+		{ip: "10.1.0.1", port: 8080, isIPv4: true, isReady: true, weight: 1},
+		{ip: "10.1.0.2", port: 8080, isIPv4: true, isReady: true, weight: 1},
+	}}
+	healthy := newEndpointProbeTarget(svc, endpoints[svcID][0])
+	broken := newEndpointProbeTarget(svc, endpoints[svcID][1])
+
+	stateChanges := 0
+	epc := NewEndpointProbeController(func() { stateChanges++ }, false)
+	defer epc.StopAll()
+	// probes are run by the test instead of the tickers
+	epc.interval = time.Hour
+	failing := map[endpointProbeTarget]bool{broken: true}
+	epc.probeFunc = func(target endpointProbeTarget) error {
+		if failing[target] {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+
+	// services that don't enable probes and UDP services aren't probed
+	epc.UpdateServicesInfo(serviceInfoMap{
+		svcID:   {namespace: "default", name: "svc-1", protocol: tcpProtocol},
+		"other": {namespace: "default", name: "svc-2", protocol: udpProtocol, probe: svc.probe},
+	}, endpointSliceInfoMap{svcID: endpoints[svcID], "other": endpoints[svcID]})
+	assert.Empty(t, epc.probes)
+
+	epc.UpdateServicesInfo(serviceInfoMap{svcID: svc}, endpoints)
+	assert.Len(t, epc.probes, 2)
+	assert.True(t, epc.isHealthy(broken))
+
+	probeAll := func() {
+		for target, state := range epc.probes {
+			epc.probe(target, state)
+		}
+	}
+	for i := 1; i < endpointProbeFailureThreshold; i++ {
+		probeAll()
+	}
+	assert.True(t, epc.isHealthy(broken))
+	assert.Equal(t, 0, stateChanges)
+
+	probeAll()
+	assert.False(t, epc.isHealthy(broken))
+	assert.True(t, epc.isHealthy(healthy))
+	assert.Equal(t, 1, stateChanges)
+
+	nsc := &NetworkServicesController{epc: epc}
+	assert.Equal(t, 0, nsc.endpointIPVSWeight(svc, endpoints[svcID][1]))
+	assert.Equal(t, 1, nsc.endpointIPVSWeight(svc, endpoints[svcID][0]))
+
+	delete(failing, broken)
+	probeAll()
+	assert.True(t, epc.isHealthy(broken))
+	assert.Equal(t, 2, stateChanges)
+
+	epc.UpdateServicesInfo(serviceInfoMap{}, endpointSliceInfoMap{})
+	assert.Empty(t, epc.probes)
+}
@@ -0,0 +1,343 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cloudnativelabs/kube-router/v2/pkg/utils"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+const (
+	hostPortChainName     = "KUBE-ROUTER-HOSTPORTS"
+	hostPortMasqChainName = "KUBE-ROUTER-HOSTPORTS-MASQ"
+	hostPortComment       = "kube-router hostports"
+)
+
+// hostPortMapping is the DNAT of a hostPort of a pod that runs on the node to one of the pod's IPs
+type hostPortMapping struct {
+	family v1.IPFamily
+	// pod is the namespace/name of the pod, it is only used in the rule comments
+	pod string
+	// hostIP is blank when the hostPort is exposed on all local addresses
+	hostIP        string
+	hostPort      int
+	protocol      string
+	podIP         string
+	containerPort int
+}
+
+// hostPortIptablesRules are the rules of the hostPort chains of one IP family
+type hostPortIptablesRules struct {
+	dnat [][]string
+	masq [][]string
+}
+
+// podHostPortMappings returns the hostPort mappings of the pod when it runs on this node and has IPs, pods that use
+// the host network don't need any DNAT
+func (nsc *NetworkServicesController) podHostPortMappings(pod *v1.Pod) []hostPortMapping {
+	if pod.Spec.NodeName != nsc.krNode.GetNodeName() || pod.Spec.HostNetwork ||
+		pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+		return nil
+	}
+
+	podIPs := make(map[v1.IPFamily]string)
+	for _, podIP := range pod.Status.PodIPs {
+		ip := net.ParseIP(podIP.IP)
+		if ip == nil {
+			continue
+		}
+		family := v1.IPv4Protocol
+		if ip.To4() == nil {
+			family = v1.IPv6Protocol
+		}
+		if _, ok := podIPs[family]; !ok {
+			podIPs[family] = ip.String()
+		}
+	}
+	if len(podIPs) == 0 {
+		return nil
+	}
+
+	var mappings []hostPortMapping
+	containers := append(append([]v1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+	for _, container := range containers {
+		for _, port := range container.Ports {
+			if port.HostPort == 0 {
+				continue
+			}
+			protocol := strings.ToLower(string(port.Protocol))
+			if protocol == "" {
+				protocol = tcpProtocol
+			}
+
+			var hostIP net.IP
+			if port.HostIP != "" {
+				hostIP = net.ParseIP(port.HostIP)
+				if hostIP == nil {
+					klog.Warningf("Ignoring hostPort %d of pod %s/%s as its hostIP %q is not a valid IP",
+						port.HostPort, pod.Namespace, pod.Name, port.HostIP)
+					continue
+				}
+			}
+
+			for _, family := range []v1.IPFamily{v1.IPv4Protocol, v1.IPv6Protocol} {
+				podIP, ok := podIPs[family]
+				if !ok {
+					continue
+				}
+				mapping := hostPortMapping{family: family, pod: pod.Namespace + "/" + pod.Name,
+					hostPort: int(port.HostPort), protocol: protocol, podIP: podIP,
+					containerPort: int(port.ContainerPort)}
+				// 0.0.0.0 and :: expose the hostPort on all local addresses, just like a blank hostIP
+				if hostIP != nil && !hostIP.IsUnspecified() {
+					if (hostIP.To4() != nil) != (family == v1.IPv4Protocol) {
+						continue
+					}
+					mapping.hostIP = hostIP.String()
+				}
+				mappings = append(mappings, mapping)
+			}
+		}
+	}
+	return mappings
+}
+
+// getHostPortMappings returns the hostPort mappings of all pods that run on this node, sorted so that unchanged pods
+// result in unchanged rules. There are no mappings when hostPort support isn't enabled.
+func (nsc *NetworkServicesController) getHostPortMappings() []hostPortMapping {
+	if !nsc.hostPortEnabled {
+		return nil
+	}
+
+	var mappings []hostPortMapping
+	for _, obj := range nsc.podLister.List() {
+		pod, ok := obj.(*v1.Pod)
+		if !ok {
+			continue
+		}
+		mappings = append(mappings, nsc.podHostPortMappings(pod)...)
+	}
+	sort.Slice(mappings, func(i, j int) bool {
+		a, b := mappings[i], mappings[j]
+		if a.family != b.family {
+			return a.family < b.family
+		}
+		if a.hostPort != b.hostPort {
+			return a.hostPort < b.hostPort
+		}
+		if a.protocol != b.protocol {
+			return a.protocol < b.protocol
+		}
+		if a.hostIP != b.hostIP {
+			return a.hostIP < b.hostIP
+		}
+		return a.podIP < b.podIP
+	})
+	return mappings
+}
+
+// buildHostPortIptablesRules returns the DNAT rules of the hostPort mappings of the given family and the rules that
+// masquerade the traffic of pods that reach themselves through their own hostPort
+func buildHostPortIptablesRules(mappings []hostPortMapping, family v1.IPFamily) hostPortIptablesRules {
+	var rules hostPortIptablesRules
+	for _, mapping := range mappings {
+		if mapping.family != family {
+			continue
+		}
+		comment := fmt.Sprintf("%s hostport %d", mapping.pod, mapping.hostPort)
+		dnat := []string{"-p", mapping.protocol}
+		if mapping.hostIP != "" {
+			dnat = append(dnat, "-d", mapping.hostIP)
+		}
+		dnat = append(dnat, "--dport", strconv.Itoa(mapping.hostPort), "-m", "comment", "--comment", comment,
+			"-j", "DNAT", "--to-destination",
+			net.JoinHostPort(mapping.podIP, strconv.Itoa(mapping.containerPort)))
+		rules.dnat = append(rules.dnat, dnat)
+		rules.masq = append(rules.masq, []string{"-s", mapping.podIP, "-d", mapping.podIP, "-p", mapping.protocol,
+			"--dport", strconv.Itoa(mapping.containerPort), "-m", "conntrack", "--ctstate", "DNAT",
+			"-m", "comment", "--comment", comment, "-j", "MASQUERADE"})
+	}
+	return rules
+}
+
+// hostPortJumpRules returns the rules that jump from the parent chains of the nat table to the hostPort chains.
+// Only traffic to local addresses is DNAT'd, locally generated traffic to the loopback addresses is left alone as it
+// can't be routed to the pods. Traffic to the address, protocol and port of an IPVS service is left to IPVS so that a
+// hostPort can't take over the port of a service, the node's own addresses are in the set of service addresses as
+// well as soon as a NodePort service exists, so only the ports of the services are excluded.
+func hostPortJumpRules(family v1.IPFamily) map[string][]string {
+	loopback := "127.0.0.0/8"
+	if family == v1.IPv6Protocol {
+		loopback = "::1/128"
+	}
+	localJump := []string{"-m", "addrtype", "--dst-type", "LOCAL",
+		"-m", "set", "!", "--match-set", getIPSetName(serviceIPPortsSetName, family), "dst,dst",
+		"-m", "comment", "--comment", hostPortComment, "-j", hostPortChainName}
+	return map[string][]string{
+		"PREROUTING":  localJump,
+		"OUTPUT":      append([]string{"!", "-d", loopback}, localJump...),
+		"POSTROUTING": {"-m", "comment", "--comment", hostPortComment, "-j", hostPortMasqChainName},
+	}
+}
+
+// syncHostPortIptablesRules rewrites the hostPort chains of every IP family whose rules changed since the last sync
+// and removes the chains of families that don't have any hostPorts
+func (nsc *NetworkServicesController) syncHostPortIptablesRules() error {
+	mappings := nsc.getHostPortMappings()
+
+	for family, iptablesCmdHandler := range nsc.iptablesCmdHandlers {
+		rules := buildHostPortIptablesRules(mappings, family)
+		if applied, ok := nsc.hostPortRules[family]; ok && reflect.DeepEqual(applied, rules) {
+			continue
+		}
+
+		if len(rules.dnat) == 0 {
+			if err := deleteHostPortIptablesRules(iptablesCmdHandler, family); err != nil {
+				return fmt.Errorf("failed to delete %s hostPort rules: %v", family, err)
+			}
+		} else {
+			if err := writeHostPortChain(iptablesCmdHandler, hostPortChainName, rules.dnat); err != nil {
+				return err
+			}
+			if err := writeHostPortChain(iptablesCmdHandler, hostPortMasqChainName, rules.masq); err != nil {
+				return err
+			}
+			for parentChain, jumpArgs := range hostPortJumpRules(family) {
+				exists, err := iptablesCmdHandler.Exists("nat", parentChain, jumpArgs...)
+				if err != nil {
+					return fmt.Errorf("failed to check for hostPort jump in %s chain: %v", parentChain, err)
+				}
+				if exists {
+					continue
+				}
+				if err = iptablesCmdHandler.Insert("nat", parentChain, 1, jumpArgs...); err != nil {
+					return fmt.Errorf("failed to add hostPort jump to %s chain: %v", parentChain, err)
+				}
+			}
+			klog.V(1).Infof("Synced %d %s hostPort rules", len(rules.dnat), family)
+		}
+		nsc.hostPortRules[family] = rules
+	}
+	return nil
+}
+
+// writeHostPortChain creates the chain in the nat table when it doesn't exist yet and replaces its rules
+func writeHostPortChain(iptablesCmdHandler utils.IPTablesHandler, chain string, rules [][]string) error {
+	exists, err := iptablesCmdHandler.ChainExists("nat", chain)
+	if err != nil {
+		return fmt.Errorf("failed to check for chain %s in nat table: %v", chain, err)
+	}
+	if !exists {
+		if err = iptablesCmdHandler.NewChain("nat", chain); err != nil {
+			return fmt.Errorf("failed to create chain %s in nat table: %v", chain, err)
+		}
+	}
+	if err = iptablesCmdHandler.ClearChain("nat", chain); err != nil {
+		return fmt.Errorf("failed to flush chain %s in nat table: %v", chain, err)
+	}
+	for _, rule := range rules {
+		if err = iptablesCmdHandler.Append("nat", chain, rule...); err != nil {
+			return fmt.Errorf("failed to add rule to chain %s in nat table: %v", chain, err)
+		}
+	}
+	return nil
+}
+
+// deleteHostPortIptablesRules removes the jumps to the hostPort chains and the chains themselves
+func deleteHostPortIptablesRules(iptablesCmdHandler utils.IPTablesHandler, family v1.IPFamily) error {
+	chainExists := make(map[string]bool)
+	for _, chain := range []string{hostPortChainName, hostPortMasqChainName} {
+		exists, err := iptablesCmdHandler.ChainExists("nat", chain)
+		if err != nil {
+			return fmt.Errorf("failed to check for chain %s in nat table: %v", chain, err)
+		}
+		chainExists[chain] = exists
+	}
+
+	// iptables can't check for jumps to chains that don't exist
+	for parentChain, jumpArgs := range hostPortJumpRules(family) {
+		if !chainExists[jumpArgs[len(jumpArgs)-1]] {
+			continue
+		}
+		if err := iptablesCmdHandler.DeleteIfExists("nat", parentChain, jumpArgs...); err != nil {
+			return fmt.Errorf("failed to delete hostPort jump from %s chain: %v", parentChain, err)
+		}
+	}
+	for chain, exists := range chainExists {
+		if !exists {
+			continue
+		}
+		if err := iptablesCmdHandler.ClearAndDeleteChain("nat", chain); err != nil {
+			return fmt.Errorf("failed to delete chain %s in nat table: %v", chain, err)
+		}
+		klog.V(1).Infof("Deleted %s hostPort chain %s", family, chain)
+	}
+	return nil
+}
+
+func (nsc *NetworkServicesController) newPodEventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			nsc.handlePodUpdate(nil, obj)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			nsc.handlePodUpdate(oldObj, newObj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			nsc.handlePodUpdate(obj, nil)
+		},
+	}
+}
+
+// handlePodUpdate requests a sync of the services of a pod when its endpoint weight annotation changed and a sync of
+// the hostPort rules when the hostPort mappings of a local pod changed, either object may be nil for added and
+// deleted pods
+func (nsc *NetworkServicesController) handlePodUpdate(oldObj, newObj interface{}) {
+	var oldPod, newPod *v1.Pod
+	for _, p := range []struct {
+		obj interface{}
+		pod **v1.Pod
+	}{{oldObj, &oldPod}, {newObj, &newPod}} {
+		if p.obj == nil {
+			continue
+		}
+		pod, ok := p.obj.(*v1.Pod)
+		if !ok {
+			klog.Errorf("unexpected object type: %v", p.obj)
+			return
+		}
+		*p.pod = pod
+	}
+
+	// the weights of added and deleted pods are picked up by the sync that the change to their endpoints requests
+	if oldPod != nil && newPod != nil &&
+		oldPod.Annotations[endpointWeightAnnotation] != newPod.Annotations[endpointWeightAnnotation] {
+		nsc.enqueuePodServicesSync(newPod)
+	}
+
+	if !nsc.hostPortEnabled {
+		return
+	}
+
+	var oldMappings, newMappings []hostPortMapping
+	if oldPod != nil {
+		oldMappings = nsc.podHostPortMappings(oldPod)
+	}
+	if newPod != nil {
+		newMappings = nsc.podHostPortMappings(newPod)
+	}
+
+	if !reflect.DeepEqual(oldMappings, newMappings) {
+		nsc.sync(synctypeHostPorts)
+	}
+}
@@ -0,0 +1,208 @@
+package proxy
+
+import (
+	"net"
+	"slices"
+	"syscall"
+	"testing"
+
+	"github.com/cloudnativelabs/kube-router/v2/pkg/utils"
+	"github.com/moby/ipvs"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	discovery "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+func newTestHostPortPod(name, nodeName string, ports []v1.ContainerPort, podIPs ...string) *v1.Pod {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+		Spec: v1.PodSpec{
+			NodeName:   nodeName,
+			Containers: []v1.Container{{Name: "app", Ports: ports}},
+		},
+		Status: v1.PodStatus{Phase: v1.PodRunning},
+	}
+	for _, podIP := range podIPs {
+		pod.Status.PodIPs = append(pod.Status.PodIPs, v1.PodIP{IP: podIP})
+	}
+	return pod
+}
+
+func newTestHostPortNSC(t *testing.T, pods ...*v1.Pod) *NetworkServicesController {
+	podLister := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, pod := range pods {
+		assert.NoError(t, podLister.Add(pod))
+	}
+	return &NetworkServicesController{
+		krNode:          &utils.LocalKRNode{KRNode: utils.KRNode{NodeName: "node-1"}},
+		podLister:       podLister,
+		hostPortEnabled: true,
+		syncChan:        make(chan int, 2),
+	}
+}
+
+func TestNetworkServicesController_getHostPortMappings(t *testing.T) {
+	webPorts := []v1.ContainerPort{{ContainerPort: 80, HostPort: 8080}, {ContainerPort: 9090}}
+	dnsPorts := []v1.ContainerPort{
+		{ContainerPort: 5353, HostPort: 53, HostIP: "10.0.0.1", Protocol: v1.ProtocolUDP},
+		{ContainerPort: 5353, HostPort: 53, HostIP: "::", Protocol: v1.ProtocolTCP},
+	}
+	hostNetwork := newTestHostPortPod("host-network", "node-1", webPorts, "10.0.0.1")
+	hostNetwork.Spec.HostNetwork = true
+	completed := newTestHostPortPod("completed", "node-1", webPorts, "10.1.0.9")
+	completed.Status.Phase = v1.PodSucceeded
+
+	nsc := newTestHostPortNSC(t,
+		newTestHostPortPod("web", "node-1", webPorts, "10.1.0.5", "fd00::5"),
+		newTestHostPortPod("dns", "node-1", dnsPorts, "10.1.0.6", "fd00::6"),
+		newTestHostPortPod("remote", "node-2", webPorts, "10.2.0.5"),
+		newTestHostPortPod("pending", "node-1", webPorts),
+		hostNetwork,
+		completed,
+	)
+
+	assert.Equal(t, []hostPortMapping{
+		{family: v1.IPv4Protocol, pod: "default/dns", hostPort: 53, protocol: tcpProtocol, podIP: "10.1.0.6",
+			containerPort: 5353},
+		{family: v1.IPv4Protocol, pod: "default/dns", hostIP: "10.0.0.1", hostPort: 53, protocol: udpProtocol,
+			podIP: "10.1.0.6", containerPort: 5353},
+		{family: v1.IPv4Protocol, pod: "default/web", hostPort: 8080, protocol: tcpProtocol, podIP: "10.1.0.5",
+			containerPort: 80},
+		{family: v1.IPv6Protocol, pod: "default/dns", hostPort: 53, protocol: tcpProtocol, podIP: "fd00::6",
+			containerPort: 5353},
+		{family: v1.IPv6Protocol, pod: "default/web", hostPort: 8080, protocol: tcpProtocol, podIP: "fd00::5",
+			containerPort: 80},
+	}, nsc.getHostPortMappings())
+
+	nsc.hostPortEnabled = false
+	assert.Empty(t, nsc.getHostPortMappings())
+}
+
+func TestBuildHostPortIptablesRules(t *testing.T) {
+	mappings := []hostPortMapping{
+		{family: v1.IPv4Protocol, pod: "default/dns", hostIP: "10.0.0.1", hostPort: 53, protocol: udpProtocol,
+			podIP: "10.1.0.6", containerPort: 5353},
+		{family: v1.IPv6Protocol, pod: "default/web", hostPort: 8080, protocol: tcpProtocol, podIP: "fd00::5",
+			containerPort: 80},
+	}
+
+	assert.Equal(t, hostPortIptablesRules{
+		dnat: [][]string{{"-p", "udp", "-d", "10.0.0.1", "--dport", "53", "-m", "comment", "--comment",
+			"default/dns hostport 53", "-j", "DNAT", "--to-destination", "10.1.0.6:5353"}},
+		masq: [][]string{{"-s", "10.1.0.6", "-d", "10.1.0.6", "-p", "udp", "--dport", "5353", "-m", "conntrack",
+			"--ctstate", "DNAT", "-m", "comment", "--comment", "default/dns hostport 53", "-j", "MASQUERADE"}},
+	}, buildHostPortIptablesRules(mappings, v1.IPv4Protocol))
+
+	ipv6Rules := buildHostPortIptablesRules(mappings, v1.IPv6Protocol)
+	assert.Equal(t, [][]string{{"-p", "tcp", "--dport", "8080", "-m", "comment", "--comment",
+		"default/web hostport 8080", "-j", "DNAT", "--to-destination", "[fd00::5]:80"}}, ipv6Rules.dnat)
+}
+
+func TestNetworkServicesController_handlePodUpdate(t *testing.T) {
+	ports := []v1.ContainerPort{{ContainerPort: 80, HostPort: 8080}}
+	pending := newTestHostPortPod("web", "node-1", ports)
+	running := newTestHostPortPod("web", "node-1", ports, "10.1.0.5")
+	nsc := newTestHostPortNSC(t)
+
+	// pods without IPs, without hostPorts or on other nodes don't need a sync
+	nsc.handlePodUpdate(nil, pending)
+	nsc.handlePodUpdate(nil, newTestHostPortPod("plain", "node-1", nil, "10.1.0.6"))
+	nsc.handlePodUpdate(nil, newTestHostPortPod("remote", "node-2", ports, "10.2.0.5"))
+	nsc.handlePodUpdate(running, running)
+	assert.Empty(t, nsc.syncChan)
+
+	nsc.handlePodUpdate(pending, running)
+	assert.Equal(t, synctypeHostPorts, <-nsc.syncChan)
+	nsc.newPodEventHandler().OnDelete(cache.DeletedFinalStateUnknown{Obj: running})
+	assert.Equal(t, synctypeHostPorts, <-nsc.syncChan)
+
+	nsc.hostPortEnabled = false
+	nsc.handlePodUpdate(nil, running)
+	assert.Empty(t, nsc.syncChan)
+}
+
+func TestNetworkServicesController_handlePodUpdateWeight(t *testing.T) {
+	nsc := newTestHostPortNSC(t)
+	nsc.hostPortEnabled = false
+	nsc.serviceQueue = workqueue.NewTyped[string]()
+	nsc.epSliceLister = cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	assert.NoError(t, nsc.epSliceLister.Add(&discovery.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web-abcde",
+			Labels: map[string]string{discovery.LabelServiceName: "web"}},
+		Endpoints: []discovery.Endpoint{{Addresses: []string{"10.1.0.5"},
+			TargetRef: &v1.ObjectReference{Kind: "Pod", Namespace: "default", Name: "web"}}},
+	}))
+	pod := newTestHostPortPod("web", "node-2", nil, "10.1.0.5")
+	weighted := pod.DeepCopy()
+	weighted.Annotations = map[string]string{endpointWeightAnnotation: "5"}
+
+	// added pods are synced through their endpoints, only changes to the annotation queue the pod's services
+	nsc.handlePodUpdate(nil, weighted)
+	nsc.handlePodUpdate(pod, pod)
+	assert.Zero(t, nsc.serviceQueue.Len())
+
+	nsc.handlePodUpdate(pod, weighted)
+	assert.Equal(t, 1, nsc.serviceQueue.Len())
+	key, _ := nsc.serviceQueue.Get()
+	assert.Equal(t, "default/web", key)
+	assert.Empty(t, nsc.syncChan)
+}
+
+func Test_hostPortJumpRules(t *testing.T) {
+	// the ports of the services on kube-dummy-if and the NodePorts are local too, but their traffic must be left to IPVS
+	jumps := hostPortJumpRules(v1.IPv6Protocol)
+	assert.Equal(t, []string{"-m", "addrtype", "--dst-type", "LOCAL", "-m", "set", "!", "--match-set",
+		"inet6:kube-router-svip-prt", "dst,dst", "-m", "comment", "--comment", hostPortComment, "-j",
+		hostPortChainName}, jumps["PREROUTING"])
+	assert.Equal(t, append([]string{"!", "-d", "::1/128"}, jumps["PREROUTING"]...), jumps["OUTPUT"])
+}
+
+// tHostPortJumpMatches evaluates the set match of the hostPort jump rule for traffic to a local address, with the
+// ipsets given by their entries
+func tHostPortJumpMatches(args []string, sets map[string][]string, address, protocol string, port int) bool {
+	for idx := 0; idx < len(args)-2; idx++ {
+		if args[idx] != "--match-set" {
+			continue
+		}
+		entry := address
+		if args[idx+2] == "dst,dst" {
+			entry = serviceAddr{address: net.ParseIP(address), protocol: protocol, port: port}.ipSetEntry()
+		}
+		return slices.Contains(sets[args[idx+1]], entry) == (args[idx-1] != "!")
+	}
+	return true
+}
+
+func TestNetworkServicesController_hostPortsWithNodePortService(t *testing.T) {
+	nsc := &NetworkServicesController{
+		krNode: &utils.LocalKRNode{KRNode: utils.KRNode{NodeName: "node-1", PrimaryIP: net.ParseIP("192.168.1.10")}},
+		ln: &LinuxNetworkingMock{ipvsGetServicesFunc: func() ([]*ipvs.Service, error) {
+			return []*ipvs.Service{
+				{Address: net.ParseIP("10.96.0.10"), Protocol: syscall.IPPROTO_TCP, Port: 80},
+				{Address: net.ParseIP("192.168.1.10"), Protocol: syscall.IPPROTO_TCP, Port: 30080},
+			}, nil
+		}},
+		serviceMap: map[string]*serviceInfo{"default/web:http": {clusterIPs: []string{"10.96.0.10"},
+			protocol: tcpProtocol, port: 80, nodePort: 30080}},
+	}
+	serviceAddrs, err := nsc.getFirewallServiceAddrs()
+	assert.NoError(t, err)
+	sets := make(map[string][]string)
+	for _, addr := range serviceAddrs[v1.IPv4Protocol] {
+		sets[serviceIPsIPSetName] = append(sets[serviceIPsIPSetName], addr.address.String())
+		sets[serviceIPPortsSetName] = append(sets[serviceIPPortsSetName], addr.ipSetEntry())
+	}
+	// the node's address is a service address because of the NodePort
+	assert.Contains(t, sets[serviceIPsIPSetName], "192.168.1.10")
+
+	jump := hostPortJumpRules(v1.IPv4Protocol)["PREROUTING"]
+	assert.True(t, tHostPortJumpMatches(jump, sets, "192.168.1.10", tcpProtocol, 8080),
+		"a hostPort on the node's address must be reachable while a NodePort service exists")
+	assert.True(t, tHostPortJumpMatches(jump, sets, "192.168.1.10", udpProtocol, 30080))
+	assert.False(t, tHostPortJumpMatches(jump, sets, "192.168.1.10", tcpProtocol, 30080),
+		"the NodePort of a service must be left to IPVS")
+	assert.False(t, tHostPortJumpMatches(jump, sets, "10.96.0.10", tcpProtocol, 80))
+}
@@ -459,12 +459,13 @@ func (ln *linuxNetworking) ipvsAddServer(service *ipvs.Service, dest *ipvs.Desti
 	}
 
 	if strings.Contains(err.Error(), IpvsServerExists) {
+		// the destination already exists, update it so that changes to its weight are reconciled
 		err = ln.ipvsUpdateDestination(service, dest)
 		if err != nil {
 			return fmt.Errorf("failed to update ipvs destination %s to the ipvs service %s due to : %s",
 				ipvsDestinationString(dest), ipvsServiceString(service), err.Error())
 		}
-		klog.V(2).Infof("ipvs destination %s already exists in the ipvs service %s so not adding destination",
+		klog.V(2).Infof("ipvs destination %s already exists in the ipvs service %s so updated the destination",
 			ipvsDestinationString(dest), ipvsServiceString(service))
 	} else {
 		return fmt.Errorf("failed to add ipvs destination %s to the ipvs service %s due to : %s",
@@ -2,11 +2,7 @@ package proxy
 
 import (
 	"fmt"
-	"os/exec"
-	"regexp"
-	"strconv"
 	"sync"
-	"syscall"
 	"time"
 
 	"github.com/moby/ipvs"
@@ -46,11 +42,9 @@ func (nsc *NetworkServicesController) ipvsDeleteDestination(svc *ipvs.Service, d
 			return err
 		}
 	}
-	// flush conntrack when Destination for a UDP service changes
-	if svc.Protocol == syscall.IPPROTO_UDP {
-		if err := nsc.flushConntrackUDP(svc); err != nil {
-			klog.Errorf("Failed to flush conntrack: %s", err.Error())
-		}
+	// delete the conntrack entries of UDP flows to the destination so that they get scheduled to another destination
+	if err := nsc.deleteConntrackEntries(svc, dst); err != nil {
+		klog.Errorf("Failed to delete conntrack entries: %v", err)
 	}
 	return nil
 }
@@ -147,23 +141,3 @@ func (nsc *NetworkServicesController) getIpvsDestinationConnStats(ipvsSvc *ipvs.
 	return 0, 0, fmt.Errorf("destination %s not found on IPVS service %s ",
 		ipvsDestinationString(dest), ipvsServiceString(ipvsSvc))
 }
-
-// flushConntrackUDP flushes UDP conntrack records for the given service destination
-func (nsc *NetworkServicesController) flushConntrackUDP(svc *ipvs.Service) error {
-	// Conntrack exits with non zero exit code when exiting if 0 flow entries have been deleted, use regex to
-	// check output and don't Error when matching
-	re := regexp.MustCompile("([[:space:]]0 flow entries have been deleted.)")
-
-	// Shell out and flush conntrack records
-	//nolint:gosec // this exec should be safe from command injection given the parameter's context
-	out, err := exec.Command("conntrack", "-D", "--orig-dst", svc.Address.String(), "-p", udpProtocol,
-		"--dport", strconv.Itoa(int(svc.Port))).CombinedOutput()
-	if err != nil {
-		if matched := re.MatchString(string(out)); !matched {
-			return fmt.Errorf("failed to delete conntrack entry for endpoint: %s:%d due to %s",
-				svc.Address.String(), svc.Port, err.Error())
-		}
-	}
-	klog.V(1).Infof("Deleted conntrack entry for endpoint: %s:%d", svc.Address.String(), svc.Port)
-	return nil
-}
@@ -6,7 +6,7 @@ import (
 	"fmt"
 	"math/big"
 	"net"
-	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -26,6 +26,7 @@ import (
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog/v2"
 )
 
@@ -38,10 +39,18 @@ const (
 	IfaceHasNoAddr    = "cannot assign requested address"
 	IpvsServerExists  = "file exists"
 	IpvsMaglevHashing = "mh"
+	IpvsShortestDelay = "sed"
+	IpvsNeverQueue    = "nq"
+	IpvsLBLC          = "lblc"
+	IpvsLBLCR         = "lblcr"
+	IpvsFailover      = "fo"
 	IpvsSvcFSched1    = "flag-1"
 	IpvsSvcFSched2    = "flag-2"
 	IpvsSvcFSched3    = "flag-3"
 
+	defaultEndpointWeight = 1
+	maxEndpointWeight     = 65535
+
 	customDSRRouteTableID    = "78"
 	customDSRRouteTableName  = "kube-router-dsr"
 	externalIPRouteTableID   = "79"
@@ -74,6 +83,9 @@ const (
 	svcLocalAnnotation              = "kube-router.io/service.local"
 	svcSkipLbIpsAnnotation          = "kube-router.io/service.skiplbips"
 	svcSchedFlagsAnnotation         = "kube-router.io/service.schedflags"
+	svcProbeAnnotation              = "kube-router.io/service.probe"
+	svcProbePathAnnotation          = "kube-router.io/service.probe.path"
+	endpointWeightAnnotation        = "kube-router.io/endpoint.weight"
 
 	// kubernetes standard labels / annotations
 	svcProxyNameLabel = "service.kubernetes.io/service-proxy-name"
@@ -83,17 +95,21 @@ const (
 	// actual formulation for this may be inet6:<setNameBase> depending on ip family, plus when we change ipsets we use
 	// a swap operation that adds a hyphen to the end, so that means that these base names actually need to be less than
 	// 24 characters
-	localIPsIPSetName     = "kube-router-local-ips"
-	serviceIPPortsSetName = "kube-router-svip-prt"
-	serviceIPsIPSetName   = "kube-router-svip"
+	localIPsIPSetName                = "kube-router-local-ips"
+	serviceIPPortsSetName            = "kube-router-svip-prt"
+	serviceIPsIPSetName              = "kube-router-svip"
+	sourceRangeServiceIPPortsSetName = "kube-router-svip-sr-prt"
+	serviceSourceRangesSetName       = "kube-router-svip-sr"
 
 	ipvsFirewallChainName = "KUBE-ROUTER-SERVICES"
 	ipvsHairpinChainName  = "KUBE-ROUTER-HAIRPIN"
 	synctypeAll           = iota
 	synctypeIpvs
+	synctypeHostPorts
 
 	tcpProtocol         = "tcp"
 	udpProtocol         = "udp"
+	sctpProtocol        = "sctp"
 	noneProtocol        = "none"
 	tunnelInterfaceType = "tunnel"
 
@@ -110,6 +126,7 @@ const (
 // NetworkServicesController struct stores information needed by the controller
 type NetworkServicesController struct {
 	krNode              utils.NodeAware
+	nodeZone            string
 	syncPeriod          time.Duration
 	mu                  sync.Mutex
 	serviceMap          serviceInfoMap
@@ -121,6 +138,9 @@ type NetworkServicesController struct {
 	ipvsPermitAll       bool
 	client              kubernetes.Interface
 	nodeportBindOnAllIP bool
+	nodePortCIDRs       []net.IPNet
+	nodePortInterfaces  []string
+	hostPortEnabled     bool
 	MetricsEnabled      bool
 	metricsMap          map[string][]string
 	ln                  LinuxNetworking
@@ -135,6 +155,8 @@ type NetworkServicesController struct {
 
 	EndpointSliceEventHandler cache.ResourceEventHandler
 	ServiceEventHandler       cache.ResourceEventHandler
+	PodEventHandler           cache.ResourceEventHandler
+	NodeEventHandler          cache.ResourceEventHandler
 
 	gracefulPeriod      time.Duration
 	gracefulQueue       gracefulQueue
@@ -145,13 +167,25 @@ type NetworkServicesController struct {
 
 	iptablesCmdHandlers map[v1.IPFamily]utils.IPTablesHandler
 	ipSetHandlers       map[v1.IPFamily]utils.IPSetHandler
+	ruleRenderer        proxyRuleRenderer
 	podIPv4CIDRs        []string
 	podIPv6CIDRs        []string
+	hostPortRules       map[v1.IPFamily]hostPortIptablesRules
 
 	hpc                *hairpinController
 	hpEndpointReceiver chan string
 
+	// serviceQueue holds the namespace/name keys of the services whose IPVS services need an incremental sync,
+	// activeServiceEndpointMap and ipvsServiceOwners are the IPVS services that the last syncs set up and the services
+	// that they were set up for
+	serviceQueue             workqueue.TypedInterface[string]
+	activeServiceEndpointMap map[string][]string
+	ipvsServiceOwners        map[string]string
+
 	nphc *nodePortHealthCheckController
+	epc  *endpointProbeController
+
+	conntrack conntrackDeleter
 }
 
 type ipvsCalls interface {
@@ -195,10 +229,13 @@ type serviceInfo struct {
 	skipLbIps                     bool
 	externalIPs                   []string
 	loadBalancerIPs               []string
+	loadBalancerSourceRanges      []string
+	trafficDistribution           string
 	intTrafficPolicy              *v1.ServiceInternalTrafficPolicy
 	extTrafficPolicy              *v1.ServiceExternalTrafficPolicy
 	flags                         schedFlags
 	healthCheckNodePort           int
+	probe                         endpointProbe
 }
 
 // IPVS scheduler flags
@@ -211,6 +248,34 @@ type schedFlags struct {
 // map of all services, with unique service id(namespace name, service name, port) as key
 type serviceInfoMap map[string]*serviceInfo
 
+// serviceAddr is the address, protocol and port tuple of an IPVS service that the IPVS firewall permits traffic to
+type serviceAddr struct {
+	address  net.IP
+	protocol string
+	port     int
+}
+
+// ipSetEntry returns the entry of the tuple in a hash:ip,port ipset
+func (addr serviceAddr) ipSetEntry() string {
+	return fmt.Sprintf("%s,%s:%d", addr.address, addr.protocol, addr.port)
+}
+
+// serviceSourceRanges is an external or LoadBalancer IP, protocol and port tuple of a service that only permits traffic
+// from the service's loadBalancerSourceRanges
+type serviceSourceRanges struct {
+	addr         serviceAddr
+	sourceRanges []string
+}
+
+// hairpinRule describes the source NAT needed so that traffic from a local endpoint to one of its own service IPs
+// finds its way back into the endpoint
+type hairpinRule struct {
+	family      v1.IPFamily
+	endpointIP  string
+	serviceIPs  []net.IP
+	servicePort int
+}
+
 // internal representation of endpoints
 type endpointSliceInfo struct {
 	ip            string
@@ -221,6 +286,12 @@ type endpointSliceInfo struct {
 	isReady       bool
 	isServing     bool
 	isTerminating bool
+	// zone is the zone of the endpoint and zoneHints is a comma separated list of the zones it was hinted for by the
+	// EndpointSlice controller, it is kept as a string so that endpointSliceInfo stays comparable
+	zone      string
+	zoneHints string
+	// weight is the IPVS weight of the endpoint, see endpointWeight
+	weight int
 }
 
 // map of all endpoints, with unique service id(namespace name, service name, port) as key
@@ -246,7 +317,7 @@ func (nsc *NetworkServicesController) Run(healthChan chan<- *healthcheck.Control
 	klog.Infof("Starting network services controller")
 
 	klog.V(1).Info("Performing cleanup of depreciated masquerade iptables rules (if needed).")
-	err := nsc.deleteBadMasqueradeIptablesRules()
+	err := nsc.ruleRenderer.cleanupStaleRules()
 	if err != nil {
 		klog.Fatalf("error cleaning up old/bad masquerade rules: %s", err.Error())
 	}
@@ -258,7 +329,7 @@ func (nsc *NetworkServicesController) Run(healthChan chan<- *healthcheck.Control
 	}
 
 	// enable masquerade rule
-	err = nsc.ensureMasqueradeIptablesRule()
+	err = nsc.ruleRenderer.ensureMasqueradeRules()
 	if err != nil {
 		klog.Fatalf("failed to do add masquerade rule in POSTROUTING chain of nat table due to: %s", err.Error())
 	}
@@ -317,7 +388,7 @@ func (nsc *NetworkServicesController) Run(healthChan chan<- *healthcheck.Control
 	}
 
 	// https://github.com/cloudnativelabs/kube-router/issues/282
-	err = nsc.setupIpvsFirewall()
+	err = nsc.ruleRenderer.setupIpvsFirewall()
 	if err != nil {
 		klog.Fatalf("error setting up ipvs firewall: %v", err.Error())
 	}
@@ -338,6 +409,8 @@ func (nsc *NetworkServicesController) Run(healthChan chan<- *healthcheck.Control
 		nsc.readyForUpdates = true
 	}
 
+	go nsc.runServiceSyncWorker()
+
 	// loop forever until notified to stop on stopCh
 	for {
 		select {
@@ -345,7 +418,9 @@ func (nsc *NetworkServicesController) Run(healthChan chan<- *healthcheck.Control
 			nsc.mu.Lock()
 			nsc.readyForUpdates = false
 			nsc.mu.Unlock()
+			nsc.serviceQueue.ShutDown()
 			nsc.nphc.StopAll()
+			nsc.epc.StopAll()
 			klog.Info("Shutting down network services controller")
 			return
 
@@ -374,9 +449,17 @@ func (nsc *NetworkServicesController) Run(healthChan chan<- *healthcheck.Control
 				if err != nil {
 					klog.Errorf("error during ipvs sync in network service controller. Error: %v", err)
 				}
-				err = nsc.syncHairpinIptablesRules()
+				err = nsc.ruleRenderer.syncHairpinRules()
 				if err != nil {
-					klog.Errorf("error syncing hairpin iptables rules: %v", err)
+					klog.Errorf("error syncing hairpin rules: %v", err)
+				}
+				nsc.mu.Unlock()
+			case synctypeHostPorts:
+				klog.V(1).Info("Performing requested sync of hostPort rules")
+				nsc.mu.Lock()
+				err = nsc.ruleRenderer.syncHostPortRules()
+				if err != nil {
+					klog.Errorf("error syncing hostPort rules: %v", err)
 				}
 				nsc.mu.Unlock()
 			}
@@ -412,16 +495,20 @@ func (nsc *NetworkServicesController) doSync() error {
 	defer nsc.mu.Unlock()
 
 	// enable masquerade rule
-	err = nsc.ensureMasqueradeIptablesRule()
+	err = nsc.ruleRenderer.ensureMasqueradeRules()
 	if err != nil {
 		klog.Errorf("Failed to do add masquerade rule in POSTROUTING chain of nat table due to: %s", err.Error())
 	}
 
 	nsc.serviceMap = nsc.buildServicesInfo()
 	nsc.endpointsMap = nsc.buildEndpointSliceInfo()
-	err = nsc.syncHairpinIptablesRules()
+	err = nsc.ruleRenderer.syncHairpinRules()
 	if err != nil {
-		klog.Errorf("Error syncing hairpin iptables rules: %s", err.Error())
+		klog.Errorf("Error syncing hairpin rules: %s", err.Error())
+	}
+	err = nsc.ruleRenderer.syncHostPortRules()
+	if err != nil {
+		klog.Errorf("Error syncing hostPort rules: %s", err.Error())
 	}
 
 	err = nsc.syncIpvsServices(nsc.serviceMap, nsc.endpointsMap)
@@ -466,6 +553,18 @@ func (nsc *NetworkServicesController) setupIpvsFirewall() error {
 		if err != nil {
 			return fmt.Errorf("failed to create ipset: %s - %v", serviceIPPortsSetName, err)
 		}
+
+		// Create 2 ipsets for services with loadBalancerSourceRanges. One for the 'ip,port' that are restricted and
+		// one for the 'ip,port,net' that are permitted
+		_, err = ipSetHandler.Create(sourceRangeServiceIPPortsSetName, utils.TypeHashIPPort, utils.OptionTimeout, "0")
+		if err != nil {
+			return fmt.Errorf("failed to create ipset: %s - %v", sourceRangeServiceIPPortsSetName, err)
+		}
+
+		_, err = ipSetHandler.Create(serviceSourceRangesSetName, utils.TypeHashIPPortNet, utils.OptionTimeout, "0")
+		if err != nil {
+			return fmt.Errorf("failed to create ipset: %s - %v", serviceSourceRangesSetName, err)
+		}
 	}
 
 	// Setup a custom iptables chain to explicitly allow input traffic to ipvs services only.
@@ -476,11 +575,6 @@ func (nsc *NetworkServicesController) setupIpvsFirewall() error {
 			return fmt.Errorf("failed to run iptables command: %s", err.Error())
 		}
 
-		// config.IpvsPermitAll: true then create INPUT/KUBE-ROUTER-SERVICE Chain creation else return
-		if !nsc.ipvsPermitAll {
-			return nil
-		}
-
 		var comment string
 		var args []string
 		var exists bool
@@ -493,37 +587,25 @@ func (nsc *NetworkServicesController) setupIpvsFirewall() error {
 			icmpRejectType = "icmp6-port-unreachable"
 		}
 
-		// Add common IPv4/IPv6 ICMP rules to the default network policy chain to ensure that pods communicate properly
-		icmpRules := utils.CommonICMPRules(family)
-		for _, icmpRule := range icmpRules {
-			icmpArgs := []string{"-m", "comment", "--comment", icmpRule.Comment, "-p", icmpRule.IPTablesProto,
-				icmpRule.IPTablesType, icmpRule.ICMPType, "-j", "ACCEPT"}
-			err = iptablesCmdHandler.AppendUnique("filter", ipvsFirewallChainName, icmpArgs...)
-			if err != nil {
-				return fmt.Errorf("failed to run iptables command: %v", err)
-			}
-		}
-
-		// Get into specific service specific allowances
-		comment = "allow input traffic to ipvs services"
-		args = []string{"-m", "comment", "--comment", comment, "-m", "set",
-			"--match-set", getIPSetName(serviceIPPortsSetName, family), "dst,dst", "-j", "ACCEPT"}
-		err := iptablesCmdHandler.AppendUnique("filter", ipvsFirewallChainName, args...)
-		if err != nil {
-			return fmt.Errorf("failed to run iptables command: %s", err.Error())
-		}
-
-		// We exclude the local addresses here as that would otherwise block all traffic to local addresses if any
-		// NodePort service exists.
-		comment = "reject all unexpected traffic to service IPs"
+		// Services with loadBalancerSourceRanges only permit traffic to their external and LoadBalancer IPs from
+		// within those ranges, this is enforced regardless of config.IpvsPermitAll
+		comment = "reject traffic to service IPs from outside of their loadBalancerSourceRanges"
 		args = []string{"-m", "comment", "--comment", comment,
-			"-m", "set", "!", "--match-set", getIPSetName(localIPsIPSetName, family), "dst",
+			"-m", "set", "--match-set", getIPSetName(sourceRangeServiceIPPortsSetName, family), "dst,dst",
+			"-m", "set", "!", "--match-set", getIPSetName(serviceSourceRangesSetName, family), "dst,dst,src",
 			"-j", "REJECT", "--reject-with", icmpRejectType}
 		err = iptablesCmdHandler.AppendUnique("filter", ipvsFirewallChainName, args...)
 		if err != nil {
 			return fmt.Errorf("failed to run iptables command: %s", err.Error())
 		}
 
+		// config.IpvsPermitAll: true then create INPUT/KUBE-ROUTER-SERVICE Chain rules
+		if nsc.ipvsPermitAll {
+			if err = nsc.setupIpvsFirewallPermitRules(iptablesCmdHandler, family, icmpRejectType); err != nil {
+				return err
+			}
+		}
+
 		// Pass incoming traffic into our custom chain.
 		ipvsFirewallInputChainRule := getIPVSFirewallInputChainRule(family)
 		exists, err = iptablesCmdHandler.Exists("filter", "INPUT", ipvsFirewallInputChainRule...)
@@ -541,6 +623,48 @@ func (nsc *NetworkServicesController) setupIpvsFirewall() error {
 	return nil
 }
 
+// setupIpvsFirewallPermitRules adds the rules to the IPVS firewall chain that only permit traffic to service IPs for
+// ports that have IPVS services
+func (nsc *NetworkServicesController) setupIpvsFirewallPermitRules(iptablesCmdHandler utils.IPTablesHandler,
+	family v1.IPFamily, icmpRejectType string) error {
+	var comment string
+	var args []string
+	var err error
+
+	// Add common IPv4/IPv6 ICMP rules to the default network policy chain to ensure that pods communicate properly
+	icmpRules := utils.CommonICMPRules(family)
+	for _, icmpRule := range icmpRules {
+		icmpArgs := []string{"-m", "comment", "--comment", icmpRule.Comment, "-p", icmpRule.IPTablesProto,
+			icmpRule.IPTablesType, icmpRule.ICMPType, "-j", "ACCEPT"}
+		err = iptablesCmdHandler.AppendUnique("filter", ipvsFirewallChainName, icmpArgs...)
+		if err != nil {
+			return fmt.Errorf("failed to run iptables command: %v", err)
+		}
+	}
+
+	// Get into specific service specific allowances
+	comment = "allow input traffic to ipvs services"
+	args = []string{"-m", "comment", "--comment", comment, "-m", "set",
+		"--match-set", getIPSetName(serviceIPPortsSetName, family), "dst,dst", "-j", "ACCEPT"}
+	err = iptablesCmdHandler.AppendUnique("filter", ipvsFirewallChainName, args...)
+	if err != nil {
+		return fmt.Errorf("failed to run iptables command: %s", err.Error())
+	}
+
+	// We exclude the local addresses here as that would otherwise block all traffic to local addresses if any
+	// NodePort service exists.
+	comment = "reject all unexpected traffic to service IPs"
+	args = []string{"-m", "comment", "--comment", comment,
+		"-m", "set", "!", "--match-set", getIPSetName(localIPsIPSetName, family), "dst",
+		"-j", "REJECT", "--reject-with", icmpRejectType}
+	err = iptablesCmdHandler.AppendUnique("filter", ipvsFirewallChainName, args...)
+	if err != nil {
+		return fmt.Errorf("failed to run iptables command: %s", err.Error())
+	}
+
+	return nil
+}
+
 func (nsc *NetworkServicesController) cleanupIpvsFirewall() {
 	// Clear iptables rules
 	for family, iptablesCmdHandler := range nsc.iptablesCmdHandlers {
@@ -596,7 +720,8 @@ func (nsc *NetworkServicesController) cleanupIpvsFirewall() {
 			return
 		}
 
-		for _, ipSetName := range []string{localIPsIPSetName, serviceIPsIPSetName, serviceIPPortsSetName} {
+		for _, ipSetName := range []string{localIPsIPSetName, serviceIPsIPSetName, serviceIPPortsSetName,
+			sourceRangeServiceIPPortsSetName, serviceSourceRangesSetName} {
 			if _, ok := ipSetHandler.Sets()[ipSetName]; ok {
 				err = ipSetHandler.Destroy(ipSetName)
 				if err != nil {
@@ -621,20 +746,12 @@ func (nsc *NetworkServicesController) syncIpvsFirewall() error {
 	}()
 
 	// Populate local addresses ipset.
-	addrsMap, err := getAllLocalIPs()
+	addrsMap, err := nsc.getFirewallLocalIPs()
 	if err != nil {
-		return fmt.Errorf("failed to get local IPs: %s", err)
+		return err
 	}
 
 	for family, addrs := range addrsMap {
-		// Don't run for families that we don't support
-		if family == v1.IPv4Protocol && !nsc.krNode.IsIPv4Capable() {
-			continue
-		}
-		if family == v1.IPv6Protocol && !nsc.krNode.IsIPv6Capable() {
-			continue
-		}
-
 		// Convert addrs from a slice of net.IP to a slice of string
 		localIPsSets := make([][]string, 0, len(addrs))
 		for _, addr := range addrs {
@@ -646,14 +763,94 @@ func (nsc *NetworkServicesController) syncIpvsFirewall() error {
 	}
 
 	// Populate service ipsets.
-	ipvsServices, err := nsc.ln.ipvsGetServices()
+	serviceAddrs, err := nsc.getFirewallServiceAddrs()
 	if err != nil {
-		return errors.New("Failed to list IPVS services: " + err.Error())
+		return err
 	}
 
 	serviceIPsSets := make(map[v1.IPFamily][][]string)
 	serviceIPPortsIPSets := make(map[v1.IPFamily][][]string)
 
+	for family, addrs := range serviceAddrs {
+		for _, addr := range addrs {
+			serviceIPsSets[family] = append(serviceIPsSets[family],
+				[]string{addr.address.String(), utils.OptionTimeout, "0"})
+
+			serviceIPPortsIPSets[family] = append(serviceIPPortsIPSets[family],
+				[]string{addr.ipSetEntry(), utils.OptionTimeout, "0"})
+		}
+	}
+
+	// Populate the ipsets of services that restrict their clients with loadBalancerSourceRanges.
+	sourceRangeServiceIPPortsSets := make(map[v1.IPFamily][][]string)
+	serviceSourceRangesSets := make(map[v1.IPFamily][][]string)
+
+	for family, ranges := range nsc.getFirewallSourceRanges() {
+		for _, svcRanges := range ranges {
+			ipvsAddressWithPort := svcRanges.addr.ipSetEntry()
+			sourceRangeServiceIPPortsSets[family] = append(sourceRangeServiceIPPortsSets[family],
+				[]string{ipvsAddressWithPort, utils.OptionTimeout, "0"})
+
+			for _, sourceRange := range svcRanges.sourceRanges {
+				serviceSourceRangesSets[family] = append(serviceSourceRangesSets[family],
+					[]string{ipvsAddressWithPort + "," + sourceRange, utils.OptionTimeout, "0"})
+			}
+		}
+	}
+
+	for family, setHandler := range nsc.ipSetHandlers {
+		setHandler.RefreshSet(serviceIPsIPSetName, serviceIPsSets[family], utils.TypeHashIP)
+
+		setHandler.RefreshSet(serviceIPPortsSetName, serviceIPPortsIPSets[family], utils.TypeHashIPPort)
+
+		setHandler.RefreshSet(sourceRangeServiceIPPortsSetName, sourceRangeServiceIPPortsSets[family],
+			utils.TypeHashIPPort)
+
+		setHandler.RefreshSet(serviceSourceRangesSetName, serviceSourceRangesSets[family], utils.TypeHashIPPortNet)
+
+		err := setHandler.Restore()
+		if err != nil {
+			return fmt.Errorf("could not save ipset for service firewall: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// getFirewallLocalIPs returns the local addresses of the node for every IP family that the node is capable of, the
+// IPVS firewall excludes these addresses from the reject rule as they would otherwise be unreachable as soon as any
+// NodePort service exists
+func (nsc *NetworkServicesController) getFirewallLocalIPs() (map[v1.IPFamily][]net.IP, error) {
+	addrsMap, err := getAllLocalIPs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get local IPs: %s", err)
+	}
+
+	// Don't return families that we don't support
+	if !nsc.krNode.IsIPv4Capable() {
+		delete(addrsMap, v1.IPv4Protocol)
+	}
+	if !nsc.krNode.IsIPv6Capable() {
+		delete(addrsMap, v1.IPv6Protocol)
+	}
+
+	return addrsMap, nil
+}
+
+// getFirewallServiceAddrs returns the address, protocol and port of all kube-router controlled IPVS services grouped
+// by IP family, FWMark based services are resolved back to the service they were created for
+func (nsc *NetworkServicesController) getFirewallServiceAddrs() (map[v1.IPFamily][]serviceAddr, error) {
+	ipvsServices, err := nsc.ln.ipvsGetServices()
+	if err != nil {
+		return nil, errors.New("Failed to list IPVS services: " + err.Error())
+	}
+	nodePortIPs, err := nsc.getNodePortIPs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get NodePort IPs: %v", err)
+	}
+
+	serviceAddrs := make(map[v1.IPFamily][]serviceAddr)
+
 	for _, ipvsService := range ipvsServices {
 		var address net.IP
 		var protocol string
@@ -662,7 +859,7 @@ func (nsc *NetworkServicesController) syncIpvsFirewall() error {
 			address = ipvsService.Address
 			port = int(ipvsService.Port)
 
-			isValid, err := nsc.isValidKubeRouterServiceArtifact(address, port)
+			isValid, err := nsc.isValidKubeRouterServiceArtifact(address, port, nodePortIPs)
 			if err != nil {
 				klog.Infof("failed to lookup service by address %s: %v - this does not appear to be a kube-router "+
 					"controlled service, skipping...", address, err)
@@ -700,26 +897,66 @@ func (nsc *NetworkServicesController) syncIpvsFirewall() error {
 			family = v1.IPv6Protocol
 		}
 
-		serviceIPsSets[family] = append(serviceIPsSets[family], []string{address.String(), utils.OptionTimeout, "0"})
+		serviceAddrs[family] = append(serviceAddrs[family],
+			serviceAddr{address: address, protocol: protocol, port: port})
+	}
 
-		ipvsAddressWithPort := fmt.Sprintf("%s,%s:%d", address, protocol, port)
-		serviceIPPortsIPSets[family] = append(serviceIPPortsIPSets[family],
-			[]string{ipvsAddressWithPort, utils.OptionTimeout, "0"})
+	return serviceAddrs, nil
+}
 
-	}
+// getFirewallSourceRanges returns the external and LoadBalancer IP, protocol and port tuples of all services that
+// restrict their clients with loadBalancerSourceRanges grouped by IP family, along with the source ranges of the
+// tuple's family. Tuples of a family without source ranges permit no traffic at all, while a family that has a
+// source range with a zero prefix length isn't restricted.
+func (nsc *NetworkServicesController) getFirewallSourceRanges() map[v1.IPFamily][]serviceSourceRanges {
+	sourceRanges := make(map[v1.IPFamily][]serviceSourceRanges)
 
-	for family, setHandler := range nsc.ipSetHandlers {
-		setHandler.RefreshSet(serviceIPsIPSetName, serviceIPsSets[family], utils.TypeHashIP)
+	for _, svc := range nsc.serviceMap {
+		if len(svc.loadBalancerSourceRanges) == 0 {
+			continue
+		}
 
-		setHandler.RefreshSet(serviceIPPortsSetName, serviceIPPortsIPSets[family], utils.TypeHashIPPort)
+		familyRanges := make(map[v1.IPFamily][]string)
+		unrestricted := make(map[v1.IPFamily]bool)
+		for _, sourceRange := range svc.loadBalancerSourceRanges {
+			_, ipNet, err := net.ParseCIDR(sourceRange)
+			if err != nil {
+				continue
+			}
+			family := v1.IPv4Protocol
+			if ipNet.IP.To4() == nil {
+				family = v1.IPv6Protocol
+			}
+			if ones, _ := ipNet.Mask.Size(); ones == 0 {
+				unrestricted[family] = true
+			}
+			familyRanges[family] = append(familyRanges[family], sourceRange)
+		}
 
-		err := setHandler.Restore()
-		if err != nil {
-			return fmt.Errorf("could not save ipset for service firewall: %v", err)
+		for family, addrs := range getAllExternalIPs(svc, !svc.skipLbIps) {
+			if unrestricted[family] {
+				continue
+			}
+			for _, addr := range addrs {
+				sourceRanges[family] = append(sourceRanges[family], serviceSourceRanges{
+					addr:         serviceAddr{address: addr, protocol: svc.protocol, port: svc.port},
+					sourceRanges: familyRanges[family],
+				})
+			}
 		}
 	}
 
-	return nil
+	// the service map is unordered, sort the tuples so that an unchanged state renders unchanged rules
+	id := func(r serviceSourceRanges) string {
+		return generateIPPortID(r.addr.address.String(), r.addr.protocol, strconv.Itoa(r.addr.port))
+	}
+	for _, ranges := range sourceRanges {
+		sort.Slice(ranges, func(i, j int) bool {
+			return id(ranges[i]) < id(ranges[j])
+		})
+	}
+
+	return sourceRanges
 }
 
 func (nsc *NetworkServicesController) publishMetrics(serviceInfoMap serviceInfoMap) error {
@@ -836,19 +1073,14 @@ func (nsc *NetworkServicesController) OnEndpointsUpdate(es *discovery.EndpointSl
 		return
 	}
 
-	// build new service and endpoints map to reflect the change
-	newServiceMap := nsc.buildServicesInfo()
-	newEndpointsMap := nsc.buildEndpointSliceInfo()
-
-	if !endpointsMapsEquivalent(newEndpointsMap, nsc.endpointsMap) {
-		nsc.endpointsMap = newEndpointsMap
-		nsc.serviceMap = newServiceMap
-		klog.V(1).Infof("Syncing IPVS services sync for update to endpoint: %s/%s", es.Namespace, es.Name)
-		nsc.sync(synctypeIpvs)
-	} else {
-		klog.V(1).Infof("Skipping IPVS services sync on endpoint: %s/%s update as nothing changed",
-			es.Namespace, es.Name)
+	service, ok := svc.(*v1.Service)
+	if !ok {
+		klog.Errorf("unexpected object type: %v", svc)
+		return
 	}
+
+	// the service and endpoint info of the service is rebuilt and compared by the service sync worker
+	nsc.enqueueServiceSync(service.Namespace, service.Name)
 }
 
 // OnServiceUpdate handle change in service update from the API server
@@ -874,19 +1106,8 @@ func (nsc *NetworkServicesController) OnServiceUpdate(svc *v1.Service) {
 		return
 	}
 
-	// build new service and endpoints map to reflect the change
-	newServiceMap := nsc.buildServicesInfo()
-	newEndpointsMap := nsc.buildEndpointSliceInfo()
-
-	if len(newServiceMap) != len(nsc.serviceMap) || !reflect.DeepEqual(newServiceMap, nsc.serviceMap) {
-		nsc.endpointsMap = newEndpointsMap
-		nsc.serviceMap = newServiceMap
-		klog.V(1).Infof("Syncing IPVS services sync on update to service: %s/%s", svc.Namespace, svc.Name)
-		nsc.sync(synctypeIpvs)
-	} else {
-		klog.V(1).Infof("Skipping syncing IPVS services for update to service: %s/%s as nothing changed",
-			svc.Namespace, svc.Name)
-	}
+	// the service and endpoint info of the service is rebuilt and compared by the service sync worker
+	nsc.enqueueServiceSync(svc.Namespace, svc.Name)
 }
 
 func hasActiveEndpoints(endpoints []endpointSliceInfo) bool {
@@ -901,117 +1122,145 @@ func hasActiveEndpoints(endpoints []endpointSliceInfo) bool {
 func (nsc *NetworkServicesController) buildServicesInfo() serviceInfoMap {
 	serviceMap := make(serviceInfoMap)
 	for _, obj := range nsc.svcLister.List() {
-		svc := obj.(*v1.Service)
+		nsc.buildServiceInfo(obj.(*v1.Service), serviceMap)
+	}
+	return serviceMap
+}
 
-		if utils.ClusterIPIsNoneOrBlank(svc.Spec.ClusterIP) {
-			klog.V(2).Infof("Skipping service name:%s namespace:%s as there is no cluster IP",
-				svc.Name, svc.Namespace)
-			continue
-		}
+// buildServiceInfo adds the serviceInfo of every port of the service to serviceMap, services that kube-router doesn't
+// proxy are skipped
+func (nsc *NetworkServicesController) buildServiceInfo(svc *v1.Service, serviceMap serviceInfoMap) {
+	if utils.ClusterIPIsNoneOrBlank(svc.Spec.ClusterIP) {
+		klog.V(2).Infof("Skipping service name:%s namespace:%s as there is no cluster IP",
+			svc.Name, svc.Namespace)
+		return
+	}
 
-		if svc.Spec.Type == "ExternalName" {
-			klog.V(2).Infof("Skipping service name:%s namespace:%s due to service Type=%s",
-				svc.Name, svc.Namespace, svc.Spec.Type)
-			continue
-		}
+	if svc.Spec.Type == "ExternalName" {
+		klog.V(2).Infof("Skipping service name:%s namespace:%s due to service Type=%s",
+			svc.Name, svc.Namespace, svc.Spec.Type)
+		return
+	}
 
-		proxyName, err := getLabelFromMap(svcProxyNameLabel, svc.Labels)
-		if err == nil && proxyName != kubeRouterProxyName {
-			klog.V(2).Infof("Skipping service name:%s namespace:%s due to service-proxy-name label not being one "+
-				"that belongs to kube-router", svc.Name, svc.Namespace)
-			continue
+	proxyName, err := getLabelFromMap(svcProxyNameLabel, svc.Labels)
+	if err == nil && proxyName != kubeRouterProxyName {
+		klog.V(2).Infof("Skipping service name:%s namespace:%s due to service-proxy-name label not being one "+
+			"that belongs to kube-router", svc.Name, svc.Namespace)
+		return
+	}
+
+	// We handle headless service labels differently from a "None" or blank ClusterIP because ClusterIP is
+	// guaranteed to be immuteable whereas labels can be added / removed
+	_, err = getLabelFromMap(svcHeadlessLabel, svc.Labels)
+	if err == nil {
+		klog.V(2).Infof("Skipping service name:%s namespace:%s due to headless label being set", svc.Name,
+			svc.Namespace)
+		return
+	}
+
+	intClusterPolicyDefault := v1.ServiceInternalTrafficPolicyCluster
+	extClusterPolicyDefault := v1.ServiceExternalTrafficPolicyCluster
+	sourceRanges := parseLoadBalancerSourceRanges(svc)
+	probe := parseEndpointProbe(svc)
+	for _, port := range svc.Spec.Ports {
+		svcInfo := serviceInfo{
+			clusterIP:           net.ParseIP(svc.Spec.ClusterIP),
+			clusterIPs:          make([]string, len(svc.Spec.ClusterIPs)),
+			port:                int(port.Port),
+			targetPort:          port.TargetPort.String(),
+			protocol:            strings.ToLower(string(port.Protocol)),
+			nodePort:            int(port.NodePort),
+			name:                svc.Name,
+			namespace:           svc.Namespace,
+			externalIPs:         make([]string, len(svc.Spec.ExternalIPs)),
+			intTrafficPolicy:    &intClusterPolicyDefault,
+			extTrafficPolicy:    &extClusterPolicyDefault,
+			healthCheckNodePort: int(svc.Spec.HealthCheckNodePort),
+		}
+		dsrMethod, ok := svc.Annotations[svcDSRAnnotation]
+		if ok {
+			svcInfo.directServerReturn = true
+			svcInfo.directServerReturnMethod = dsrMethod
+		}
+		svcInfo.scheduler = ipvs.RoundRobin
+		schedulingMethod, ok := svc.Annotations[svcSchedulerAnnotation]
+		if ok {
+			switch schedulingMethod {
+			case ipvs.RoundRobin, ipvs.WeightedRoundRobin, ipvs.LeastConnection, ipvs.WeightedLeastConnection,
+				ipvs.DestinationHashing, ipvs.SourceHashing, IpvsMaglevHashing, IpvsShortestDelay, IpvsNeverQueue,
+				IpvsLBLC, IpvsLBLCR, IpvsFailover:
+				svcInfo.scheduler = schedulingMethod
+			default:
+				klog.Warningf("Unsupported scheduler %q in the %s annotation of service %s/%s, using %s",
+					schedulingMethod, svcSchedulerAnnotation, svc.Namespace, svc.Name, svcInfo.scheduler)
+			}
 		}
 
-		// We handle headless service labels differently from a "None" or blank ClusterIP because ClusterIP is
-		// guaranteed to be immuteable whereas labels can be added / removed
-		_, err = getLabelFromMap(svcHeadlessLabel, svc.Labels)
-		if err == nil {
-			klog.V(2).Infof("Skipping service name:%s namespace:%s due to headless label being set", svc.Name,
-				svc.Namespace)
-			continue
+		flags, ok := svc.Annotations[svcSchedFlagsAnnotation]
+		if ok && svcInfo.scheduler == IpvsMaglevHashing {
+			svcInfo.flags = parseSchedFlags(flags)
 		}
 
-		intClusterPolicyDefault := v1.ServiceInternalTrafficPolicyCluster
-		extClusterPolicyDefault := v1.ServiceExternalTrafficPolicyCluster
-		for _, port := range svc.Spec.Ports {
-			svcInfo := serviceInfo{
-				clusterIP:           net.ParseIP(svc.Spec.ClusterIP),
-				clusterIPs:          make([]string, len(svc.Spec.ClusterIPs)),
-				port:                int(port.Port),
-				targetPort:          port.TargetPort.String(),
-				protocol:            strings.ToLower(string(port.Protocol)),
-				nodePort:            int(port.NodePort),
-				name:                svc.Name,
-				namespace:           svc.Namespace,
-				externalIPs:         make([]string, len(svc.Spec.ExternalIPs)),
-				intTrafficPolicy:    &intClusterPolicyDefault,
-				extTrafficPolicy:    &extClusterPolicyDefault,
-				healthCheckNodePort: int(svc.Spec.HealthCheckNodePort),
-			}
-			dsrMethod, ok := svc.Annotations[svcDSRAnnotation]
-			if ok {
-				svcInfo.directServerReturn = true
-				svcInfo.directServerReturnMethod = dsrMethod
-			}
-			svcInfo.scheduler = ipvs.RoundRobin
-			schedulingMethod, ok := svc.Annotations[svcSchedulerAnnotation]
-			if ok {
-				switch schedulingMethod {
-				case ipvs.RoundRobin:
-					svcInfo.scheduler = ipvs.RoundRobin
-				case ipvs.LeastConnection:
-					svcInfo.scheduler = ipvs.LeastConnection
-				case ipvs.DestinationHashing:
-					svcInfo.scheduler = ipvs.DestinationHashing
-				case ipvs.SourceHashing:
-					svcInfo.scheduler = ipvs.SourceHashing
-				case IpvsMaglevHashing:
-					svcInfo.scheduler = IpvsMaglevHashing
-				}
+		copy(svcInfo.externalIPs, svc.Spec.ExternalIPs)
+		copy(svcInfo.clusterIPs, svc.Spec.ClusterIPs)
+		for _, lbIngress := range svc.Status.LoadBalancer.Ingress {
+			if len(lbIngress.IP) > 0 {
+				svcInfo.loadBalancerIPs = append(svcInfo.loadBalancerIPs, lbIngress.IP)
 			}
+		}
+		svcInfo.loadBalancerSourceRanges = sourceRanges
+		svcInfo.probe = probe
+		svcInfo.sessionAffinity = svc.Spec.SessionAffinity == v1.ServiceAffinityClientIP
 
-			flags, ok := svc.Annotations[svcSchedFlagsAnnotation]
-			if ok && svcInfo.scheduler == IpvsMaglevHashing {
-				svcInfo.flags = parseSchedFlags(flags)
-			}
+		if svcInfo.sessionAffinity {
+			// Kube-apiserver side guarantees SessionAffinityConfig won't be nil when session affinity
+			// type is ClientIP
+			// https://github.com/kubernetes/kubernetes/blob/master/pkg/apis/core/v1/defaults.go#L106
+			svcInfo.sessionAffinityTimeoutSeconds = *svc.Spec.SessionAffinityConfig.ClientIP.TimeoutSeconds
+		}
+		_, svcInfo.hairpin = svc.Annotations[svcHairpinAnnotation]
+		_, svcInfo.hairpinExternalIPs = svc.Annotations[svcHairpinExternalIPsAnnotation]
+		_, svcInfo.skipLbIps = svc.Annotations[svcSkipLbIpsAnnotation]
+		svcInfo.intTrafficPolicy = svc.Spec.InternalTrafficPolicy
+		svcInfo.extTrafficPolicy = &svc.Spec.ExternalTrafficPolicy
+		if svc.Spec.TrafficDistribution != nil {
+			svcInfo.trafficDistribution = *svc.Spec.TrafficDistribution
+		}
 
-			copy(svcInfo.externalIPs, svc.Spec.ExternalIPs)
-			copy(svcInfo.clusterIPs, svc.Spec.ClusterIPs)
-			for _, lbIngress := range svc.Status.LoadBalancer.Ingress {
-				if len(lbIngress.IP) > 0 {
-					svcInfo.loadBalancerIPs = append(svcInfo.loadBalancerIPs, lbIngress.IP)
-				}
-			}
-			svcInfo.sessionAffinity = svc.Spec.SessionAffinity == v1.ServiceAffinityClientIP
+		// The kube-router.io/service.local annotation has the ability to override the internal and external traffic
+		// policy that is set in the spec. Previously, when this was active set both to local when the annotation is
+		// true so that previous functionality of the annotation is best preserved. However, this has proved to not
+		// be a good fit for ClusterIP traffic, so we retain cluster for internal traffic policy.
+		if svc.Annotations[svcLocalAnnotation] == "true" {
+			intTrafficPolicyLocal := v1.ServiceInternalTrafficPolicyCluster
+			extTrafficPolicyLocal := v1.ServiceExternalTrafficPolicyLocal
+			svcInfo.intTrafficPolicy = &intTrafficPolicyLocal
+			svcInfo.extTrafficPolicy = &extTrafficPolicyLocal
+		}
 
-			if svcInfo.sessionAffinity {
-				// Kube-apiserver side guarantees SessionAffinityConfig won't be nil when session affinity
-				// type is ClientIP
-				// https://github.com/kubernetes/kubernetes/blob/master/pkg/apis/core/v1/defaults.go#L106
-				svcInfo.sessionAffinityTimeoutSeconds = *svc.Spec.SessionAffinityConfig.ClientIP.TimeoutSeconds
-			}
-			_, svcInfo.hairpin = svc.Annotations[svcHairpinAnnotation]
-			_, svcInfo.hairpinExternalIPs = svc.Annotations[svcHairpinExternalIPsAnnotation]
-			_, svcInfo.skipLbIps = svc.Annotations[svcSkipLbIpsAnnotation]
-			svcInfo.intTrafficPolicy = svc.Spec.InternalTrafficPolicy
-			svcInfo.extTrafficPolicy = &svc.Spec.ExternalTrafficPolicy
-
-			// The kube-router.io/service.local annotation has the ability to override the internal and external traffic
-			// policy that is set in the spec. Previously, when this was active set both to local when the annotation is
-			// true so that previous functionality of the annotation is best preserved. However, this has proved to not
-			// be a good fit for ClusterIP traffic, so we retain cluster for internal traffic policy.
-			if svc.Annotations[svcLocalAnnotation] == "true" {
-				intTrafficPolicyLocal := v1.ServiceInternalTrafficPolicyCluster
-				extTrafficPolicyLocal := v1.ServiceExternalTrafficPolicyLocal
-				svcInfo.intTrafficPolicy = &intTrafficPolicyLocal
-				svcInfo.extTrafficPolicy = &extTrafficPolicyLocal
-			}
+		svcID := generateServiceID(svc.Namespace, svc.Name, port.Name)
+		serviceMap[svcID] = &svcInfo
+	}
+}
 
-			svcID := generateServiceID(svc.Namespace, svc.Name, port.Name)
-			serviceMap[svcID] = &svcInfo
+// parseLoadBalancerSourceRanges returns the normalized CIDRs of the service's loadBalancerSourceRanges, invalid CIDRs
+// are logged and skipped
+func parseLoadBalancerSourceRanges(svc *v1.Service) []string {
+	if len(svc.Spec.LoadBalancerSourceRanges) == 0 {
+		return nil
+	}
+
+	sourceRanges := make([]string, 0, len(svc.Spec.LoadBalancerSourceRanges))
+	for _, sourceRange := range svc.Spec.LoadBalancerSourceRanges {
+		_, ipNet, err := net.ParseCIDR(strings.TrimSpace(sourceRange))
+		if err != nil {
+			klog.Warningf("ignoring invalid loadBalancerSourceRange %q of service %s/%s: %v", sourceRange,
+				svc.Namespace, svc.Name, err)
+			continue
 		}
+		sourceRanges = append(sourceRanges, ipNet.String())
 	}
-	return serviceMap
+	return sourceRanges
 }
 
 func parseSchedFlags(value string) schedFlags {
@@ -1037,6 +1286,66 @@ func parseSchedFlags(value string) schedFlags {
 	return schedFlags{flag1, flag2, flag3}
 }
 
+// endpointWeight returns the IPVS weight of the endpoint from the kube-router.io/endpoint.weight annotation of the pod
+// that backs it. Endpoints that aren't backed by a pod or whose pod doesn't have a valid weight get the default weight.
+func (nsc *NetworkServicesController) endpointWeight(namespace string, ep discovery.Endpoint) int {
+	if ep.TargetRef == nil || ep.TargetRef.Kind != "Pod" || nsc.podLister == nil {
+		return defaultEndpointWeight
+	}
+	if ep.TargetRef.Namespace != "" {
+		namespace = ep.TargetRef.Namespace
+	}
+	obj, exists, err := nsc.podLister.GetByKey(namespace + "/" + ep.TargetRef.Name)
+	if err != nil || !exists {
+		return defaultEndpointWeight
+	}
+	pod, ok := obj.(*v1.Pod)
+	if !ok {
+		return defaultEndpointWeight
+	}
+	value, ok := pod.Annotations[endpointWeightAnnotation]
+	if !ok {
+		return defaultEndpointWeight
+	}
+	weight, err := parseEndpointWeight(value)
+	if err != nil {
+		klog.Warningf("Ignoring the %s annotation of pod %s/%s: %v", endpointWeightAnnotation, pod.Namespace,
+			pod.Name, err)
+		return defaultEndpointWeight
+	}
+	return weight
+}
+
+// enqueuePodServicesSync queues an incremental sync of the services that have an endpoint backed by the pod, so that
+// the weight of the pod's endpoints is updated
+func (nsc *NetworkServicesController) enqueuePodServicesSync(pod *v1.Pod) {
+	for _, obj := range nsc.epSliceLister.List() {
+		es, ok := obj.(*discovery.EndpointSlice)
+		if !ok || es.Namespace != pod.Namespace || es.Labels[discovery.LabelServiceName] == "" {
+			continue
+		}
+		for _, ep := range es.Endpoints {
+			if ep.TargetRef != nil && ep.TargetRef.Kind == "Pod" && ep.TargetRef.Name == pod.Name {
+				nsc.enqueueServiceSync(es.Namespace, es.Labels[discovery.LabelServiceName])
+				break
+			}
+		}
+	}
+}
+
+// parseEndpointWeight parses the value of the kube-router.io/endpoint.weight annotation. A weight of 0 is allowed so
+// that an endpoint can be drained, as IPVS doesn't send new connections to destinations with a weight of 0.
+func parseEndpointWeight(value string) (int, error) {
+	weight, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("weight %q is not a number", value)
+	}
+	if weight < 0 || weight > maxEndpointWeight {
+		return 0, fmt.Errorf("weight %d is not between 0 and %d", weight, maxEndpointWeight)
+	}
+	return weight, nil
+}
+
 func shuffle(endPoints []endpointSliceInfo) []endpointSliceInfo {
 	for index1 := range endPoints {
 		randBitInt, err := rand.Int(rand.Reader, big.NewInt(int64(index1+1)))
@@ -1053,91 +1362,113 @@ func shuffle(endPoints []endpointSliceInfo) []endpointSliceInfo {
 func (nsc *NetworkServicesController) buildEndpointSliceInfo() endpointSliceInfoMap {
 	endpointsMap := make(endpointSliceInfoMap)
 	for _, obj := range nsc.epSliceLister.List() {
-		var isIPv4, isIPv6 bool
-		es := obj.(*discovery.EndpointSlice)
-		klog.V(2).Infof("Building endpoint info for EndpointSlice: %s/%s", es.Namespace, es.Name)
-		switch es.AddressType {
-		case discovery.AddressTypeIPv4:
-			isIPv4 = true
-		case discovery.AddressTypeIPv6:
-			isIPv6 = true
-		case discovery.AddressTypeFQDN:
-			// At this point we don't handle FQDN type EndpointSlices, at some point in the future this might change
-			continue
-		default:
-			// If at some point k8s adds more AddressTypes, we'd prefer to handle them manually to ensure consistent
-			// functionality within kube-router
-			continue
-		}
+		nsc.buildEndpointSliceInfoForSlice(obj.(*discovery.EndpointSlice), endpointsMap)
+	}
+	return endpointsMap
+}
 
-		// In order to properly link the endpoint with the service, we need the service's name
-		svcName, err := utils.ServiceNameforEndpointSlice(es)
-		if err != nil {
-			klog.Errorf("unable to lookup service from EndpointSlice, skipping: %v", err)
+// buildEndpointSliceInfoForSlice adds the endpoints of the EndpointSlice to endpointsMap, endpoints of the same service
+// port from other EndpointSlices that are already in endpointsMap are kept
+func (nsc *NetworkServicesController) buildEndpointSliceInfoForSlice(es *discovery.EndpointSlice,
+	endpointsMap endpointSliceInfoMap) {
+	var isIPv4, isIPv6 bool
+	klog.V(2).Infof("Building endpoint info for EndpointSlice: %s/%s", es.Namespace, es.Name)
+	switch es.AddressType {
+	case discovery.AddressTypeIPv4:
+		isIPv4 = true
+	case discovery.AddressTypeIPv6:
+		isIPv6 = true
+	case discovery.AddressTypeFQDN:
+		// At this point we don't handle FQDN type EndpointSlices, at some point in the future this might change
+		return
+	default:
+		// If at some point k8s adds more AddressTypes, we'd prefer to handle them manually to ensure consistent
+		// functionality within kube-router
+		return
+	}
+
+	// In order to properly link the endpoint with the service, we need the service's name
+	svcName, err := utils.ServiceNameforEndpointSlice(es)
+	if err != nil {
+		klog.Errorf("unable to lookup service from EndpointSlice, skipping: %v", err)
+		return
+	}
+
+	// Keep in mind that ports aren't embedded in Endpoints, but we do need to make an endpointSliceInfo and a svcID
+	// for each pair, so we consume them as an inter and outer loop. Actual structure of EndpointSlice looks like:
+	//
+	// metadata:
+	//	name: ...
+	//	namespace: ...
+	// endpoints:
+	// - addresses:
+	//   - 10.0.0.1
+	//   conditions:
+	//     ready: (true|false)
+	//   nodeName: foo
+	//   targetRef:
+	//     kind: Pod
+	//     name: bar
+	//   zone: z1
+	// ports:
+	//   - name: baz
+	//     port: 8080
+	//     protocol: TCP
+	//
+	for _, ep := range es.Endpoints {
+		// We should only look at serving or ready if we want to be compliant with the upstream expectantions of a
+		// network provider
+		if (ep.Conditions.Serving == nil || !*ep.Conditions.Serving) &&
+			(ep.Conditions.Ready == nil || !*ep.Conditions.Ready) {
+			klog.V(2).Infof("Endpoint (with addresses %s) does not have a ready or serving status, skipping...",
+				ep.Addresses)
 			continue
 		}
 
-		// Keep in mind that ports aren't embedded in Endpoints, but we do need to make an endpointSliceInfo and a svcID
-		// for each pair, so we consume them as an inter and outer loop. Actual structure of EndpointSlice looks like:
-		//
-		// metadata:
-		//	name: ...
-		//	namespace: ...
-		// endpoints:
-		// - addresses:
-		//   - 10.0.0.1
-		//   conditions:
-		//     ready: (true|false)
-		//   nodeName: foo
-		//   targetRef:
-		//     kind: Pod
-		//     name: bar
-		//   zone: z1
-		// ports:
-		//   - name: baz
-		//     port: 8080
-		//     protocol: TCP
-		//
-		for _, ep := range es.Endpoints {
-			// We should only look at serving or ready if we want to be compliant with the upstream expectantions of a
-			// network provider
-			if (ep.Conditions.Serving == nil || !*ep.Conditions.Serving) &&
-				(ep.Conditions.Ready == nil || !*ep.Conditions.Ready) {
-				klog.V(2).Infof("Endpoint (with addresses %s) does not have a ready or serving status, skipping...",
-					ep.Addresses)
-				continue
+		var zone string
+		if ep.Zone != nil {
+			zone = *ep.Zone
+		}
+		var zoneHints []string
+		if ep.Hints != nil {
+			for _, forZone := range ep.Hints.ForZones {
+				zoneHints = append(zoneHints, forZone.Name)
 			}
+		}
+		sort.Strings(zoneHints)
+		weight := nsc.endpointWeight(es.Namespace, ep)
 
-			for _, port := range es.Ports {
-				var endpoints []endpointSliceInfo
-				var ok bool
+		for _, port := range es.Ports {
+			var endpoints []endpointSliceInfo
+			var ok bool
 
-				svcID := generateServiceID(es.Namespace, svcName, *port.Name)
+			svcID := generateServiceID(es.Namespace, svcName, *port.Name)
 
-				// we may have already started to populate endpoints for this service from another EndpointSlice, if so
-				// continue where we left off, otherwise create a new slice
-				if endpoints, ok = endpointsMap[svcID]; !ok {
-					endpoints = make([]endpointSliceInfo, 0)
-				}
+			// we may have already started to populate endpoints for this service from another EndpointSlice, if so
+			// continue where we left off, otherwise create a new slice
+			if endpoints, ok = endpointsMap[svcID]; !ok {
+				endpoints = make([]endpointSliceInfo, 0)
+			}
 
-				for _, addr := range ep.Addresses {
-					isLocal := ep.NodeName != nil && *ep.NodeName == nsc.krNode.GetNodeName()
-					endpoints = append(endpoints, endpointSliceInfo{
-						ip:            addr,
-						port:          int(*port.Port),
-						isLocal:       isLocal,
-						isIPv4:        isIPv4,
-						isIPv6:        isIPv6,
-						isReady:       ep.Conditions.Ready != nil && *ep.Conditions.Ready,
-						isServing:     ep.Conditions.Serving != nil && *ep.Conditions.Serving,
-						isTerminating: ep.Conditions.Terminating != nil && *ep.Conditions.Terminating,
-					})
-				}
-				endpointsMap[svcID] = shuffle(endpoints)
+			for _, addr := range ep.Addresses {
+				isLocal := ep.NodeName != nil && *ep.NodeName == nsc.krNode.GetNodeName()
+				endpoints = append(endpoints, endpointSliceInfo{
+					ip:            addr,
+					port:          int(*port.Port),
+					isLocal:       isLocal,
+					isIPv4:        isIPv4,
+					isIPv6:        isIPv6,
+					isReady:       ep.Conditions.Ready != nil && *ep.Conditions.Ready,
+					isServing:     ep.Conditions.Serving != nil && *ep.Conditions.Serving,
+					isTerminating: ep.Conditions.Terminating != nil && *ep.Conditions.Terminating,
+					zone:          zone,
+					zoneHints:     strings.Join(zoneHints, ","),
+					weight:        weight,
+				})
 			}
+			endpointsMap[svcID] = shuffle(endpoints)
 		}
 	}
-	return endpointsMap
 }
 
 // Add an iptables rule to masquerade outbound IPVS traffic. IPVS nat requires that reverse path traffic
@@ -1259,7 +1590,6 @@ func (nsc *NetworkServicesController) deleteBadMasqueradeIptablesRules() error {
 // it.
 func (nsc *NetworkServicesController) syncHairpinIptablesRules() error {
 	// TODO: Use ipset?
-	// TODO: Log a warning that this will not work without hairpin sysctl set on veth
 
 	// Key is a string that will match iptables.List() rules
 	// Value is a string[] with arguments that iptables transaction functions expect
@@ -1267,82 +1597,13 @@ func (nsc *NetworkServicesController) syncHairpinIptablesRules() error {
 	ipv6RulesNeeded := make(map[string][]string)
 
 	// Generate the rules that we need
-	for svcName, svcInfo := range nsc.serviceMap {
-		if nsc.globalHairpin || svcInfo.hairpin {
-			// If this service doesn't have any active & local endpoints on this node, then skip it as only local
-			// endpoints matter for hairpinning
-			if !hasActiveEndpoints(nsc.endpointsMap[svcName]) {
-				continue
-			}
-
-			clusterIPs := getAllClusterIPs(svcInfo)
-			externalIPs := getAllExternalIPs(svcInfo, false)
-
-			for _, ep := range nsc.endpointsMap[svcName] {
-				var familyClusterIPs []net.IP
-				var familyExternalIPs []net.IP
-				var familyNodeIPs []net.IP
-				var family v1.IPFamily
-				var rulesMap map[string][]string
-
-				// If this specific endpoint is not local, then skip it as only local endpoints matter for hairpinning
-				if !ep.isLocal {
-					continue
-				}
-
-				// Get the IP family from the endpoint and match it to an existing Cluster IP family slice and do some
-				// basic sanity checking
-				epIP := net.ParseIP(ep.ip)
-				if epIP == nil {
-					klog.Warningf("found a nil IP in our internal structures for service %s, this shouldn't happen",
-						svcName)
-					continue
-				}
-				if epIP.To4() != nil {
-					family = v1.IPv4Protocol
-					familyClusterIPs = clusterIPs[v1.IPv4Protocol]
-					familyExternalIPs = externalIPs[v1.IPv4Protocol]
-					familyNodeIPs = nsc.krNode.GetNodeIPv4Addrs()
-					rulesMap = ipv4RulesNeeded
-				} else {
-					family = v1.IPv6Protocol
-					familyClusterIPs = clusterIPs[v1.IPv6Protocol]
-					familyExternalIPs = externalIPs[v1.IPv6Protocol]
-					familyNodeIPs = nsc.krNode.GetNodeIPv6Addrs()
-					rulesMap = ipv6RulesNeeded
-				}
-				if len(familyClusterIPs) < 1 {
-					klog.Infof("service %s - endpoint %s didn't have any IPs that matched it's IP family, skipping",
-						svcName, epIP)
-					continue
-				}
-
-				// Ensure that hairpin mode is enabled for the virtual interface assigned to the pod behind the endpoint
-				// IP.
-				//
-				// This used to be handled by the kubelet, and then later the functionality was moved to the docker-shim
-				// but now the docker-shim has been removed, and its possible that it never existed for containerd or
-				// cri-o so we now ensure that it is handled.
-				//
-				// Without this change, the return traffic from a client to a service within the same pod will never
-				// make it back into the pod's namespace
-				if nsc.hpc != nil {
-					nsc.hpEndpointReceiver <- ep.ip
-				}
-
-				// Handle ClusterIP Service
-				hairpinRuleFrom(familyClusterIPs, ep.ip, family, svcInfo.port, rulesMap)
-
-				// Handle ExternalIPs if requested
-				if svcInfo.hairpinExternalIPs {
-					hairpinRuleFrom(familyExternalIPs, ep.ip, family, svcInfo.port, rulesMap)
-				}
-
-				// Handle NodePort Service
-				if svcInfo.nodePort != 0 {
-					hairpinRuleFrom(familyNodeIPs, ep.ip, family, svcInfo.nodePort, rulesMap)
-				}
-			}
+	for _, rule := range nsc.getHairpinRules() {
+		//nolint:exhaustive // we don't need exhaustive searching for IP Families
+		switch rule.family {
+		case v1.IPv4Protocol:
+			hairpinRuleFrom(rule.serviceIPs, rule.endpointIP, rule.family, rule.servicePort, ipv4RulesNeeded)
+		case v1.IPv6Protocol:
+			hairpinRuleFrom(rule.serviceIPs, rule.endpointIP, rule.family, rule.servicePort, ipv6RulesNeeded)
 		}
 	}
 
@@ -1435,6 +1696,94 @@ func (nsc *NetworkServicesController) syncHairpinIptablesRules() error {
 	return nil
 }
 
+// getHairpinRules returns the hairpin rules needed for all local endpoints of services that have hairpin mode
+// enabled either globally via CLI argument or via a service annotation
+func (nsc *NetworkServicesController) getHairpinRules() []hairpinRule {
+	// TODO: Log a warning that this will not work without hairpin sysctl set on veth
+	var rules []hairpinRule
+
+	for svcName, svcInfo := range nsc.serviceMap {
+		if nsc.globalHairpin || svcInfo.hairpin {
+			// If this service doesn't have any active & local endpoints on this node, then skip it as only local
+			// endpoints matter for hairpinning
+			if !hasActiveEndpoints(nsc.endpointsMap[svcName]) {
+				continue
+			}
+
+			clusterIPs := getAllClusterIPs(svcInfo)
+			externalIPs := getAllExternalIPs(svcInfo, false)
+
+			for _, ep := range nsc.endpointsMap[svcName] {
+				var familyClusterIPs []net.IP
+				var familyExternalIPs []net.IP
+				var familyNodeIPs []net.IP
+				var family v1.IPFamily
+
+				// If this specific endpoint is not local, then skip it as only local endpoints matter for hairpinning
+				if !ep.isLocal {
+					continue
+				}
+
+				// Get the IP family from the endpoint and match it to an existing Cluster IP family slice and do some
+				// basic sanity checking
+				epIP := net.ParseIP(ep.ip)
+				if epIP == nil {
+					klog.Warningf("found a nil IP in our internal structures for service %s, this shouldn't happen",
+						svcName)
+					continue
+				}
+				if epIP.To4() != nil {
+					family = v1.IPv4Protocol
+					familyClusterIPs = clusterIPs[v1.IPv4Protocol]
+					familyExternalIPs = externalIPs[v1.IPv4Protocol]
+					familyNodeIPs = nsc.krNode.GetNodeIPv4Addrs()
+				} else {
+					family = v1.IPv6Protocol
+					familyClusterIPs = clusterIPs[v1.IPv6Protocol]
+					familyExternalIPs = externalIPs[v1.IPv6Protocol]
+					familyNodeIPs = nsc.krNode.GetNodeIPv6Addrs()
+				}
+				if len(familyClusterIPs) < 1 {
+					klog.Infof("service %s - endpoint %s didn't have any IPs that matched it's IP family, skipping",
+						svcName, epIP)
+					continue
+				}
+
+				// Ensure that hairpin mode is enabled for the virtual interface assigned to the pod behind the endpoint
+				// IP.
+				//
+				// This used to be handled by the kubelet, and then later the functionality was moved to the docker-shim
+				// but now the docker-shim has been removed, and its possible that it never existed for containerd or
+				// cri-o so we now ensure that it is handled.
+				//
+				// Without this change, the return traffic from a client to a service within the same pod will never
+				// make it back into the pod's namespace
+				if nsc.hpc != nil {
+					nsc.hpEndpointReceiver <- ep.ip
+				}
+
+				// Handle ClusterIP Service
+				rules = append(rules, hairpinRule{family: family, endpointIP: ep.ip, serviceIPs: familyClusterIPs,
+					servicePort: svcInfo.port})
+
+				// Handle ExternalIPs if requested
+				if svcInfo.hairpinExternalIPs {
+					rules = append(rules, hairpinRule{family: family, endpointIP: ep.ip,
+						serviceIPs: familyExternalIPs, servicePort: svcInfo.port})
+				}
+
+				// Handle NodePort Service
+				if svcInfo.nodePort != 0 {
+					rules = append(rules, hairpinRule{family: family, endpointIP: ep.ip, serviceIPs: familyNodeIPs,
+						servicePort: svcInfo.nodePort})
+				}
+			}
+		}
+	}
+
+	return rules
+}
+
 func (nsc *NetworkServicesController) deleteHairpinIptablesRules(family v1.IPFamily) error {
 	iptablesCmdHandler := nsc.iptablesCmdHandlers[family]
 
@@ -1777,6 +2126,14 @@ func generateEndpointID(ip, port string) string {
 func (nsc *NetworkServicesController) Cleanup() {
 	klog.Infof("Cleaning up NetworkServiceController configurations...")
 
+	// Remove the kube-router table in case the nftables firewall backend was used, nodes that never had nft installed
+	// can't have the table so a missing binary isn't an error here
+	if nftablesHandler, err := utils.NewNFTables(); err == nil {
+		if err = nftablesHandler.DeleteTable(utils.NFTablesFamilyInet, utils.KubeRouterNFTable); err != nil {
+			klog.Errorf("error encountered attempting to delete nftables table %s: %v", utils.KubeRouterNFTable, err)
+		}
+	}
+
 	// cleanup ipvs rules by flush
 	handle, err := ipvs.New("")
 	if err != nil {
@@ -1826,6 +2183,15 @@ func (nsc *NetworkServicesController) Cleanup() {
 		}
 	}
 
+	// cleanup iptables hostPort rules
+	for family, iptablesCmdHandler := range nsc.iptablesCmdHandlers {
+		err = deleteHostPortIptablesRules(iptablesCmdHandler, family)
+		if err != nil {
+			klog.Errorf("Failed to cleanup iptables hostPort rules: %s", err.Error())
+			return
+		}
+	}
+
 	nsc.cleanupIpvsFirewall()
 
 	// delete dummy interface used to assign cluster IP's
@@ -1998,7 +2364,7 @@ func (nsc *NetworkServicesController) setupHandlers(node *v1.Node) error {
 func NewNetworkServicesController(clientset kubernetes.Interface,
 	config *options.KubeRouterConfig, svcInformer cache.SharedIndexInformer,
 	epSliceInformer cache.SharedIndexInformer, podInformer cache.SharedIndexInformer,
-	ipsetMutex *sync.Mutex) (*NetworkServicesController, error) {
+	ipsetMutex *sync.Mutex, nftTable *utils.NFTablesSharedTable) (*NetworkServicesController, error) {
 
 	var err error
 	ln, err := newLinuxNetworking(config.ServiceTCPTimeout, config.ServiceTCPFinTimeout, config.ServiceUDPTimeout)
@@ -2013,6 +2379,8 @@ func NewNetworkServicesController(clientset kubernetes.Interface,
 		// Register the metrics for this controller
 		metrics.DefaultRegisterer.MustRegister(metrics.ControllerIpvsServices)
 		metrics.DefaultRegisterer.MustRegister(metrics.ControllerIpvsServicesSyncTime)
+		metrics.DefaultRegisterer.MustRegister(metrics.ControllerIpvsServicesIncrementalSyncTime)
+		metrics.DefaultRegisterer.MustRegister(metrics.ControllerIpvsConntrackEntriesRemoved)
 		metrics.DefaultRegisterer.MustRegister(metrics.ServiceBpsIn)
 		metrics.DefaultRegisterer.MustRegister(metrics.ServiceBpsOut)
 		metrics.DefaultRegisterer.MustRegister(metrics.ServiceBytesIn)
@@ -2023,6 +2391,8 @@ func NewNetworkServicesController(clientset kubernetes.Interface,
 		metrics.DefaultRegisterer.MustRegister(metrics.ServicePpsIn)
 		metrics.DefaultRegisterer.MustRegister(metrics.ServicePpsOut)
 		metrics.DefaultRegisterer.MustRegister(metrics.ServiceTotalConn)
+		metrics.DefaultRegisterer.MustRegister(metrics.ServiceEndpointProbeHealthy)
+		metrics.DefaultRegisterer.MustRegister(metrics.ServiceEndpointProbeFailures)
 		nsc.MetricsEnabled = true
 	}
 
@@ -2033,9 +2403,24 @@ func NewNetworkServicesController(clientset kubernetes.Interface,
 	nsc.globalHairpin = config.GlobalHairpinMode
 
 	nsc.serviceMap = make(serviceInfoMap)
+	nsc.serviceQueue = workqueue.NewTyped[string]()
 	nsc.endpointsMap = make(endpointSliceInfoMap)
 	nsc.client = clientset
 
+	switch config.FirewallBackend {
+	case options.FirewallBackendIPTables, "":
+		nsc.ruleRenderer = &iptablesRuleRenderer{nsc: &nsc}
+	case options.FirewallBackendNFTables:
+		if nftTable == nil {
+			return nil, fmt.Errorf("--firewall-backend=%s requires the shared kube-router nftables table",
+				config.FirewallBackend)
+		}
+		nsc.ruleRenderer = newNFTablesRuleRenderer(&nsc, nftTable)
+	default:
+		return nil, fmt.Errorf("failed to parse --firewall-backend parameter: '%s' is not one of %s or %s",
+			config.FirewallBackend, options.FirewallBackendIPTables, options.FirewallBackendNFTables)
+	}
+
 	nsc.ProxyFirewallSetup = sync.NewCond(&sync.Mutex{})
 	nsc.dsr = &dsrOpt{runtimeEndpoint: config.RuntimeEndpoint}
 
@@ -2048,6 +2433,11 @@ func NewNetworkServicesController(clientset kubernetes.Interface,
 		nsc.nodeportBindOnAllIP = true
 	}
 
+	nsc.nodePortCIDRs, nsc.nodePortInterfaces, err = parseNodePortAddresses(config.NodePortAddresses)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse --nodeport-addresses parameter: %v", err)
+	}
+
 	if config.RunRouter {
 		node, err := utils.GetNodeObject(nsc.client, config.HostnameOverride)
 		if err != nil {
@@ -2084,6 +2474,8 @@ func NewNetworkServicesController(clientset kubernetes.Interface,
 	if err != nil {
 		return nil, err
 	}
+	nsc.nodeZone = node.Labels[v1.LabelTopologyZone]
+	nsc.NodeEventHandler = nsc.newNodeEventHandler()
 
 	// This function is responsible for quite a bit:
 	// * Sets nsc.nodeIPv4Addrs & nsc.isIPv4Capable
@@ -2106,6 +2498,9 @@ func NewNetworkServicesController(clientset kubernetes.Interface,
 	nsc.dsrTCPMSS = automtu - utils.IPInIPHeaderLength*3
 
 	nsc.podLister = podInformer.GetIndexer()
+	nsc.hostPortEnabled = config.EnableHostPort
+	nsc.hostPortRules = make(map[v1.IPFamily]hostPortIptablesRules)
+	nsc.PodEventHandler = nsc.newPodEventHandler()
 
 	nsc.svcLister = svcInformer.GetIndexer()
 	nsc.ServiceEventHandler = nsc.newSvcEventHandler()
@@ -2123,6 +2518,8 @@ func NewNetworkServicesController(clientset kubernetes.Interface,
 	// nsc.hpc = NewHairpinController(&nsc, nsc.hpEndpointReceiver)
 
 	nsc.nphc = NewNodePortHealthCheck()
+	nsc.conntrack = &netlink.Handle{}
+	nsc.epc = NewEndpointProbeController(func() { nsc.sync(synctypeIpvs) }, nsc.MetricsEnabled)
 
 	return &nsc, nil
 }
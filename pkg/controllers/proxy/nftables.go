@@ -0,0 +1,386 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cloudnativelabs/kube-router/v2/pkg/utils"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// nftTableOwner identifies the part of the shared kube-router table that holds the rules of the NSC
+	nftTableOwner = "service-proxy"
+
+	// the base chains are prefixed so that they don't collide with the chains of the network policy controller
+	nftInputChainName       = "KUBE-ROUTER-PROXY-INPUT"
+	nftPostroutingChainName = "KUBE-ROUTER-PROXY-POSTROUTING"
+	nftPreroutingChainName  = "KUBE-ROUTER-PROXY-PREROUTING"
+	nftOutputChainName      = "KUBE-ROUTER-PROXY-OUTPUT"
+	// the hostPort DNAT needs nat base chains next to the filter and route chains of the same hooks
+	nftPreroutingDNATChainName = "KUBE-ROUTER-PROXY-PREROUTING-DNAT"
+	nftOutputDNATChainName     = "KUBE-ROUTER-PROXY-OUTPUT-DNAT"
+)
+
+// nftDSRRule holds the parameters of a setupMangleTableRule call so that the FW mark rules of all DSR services can be
+// rendered into the table on every apply
+type nftDSRRule struct {
+	ip       string
+	protocol string
+	port     string
+	fwmark   string
+	tcpMSS   int
+}
+
+// nftablesRuleRenderer is the proxyRuleRenderer for the nftables firewall backend. Instead of modifying rules in the
+// shared iptables tables one at a time, it keeps the state of all of the NSC's rules and renders them into its part
+// of the kube-router nft table, which is atomically replaced whenever that state changes.
+//
+// nftables has no equivalent of the iptables ipvs match, so traffic that IPVS forwarded to an endpoint is identified
+// by its conntrack entry instead: the original destination is a service IP, while the current destination isn't.
+type nftablesRuleRenderer struct {
+	nsc   *NetworkServicesController
+	table *utils.NFTablesSharedTable
+
+	mu           sync.Mutex
+	localIPs     map[v1.IPFamily][]net.IP
+	serviceAddrs map[v1.IPFamily][]serviceAddr
+	sourceRanges map[v1.IPFamily][]serviceSourceRanges
+	hairpinRules []hairpinRule
+	hostPorts    []hostPortMapping
+	dsrRules     map[string]nftDSRRule
+	lastApplied  []byte
+}
+
+func newNFTablesRuleRenderer(nsc *NetworkServicesController, table *utils.NFTablesSharedTable) *nftablesRuleRenderer {
+	return &nftablesRuleRenderer{
+		nsc:          nsc,
+		table:        table,
+		localIPs:     make(map[v1.IPFamily][]net.IP),
+		serviceAddrs: make(map[v1.IPFamily][]serviceAddr),
+		sourceRanges: make(map[v1.IPFamily][]serviceSourceRanges),
+		dsrRules:     make(map[string]nftDSRRule),
+	}
+}
+
+// cleanupStaleRules is a no-op for nftables as the whole part of the table is replaced on every apply
+func (r *nftablesRuleRenderer) cleanupStaleRules() error {
+	return nil
+}
+
+func (r *nftablesRuleRenderer) setupIpvsFirewall() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.apply()
+}
+
+func (r *nftablesRuleRenderer) syncIpvsFirewall() error {
+	localIPs, err := r.nsc.getFirewallLocalIPs()
+	if err != nil {
+		return err
+	}
+	serviceAddrs, err := r.nsc.getFirewallServiceAddrs()
+	if err != nil {
+		return err
+	}
+	sourceRanges := r.nsc.getFirewallSourceRanges()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.localIPs = localIPs
+	r.serviceAddrs = serviceAddrs
+	r.sourceRanges = sourceRanges
+	return r.apply()
+}
+
+func (r *nftablesRuleRenderer) ensureMasqueradeRules() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.apply()
+}
+
+func (r *nftablesRuleRenderer) syncHairpinRules() error {
+	hairpinRules := r.nsc.getHairpinRules()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hairpinRules = hairpinRules
+	return r.apply()
+}
+
+func (r *nftablesRuleRenderer) syncHostPortRules() error {
+	hostPorts := r.nsc.getHostPortMappings()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hostPorts = hostPorts
+	return r.apply()
+}
+
+func (r *nftablesRuleRenderer) setupMangleTableRule(ip string, protocol string, port string, fwmark string,
+	tcpMSS int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dsrRules[generateIPPortID(ip, protocol, port)] = nftDSRRule{ip: ip, protocol: protocol, port: port,
+		fwmark: fwmark, tcpMSS: tcpMSS}
+	return r.apply()
+}
+
+func (r *nftablesRuleRenderer) cleanupDSRRules(ip string, protocol string, port int, _ uint32) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.dsrRules, generateIPPortID(ip, protocol, strconv.Itoa(port)))
+	return r.apply()
+}
+
+// apply renders the NSC's part of the table and loads it, unless it is identical to the part that was loaded last.
+// Callers must hold r.mu.
+func (r *nftablesRuleRenderer) apply() error {
+	table := r.render()
+	var buf bytes.Buffer
+	table.Render(&buf)
+	if bytes.Equal(buf.Bytes(), r.lastApplied) {
+		klog.V(2).Infof("nftables rules of the service proxy are unchanged, skipping apply")
+		return nil
+	}
+
+	if err := r.table.Apply(nftTableOwner, table); err != nil {
+		return err
+	}
+	r.lastApplied = buf.Bytes()
+	klog.V(2).Infof("Successfully synced nftables rules of the service proxy")
+	return nil
+}
+
+// render builds the NSC's part of the kube-router table out of the current state
+func (r *nftablesRuleRenderer) render() *utils.NFTablesTable {
+	table := utils.NewNFTablesTable(utils.NFTablesFamilyInet, utils.KubeRouterNFTable)
+	input := table.BaseChain(nftInputChainName, "filter", "input", "filter")
+	postrouting := table.BaseChain(nftPostroutingChainName, "nat", "postrouting", "srcnat")
+	prerouting := table.BaseChain(nftPreroutingChainName, "filter", "prerouting", "mangle")
+	// a route chain is needed in output so that the packets are re-routed after their mark was changed
+	output := table.BaseChain(nftOutputChainName, "route", "output", "mangle")
+
+	families := make([]v1.IPFamily, 0, 2)
+	if r.nsc.krNode.IsIPv4Capable() {
+		families = append(families, v1.IPv4Protocol)
+	}
+	if r.nsc.krNode.IsIPv6Capable() {
+		families = append(families, v1.IPv6Protocol)
+	}
+
+	for _, family := range families {
+		r.renderServiceSets(table, family)
+		r.renderIpvsFirewall(table, input, family)
+		r.renderMasqueradeRules(postrouting, family)
+	}
+
+	// hairpin rules are evaluated after the masquerade rules, just like the jump to the iptables hairpin chain is
+	// appended after the masquerade rules to POSTROUTING
+	r.renderHairpinRules(table, postrouting)
+	r.renderHostPortRules(table, postrouting)
+	r.renderDSRRules(prerouting, output)
+
+	return table
+}
+
+// renderServiceSets adds the sets of local addresses, service IPs and service IP/protocol/port tuples that the rules
+// of the given family match against
+func (r *nftablesRuleRenderer) renderServiceSets(table *utils.NFTablesTable, family v1.IPFamily) {
+	addrType := utils.NFTablesAddrType(family)
+
+	localIPs := make([]string, 0, len(r.localIPs[family]))
+	for _, ip := range r.localIPs[family] {
+		localIPs = append(localIPs, ip.String())
+	}
+	table.AddSet(getNFTSetName(localIPsIPSetName, family), addrType, false, localIPs)
+
+	serviceIPs := make([]string, 0, len(r.serviceAddrs[family]))
+	serviceIPPorts := make([]string, 0, len(r.serviceAddrs[family]))
+	for _, addr := range r.serviceAddrs[family] {
+		serviceIPs = append(serviceIPs, addr.address.String())
+		serviceIPPorts = append(serviceIPPorts, fmt.Sprintf("%s . %s . %d", addr.address, addr.protocol, addr.port))
+	}
+	table.AddSet(getNFTSetName(serviceIPsIPSetName, family), addrType, false, serviceIPs)
+	table.AddSet(getNFTSetName(serviceIPPortsSetName, family), addrType+" . inet_proto . inet_service", false,
+		serviceIPPorts)
+}
+
+// renderIpvsFirewall is the nftables equivalent of setupIpvsFirewall
+func (r *nftablesRuleRenderer) renderIpvsFirewall(table *utils.NFTablesTable, input *utils.NFTablesChain,
+	family v1.IPFamily) {
+	addrFamily := utils.NFTablesAddrFamily(family)
+	chain := table.Chain(ipvsFirewallChainName)
+
+	// Services with loadBalancerSourceRanges only permit traffic to their external and LoadBalancer IPs from within
+	// those ranges, this is enforced regardless of config.IpvsPermitAll
+	for _, svcRanges := range r.sourceRanges[family] {
+		rule := []string{addrFamily, "daddr", svcRanges.addr.address.String(), "meta l4proto", svcRanges.addr.protocol,
+			"th dport", strconv.Itoa(svcRanges.addr.port)}
+		if len(svcRanges.sourceRanges) > 0 {
+			rule = append(rule, addrFamily, "saddr != {", strings.Join(svcRanges.sourceRanges, ", "), "}")
+		}
+		rule = append(rule, "reject with icmpx type port-unreachable",
+			utils.NFTablesComment("reject traffic to service IPs from outside of their loadBalancerSourceRanges"))
+		chain.Append(rule...)
+	}
+
+	// config.IpvsPermitAll: true then create INPUT/KUBE-ROUTER-SERVICE Chain rules
+	if r.nsc.ipvsPermitAll {
+		for _, icmpRule := range utils.CommonICMPRules(family) {
+			chain.Append(utils.NFTablesICMPMatch(icmpRule), "accept", utils.NFTablesComment(icmpRule.Comment))
+		}
+
+		chain.Append(addrFamily, "daddr . meta l4proto . th dport", "@"+getNFTSetName(serviceIPPortsSetName, family),
+			"accept", utils.NFTablesComment("allow input traffic to ipvs services"))
+
+		// We exclude the local addresses here as that would otherwise block all traffic to local addresses if any
+		// NodePort service exists.
+		chain.Append(addrFamily, "daddr != @"+getNFTSetName(localIPsIPSetName, family),
+			"reject with icmpx type port-unreachable",
+			utils.NFTablesComment("reject all unexpected traffic to service IPs"))
+	}
+
+	input.Append(addrFamily, "daddr @"+getNFTSetName(serviceIPsIPSetName, family), "jump", ipvsFirewallChainName,
+		utils.NFTablesComment("handle traffic to IPVS service IPs in custom chain"))
+}
+
+// renderMasqueradeRules is the nftables equivalent of ensureMasqueradeIptablesRule
+func (r *nftablesRuleRenderer) renderMasqueradeRules(postrouting *utils.NFTablesChain, family v1.IPFamily) {
+	primaryIP, cidrs := r.nsc.getPrimaryAndCIDRsByFamily(family)
+	// A blank primaryIP here indicates that we are not enabled for this family or that something has gone wrong
+	if primaryIP == "" {
+		return
+	}
+
+	addrFamily := utils.NFTablesAddrFamily(family)
+	serviceIPsSet := "@" + getNFTSetName(serviceIPsIPSetName, family)
+	ipvsMatch := fmt.Sprintf("ct original %s daddr %s %s daddr != %s", addrFamily, serviceIPsSet, addrFamily,
+		serviceIPsSet)
+	snat := fmt.Sprintf("snat %s to %s fully-random", addrFamily, primaryIP)
+
+	if r.nsc.masqueradeAll {
+		postrouting.Append(ipvsMatch, snat, utils.NFTablesComment("masquerade all outbound IPVS traffic"))
+	}
+
+	for _, cidr := range cidrs {
+		postrouting.Append(ipvsMatch, addrFamily, "saddr !=", cidr, addrFamily, "daddr !=", cidr, snat,
+			utils.NFTablesComment("masquerade outbound IPVS traffic from outside of the pod CIDR"))
+	}
+}
+
+// renderHairpinRules is the nftables equivalent of syncHairpinIptablesRules
+func (r *nftablesRuleRenderer) renderHairpinRules(table *utils.NFTablesTable, postrouting *utils.NFTablesChain) {
+	if len(r.hairpinRules) == 0 {
+		return
+	}
+
+	// hairpin rules are generated from maps, sort them so that an unchanged state renders an unchanged table
+	rules := make([]string, 0, len(r.hairpinRules))
+	for _, rule := range r.hairpinRules {
+		addrFamily := utils.NFTablesAddrFamily(rule.family)
+		for _, svcIP := range rule.serviceIPs {
+			rules = append(rules, strings.Join([]string{addrFamily, "saddr", rule.endpointIP, addrFamily, "daddr",
+				rule.endpointIP, "ct original", addrFamily, "daddr", svcIP.String(), "ct original proto-dst",
+				strconv.Itoa(rule.servicePort), "snat", addrFamily, "to", svcIP.String()}, " "))
+		}
+	}
+	sort.Strings(rules)
+
+	chain := table.Chain(ipvsHairpinChainName)
+	for _, rule := range rules {
+		chain.Append(rule)
+	}
+	postrouting.Append("jump", ipvsHairpinChainName)
+}
+
+// renderHostPortRules is the nftables equivalent of syncHostPortIptablesRules, the mappings are already sorted
+func (r *nftablesRuleRenderer) renderHostPortRules(table *utils.NFTablesTable, postrouting *utils.NFTablesChain) {
+	if len(r.hostPorts) == 0 {
+		return
+	}
+
+	dnat := table.Chain(hostPortChainName)
+	masq := table.Chain(hostPortMasqChainName)
+	families := make(map[v1.IPFamily]bool)
+	for _, mapping := range r.hostPorts {
+		families[mapping.family] = true
+		addrFamily := utils.NFTablesAddrFamily(mapping.family)
+		comment := utils.NFTablesComment(fmt.Sprintf("%s hostport %d", mapping.pod, mapping.hostPort))
+		// the addresses of hostPorts that are exposed on all local addresses don't imply the family of the rule
+		match := []string{"meta nfproto ipv4"}
+		if mapping.family == v1.IPv6Protocol {
+			match = []string{"meta nfproto ipv6"}
+		}
+		if mapping.hostIP != "" {
+			match = []string{addrFamily, "daddr", mapping.hostIP}
+		}
+		dnat.Append(append(match, "meta l4proto", mapping.protocol, "th dport", strconv.Itoa(mapping.hostPort),
+			"dnat", addrFamily, "to", net.JoinHostPort(mapping.podIP, strconv.Itoa(mapping.containerPort)),
+			comment)...)
+		masq.Append(addrFamily, "saddr", mapping.podIP, addrFamily, "daddr", mapping.podIP, "meta l4proto",
+			mapping.protocol, "th dport", strconv.Itoa(mapping.containerPort), "ct status dnat masquerade", comment)
+	}
+
+	prerouting := table.BaseChain(nftPreroutingDNATChainName, "nat", "prerouting", "dstnat")
+	output := table.BaseChain(nftOutputDNATChainName, "nat", "output", "dstnat")
+	// locally generated traffic to the loopback addresses can't be routed to the pods, and traffic to the ports of
+	// services is left to IPVS
+	for _, family := range []v1.IPFamily{v1.IPv4Protocol, v1.IPv6Protocol} {
+		if !families[family] {
+			continue
+		}
+		addrFamily := utils.NFTablesAddrFamily(family)
+		loopback := "127.0.0.0/8"
+		if family == v1.IPv6Protocol {
+			loopback = "::1"
+		}
+		notServicePort := addrFamily + " daddr . meta l4proto . th dport != @" +
+			getNFTSetName(serviceIPPortsSetName, family)
+		prerouting.Append(notServicePort, "fib daddr type local jump", hostPortChainName)
+		output.Append(addrFamily, "daddr !=", loopback, notServicePort, "fib daddr type local jump",
+			hostPortChainName)
+	}
+	postrouting.Append("jump", hostPortMasqChainName)
+}
+
+// renderDSRRules is the nftables equivalent of setupMangleTableRule for all DSR services
+func (r *nftablesRuleRenderer) renderDSRRules(prerouting, output *utils.NFTablesChain) {
+	ids := make([]string, 0, len(r.dsrRules))
+	for id := range r.dsrRules {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		rule := r.dsrRules[id]
+		family := v1.IPv4Protocol
+		if net.ParseIP(rule.ip).To4() == nil {
+			family = v1.IPv6Protocol
+		}
+		addrFamily := utils.NFTablesAddrFamily(family)
+
+		markRule := []string{addrFamily, "daddr", rule.ip, rule.protocol, "dport", rule.port, "meta mark set",
+			rule.fwmark}
+		prerouting.Append(markRule...)
+		output.Append(markRule...)
+
+		// setup TCPMSS rule for DSR mode to fix mtu problem, only reply packets from PODs are altered here
+		if rule.protocol == tcpProtocol {
+			prerouting.Append(addrFamily, "saddr", rule.ip, "iifname \"kube-bridge\"", "tcp sport", rule.port,
+				"tcp flags & (syn | rst) == syn tcp option maxseg size set", strconv.Itoa(rule.tcpMSS))
+		}
+	}
+}
+
+// getNFTSetName formulates the name of an nft set of the NSC in the kube-router table based upon the name of the ipset
+// that the iptables backend uses, the colon of IPv6 ipset names isn't allowed in nft identifiers
+func getNFTSetName(nameBase string, family v1.IPFamily) string {
+	return strings.ReplaceAll(getIPSetName(nameBase, family), ":", "-")
+}
@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/cloudnativelabs/kube-router/v2/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+)
+
+type fakeNFTables struct {
+	scripts []string
+}
+
+func (f *fakeNFTables) Apply(script []byte) error {
+	f.scripts = append(f.scripts, string(script))
+	return nil
+}
+
+func (f *fakeNFTables) TableExists(_, _ string) (bool, error) {
+	return len(f.scripts) > 0, nil
+}
+
+func (f *fakeNFTables) DeleteTable(_, _ string) error {
+	f.scripts = nil
+	return nil
+}
+
+func TestNFTablesRuleRenderer(t *testing.T) {
+	nsc := getMoqNSC()
+	nsc.krNode = &utils.LocalKRNode{
+		KRNode: utils.KRNode{
+			NodeName:      "node-1",
+			PrimaryIP:     net.ParseIP("10.0.0.1"),
+			NodeIPv4Addrs: map[v1.NodeAddressType][]net.IP{v1.NodeInternalIP: {net.ParseIP("10.0.0.1")}},
+		},
+	}
+	nsc.podCidr = "10.1.0.0/24"
+	nsc.ipvsPermitAll = true
+	nft := &fakeNFTables{}
+	r := newNFTablesRuleRenderer(nsc,
+		utils.NewNFTablesSharedTable(nft, utils.NFTablesFamilyInet, utils.KubeRouterNFTable))
+	r.serviceAddrs[v1.IPv4Protocol] = []serviceAddr{{address: net.ParseIP("10.96.0.10"), protocol: "udp", port: 53}}
+	r.sourceRanges[v1.IPv4Protocol] = []serviceSourceRanges{
+		{addr: serviceAddr{address: net.ParseIP("172.16.0.1"), protocol: tcpProtocol, port: 443},
+			sourceRanges: []string{"10.0.0.0/8", "192.0.2.0/24"}},
+		{addr: serviceAddr{address: net.ParseIP("172.16.0.2"), protocol: tcpProtocol, port: 443}},
+	}
+	r.hairpinRules = []hairpinRule{{family: v1.IPv4Protocol, endpointIP: "10.1.0.5",
+		serviceIPs: []net.IP{net.ParseIP("10.96.0.20")}, servicePort: 80}}
+	r.hostPorts = []hostPortMapping{
+		{family: v1.IPv4Protocol, pod: "default/web", hostPort: 8080, protocol: tcpProtocol, podIP: "10.1.0.6",
+			containerPort: 80},
+		{family: v1.IPv4Protocol, pod: "default/dns", hostIP: "10.0.0.1", hostPort: 53, protocol: udpProtocol,
+			podIP: "10.1.0.7", containerPort: 5353},
+	}
+
+	assert.NoError(t, r.setupMangleTableRule("1.1.1.1", tcpProtocol, "443", "1234", 1400))
+	assert.Len(t, nft.scripts, 1)
+	rendered := nft.scripts[0]
+
+	for _, expected := range []string{
+		"table inet " + utils.KubeRouterNFTable + " {",
+		"chain " + nftInputChainName + " {",
+		"elements = { 10.96.0.10 . udp . 53 }",
+		"ip daddr @kube-router-svip jump " + ipvsFirewallChainName,
+		"ip daddr 172.16.0.1 meta l4proto tcp th dport 443 ip saddr != { 10.0.0.0/8, 192.0.2.0/24 } " +
+			"reject with icmpx type port-unreachable",
+		"ip daddr 172.16.0.2 meta l4proto tcp th dport 443 reject with icmpx type port-unreachable",
+		"ip daddr . meta l4proto . th dport @kube-router-svip-prt accept",
+		"ip daddr != @kube-router-local-ips reject with icmpx type port-unreachable",
+		"ct original ip daddr @kube-router-svip ip daddr != @kube-router-svip ip saddr != 10.1.0.0/24 " +
+			"ip daddr != 10.1.0.0/24 snat ip to 10.0.0.1 fully-random",
+		"ip saddr 10.1.0.5 ip daddr 10.1.0.5 ct original ip daddr 10.96.0.20 ct original proto-dst 80 " +
+			"snat ip to 10.96.0.20",
+		"jump " + ipvsHairpinChainName,
+		"meta nfproto ipv4 meta l4proto tcp th dport 8080 dnat ip to 10.1.0.6:80 comment \"default/web hostport 8080\"",
+		"ip daddr 10.0.0.1 meta l4proto udp th dport 53 dnat ip to 10.1.0.7:5353",
+		"ip saddr 10.1.0.6 ip daddr 10.1.0.6 meta l4proto tcp th dport 80 ct status dnat masquerade",
+		"type nat hook prerouting priority dstnat",
+		"ip daddr . meta l4proto . th dport != @kube-router-svip-prt fib daddr type local jump " + hostPortChainName,
+		"ip daddr != 127.0.0.0/8 ip daddr . meta l4proto . th dport != @kube-router-svip-prt fib daddr type local " +
+			"jump " + hostPortChainName,
+		"jump " + hostPortMasqChainName,
+		"ip daddr 1.1.1.1 tcp dport 443 meta mark set 1234",
+		"ip saddr 1.1.1.1 iifname \"kube-bridge\" tcp sport 443 tcp flags & (syn | rst) == syn " +
+			"tcp option maxseg size set 1400",
+	} {
+		if !strings.Contains(rendered, expected) {
+			t.Errorf("expected rendered nftables table to contain %q, got:\n%s", expected, rendered)
+		}
+	}
+	assert.NotContains(t, rendered, "inet6-", "IPv6 sets shouldn't be rendered for an IPv4 only node")
+
+	// nothing changed, so the table should not be loaded again
+	assert.NoError(t, r.ensureMasqueradeRules())
+	assert.Len(t, nft.scripts, 1)
+
+	assert.NoError(t, r.cleanupDSRRules("1.1.1.1", tcpProtocol, 443, 1234))
+	assert.Len(t, nft.scripts, 2)
+	assert.NotContains(t, nft.scripts[1], "meta mark set 1234")
+}
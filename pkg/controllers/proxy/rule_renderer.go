@@ -0,0 +1,63 @@
+package proxy
+
+// proxyRuleRenderer is implemented by the firewall backends (see --firewall-backend) that the NSC uses to program the
+// netfilter rules that accompany its IPVS services: the IPVS firewall, the masquerade and hairpin source NAT rules, the
+// hostPort DNAT rules and the FW mark rules that are needed for DSR services
+type proxyRuleRenderer interface {
+	// cleanupStaleRules removes rules that were created by previous versions of kube-router and are no longer valid
+	cleanupStaleRules() error
+	// setupIpvsFirewall sets up the rules that only permit traffic to service IPs for ports that have IPVS services
+	setupIpvsFirewall() error
+	// syncIpvsFirewall updates the IPVS firewall with the currently active IPVS services and local addresses
+	syncIpvsFirewall() error
+	// ensureMasqueradeRules ensures that the rules which masquerade outbound IPVS traffic exist
+	ensureMasqueradeRules() error
+	// syncHairpinRules adds/removes the rules for traffic from an endpoint to its own service IP
+	syncHairpinRules() error
+	// syncHostPortRules adds/removes the DNAT rules for the hostPorts of the pods that run on the node
+	syncHostPortRules() error
+	// setupMangleTableRule ensures that traffic to the given DSR service is marked with the service's FW mark
+	setupMangleTableRule(ip string, protocol string, port string, fwmark string, tcpMSS int) error
+	// cleanupDSRRules removes the rules that were created by setupMangleTableRule for the given DSR service
+	cleanupDSRRules(ip string, protocol string, port int, fwMark uint32) error
+}
+
+// iptablesRuleRenderer is the proxyRuleRenderer for the iptables firewall backend, it manages rules within the
+// filter, nat and mangle tables and uses ipsets to match service and local addresses
+type iptablesRuleRenderer struct {
+	nsc *NetworkServicesController
+}
+
+func (r *iptablesRuleRenderer) cleanupStaleRules() error {
+	return r.nsc.deleteBadMasqueradeIptablesRules()
+}
+
+func (r *iptablesRuleRenderer) setupIpvsFirewall() error {
+	return r.nsc.setupIpvsFirewall()
+}
+
+func (r *iptablesRuleRenderer) syncIpvsFirewall() error {
+	return r.nsc.syncIpvsFirewall()
+}
+
+func (r *iptablesRuleRenderer) ensureMasqueradeRules() error {
+	return r.nsc.ensureMasqueradeIptablesRule()
+}
+
+func (r *iptablesRuleRenderer) syncHairpinRules() error {
+	return r.nsc.syncHairpinIptablesRules()
+}
+
+func (r *iptablesRuleRenderer) syncHostPortRules() error {
+	return r.nsc.syncHostPortIptablesRules()
+}
+
+func (r *iptablesRuleRenderer) setupMangleTableRule(ip string, protocol string, port string, fwmark string,
+	tcpMSS int) error {
+	return r.nsc.setupMangleTableRule(ip, protocol, port, fwmark, tcpMSS)
+}
+
+func (r *iptablesRuleRenderer) cleanupDSRRules(ip string, protocol string, port int, fwMark uint32) error {
+	r.nsc.cleanupDSRIptablesRules(ip, protocol, port, fwMark)
+	return nil
+}
@@ -39,27 +39,13 @@ func (nsc *NetworkServicesController) syncIpvsServices(serviceInfoMap serviceInf
 	// map to track all active IPVS services and servers that are setup during sync of
 	// cluster IP, nodeport and external IP services
 	activeServiceEndpointMap := make(map[string][]string)
+	nsc.ipvsServiceOwners = make(map[string]string)
 
-	klog.V(1).Info("Syncing ClusterIP Services")
-	err = nsc.setupClusterIPServices(serviceInfoMap, endpointsInfoMap, activeServiceEndpointMap)
-	if err != nil {
-		syncErrors = true
-		klog.Errorf("Error setting up IPVS services for service cluster IP's: %s", err.Error())
-	}
+	klog.V(1).Info("Syncing endpoint probes")
+	nsc.epc.UpdateServicesInfo(serviceInfoMap, endpointsInfoMap)
 
-	klog.V(1).Info("Syncing NodePort Services")
-	err = nsc.setupNodePortServices(serviceInfoMap, endpointsInfoMap, activeServiceEndpointMap)
-	if err != nil {
+	if !nsc.setupIpvsServices(serviceInfoMap, endpointsInfoMap, activeServiceEndpointMap) {
 		syncErrors = true
-		klog.Errorf("Error setting up IPVS services for service nodeport's: %s", err.Error())
-	}
-
-	klog.V(1).Info("Syncing ExternalIP Services")
-	err = nsc.setupExternalIPServices(serviceInfoMap, endpointsInfoMap, activeServiceEndpointMap)
-	if err != nil {
-		syncErrors = true
-		klog.Errorf("Error setting up IPVS services for service external IP's and load balancer IP's: %s",
-			err.Error())
 	}
 
 	klog.V(1).Info("Setting up NodePort Health Checks for LB services")
@@ -77,7 +63,7 @@ func (nsc *NetworkServicesController) syncIpvsServices(serviceInfoMap serviceInf
 	}
 
 	klog.V(1).Info("Cleaning Up Stale VIPs from IPVS")
-	err = nsc.cleanupStaleIPVSConfig(activeServiceEndpointMap)
+	err = nsc.cleanupStaleIPVSConfig(activeServiceEndpointMap, nil)
 	if err != nil {
 		syncErrors = true
 		klog.Errorf("Error cleaning up stale IPVS services and servers: %s", err.Error())
@@ -87,7 +73,7 @@ func (nsc *NetworkServicesController) syncIpvsServices(serviceInfoMap serviceInf
 	nsc.cleanupStaleMetrics(activeServiceEndpointMap)
 
 	klog.V(1).Info("Syncing IPVS Firewall")
-	err = nsc.syncIpvsFirewall()
+	err = nsc.ruleRenderer.syncIpvsFirewall()
 	if err != nil {
 		syncErrors = true
 		klog.Errorf("Error syncing ipvs svc iptables rules to permit traffic to service VIP's: %s", err.Error())
@@ -101,6 +87,9 @@ func (nsc *NetworkServicesController) syncIpvsServices(serviceInfoMap serviceInf
 			"direct server return: %s", err.Error())
 	}
 
+	// keep the active IPVS services around for the incremental syncs of single services
+	nsc.activeServiceEndpointMap = activeServiceEndpointMap
+
 	if syncErrors {
 		klog.V(1).Info("One or more errors encountered during sync of IPVS services and servers " +
 			"to desired state")
@@ -111,6 +100,37 @@ func (nsc *NetworkServicesController) syncIpvsServices(serviceInfoMap serviceInf
 	return nil
 }
 
+// setupIpvsServices sets up the IPVS services of the cluster IPs, NodePorts and external IPs of the given services and
+// adds them to activeServiceEndpointMap, it returns false when errors were encountered
+func (nsc *NetworkServicesController) setupIpvsServices(serviceInfoMap serviceInfoMap,
+	endpointsInfoMap endpointSliceInfoMap, activeServiceEndpointMap map[string][]string) bool {
+	ok := true
+
+	klog.V(1).Info("Syncing ClusterIP Services")
+	err := nsc.setupClusterIPServices(serviceInfoMap, endpointsInfoMap, activeServiceEndpointMap)
+	if err != nil {
+		ok = false
+		klog.Errorf("Error setting up IPVS services for service cluster IP's: %s", err.Error())
+	}
+
+	klog.V(1).Info("Syncing NodePort Services")
+	err = nsc.setupNodePortServices(serviceInfoMap, endpointsInfoMap, activeServiceEndpointMap)
+	if err != nil {
+		ok = false
+		klog.Errorf("Error setting up IPVS services for service nodeport's: %s", err.Error())
+	}
+
+	klog.V(1).Info("Syncing ExternalIP Services")
+	err = nsc.setupExternalIPServices(serviceInfoMap, endpointsInfoMap, activeServiceEndpointMap)
+	if err != nil {
+		ok = false
+		klog.Errorf("Error setting up IPVS services for service external IP's and load balancer IP's: %s",
+			err.Error())
+	}
+
+	return ok
+}
+
 func (nsc *NetworkServicesController) setupClusterIPServices(serviceInfoMap serviceInfoMap,
 	endpointsInfoMap endpointSliceInfoMap, activeServiceEndpointMap map[string][]string) error {
 	ipvsSvcs, err := nsc.ln.ipvsGetServices()
@@ -193,10 +213,22 @@ func (nsc *NetworkServicesController) addIPVSService(ipvsSvcs []*ipvs.Service, s
 
 	svcID := generateIPPortID(vip.String(), svc.protocol, strconv.Itoa(int(port)))
 	svcEndpointMap[svcID] = make([]string, 0)
+	nsc.recordIPVSServiceOwner(svcID, svc)
 
 	return ipvsSvcs, svcID, ipvsService
 }
 
+// endpointIPVSWeight returns the weight of the IPVS destination of the endpoint, which is 0 when the endpoint failed
+// the active health probes of the service so that it doesn't receive new connections
+func (nsc *NetworkServicesController) endpointIPVSWeight(svc *serviceInfo, endpoint endpointSliceInfo) int {
+	if svc.probe.protocol != "" && !nsc.epc.isHealthy(newEndpointProbeTarget(svc, endpoint)) {
+		klog.V(1).Infof("endpoint %s of service %s/%s failed its probes, setting its weight to 0", endpoint.ip,
+			svc.namespace, svc.name)
+		return 0
+	}
+	return endpoint.weight
+}
+
 func (nsc *NetworkServicesController) addEndpointsToIPVSService(endpoints []endpointSliceInfo,
 	svcEndpointMap map[string][]string, svc *serviceInfo, svcID string, ipvsSvc *ipvs.Service, vip net.IP,
 	isClusterIP bool) {
@@ -210,6 +242,14 @@ func (nsc *NetworkServicesController) addEndpointsToIPVSService(endpoints []endp
 	if len(endpoints) < 1 {
 		klog.Infof("No endpoints detected for service VIP: %s, skipping adding endpoints...", vip)
 	}
+
+	// topology aware routing only applies when the traffic may be sent to endpoints on any node
+	if (isClusterIP && (svc.intTrafficPolicy == nil ||
+		*svc.intTrafficPolicy != v1.ServiceInternalTrafficPolicyLocal)) ||
+		(!isClusterIP && (svc.extTrafficPolicy == nil ||
+			*svc.extTrafficPolicy != v1.ServiceExternalTrafficPolicyLocal)) {
+		endpoints = nsc.filterEndpointsForTopology(svc, endpoints, family)
+	}
 	for _, endpoint := range endpoints {
 		// Conditions on which to add an endpoint on this node:
 		// 1) Service is not a local service
@@ -255,7 +295,7 @@ func (nsc *NetworkServicesController) addEndpointsToIPVSService(endpoints []endp
 			Address:       eIP,
 			AddressFamily: syscallINET,
 			Port:          ePort,
-			Weight:        1,
+			Weight:        nsc.endpointIPVSWeight(svc, endpoint),
 		}
 		err = nsc.ln.ipvsAddServer(ipvsSvc, &dst)
 		if err != nil {
@@ -274,6 +314,16 @@ func (nsc *NetworkServicesController) setupNodePortServices(serviceInfoMap servi
 		return errors.New("Failed get list of IPVS services due to: " + err.Error())
 	}
 
+	// NodePorts are created on the primary IP of the node, unless --nodeport-addresses or --nodeport-bindon-all-ip
+	// select other IPs
+	nodePortIPs, err := nsc.getNodePortIPs()
+	if err != nil {
+		return fmt.Errorf("could not get list of node IPs for NodePort services: %v", err)
+	}
+	if len(nodePortIPs[v1.IPv4Protocol]) == 0 && len(nodePortIPs[v1.IPv6Protocol]) == 0 {
+		klog.Warning("No node IPs found to create the IPVS services of NodePorts on")
+	}
+
 	// For each Service in our service map
 	for k, svc := range serviceInfoMap {
 		protocol := convertSvcProtoToSysCallProto(svc.protocol)
@@ -299,51 +349,16 @@ func (nsc *NetworkServicesController) setupNodePortServices(serviceInfoMap servi
 
 		var svcID string
 		var ipvsSvc *ipvs.Service
-		if nsc.nodeportBindOnAllIP {
-			// Bind on all interfaces instead of just the primary interface
-			addrMap, err := getAllLocalIPs()
-			if err != nil {
-				klog.Errorf("Could not get list of system addresses for ipvs services: %s", err.Error())
-				continue
-			}
-
-			// Check that any addrs were actually found
-			addrsFound := false
-			for _, addrs := range addrMap {
-				if len(addrs) > 0 {
-					addrsFound = true
-				}
-				if addrsFound {
-					break
-				}
-			}
-			if !addrsFound {
-				klog.Errorf("No IP addresses returned for nodeport service creation!")
-				continue
-			}
-
-			// Create the services
-			for _, addrs := range addrMap {
-				for _, addr := range addrs {
-
-					ipvsSvcs, svcID, ipvsSvc = nsc.addIPVSService(ipvsSvcs, activeServiceEndpointMap, svc, addr,
-						protocol, nPort)
-					// We weren't able to create the IPVS service, so we won't be able to add endpoints to it
-					if svcID == "" {
-						continue
-					}
-					nsc.addEndpointsToIPVSService(endpoints, activeServiceEndpointMap, svc, svcID, ipvsSvc, addr, false)
+		for _, addrs := range nodePortIPs {
+			for _, addr := range addrs {
+				ipvsSvcs, svcID, ipvsSvc = nsc.addIPVSService(ipvsSvcs, activeServiceEndpointMap, svc, addr,
+					protocol, nPort)
+				// We weren't able to create the IPVS service, so we won't be able to add endpoints to it
+				if svcID == "" {
+					continue
 				}
+				nsc.addEndpointsToIPVSService(endpoints, activeServiceEndpointMap, svc, svcID, ipvsSvc, addr, false)
 			}
-		} else {
-			ipvsSvcs, svcID, ipvsSvc = nsc.addIPVSService(ipvsSvcs, activeServiceEndpointMap, svc,
-				nsc.krNode.GetPrimaryNodeIP(), protocol, nPort)
-			// We weren't able to create the IPVS service, so we won't be able to add endpoints to it
-			if svcID == "" {
-				continue
-			}
-			nsc.addEndpointsToIPVSService(endpoints, activeServiceEndpointMap, svc, svcID, ipvsSvc,
-				nsc.krNode.GetPrimaryNodeIP(), false)
 		}
 	}
 
@@ -542,10 +557,11 @@ func (nsc *NetworkServicesController) setupExternalIPForDSRService(svcIn *servic
 	}
 
 	externalIPServiceID := fmt.Sprint(fwMark)
+	nsc.recordIPVSServiceOwner(externalIPServiceID, svcIn)
 
 	// ensure there is iptables mangle table rule to FWMARK the packet
-	err = nsc.setupMangleTableRule(externalIP.String(), svcIn.protocol, strconv.Itoa(svcIn.port), externalIPServiceID,
-		nsc.dsrTCPMSS)
+	err = nsc.ruleRenderer.setupMangleTableRule(externalIP.String(), svcIn.protocol, strconv.Itoa(svcIn.port),
+		externalIPServiceID, nsc.dsrTCPMSS)
 	if err != nil {
 		return fmt.Errorf("failed to setup mangle table rule to forward the traffic to external IP")
 	}
@@ -605,7 +621,7 @@ func (nsc *NetworkServicesController) setupExternalIPForDSRService(svcIn *servic
 			AddressFamily:   syscallINET,
 			ConnectionFlags: ipvs.ConnectionFlagTunnel,
 			Port:            ePort,
-			Weight:          1,
+			Weight:          nsc.endpointIPVSWeight(svcIn, endpoint),
 		}
 
 		// add the destination for the IPVS service for this external IP
@@ -711,7 +727,10 @@ func (nsc *NetworkServicesController) cleanupStaleVIPs(activeServiceEndpointMap
 	return nil
 }
 
-func (nsc *NetworkServicesController) cleanupStaleIPVSConfig(activeServiceEndpointMap map[string][]string) error {
+// cleanupStaleIPVSConfig deletes the IPVS services that aren't in activeServiceEndpointMap and the destinations that
+// aren't active, only the IPVS services whose keys are in scope are considered unless scope is nil
+func (nsc *NetworkServicesController) cleanupStaleIPVSConfig(activeServiceEndpointMap map[string][]string,
+	scope map[string]bool) error {
 	ipvsSvcs, err := nsc.ln.ipvsGetServices()
 	if err != nil {
 		return errors.New("failed get list of IPVS services due to: " + err.Error())
@@ -725,7 +744,7 @@ func (nsc *NetworkServicesController) cleanupStaleIPVSConfig(activeServiceEndpoi
 	var protocol string
 	for _, ipvsSvc := range ipvsSvcs {
 		// Note that this isn't all that safe of an assumption because FWMark services have a completely different
-		// protocol. So do SCTP services. However, we don't deal with SCTP in kube-router and FWMark is handled below.
+		// protocol. However, FWMark is handled below.
 		protocol = convertSysCallProtoToSvcProto(ipvsSvc.Protocol)
 		// FWMark services by definition don't have a protocol, so we exclude those from the conditional so that they
 		// can be cleaned up correctly.
@@ -744,6 +763,9 @@ func (nsc *NetworkServicesController) cleanupStaleIPVSConfig(activeServiceEndpoi
 		default:
 			continue
 		}
+		if scope != nil && !scope[key] {
+			continue
+		}
 
 		endpointIDs, ok := activeServiceEndpointMap[key]
 		// Only delete the service if it's not there anymore to prevent flapping
@@ -780,6 +802,10 @@ func (nsc *NetworkServicesController) cleanupStaleIPVSConfig(activeServiceEndpoi
 					ipvsServiceString(ipvsSvc), err.Error())
 				continue
 			}
+			// this also covers the IPVS services of NodePorts that were reassigned
+			if err = nsc.deleteConntrackEntries(ipvsSvc, nil); err != nil {
+				klog.Errorf("Failed to delete conntrack entries of stale IPVS service: %v", err)
+			}
 		} else {
 			dsts, err := nsc.ln.ipvsGetDestinations(ipvsSvc)
 			if err != nil {
@@ -817,9 +843,23 @@ func (nsc *NetworkServicesController) cleanupDSRService(fwMark uint32) error {
 			fwMark, err)
 	}
 
+	klog.V(2).Infof("service %s:%s:%d was found, continuing with DSR service cleanup", ipAddress, proto, port)
+	err = nsc.ruleRenderer.cleanupDSRRules(ipAddress, proto, port, fwMark)
+	if err != nil {
+		klog.Errorf("failed to verify and cleanup any mangle table rule to FORWARD the traffic "+
+			"to external IP due to: %v", err)
+	}
+
+	// cleanup the fwMarkMap to ensure that we don't accidentally build state
+	delete(nsc.fwMarkMap, fwMark)
+	return nil
+}
+
+// cleanupDSRIptablesRules looks up the mangle table rules that were created for the DSR service with the given FW
+// mark in iptables-save output and removes them
+func (nsc *NetworkServicesController) cleanupDSRIptablesRules(ipAddress, proto string, port int, fwMark uint32) {
 	// abstract cleanup as anonymous function so that we can reuse it for both IPv4 and IPv6
 	cleanupTables := func(iptablesBinary string) {
-		klog.V(2).Infof("service %s:%s:%d was found, continuing with DSR service cleanup", ipAddress, proto, port)
 		mangleTableRulesDump := bytes.Buffer{}
 		var mangleTableRules []string
 		if err := utils.SaveInto(iptablesBinary, "mangle", &mangleTableRulesDump); err != nil {
@@ -836,7 +876,7 @@ func (nsc *NetworkServicesController) cleanupDSRService(fwMark uint32) error {
 				klog.V(2).Infof("found mangle rule to cleanup: %s", mangleTableRule)
 
 				// When we cleanup the iptables rule, we need to pass FW mark as an int string rather than a hex string
-				err = nsc.cleanupMangleTableRule(ipAddress, proto, strconv.Itoa(port), strconv.Itoa(int(fwMark)),
+				err := nsc.cleanupMangleTableRule(ipAddress, proto, strconv.Itoa(port), strconv.Itoa(int(fwMark)),
 					nsc.dsrTCPMSS)
 				if err != nil {
 					klog.Errorf("failed to verify and cleanup any mangle table rule to FORWARD the traffic "+
@@ -856,10 +896,6 @@ func (nsc *NetworkServicesController) cleanupDSRService(fwMark uint32) error {
 	if nsc.krNode.IsIPv6Capable() {
 		cleanupTables("ip6tables-save")
 	}
-
-	// cleanup the fwMarkMap to ensure that we don't accidentally build state
-	delete(nsc.fwMarkMap, fwMark)
-	return nil
 }
 
 func (nsc *NetworkServicesController) cleanupStaleMetrics(activeServiceEndpointMap map[string][]string) {
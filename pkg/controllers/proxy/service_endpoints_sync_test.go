@@ -0,0 +1,83 @@
+package proxy
+
+import (
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/moby/ipvs"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+)
+
+func TestNetworkServicesController_setupClusterIPServicesSCTP(t *testing.T) {
+	nsc := getMoqNSC()
+	serviceMap := make(serviceInfoMap)
+	nsc.buildServiceInfo(&v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "diameter"},
+		Spec: v1.ServiceSpec{
+			Type:       v1.ServiceTypeClusterIP,
+			ClusterIP:  "10.96.0.20",
+			ClusterIPs: []string{"10.96.0.20"},
+			// defaulted by the API server
+			InternalTrafficPolicy: ptr.To(v1.ServiceInternalTrafficPolicyCluster),
+			Ports: []v1.ServicePort{
+				{Name: "sctp", Port: 3868, Protocol: v1.ProtocolSCTP},
+				{Name: "tcp", Port: 3868, Protocol: v1.ProtocolTCP},
+			},
+		},
+	}, serviceMap)
+
+	activeServiceEndpointMap := make(map[string][]string)
+	assert.NoError(t, nsc.setupClusterIPServices(serviceMap, endpointSliceInfoMap{}, activeServiceEndpointMap))
+
+	mock := nsc.ln.(*LinuxNetworkingMock)
+	protocols := make([]uint16, 0, 2)
+	for _, call := range mock.ipvsAddServiceCalls() {
+		assert.Equal(t, "10.96.0.20", call.Vip.String())
+		assert.Equal(t, uint16(3868), call.Port)
+		protocols = append(protocols, call.Protocol)
+	}
+	assert.ElementsMatch(t, []uint16{syscall.IPPROTO_SCTP, syscall.IPPROTO_TCP}, protocols)
+	sctpID := generateIPPortID("10.96.0.20", sctpProtocol, "3868")
+	tcpID := generateIPPortID("10.96.0.20", tcpProtocol, "3868")
+	assert.Contains(t, activeServiceEndpointMap, sctpID)
+	assert.Contains(t, activeServiceEndpointMap, tcpID)
+	assert.Equal(t, "default/diameter", nsc.ipvsServiceOwners[sctpID])
+}
+
+func TestNetworkServicesController_cleanupStaleIPVSConfigSCTP(t *testing.T) {
+	nsc := getMoqNSC()
+	deleter := &fakeConntrackDeleter{}
+	nsc.conntrack = deleter
+	for _, svc := range []struct {
+		protocol uint16
+		port     uint16
+	}{
+		{syscall.IPPROTO_SCTP, 3868},
+		{syscall.IPPROTO_SCTP, 9999},
+		// the port of the SCTP service, but with a protocol that no service port has
+		{syscall.IPPROTO_UDP, 3868},
+		// IPVS services with a protocol that kube-router doesn't handle aren't kube-router's to clean up
+		{syscall.IPPROTO_ICMP, 3868},
+	} {
+		_, _, err := nsc.ln.ipvsAddService(nil, net.ParseIP("10.96.0.20"), svc.protocol, svc.port, false, 0,
+			ipvs.RoundRobin, schedFlags{})
+		assert.NoError(t, err)
+	}
+
+	activeServiceEndpointMap := map[string][]string{generateIPPortID("10.96.0.20", sctpProtocol, "3868"): {}}
+	assert.NoError(t, nsc.cleanupStaleIPVSConfig(activeServiceEndpointMap, nil))
+
+	mock := nsc.ln.(*LinuxNetworkingMock)
+	deleted := make(map[uint16]uint16)
+	for _, call := range mock.ipvsDelServiceCalls() {
+		deleted[call.IpvsSvc.Port] = call.IpvsSvc.Protocol
+	}
+	assert.Len(t, mock.ipvsDelServiceCalls(), 2)
+	assert.Equal(t, map[uint16]uint16{9999: syscall.IPPROTO_SCTP, 3868: syscall.IPPROTO_UDP}, deleted)
+	// conntrack entries are only deleted for UDP services
+	assert.Len(t, deleter.families, 1)
+}
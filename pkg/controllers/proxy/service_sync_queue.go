@@ -0,0 +1,276 @@
+package proxy
+
+import (
+	"maps"
+	"reflect"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/v2/pkg/metrics"
+	"github.com/cloudnativelabs/kube-router/v2/pkg/utils"
+	v1 "k8s.io/api/core/v1"
+	discovery "k8s.io/api/discovery/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// enqueueServiceSync queues an incremental sync of the IPVS services of the service
+func (nsc *NetworkServicesController) enqueueServiceSync(namespace, name string) {
+	nsc.serviceQueue.Add(namespace + "/" + name)
+}
+
+// runServiceSyncWorker processes the service queue until it is shut down. Services that are queued while a sync is
+// running are synced together by the next sync, so that bursts of updates don't cause a sync per update.
+func (nsc *NetworkServicesController) runServiceSyncWorker() {
+	for {
+		key, shutdown := nsc.serviceQueue.Get()
+		if shutdown {
+			return
+		}
+		keys := []string{key}
+		for nsc.serviceQueue.Len() > 0 {
+			if key, shutdown = nsc.serviceQueue.Get(); shutdown {
+				break
+			}
+			keys = append(keys, key)
+		}
+
+		nsc.syncServices(keys)
+		for _, key := range keys {
+			nsc.serviceQueue.Done(key)
+		}
+	}
+}
+
+// syncServices rebuilds the service and endpoint info of the given services (namespace/name keys) and only syncs the
+// IPVS services of the services whose info changed, instead of rebuilding the info of all services and walking all
+// IPVS services like doSync does
+func (nsc *NetworkServicesController) syncServices(keys []string) {
+	nsc.mu.Lock()
+	defer nsc.mu.Unlock()
+
+	if !nsc.readyForUpdates {
+		klog.V(3).Infof("Skipping sync of %d services as controller is not ready to process service and endpoints "+
+			"updates", len(keys))
+		return
+	}
+
+	changed := make(map[string]bool)
+	servicesChanged := false
+	for _, key := range keys {
+		keyChanged, serviceChanged := nsc.updateServiceInfo(key)
+		if keyChanged {
+			changed[key] = true
+		}
+		servicesChanged = servicesChanged || serviceChanged
+	}
+	if len(changed) == 0 {
+		klog.V(1).Infof("Skipping IPVS services sync for update to %d services as nothing changed", len(keys))
+		return
+	}
+
+	klog.V(1).Infof("Syncing IPVS services of %d changed services", len(changed))
+	if err := nsc.syncIpvsServicesIncremental(changed, servicesChanged); err != nil {
+		klog.Errorf("error during incremental ipvs sync in network service controller. Error: %v", err)
+	}
+	if err := nsc.ruleRenderer.syncHairpinRules(); err != nil {
+		klog.Errorf("error syncing hairpin rules: %v", err)
+	}
+}
+
+// updateServiceInfo replaces the service and endpoint info of the service (namespace/name key) in nsc.serviceMap and
+// nsc.endpointsMap and returns whether the info of a service that kube-router proxies changed, and whether more than
+// its endpoints changed. Callers must hold nsc.mu.
+func (nsc *NetworkServicesController) updateServiceInfo(key string) (bool, bool) {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		klog.Errorf("invalid service key %q: %v", key, err)
+		return false, false
+	}
+
+	newServices := make(serviceInfoMap)
+	obj, exists, err := nsc.svcLister.GetByKey(key)
+	if err != nil {
+		klog.Errorf("failed to get service %s from the lister: %v", key, err)
+		return false, false
+	}
+	if exists {
+		nsc.buildServiceInfo(obj.(*v1.Service), newServices)
+	}
+	newEndpoints := nsc.buildServiceEndpointSliceInfo(namespace, name)
+
+	oldServices := make(serviceInfoMap)
+	for svcID, svc := range nsc.serviceMap {
+		if svc.namespace == namespace && svc.name == name {
+			oldServices[svcID] = svc
+		}
+	}
+	oldEndpoints := make(endpointSliceInfoMap)
+	for _, svcIDs := range []map[string]bool{mapKeys(oldServices), mapKeys(newServices), mapKeys(newEndpoints)} {
+		for svcID := range svcIDs {
+			if endpoints, ok := nsc.endpointsMap[svcID]; ok {
+				oldEndpoints[svcID] = endpoints
+			}
+		}
+	}
+
+	serviceChanged := !reflect.DeepEqual(oldServices, newServices)
+	if !serviceChanged && endpointsMapsEquivalent(oldEndpoints, newEndpoints) {
+		return false, false
+	}
+	for svcID := range oldServices {
+		delete(nsc.serviceMap, svcID)
+	}
+	for svcID := range oldEndpoints {
+		delete(nsc.endpointsMap, svcID)
+	}
+	maps.Copy(nsc.serviceMap, newServices)
+	maps.Copy(nsc.endpointsMap, newEndpoints)
+
+	// the endpoints of services that kube-router doesn't proxy are tracked, but don't need an IPVS sync
+	proxied := len(oldServices) > 0 || len(newServices) > 0
+	return proxied, proxied && serviceChanged
+}
+
+func mapKeys[V any](m map[string]V) map[string]bool {
+	keys := make(map[string]bool, len(m))
+	for key := range m {
+		keys[key] = true
+	}
+	return keys
+}
+
+// buildServiceEndpointSliceInfo creates a map of the EndpointSlices of a single service
+func (nsc *NetworkServicesController) buildServiceEndpointSliceInfo(namespace, name string) endpointSliceInfoMap {
+	endpointsMap := make(endpointSliceInfoMap)
+	slices, err := nsc.epSliceLister.ByIndex(cache.NamespaceIndex, namespace)
+	if err != nil {
+		// the informer's indexer always has the namespace index, but don't rely on it
+		slices = nsc.epSliceLister.List()
+	}
+	for _, obj := range slices {
+		es := obj.(*discovery.EndpointSlice)
+		if es.Namespace != namespace {
+			continue
+		}
+		if svcName, err := utils.ServiceNameforEndpointSlice(es); err != nil || svcName != name {
+			continue
+		}
+		nsc.buildEndpointSliceInfoForSlice(es, endpointsMap)
+	}
+	return endpointsMap
+}
+
+// recordIPVSServiceOwner records the service that the IPVS service (an activeServiceEndpointMap key) was set up for,
+// so that an incremental sync knows which IPVS services may have gone stale when the service changed
+func (nsc *NetworkServicesController) recordIPVSServiceOwner(ipvsSvcKey string, svc *serviceInfo) {
+	if nsc.ipvsServiceOwners == nil {
+		nsc.ipvsServiceOwners = make(map[string]string)
+	}
+	nsc.ipvsServiceOwners[ipvsSvcKey] = svc.namespace + "/" + svc.name
+}
+
+// syncIpvsServicesIncremental syncs the IPVS services of the changed services (namespace/name keys), whose info in
+// nsc.serviceMap and nsc.endpointsMap is already up to date. Only the IPVS services that were or are now set up for
+// the changed services are cleaned up, the IPVS services of all other services are left as they were set up by the
+// last sync. The periodic full sync remains in place to repair anything that an incremental sync missed.
+//
+// The IPVS firewall and the DSR routes only depend on the IPVS services and the info of the services, so when only
+// endpoints changed (servicesChanged is false) and the same IPVS services are set up, they are left alone.
+func (nsc *NetworkServicesController) syncIpvsServicesIncremental(changed map[string]bool,
+	servicesChanged bool) error {
+	// the IPVS services that were set up for the changed services are only known after a full sync
+	if nsc.activeServiceEndpointMap == nil {
+		return nsc.syncIpvsServices(nsc.serviceMap, nsc.endpointsMap)
+	}
+
+	start := time.Now()
+	defer func() {
+		endTime := time.Since(start)
+		if nsc.MetricsEnabled {
+			metrics.ControllerIpvsServicesIncrementalSyncTime.Observe(endTime.Seconds())
+		}
+		klog.V(1).Infof("incremental sync of ipvs services of %d services took %v", len(changed), endTime)
+	}()
+
+	serviceInfoMap := make(serviceInfoMap)
+	endpointsInfoMap := make(endpointSliceInfoMap)
+	for svcID, svc := range nsc.serviceMap {
+		if changed[svc.namespace+"/"+svc.name] {
+			serviceInfoMap[svcID] = svc
+			endpointsInfoMap[svcID] = nsc.endpointsMap[svcID]
+		}
+	}
+
+	// forget the IPVS services of the changed services, the ones that still belong to them are recorded again while
+	// they are set up and the others are stale
+	scope := make(map[string]bool)
+	oldIPVSServices := make(map[string]bool)
+	for ipvsSvcKey, owner := range nsc.ipvsServiceOwners {
+		if changed[owner] {
+			scope[ipvsSvcKey] = true
+			oldIPVSServices[ipvsSvcKey] = true
+			delete(nsc.ipvsServiceOwners, ipvsSvcKey)
+			delete(nsc.activeServiceEndpointMap, ipvsSvcKey)
+		}
+	}
+
+	var syncErrors bool
+	klog.V(1).Info("Syncing endpoint probes")
+	nsc.epc.UpdateServicesInfo(nsc.serviceMap, nsc.endpointsMap)
+
+	activeServiceEndpointMap := make(map[string][]string)
+	if !nsc.setupIpvsServices(serviceInfoMap, endpointsInfoMap, activeServiceEndpointMap) {
+		syncErrors = true
+	}
+	for ipvsSvcKey, endpointIDs := range activeServiceEndpointMap {
+		scope[ipvsSvcKey] = true
+		nsc.activeServiceEndpointMap[ipvsSvcKey] = endpointIDs
+	}
+	firewallChanged := servicesChanged || !maps.Equal(oldIPVSServices, mapKeys(activeServiceEndpointMap))
+
+	klog.V(1).Info("Setting up NodePort Health Checks for LB services")
+	if err := nsc.nphc.UpdateServicesInfo(nsc.serviceMap, nsc.endpointsMap); err != nil {
+		syncErrors = true
+		klog.Errorf("Error setting up NodePort Health Checks for LB Services: %v", err)
+	}
+
+	klog.V(1).Info("Cleaning Up Stale VIPs from dummy interface")
+	if err := nsc.cleanupStaleVIPs(nsc.activeServiceEndpointMap); err != nil {
+		syncErrors = true
+		klog.Errorf("Error cleaning up stale VIP's configured on the dummy interface: %s", err.Error())
+	}
+
+	klog.V(1).Info("Cleaning Up Stale VIPs from IPVS")
+	if err := nsc.cleanupStaleIPVSConfig(nsc.activeServiceEndpointMap, scope); err != nil {
+		syncErrors = true
+		klog.Errorf("Error cleaning up stale IPVS services and servers: %s", err.Error())
+	}
+
+	klog.V(1).Info("Cleaning Up Stale metrics")
+	nsc.cleanupStaleMetrics(nsc.activeServiceEndpointMap)
+
+	if firewallChanged {
+		klog.V(1).Info("Syncing IPVS Firewall")
+		if err := nsc.ruleRenderer.syncIpvsFirewall(); err != nil {
+			syncErrors = true
+			klog.Errorf("Error syncing ipvs svc iptables rules to permit traffic to service VIP's: %s", err.Error())
+		}
+
+		klog.V(1).Info("Setting up DSR Services")
+		if err := nsc.setupForDSR(nsc.serviceMap); err != nil {
+			syncErrors = true
+			klog.Errorf("Error setting up necessary policy based routing configuration needed for "+
+				"direct server return: %s", err.Error())
+		}
+	} else {
+		klog.V(1).Info("Only endpoints changed, skipping the IPVS firewall and DSR sync")
+	}
+
+	if syncErrors {
+		klog.V(1).Info("One or more errors encountered during incremental sync of IPVS services and servers " +
+			"to desired state")
+	} else {
+		klog.V(1).Info("IPVS servers and services of the changed services are synced to desired state")
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package proxy
+
+import (
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/cloudnativelabs/kube-router/v2/pkg/utils"
+	"github.com/moby/ipvs"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	discovery "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/utils/ptr"
+)
+
+func newTestSyncService(name, clusterIP string) *v1.Service {
+	return &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+		Spec: v1.ServiceSpec{
+			Type:       v1.ServiceTypeClusterIP,
+			ClusterIP:  clusterIP,
+			ClusterIPs: []string{clusterIP},
+			Ports:      []v1.ServicePort{{Name: "http", Port: 80, Protocol: v1.ProtocolTCP}},
+		},
+	}
+}
+
+func newTestSyncEndpointSlice(svcName string, addresses ...string) *discovery.EndpointSlice {
+	es := &discovery.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: svcName + "-abcde",
+			Labels: map[string]string{discovery.LabelServiceName: svcName}},
+		AddressType: discovery.AddressTypeIPv4,
+		Ports:       []discovery.EndpointPort{{Name: ptr.To("http"), Port: ptr.To[int32](8080)}},
+	}
+	for _, address := range addresses {
+		es.Endpoints = append(es.Endpoints, discovery.Endpoint{Addresses: []string{address},
+			Conditions: discovery.EndpointConditions{Ready: ptr.To(true)}})
+	}
+	return es
+}
+
+func newTestSyncNSC(t *testing.T, objs ...interface{}) *NetworkServicesController {
+	svcLister := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	epSliceLister := cache.NewIndexer(cache.MetaNamespaceKeyFunc,
+		cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, obj := range objs {
+		switch obj.(type) {
+		case *v1.Service:
+			assert.NoError(t, svcLister.Add(obj))
+		case *discovery.EndpointSlice:
+			assert.NoError(t, epSliceLister.Add(obj))
+		}
+	}
+	nsc := &NetworkServicesController{
+		krNode:        &utils.LocalKRNode{KRNode: utils.KRNode{NodeName: "node-1"}},
+		svcLister:     svcLister,
+		epSliceLister: epSliceLister,
+	}
+	nsc.serviceMap = nsc.buildServicesInfo()
+	nsc.endpointsMap = nsc.buildEndpointSliceInfo()
+	return nsc
+}
+
+func TestNetworkServicesController_updateServiceInfo(t *testing.T) {
+	nsc := newTestSyncNSC(t,
+		newTestSyncService("svc-1", "10.96.0.10"), newTestSyncEndpointSlice("svc-1", "10.1.0.5"),
+		newTestSyncService("svc-2", "10.96.0.20"), newTestSyncEndpointSlice("svc-2", "10.1.0.6"),
+	)
+	svc1ID := generateServiceID("default", "svc-1", "http")
+	svc2ID := generateServiceID("default", "svc-2", "http")
+	svc2Info, svc2Endpoints := nsc.serviceMap[svc2ID], nsc.endpointsMap[svc2ID]
+
+	changed, serviceChanged := nsc.updateServiceInfo("default/svc-1")
+	assert.False(t, changed, "nothing changed")
+	assert.False(t, serviceChanged)
+
+	// only the endpoints changed
+	assert.NoError(t, nsc.epSliceLister.Update(newTestSyncEndpointSlice("svc-1", "10.1.0.5", "10.1.0.7")))
+	changed, serviceChanged = nsc.updateServiceInfo("default/svc-1")
+	assert.True(t, changed)
+	assert.False(t, serviceChanged)
+	assert.Len(t, nsc.endpointsMap[svc1ID], 2)
+
+	assert.NoError(t, nsc.svcLister.Delete(newTestSyncService("svc-1", "10.96.0.10")))
+	changed, serviceChanged = nsc.updateServiceInfo("default/svc-1")
+	assert.True(t, changed)
+	assert.True(t, serviceChanged)
+	assert.NotContains(t, nsc.serviceMap, svc1ID)
+
+	// the endpoints of services that kube-router doesn't proxy don't need an IPVS sync
+	assert.NoError(t, nsc.epSliceLister.Update(newTestSyncEndpointSlice("svc-1", "10.1.0.5")))
+	changed, _ = nsc.updateServiceInfo("default/svc-1")
+	assert.False(t, changed)
+
+	// other services are left alone
+	assert.Same(t, svc2Info, nsc.serviceMap[svc2ID])
+	assert.Equal(t, svc2Endpoints, nsc.endpointsMap[svc2ID])
+	assert.Len(t, nsc.serviceMap, 1)
+}
+
+func TestNetworkServicesController_cleanupStaleIPVSConfigScope(t *testing.T) {
+	nsc := getMoqNSC()
+	for _, vip := range []string{"10.96.0.10", "10.96.0.20"} {
+		_, _, err := nsc.ln.ipvsAddService(nil, net.ParseIP(vip), syscall.IPPROTO_TCP, 80, false, 0, ipvs.RoundRobin,
+			schedFlags{})
+		assert.NoError(t, err)
+	}
+	stale := generateIPPortID("10.96.0.10", tcpProtocol, "80")
+
+	// neither service is active, but only the one in scope is cleaned up
+	assert.NoError(t, nsc.cleanupStaleIPVSConfig(map[string][]string{}, map[string]bool{stale: true}))
+	mock := nsc.ln.(*LinuxNetworkingMock)
+	if assert.Len(t, mock.ipvsDelServiceCalls(), 1) {
+		assert.Equal(t, "10.96.0.10", mock.ipvsDelServiceCalls()[0].IpvsSvc.Address.String())
+	}
+}
+
+func TestNetworkServicesController_recordIPVSServiceOwner(t *testing.T) {
+	nsc := getMoqNSC()
+	svc := &serviceInfo{namespace: "default", name: "svc-1", protocol: tcpProtocol}
+	activeServiceEndpointMap := make(map[string][]string)
+	_, svcID, _ := nsc.addIPVSService(nil, activeServiceEndpointMap, svc, net.ParseIP("10.96.0.10"),
+		syscall.IPPROTO_TCP, 80)
+
+	assert.Equal(t, generateIPPortID("10.96.0.10", tcpProtocol, "80"), svcID)
+	assert.Contains(t, activeServiceEndpointMap, svcID)
+	assert.Equal(t, map[string]string{svcID: "default/svc-1"}, nsc.ipvsServiceOwners)
+}
@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"slices"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+func (nsc *NetworkServicesController) newNodeEventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			nsc.handleNodeUpdate(obj)
+		},
+		UpdateFunc: func(_, newObj interface{}) {
+			nsc.handleNodeUpdate(newObj)
+		},
+	}
+}
+
+// handleNodeUpdate keeps the zone of the node up to date and requests a full sync when it changed, so that topology
+// aware routing selects the endpoints of the node's current zone
+func (nsc *NetworkServicesController) handleNodeUpdate(obj interface{}) {
+	node, ok := obj.(*v1.Node)
+	if !ok {
+		klog.Errorf("unexpected object type: %v", obj)
+		return
+	}
+	if node.Name != nsc.krNode.GetNodeName() {
+		return
+	}
+
+	zone := node.Labels[v1.LabelTopologyZone]
+	nsc.mu.Lock()
+	changed := zone != nsc.nodeZone
+	nsc.nodeZone = zone
+	readyForUpdates := nsc.readyForUpdates
+	nsc.mu.Unlock()
+
+	// the initial sync picks up the zone of a controller that isn't ready for updates yet
+	if changed && readyForUpdates {
+		klog.Infof("Zone of node %s changed to %q, requesting a full sync of services", node.Name, zone)
+		nsc.sync(synctypeAll)
+	}
+}
+
+// filterEndpointsForTopology returns the endpoints of the given family that the node sends the traffic of the service
+// to when topology aware routing applies to it, otherwise all endpoints are returned. Callers must hold nsc.mu.
+//
+// The topology aware hints that the EndpointSlice controller sets for services with the
+// service.kubernetes.io/topology-mode annotation or a trafficDistribution of PreferClose are honored when all ready
+// endpoints have hints. Without hints, services with a trafficDistribution of PreferClose prefer the endpoints that are
+// in the same zone as the node. In both cases all endpoints are used when none of the selected ones is ready, so that
+// the service stays reachable when its zone has no endpoints.
+func (nsc *NetworkServicesController) filterEndpointsForTopology(svc *serviceInfo, endpoints []endpointSliceInfo,
+	family v1.IPFamily) []endpointSliceInfo {
+	if nsc.nodeZone == "" {
+		return endpoints
+	}
+
+	familyEndpoints := make([]endpointSliceInfo, 0, len(endpoints))
+	for _, ep := range endpoints {
+		if (family == v1.IPv4Protocol && ep.isIPv4) || (family == v1.IPv6Protocol && ep.isIPv6) {
+			familyEndpoints = append(familyEndpoints, ep)
+		}
+	}
+
+	hinted, unhinted := false, false
+	for _, ep := range familyEndpoints {
+		if !ep.isReady {
+			continue
+		}
+		if len(ep.zoneHints) > 0 {
+			hinted = true
+		} else {
+			unhinted = true
+		}
+	}
+
+	var inZone func(ep endpointSliceInfo) bool
+	switch {
+	case hinted && unhinted:
+		klog.V(1).Infof("Ignoring topology aware hints of service %s/%s as some of its endpoints don't have hints",
+			svc.namespace, svc.name)
+		return endpoints
+	case hinted:
+		inZone = func(ep endpointSliceInfo) bool {
+			return slices.Contains(strings.Split(ep.zoneHints, ","), nsc.nodeZone)
+		}
+	case svc.trafficDistribution == v1.ServiceTrafficDistributionPreferClose:
+		inZone = func(ep endpointSliceInfo) bool {
+			return ep.zone == nsc.nodeZone
+		}
+	default:
+		return endpoints
+	}
+
+	zoneEndpoints := make([]endpointSliceInfo, 0, len(familyEndpoints))
+	hasReady := false
+	for _, ep := range familyEndpoints {
+		if inZone(ep) {
+			zoneEndpoints = append(zoneEndpoints, ep)
+			hasReady = hasReady || ep.isReady
+		}
+	}
+	if !hasReady {
+		klog.V(1).Infof("Service %s/%s has no ready %s endpoints for zone %s, using endpoints of all zones",
+			svc.namespace, svc.name, family, nsc.nodeZone)
+		return endpoints
+	}
+
+	klog.V(2).Infof("Using %d of %d %s endpoints of service %s/%s for zone %s", len(zoneEndpoints),
+		len(familyEndpoints), family, svc.namespace, svc.name, nsc.nodeZone)
+	return zoneEndpoints
+}
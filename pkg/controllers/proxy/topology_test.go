@@ -0,0 +1,145 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/cloudnativelabs/kube-router/v2/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNetworkServicesController_filterEndpointsForTopology(t *testing.T) {
+	zoneA := endpointSliceInfo{ip: "10.1.0.1", isIPv4: true, isReady: true, zone: "a", zoneHints: "a"}
+	zoneB := endpointSliceInfo{ip: "10.1.1.1", isIPv4: true, isReady: true, zone: "b", zoneHints: "b"}
+	zoneBHintedA := endpointSliceInfo{ip: "10.1.1.2", isIPv4: true, isReady: true, zone: "b", zoneHints: "a,c"}
+	zoneAUnhinted := endpointSliceInfo{ip: "10.1.0.2", isIPv4: true, isReady: true, zone: "a"}
+	zoneBUnhinted := endpointSliceInfo{ip: "10.1.1.3", isIPv4: true, isReady: true, zone: "b"}
+	zoneANotReady := endpointSliceInfo{ip: "10.1.0.3", isIPv4: true, isServing: true, isTerminating: true, zone: "a"}
+	zoneAIPv6 := endpointSliceInfo{ip: "fd00::1", isIPv6: true, isReady: true, zone: "a", zoneHints: "a"}
+
+	testcases := []struct {
+		name                string
+		nodeZone            string
+		trafficDistribution string
+		family              v1.IPFamily
+		endpoints           []endpointSliceInfo
+		expected            []endpointSliceInfo
+	}{
+		{
+			name:      "hints for the node's zone are honored",
+			nodeZone:  "a",
+			family:    v1.IPv4Protocol,
+			endpoints: []endpointSliceInfo{zoneA, zoneB, zoneBHintedA},
+			expected:  []endpointSliceInfo{zoneA, zoneBHintedA},
+		},
+		{
+			name:      "all endpoints are used when the node has no zone",
+			family:    v1.IPv4Protocol,
+			endpoints: []endpointSliceInfo{zoneA, zoneB},
+			expected:  []endpointSliceInfo{zoneA, zoneB},
+		},
+		{
+			name:      "all endpoints are used when some endpoints have no hints",
+			nodeZone:  "a",
+			family:    v1.IPv4Protocol,
+			endpoints: []endpointSliceInfo{zoneA, zoneB, zoneBUnhinted},
+			expected:  []endpointSliceInfo{zoneA, zoneB, zoneBUnhinted},
+		},
+		{
+			name:      "all endpoints are used when no endpoint is hinted for the node's zone",
+			nodeZone:  "c",
+			family:    v1.IPv4Protocol,
+			endpoints: []endpointSliceInfo{zoneA, zoneB},
+			expected:  []endpointSliceInfo{zoneA, zoneB},
+		},
+		{
+			name:      "unhinted endpoints are used as is without PreferClose",
+			nodeZone:  "a",
+			family:    v1.IPv4Protocol,
+			endpoints: []endpointSliceInfo{zoneAUnhinted, zoneBUnhinted},
+			expected:  []endpointSliceInfo{zoneAUnhinted, zoneBUnhinted},
+		},
+		{
+			name:                "PreferClose without hints prefers endpoints of the node's zone",
+			nodeZone:            "a",
+			trafficDistribution: v1.ServiceTrafficDistributionPreferClose,
+			family:              v1.IPv4Protocol,
+			endpoints:           []endpointSliceInfo{zoneAUnhinted, zoneBUnhinted, zoneANotReady},
+			expected:            []endpointSliceInfo{zoneAUnhinted, zoneANotReady},
+		},
+		{
+			name:                "PreferClose falls back to all zones without ready endpoints in the node's zone",
+			nodeZone:            "a",
+			trafficDistribution: v1.ServiceTrafficDistributionPreferClose,
+			family:              v1.IPv4Protocol,
+			endpoints:           []endpointSliceInfo{zoneBUnhinted, zoneANotReady},
+			expected:            []endpointSliceInfo{zoneBUnhinted, zoneANotReady},
+		},
+		{
+			name:      "only endpoints of the family are considered",
+			nodeZone:  "a",
+			family:    v1.IPv6Protocol,
+			endpoints: []endpointSliceInfo{zoneA, zoneB, zoneAIPv6},
+			expected:  []endpointSliceInfo{zoneAIPv6},
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			nsc := &NetworkServicesController{nodeZone: testcase.nodeZone}
+			svc := &serviceInfo{name: "svc", namespace: "default", trafficDistribution: testcase.trafficDistribution}
+			assert.Equal(t, testcase.expected, nsc.filterEndpointsForTopology(svc, testcase.endpoints, testcase.family))
+		})
+	}
+}
+
+func TestEndpointsMapsEquivalentWithZoneHints(t *testing.T) {
+	a := endpointSliceInfoMap{"svc": {{ip: "10.1.0.1", isIPv4: true, isReady: true, zone: "a", zoneHints: "a"}}}
+	b := endpointSliceInfoMap{"svc": {{ip: "10.1.0.1", isIPv4: true, isReady: true, zone: "a", zoneHints: "a,b"}}}
+	assert.True(t, endpointsMapsEquivalent(a, a))
+	assert.False(t, endpointsMapsEquivalent(a, b))
+}
+
+func Test_unsortedListsEquivalentWithZoneHints(t *testing.T) {
+	hintedA := endpointSliceInfo{ip: "10.1.0.1", isIPv4: true, isReady: true, zone: "a", zoneHints: "a"}
+	hintedAB := endpointSliceInfo{ip: "10.1.0.1", isIPv4: true, isReady: true, zone: "a", zoneHints: "a,b"}
+	unhinted := endpointSliceInfo{ip: "10.1.0.2", isIPv4: true, isReady: true, zone: "b"}
+	assert.True(t, unsortedListsEquivalent([]endpointSliceInfo{hintedA, unhinted},
+		[]endpointSliceInfo{unhinted, hintedA}))
+	assert.False(t, unsortedListsEquivalent([]endpointSliceInfo{hintedA, unhinted},
+		[]endpointSliceInfo{hintedAB, unhinted}))
+}
+
+func TestNetworkServicesController_handleNodeUpdate(t *testing.T) {
+	newNode := func(name, zone string) *v1.Node {
+		return &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{v1.LabelTopologyZone: zone}}}
+	}
+	nsc := &NetworkServicesController{
+		krNode:          &utils.LocalKRNode{KRNode: utils.KRNode{NodeName: "node-1"}},
+		nodeZone:        "a",
+		readyForUpdates: true,
+		syncChan:        make(chan int, 2),
+	}
+	handler := nsc.newNodeEventHandler()
+
+	// other nodes and an unchanged zone don't need a sync
+	handler.OnUpdate(nil, newNode("node-2", "b"))
+	handler.OnUpdate(nil, newNode("node-1", "a"))
+	assert.Equal(t, "a", nsc.nodeZone)
+	assert.Empty(t, nsc.syncChan)
+
+	handler.OnUpdate(nil, newNode("node-1", "b"))
+	assert.Equal(t, "b", nsc.nodeZone)
+	assert.Equal(t, synctypeAll, <-nsc.syncChan)
+
+	handler.OnUpdate(nil, &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}})
+	assert.Empty(t, nsc.nodeZone)
+	assert.Equal(t, synctypeAll, <-nsc.syncChan)
+
+	// the initial sync picks up the zone
+	nsc.readyForUpdates = false
+	handler.OnAdd(newNode("node-1", "c"), true)
+	assert.Equal(t, "c", nsc.nodeZone)
+	assert.Empty(t, nsc.syncChan)
+}
@@ -8,6 +8,7 @@ import (
 	"net"
 	"os/exec"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"syscall"
@@ -123,8 +124,9 @@ func (nsc *NetworkServicesController) lookupServiceByFWMark(fwMark uint32) (stri
 }
 
 // isValidKubeRouterServiceArtifact looks up a service by its clusterIP, externalIP, or loadBalancerIP. It returns
-// truthy
-func (nsc *NetworkServicesController) isValidKubeRouterServiceArtifact(address net.IP, nodePort int) (bool, error) {
+// truthy. NodePort services are looked up by the nodePortIPs of getNodePortIPs, which callers get once per sync.
+func (nsc *NetworkServicesController) isValidKubeRouterServiceArtifact(address net.IP, nodePort int,
+	nodePortIPs map[v1.IPFamily][]net.IP) (bool, error) {
 	for _, svc := range nsc.serviceMap {
 		for _, clIP := range svc.clusterIPs {
 			if net.ParseIP(clIP).Equal(address) {
@@ -142,24 +144,16 @@ func (nsc *NetworkServicesController) isValidKubeRouterServiceArtifact(address n
 			}
 		}
 		if nodePort != 0 && svc.nodePort == nodePort {
-			if nsc.nodeportBindOnAllIP {
-				addrMap, err := getAllLocalIPs()
-				if err != nil {
-					return false, fmt.Errorf("failed to get all local IPs: %v", err)
-				}
-				var addresses []net.IP
-				if address.To4() != nil {
-					addresses = addrMap[v1.IPv4Protocol]
-				} else {
-					addresses = addrMap[v1.IPv6Protocol]
-				}
-				for _, addr := range addresses {
-					if addr.Equal(address) {
-						return true, nil
-					}
+			var addresses []net.IP
+			if address.To4() != nil {
+				addresses = nodePortIPs[v1.IPv4Protocol]
+			} else {
+				addresses = nodePortIPs[v1.IPv6Protocol]
+			}
+			for _, addr := range addresses {
+				if addr.Equal(address) {
+					return true, nil
 				}
-			} else if address.Equal(nsc.krNode.GetPrimaryNodeIP()) {
-				return true, nil
 			}
 		}
 	}
@@ -220,6 +214,8 @@ func convertSvcProtoToSysCallProto(svcProtocol string) uint16 {
 		return syscall.IPPROTO_TCP
 	case udpProtocol:
 		return syscall.IPPROTO_UDP
+	case sctpProtocol:
+		return syscall.IPPROTO_SCTP
 	default:
 		return syscall.IPPROTO_NONE
 	}
@@ -233,6 +229,8 @@ func convertSysCallProtoToSvcProto(sysProtocol uint16) string {
 		return tcpProtocol
 	case syscall.IPPROTO_UDP:
 		return udpProtocol
+	case syscall.IPPROTO_SCTP:
+		return sctpProtocol
 	default:
 		return noneProtocol
 	}
@@ -543,9 +541,67 @@ func ensureHairpinChain(iptablesCmdHandler utils.IPTablesHandler) error {
 	return nil
 }
 
+// parseNodePortAddresses splits the values of --nodeport-addresses into CIDRs and interface names
+func parseNodePortAddresses(addresses []string) ([]net.IPNet, []string, error) {
+	var cidrs []net.IPNet
+	var interfaces []string
+	for _, address := range addresses {
+		address = strings.TrimSpace(address)
+		if address == "" {
+			continue
+		}
+		if !strings.Contains(address, "/") {
+			interfaces = append(interfaces, address)
+			continue
+		}
+		_, ipnet, err := net.ParseCIDR(address)
+		if err != nil {
+			return nil, nil, fmt.Errorf("'%s' is neither a CIDR nor an interface name", address)
+		}
+		cidrs = append(cidrs, *ipnet)
+	}
+	return cidrs, interfaces, nil
+}
+
+// getNodePortIPs returns the IPs of the node that the IPVS services of NodePorts are created on: the IPs that are
+// allowed by --nodeport-addresses when it is set, all local IPs with --nodeport-bindon-all-ip or else the primary IP
+func (nsc *NetworkServicesController) getNodePortIPs() (map[v1.IPFamily][]net.IP, error) {
+	switch {
+	case len(nsc.nodePortCIDRs) > 0 || len(nsc.nodePortInterfaces) > 0:
+		return getLocalIPs(nsc.isNodePortAddressAllowed)
+	case nsc.nodeportBindOnAllIP:
+		return getAllLocalIPs()
+	default:
+		primaryIP := nsc.krNode.GetPrimaryNodeIP()
+		if primaryIP.To4() != nil {
+			return map[v1.IPFamily][]net.IP{v1.IPv4Protocol: {primaryIP}}, nil
+		}
+		return map[v1.IPFamily][]net.IP{v1.IPv6Protocol: {primaryIP}}, nil
+	}
+}
+
+// isNodePortAddressAllowed checks whether the IP on the given interface is allowed by --nodeport-addresses
+func (nsc *NetworkServicesController) isNodePortAddressAllowed(ifName string, ip net.IP) bool {
+	if slices.Contains(nsc.nodePortInterfaces, ifName) {
+		return true
+	}
+	for _, cidr := range nsc.nodePortCIDRs {
+		if cidr.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
 // getAllLocalIPs returns all IP addresses found on any network address in the system, excluding dummy and docker
 // interfaces in a map that distinguishes between IPv4 and IPv6 addresses by v1.IPFamily
 func getAllLocalIPs() (map[v1.IPFamily][]net.IP, error) {
+	return getLocalIPs(nil)
+}
+
+// getLocalIPs returns the IP addresses that getAllLocalIPs returns, limited to those that include returns true for
+// when include isn't nil
+func getLocalIPs(include func(ifName string, ip net.IP) bool) (map[v1.IPFamily][]net.IP, error) {
 	// We use maps here so that we can de-duplicate repeat IP addresses
 	v4Map := make(map[string]bool)
 	v6Map := make(map[string]bool)
@@ -569,6 +625,9 @@ func getAllLocalIPs() (map[v1.IPFamily][]net.IP, error) {
 		}
 
 		for _, addr := range linkAddrs {
+			if include != nil && !include(link.Attrs().Name, addr.IP) {
+				continue
+			}
 			if addr.IP.To4() != nil {
 				v4Map[addr.IP.String()] = true
 			} else {
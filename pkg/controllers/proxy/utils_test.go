@@ -4,10 +4,17 @@ import (
 	"fmt"
 	"net"
 	"strconv"
+	"sync"
+	"syscall"
 	"testing"
 
 	"github.com/cloudnativelabs/kube-router/v2/pkg/utils"
+	"github.com/moby/ipvs"
 	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	discovery "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
 )
 
 func getMoqNSC() *NetworkServicesController {
@@ -228,10 +235,238 @@ func TestIsValidKubeRouterServiceArtifact(t *testing.T) {
 		{net.ParseIP("192.168.1.10"), 0, false, fmt.Errorf("service not found for address 192.168.1.10")},
 	}
 
+	nodePortIPs, err := nsc.getNodePortIPs()
+	assert.NoError(t, err)
 	for _, test := range tests {
-		result, err := nsc.isValidKubeRouterServiceArtifact(test.address, test.port)
+		result, err := nsc.isValidKubeRouterServiceArtifact(test.address, test.port, nodePortIPs)
 		if result != test.expected || (err != nil && err.Error() != test.err.Error()) {
 			t.Errorf("lookupServiceByAddress(%v) = %v, %v; want %v, %v", test.address, result, err, test.expected, test.err)
 		}
 	}
 }
+
+func TestParseLoadBalancerSourceRanges(t *testing.T) {
+	svc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "svc-1", Namespace: "default"},
+		Spec: v1.ServiceSpec{
+			LoadBalancerSourceRanges: []string{" 192.168.1.1/24", "2001:db8::/64", "invalid", "10.0.0.1/32"},
+		},
+	}
+	assert.Equal(t, []string{"192.168.1.0/24", "2001:db8::/64", "10.0.0.1/32"}, parseLoadBalancerSourceRanges(svc))
+
+	svc.Spec.LoadBalancerSourceRanges = nil
+	assert.Nil(t, parseLoadBalancerSourceRanges(svc))
+}
+
+func TestNetworkServicesController_getFirewallSourceRanges(t *testing.T) {
+	nsc := &NetworkServicesController{
+		serviceMap: map[string]*serviceInfo{
+			"restricted": {
+				protocol:                 tcpProtocol,
+				port:                     443,
+				externalIPs:              []string{"192.168.1.1"},
+				loadBalancerIPs:          []string{"172.16.0.1", "2001:db8::1"},
+				loadBalancerSourceRanges: []string{"10.0.0.0/8", "192.0.2.0/24"},
+			},
+			"skip-lb-ips": {
+				protocol:                 udpProtocol,
+				port:                     53,
+				externalIPs:              []string{"192.168.1.2"},
+				loadBalancerIPs:          []string{"172.16.0.2"},
+				loadBalancerSourceRanges: []string{"10.0.0.0/8"},
+				skipLbIps:                true,
+			},
+			"unrestricted-ipv4": {
+				protocol:                 tcpProtocol,
+				port:                     80,
+				loadBalancerIPs:          []string{"172.16.0.3", "2001:db8::3"},
+				loadBalancerSourceRanges: []string{"0.0.0.0/0", "2001:db8:1::/48"},
+			},
+			"without-source-ranges": {
+				protocol:        tcpProtocol,
+				port:            80,
+				loadBalancerIPs: []string{"172.16.0.4"},
+			},
+		},
+	}
+
+	sourceRanges := nsc.getFirewallSourceRanges()
+	assert.Equal(t, []serviceSourceRanges{
+		{
+			addr:         serviceAddr{address: net.ParseIP("172.16.0.1"), protocol: tcpProtocol, port: 443},
+			sourceRanges: []string{"10.0.0.0/8", "192.0.2.0/24"},
+		},
+		{
+			addr:         serviceAddr{address: net.ParseIP("192.168.1.1"), protocol: tcpProtocol, port: 443},
+			sourceRanges: []string{"10.0.0.0/8", "192.0.2.0/24"},
+		},
+		{
+			addr:         serviceAddr{address: net.ParseIP("192.168.1.2"), protocol: udpProtocol, port: 53},
+			sourceRanges: []string{"10.0.0.0/8"},
+		},
+	}, sourceRanges[v1.IPv4Protocol])
+	// IPs of a family without source ranges don't permit any traffic
+	assert.Equal(t, []serviceSourceRanges{
+		{
+			addr:         serviceAddr{address: net.ParseIP("2001:db8::1"), protocol: tcpProtocol, port: 443},
+			sourceRanges: nil,
+		},
+		{
+			addr:         serviceAddr{address: net.ParseIP("2001:db8::3"), protocol: tcpProtocol, port: 80},
+			sourceRanges: []string{"2001:db8:1::/48"},
+		},
+	}, sourceRanges[v1.IPv6Protocol])
+}
+
+// fakeIPSet records the entries that the sets were last refreshed with
+type fakeIPSet struct {
+	entries map[string][]string
+}
+
+func (f *fakeIPSet) Create(_ string, _ ...string) (*utils.Set, error) { return nil, nil }
+func (f *fakeIPSet) Add(_ *utils.Set) error                           { return nil }
+func (f *fakeIPSet) RefreshSet(setName string, entriesWithOptions [][]string, _ string) {
+	f.entries[setName] = nil
+	for _, entry := range entriesWithOptions {
+		f.entries[setName] = append(f.entries[setName], entry[0])
+	}
+}
+func (f *fakeIPSet) Destroy(_ string) error      { return nil }
+func (f *fakeIPSet) DestroyAllWithin() error     { return nil }
+func (f *fakeIPSet) Save() error                 { return nil }
+func (f *fakeIPSet) Restore() error              { return nil }
+func (f *fakeIPSet) Flush() error                { return nil }
+func (f *fakeIPSet) Get(_ string) *utils.Set     { return nil }
+func (f *fakeIPSet) Sets() map[string]*utils.Set { return nil }
+func (f *fakeIPSet) Name(name string) string     { return name }
+
+func TestNetworkServicesController_syncIpvsFirewallSCTP(t *testing.T) {
+	ipSet := &fakeIPSet{entries: make(map[string][]string)}
+	nsc := &NetworkServicesController{
+		krNode: &utils.LocalKRNode{KRNode: utils.KRNode{NodeName: "node-1", PrimaryIP: net.ParseIP("192.168.1.10"),
+			NodeIPv4Addrs: map[v1.NodeAddressType][]net.IP{v1.NodeInternalIP: {net.ParseIP("192.168.1.10")}}}},
+		ln: &LinuxNetworkingMock{ipvsGetServicesFunc: func() ([]*ipvs.Service, error) {
+			return []*ipvs.Service{
+				{Address: net.ParseIP("10.96.0.20"), Protocol: syscall.IPPROTO_SCTP, Port: 3868},
+				{Address: net.ParseIP("172.16.0.20"), Protocol: syscall.IPPROTO_SCTP, Port: 3868},
+				// kube-router doesn't create IPVS services of other protocols, so they're left out of the firewall
+				{Address: net.ParseIP("10.96.0.20"), Protocol: syscall.IPPROTO_ICMP, Port: 3868},
+			}, nil
+		}},
+		serviceMap: map[string]*serviceInfo{"default/diameter:sctp": {clusterIPs: []string{"10.96.0.20"},
+			loadBalancerIPs: []string{"172.16.0.20"}, loadBalancerSourceRanges: []string{"10.0.0.0/8"},
+			protocol: sctpProtocol, port: 3868}},
+		ipsetMutex:    &sync.Mutex{},
+		ipSetHandlers: map[v1.IPFamily]utils.IPSetHandler{v1.IPv4Protocol: ipSet},
+	}
+
+	assert.NoError(t, nsc.syncIpvsFirewall())
+	assert.ElementsMatch(t, []string{"10.96.0.20", "172.16.0.20"}, ipSet.entries[serviceIPsIPSetName])
+	assert.ElementsMatch(t, []string{"10.96.0.20,sctp:3868", "172.16.0.20,sctp:3868"},
+		ipSet.entries[serviceIPPortsSetName])
+	assert.Equal(t, []string{"172.16.0.20,sctp:3868"}, ipSet.entries[sourceRangeServiceIPPortsSetName])
+	assert.Equal(t, []string{"172.16.0.20,sctp:3868,10.0.0.0/8"}, ipSet.entries[serviceSourceRangesSetName])
+
+	// the nftables backend matches the same tuples
+	nft := &fakeNFTables{}
+	r := newNFTablesRuleRenderer(nsc,
+		utils.NewNFTablesSharedTable(nft, utils.NFTablesFamilyInet, utils.KubeRouterNFTable))
+	assert.NoError(t, r.syncIpvsFirewall())
+	if assert.Len(t, nft.scripts, 1) {
+		assert.Contains(t, nft.scripts[0], "elements = { 10.96.0.20 . sctp . 3868, 172.16.0.20 . sctp . 3868 }")
+		assert.Contains(t, nft.scripts[0], "ip daddr 172.16.0.20 meta l4proto sctp th dport 3868 ip saddr != "+
+			"{ 10.0.0.0/8 } reject")
+	}
+}
+
+func TestConvertSvcProtoToSysCallProto(t *testing.T) {
+	for _, protocol := range []string{tcpProtocol, udpProtocol, sctpProtocol} {
+		sysProtocol := convertSvcProtoToSysCallProto(protocol)
+		assert.NotEqual(t, uint16(syscall.IPPROTO_NONE), sysProtocol, protocol)
+		assert.Equal(t, protocol, convertSysCallProtoToSvcProto(sysProtocol))
+	}
+	assert.Equal(t, uint16(syscall.IPPROTO_SCTP), convertSvcProtoToSysCallProto(sctpProtocol))
+	assert.Equal(t, uint16(syscall.IPPROTO_NONE), convertSvcProtoToSysCallProto("icmp"))
+	assert.Equal(t, noneProtocol, convertSysCallProtoToSvcProto(syscall.IPPROTO_ICMP))
+}
+
+func TestParseEndpointWeight(t *testing.T) {
+	for value, expected := range map[string]int{"0": 0, "1": 1, " 10 ": 10, "65535": 65535} {
+		weight, err := parseEndpointWeight(value)
+		assert.NoError(t, err, value)
+		assert.Equal(t, expected, weight, value)
+	}
+	for _, value := range []string{"", "-1", "65536", "heavy"} {
+		_, err := parseEndpointWeight(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestNetworkServicesController_endpointWeight(t *testing.T) {
+	podLister := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for name, weight := range map[string]string{"drained": "0", "large": "4", "invalid": "-4"} {
+		assert.NoError(t, podLister.Add(&v1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "default",
+			Name:        name,
+			Annotations: map[string]string{endpointWeightAnnotation: weight},
+		}}))
+	}
+	assert.NoError(t, podLister.Add(&v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "plain"}}))
+	nsc := &NetworkServicesController{podLister: podLister}
+
+	podEndpoint := func(name string) discovery.Endpoint {
+		return discovery.Endpoint{TargetRef: &v1.ObjectReference{Kind: "Pod", Namespace: "default", Name: name}}
+	}
+	assert.Equal(t, 0, nsc.endpointWeight("default", podEndpoint("drained")))
+	assert.Equal(t, 4, nsc.endpointWeight("default", podEndpoint("large")))
+	assert.Equal(t, defaultEndpointWeight, nsc.endpointWeight("default", podEndpoint("invalid")))
+	assert.Equal(t, defaultEndpointWeight, nsc.endpointWeight("default", podEndpoint("plain")))
+	assert.Equal(t, defaultEndpointWeight, nsc.endpointWeight("default", podEndpoint("missing")))
+	assert.Equal(t, defaultEndpointWeight, nsc.endpointWeight("default", discovery.Endpoint{}))
+}
+
+func TestParseNodePortAddresses(t *testing.T) {
+	cidrs, interfaces, err := parseNodePortAddresses([]string{"10.0.0.1/24", " eth1 ", "", "2001:db8::/64"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.0/24", "2001:db8::/64"}, []string{cidrs[0].String(), cidrs[1].String()})
+	assert.Equal(t, []string{"eth1"}, interfaces)
+
+	_, _, err = parseNodePortAddresses([]string{"10.0.0.300/24"})
+	assert.Error(t, err)
+}
+
+func TestNetworkServicesController_getNodePortIPs(t *testing.T) {
+	krNode := &utils.LocalKRNode{
+		KRNode: utils.KRNode{
+			NodeName:  "node-1",
+			PrimaryIP: net.ParseIP("192.168.1.10"),
+		},
+	}
+	nsc := &NetworkServicesController{
+		krNode:     krNode,
+		serviceMap: map[string]*serviceInfo{"service1": {nodePort: 30000}},
+	}
+
+	nodePortIPs, err := nsc.getNodePortIPs()
+	assert.NoError(t, err)
+	assert.Equal(t, map[v1.IPFamily][]net.IP{v1.IPv4Protocol: {net.ParseIP("192.168.1.10")}}, nodePortIPs)
+
+	// only the loopback address is allowed, which every node has
+	nsc.nodePortCIDRs, nsc.nodePortInterfaces, err = parseNodePortAddresses([]string{"127.0.0.0/8"})
+	assert.NoError(t, err)
+	nodePortIPs, err = nsc.getNodePortIPs()
+	assert.NoError(t, err)
+	assert.Equal(t, []net.IP{net.ParseIP("127.0.0.1")}, nodePortIPs[v1.IPv4Protocol])
+	assert.Empty(t, nodePortIPs[v1.IPv6Protocol])
+
+	valid, err := nsc.isValidKubeRouterServiceArtifact(net.ParseIP("127.0.0.1"), 30000, nodePortIPs)
+	assert.NoError(t, err)
+	assert.True(t, valid)
+	valid, _ = nsc.isValidKubeRouterServiceArtifact(net.ParseIP("192.168.1.10"), 30000, nodePortIPs)
+	assert.False(t, valid)
+
+	assert.True(t, nsc.isNodePortAddressAllowed("eth0", net.ParseIP("127.0.0.2")))
+	nsc.nodePortInterfaces = []string{"eth1"}
+	assert.True(t, nsc.isNodePortAddressAllowed("eth1", net.ParseIP("10.0.0.1")))
+	assert.False(t, nsc.isNodePortAddressAllowed("eth0", net.ParseIP("10.0.0.1")))
+}
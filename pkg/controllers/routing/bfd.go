@@ -0,0 +1,168 @@
+package routing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/cloudnativelabs/kube-router/v2/pkg/bfd"
+	"github.com/cloudnativelabs/kube-router/v2/pkg/bgp"
+	"github.com/cloudnativelabs/kube-router/v2/pkg/metrics"
+	"github.com/cloudnativelabs/kube-router/v2/pkg/routes"
+	gobgpapi "github.com/osrg/gobgp/v3/api"
+	"k8s.io/klog/v2"
+)
+
+// parsePeerBFD parses the values of the kube-router.io/peer.bfd annotation, which enable or disable BFD for each of
+// the peers in the kube-router.io/peer.ips annotation. Blank items leave the peer at the --enable-bfd default.
+func parsePeerBFD(ips []net.IP, values []string) (map[string]bool, error) {
+	if len(ips) != len(values) {
+		return nil, errors.New("invalid peer router config. The number of BFD settings should be one per peer " +
+			"router. If blank items are used, it will default to --enable-bfd. " +
+			"Example: \"true,,false\" OR [\"true\",\"\",\"false\"]")
+	}
+	peers := make(map[string]bool)
+	for i, value := range values {
+		if value == "" {
+			continue
+		}
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse \"%s\" as a boolean", value)
+		}
+		peers[ips[i].String()] = enabled
+	}
+	return peers, nil
+}
+
+// bfdEnabledForPeer checks whether a BFD session should be run with the given peer, which is the case when BFD is
+// enabled globally unless the peer.bfd annotation of the node overrides it for the peer
+func (nrc *NetworkRoutingController) bfdEnabledForPeer(peerIP string) bool {
+	nrc.bfdPeersMu.RLock()
+	defer nrc.bfdPeersMu.RUnlock()
+	if enabled, ok := nrc.bfdPeers[peerIP]; ok {
+		return enabled
+	}
+	return nrc.bfdEnabled
+}
+
+// setPeerBFD overrides whether a BFD session is run with the given peer, nil removes the override
+func (nrc *NetworkRoutingController) setPeerBFD(peerIP string, enabled *bool) {
+	nrc.bfdPeersMu.Lock()
+	defer nrc.bfdPeersMu.Unlock()
+	if enabled == nil {
+		delete(nrc.bfdPeers, peerIP)
+		return
+	}
+	nrc.bfdPeers[peerIP] = *enabled
+}
+
+// addBFDSession starts a BFD session with the given BGP peer, if there isn't one already
+func (nrc *NetworkRoutingController) addBFDSession(localIP, peerIP string) {
+	if nrc.bfdManager == nil {
+		return
+	}
+	local := net.ParseIP(localIP)
+	peer := net.ParseIP(peerIP)
+	if local == nil || peer == nil {
+		klog.Errorf("unable to parse addresses %s and %s of BGP peer, not starting a BFD session", localIP, peerIP)
+		return
+	}
+	if err := nrc.bfdManager.AddSession(local, peer); err != nil {
+		klog.Errorf("Failed to start BFD session with BGP peer %s: %v", peerIP, err)
+		return
+	}
+	if nrc.MetricsEnabled {
+		metrics.ControllerBFDSessionState.WithLabelValues(peerIP).Set(float64(bfd.StateDown))
+	}
+}
+
+// removeBFDSession stops the BFD session with the given BGP peer
+func (nrc *NetworkRoutingController) removeBFDSession(peerIP string) {
+	if nrc.bfdManager == nil {
+		return
+	}
+	nrc.bfdManager.RemoveSession(net.ParseIP(peerIP))
+
+	nrc.bfdMu.Lock()
+	delete(nrc.bfdDisabledPeers, peerIP)
+	nrc.bfdMu.Unlock()
+
+	if nrc.MetricsEnabled {
+		metrics.ControllerBFDSessionState.DeleteLabelValues(peerIP)
+	}
+}
+
+// onBFDStateChange shuts the BGP peer down as soon as its BFD session goes down, instead of waiting for the BGP hold
+// timer to expire, and brings it back up once the BFD session is up again
+func (nrc *NetworkRoutingController) onBFDStateChange(peer net.IP, oldState, newState bfd.State) {
+	peerIP := peer.String()
+	if nrc.MetricsEnabled {
+		metrics.ControllerBFDSessionState.WithLabelValues(peerIP).Set(float64(newState))
+		metrics.ControllerBFDSessionStateChanges.WithLabelValues(peerIP, newState.String()).Inc()
+	}
+
+	nrc.bfdMu.Lock()
+	defer nrc.bfdMu.Unlock()
+
+	switch {
+	case oldState == bfd.StateUp:
+		klog.Warningf("BFD session with BGP peer %s went down, shutting down the peer", peerIP)
+		nrc.withdrawPeerRoutes(peerIP)
+		if err := nrc.bgpServer.DisablePeer(context.Background(), &gobgpapi.DisablePeerRequest{
+			Address:       peerIP,
+			Communication: "BFD session down",
+		}); err != nil {
+			klog.Errorf("Failed to shut down BGP peer %s after its BFD session went down: %v", peerIP, err)
+			return
+		}
+		nrc.bfdDisabledPeers[peerIP] = true
+	case newState == bfd.StateUp && nrc.bfdDisabledPeers[peerIP]:
+		klog.Infof("BFD session with BGP peer %s is up again, bringing the peer back up", peerIP)
+		if err := nrc.bgpServer.EnablePeer(context.Background(), &gobgpapi.EnablePeerRequest{
+			Address: peerIP,
+		}); err != nil {
+			klog.Errorf("Failed to bring BGP peer %s back up after its BFD session came up: %v", peerIP, err)
+			return
+		}
+		delete(nrc.bfdDisabledPeers, peerIP)
+	}
+}
+
+// withdrawPeerRoutes removes the routes that were injected for the paths received from the given peer. If another peer
+// advertises the same destination, its path becomes the best path once the peer is shut down and gets injected again.
+func (nrc *NetworkRoutingController) withdrawPeerRoutes(peerIP string) {
+	families := make([]*gobgpapi.Family, 0)
+	if nrc.krNode.IsIPv4Capable() {
+		families = append(families, &gobgpapi.Family{Afi: gobgpapi.Family_AFI_IP, Safi: gobgpapi.Family_SAFI_UNICAST})
+	}
+	if nrc.krNode.IsIPv6Capable() {
+		families = append(families, &gobgpapi.Family{Afi: gobgpapi.Family_AFI_IP6, Safi: gobgpapi.Family_SAFI_UNICAST})
+	}
+
+	for _, family := range families {
+		err := nrc.bgpServer.ListPath(context.Background(), &gobgpapi.ListPathRequest{
+			TableType: gobgpapi.TableType_ADJ_IN,
+			Name:      peerIP,
+			Family:    family,
+		}, func(d *gobgpapi.Destination) {
+			for _, path := range d.Paths {
+				dst, _, err := bgp.ParsePath(path)
+				if err != nil {
+					klog.Warningf("unable to parse path received from BGP peer %s: %v", peerIP, err)
+					continue
+				}
+				klog.V(2).Infof("Removing route: '%s' from peer %s whose BFD session went down", dst, peerIP)
+				nrc.routeSyncer.DelInjectedRoute(dst)
+				if err = routes.DeleteByDestination(dst); err != nil {
+					klog.Errorf("Failed to remove route to %s from BGP peer %s: %v", dst, peerIP, err)
+				}
+			}
+		})
+		if err != nil {
+			klog.Errorf("Failed to list paths received from BGP peer %s: %v", peerIP, err)
+		}
+	}
+}
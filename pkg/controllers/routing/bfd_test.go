@@ -0,0 +1,87 @@
+package routing
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/utils/ptr"
+)
+
+func Test_parsePeerBFD(t *testing.T) {
+	ips := []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2"), net.ParseIP("2001:db8::1")}
+
+	testcases := []struct {
+		name     string
+		values   []string
+		expected map[string]bool
+		err      bool
+	}{
+		{
+			name:     "blank items are left at the default",
+			values:   []string{"true", "", "false"},
+			expected: map[string]bool{"10.0.0.1": true, "2001:db8::1": false},
+		},
+		{
+			name:   "one item is required per peer",
+			values: []string{"true", "false"},
+			err:    true,
+		},
+		{
+			name:   "items need to be booleans",
+			values: []string{"true", "yes", "false"},
+			err:    true,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			peers, err := parsePeerBFD(ips, tc.values)
+			if tc.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, peers)
+		})
+	}
+}
+
+func Test_bfdEnabledForPeer(t *testing.T) {
+	nrc := &NetworkRoutingController{
+		bfdEnabled: true,
+		bfdPeers:   map[string]bool{"10.0.0.2": false},
+	}
+	assert.True(t, nrc.bfdEnabledForPeer("10.0.0.1"), "peers without annotation use the global setting")
+	assert.False(t, nrc.bfdEnabledForPeer("10.0.0.2"), "annotation overrides the global setting")
+
+	nrc = &NetworkRoutingController{bfdPeers: map[string]bool{"10.0.0.2": true}}
+	assert.False(t, nrc.bfdEnabledForPeer("10.0.0.1"))
+	assert.True(t, nrc.bfdEnabledForPeer("10.0.0.2"))
+}
+
+func Test_setPeerBFD(t *testing.T) {
+	nrc := &NetworkRoutingController{bfdEnabled: true, bfdPeers: make(map[string]bool)}
+	nrc.setPeerBFD("10.0.0.2", ptr.To(false))
+	assert.False(t, nrc.bfdEnabledForPeer("10.0.0.2"))
+	nrc.setPeerBFD("10.0.0.2", nil)
+	assert.True(t, nrc.bfdEnabledForPeer("10.0.0.2"))
+
+	// the node informer reads the overrides while BGPPeer syncs update them
+	var wg sync.WaitGroup
+	for i := range 10 {
+		wg.Add(2)
+		peerIP := fmt.Sprintf("10.0.1.%d", i)
+		go func() {
+			defer wg.Done()
+			nrc.setPeerBFD(peerIP, ptr.To(false))
+		}()
+		go func() {
+			defer wg.Done()
+			nrc.bfdEnabledForPeer(peerIP)
+		}()
+	}
+	wg.Wait()
+	assert.Len(t, nrc.bfdPeers, 10)
+}
@@ -176,6 +176,10 @@ func (nrc *NetworkRoutingController) syncInternalPeers() {
 				klog.Errorf("Failed to add node %s as peer due to %s", targetNode.GetPrimaryNodeIP(), err)
 			}
 		}
+
+		if nrc.bfdEnabledForPeer(n.Conf.NeighborAddress) {
+			nrc.addBFDSession(n.Transport.LocalAddress, n.Conf.NeighborAddress)
+		}
 	}
 
 	// find the list of the node removed, from the last known list of active nodes
@@ -198,6 +202,7 @@ func (nrc *NetworkRoutingController) syncInternalPeers() {
 		if err := nrc.bgpServer.DeletePeer(context.Background(), &gobgpapi.DeletePeerRequest{Address: ip}); err != nil {
 			klog.Errorf("Failed to remove node %s as peer due to %s", ip, err)
 		}
+		nrc.removeBFDSession(ip)
 		delete(nrc.activeNodes, ip)
 	}
 }
@@ -280,6 +285,15 @@ func (nrc *NetworkRoutingController) connectToExternalBGPPeers(server *gobgp.Bgp
 		}
 		klog.V(2).Infof("Successfully configured %s in ASN %v as BGP peer to the node",
 			n.Conf.NeighborAddress, n.Conf.PeerAsn)
+
+		if nrc.bfdEnabledForPeer(neighborIPStr) {
+			// single hop BFD requires the peer to be directly connected
+			if peerMultihopTTL > 1 {
+				klog.Warningf("Not starting a BFD session with multihop BGP peer %s", neighborIPStr)
+				continue
+			}
+			nrc.addBFDSession(n.Transport.LocalAddress, neighborIPStr)
+		}
 	}
 	return nil
 }
@@ -374,7 +388,9 @@ func (nrc *NetworkRoutingController) newNodeEventHandler() cache.ResourceEventHa
 			nrc.OnNodeUpdate(obj)
 		},
 		UpdateFunc: func(oldObj, newObj interface{}) {
-			// we are only interested in node add/delete, so skip update
+			// we are only interested in node add/delete, with the exception of wireguard public key changes which
+			// need to be applied to the peers of the wireguard interface
+			nrc.onWireGuardPublicKeyUpdate(oldObj, newObj)
 		},
 		DeleteFunc: func(obj interface{}) {
 			node, ok := obj.(*v1core.Node)
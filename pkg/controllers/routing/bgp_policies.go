@@ -33,6 +33,11 @@ const (
 	iBGPPeerSet       = "iBGPpeerset"
 	iBGPPeerSetV6     = "iBGPpeersetv6"
 
+	bgpPeerExportRejectSet   = "bgppeerexportrejectset"
+	bgpPeerExportRejectSetV6 = "bgppeerexportrejectsetv6"
+	bgpPeerImportRejectSet   = "bgppeerimportrejectset"
+	bgpPeerImportRejectSetV6 = "bgppeerimportrejectsetv6"
+
 	customImportRejectSet = "customimportrejectdefinedset"
 	defaultRouteSet       = "defaultroutedefinedset"
 	defaultRouteSetV6     = "defaultroutedefinedsetv6"
@@ -90,6 +95,11 @@ func (nrc *NetworkRoutingController) AddPolicies() error {
 		klog.Errorf("Failed to add `allpeerset` defined set: %s", err)
 	}
 
+	err = nrc.addBGPPeerRejectDefinedSets()
+	if err != nil {
+		klog.Errorf("Failed to add `bgppeerimportrejectset` and `bgppeerexportrejectset` defined sets: %s", err)
+	}
+
 	err = nrc.addExportPolicies()
 	if err != nil {
 		return err
@@ -175,6 +185,8 @@ func (nrc *NetworkRoutingController) addServiceVIPsDefinedSet() error {
 
 		advIPPrefixList := make([]*gobgpapi.Prefix, 0)
 		advIps, _, _ := nrc.getVIPs()
+		// the egress IPs that this node is the gateway for are advertised to the same peers as the service VIPs
+		advIps = append(advIps, nrc.egressGatewayIPs()...)
 		for _, ipStr := range advIps {
 			ip := net.ParseIP(ipStr)
 			if ip == nil {
@@ -445,9 +457,26 @@ func (nrc *NetworkRoutingController) addiBGPPeersDefinedSet() (map[v1core.IPFami
 }
 
 func (nrc *NetworkRoutingController) addExternalBGPPeersDefinedSet() (map[v1core.IPFamily][]string, error) {
+	externalBgpPeers := nrc.externalBGPPeerAddresses()
+	externalBGPPeerCIDRs := peerAddressesToCIDRs(externalBgpPeers)
+
+	for family, extPeerSetName := range map[v1core.IPFamily]string{
+		v1core.IPv4Protocol: externalPeerSet,
+		v1core.IPv6Protocol: externalPeerSetV6} {
+		// peers of BGPPeer resources come and go, so the set needs to follow them
+		err := nrc.syncNeighborDefinedSet(extPeerSetName, externalBGPPeerCIDRs[family], len(externalBgpPeers) > 0)
+		if err != nil {
+			return externalBGPPeerCIDRs, err
+		}
+	}
+
+	return externalBGPPeerCIDRs, nil
+}
 
+// externalBGPPeerAddresses returns the addresses of all external peers, whether they were configured through flags,
+// node annotations or BGPPeer resources
+func (nrc *NetworkRoutingController) externalBGPPeerAddresses() []string {
 	externalBgpPeers := make([]string, 0)
-	externalBGPPeerCIDRs := make(map[v1core.IPFamily][]string)
 
 	if len(nrc.globalPeerRouters) > 0 {
 		for _, peer := range nrc.globalPeerRouters {
@@ -457,53 +486,149 @@ func (nrc *NetworkRoutingController) addExternalBGPPeersDefinedSet() (map[v1core
 	if len(nrc.nodePeerRouters) > 0 {
 		externalBgpPeers = append(externalBgpPeers, nrc.nodePeerRouters...)
 	}
-	if len(externalBgpPeers) == 0 {
-		return externalBGPPeerCIDRs, nil
+	resourcePeers, _, _ := nrc.bgpPeerResourceAddresses()
+	return append(externalBgpPeers, resourcePeers...)
+}
+
+// addBGPPeerRejectDefinedSets creates the defined sets of the BGPPeer resource peers whose import or export policy
+// rejects all routes
+func (nrc *NetworkRoutingController) addBGPPeerRejectDefinedSets() error {
+	_, importReject, exportReject := nrc.bgpPeerResourceAddresses()
+	importRejectCIDRs := peerAddressesToCIDRs(importReject)
+	exportRejectCIDRs := peerAddressesToCIDRs(exportReject)
+
+	for setName, cidrs := range map[string][]string{
+		bgpPeerImportRejectSet:   importRejectCIDRs[v1core.IPv4Protocol],
+		bgpPeerImportRejectSetV6: importRejectCIDRs[v1core.IPv6Protocol],
+		bgpPeerExportRejectSet:   exportRejectCIDRs[v1core.IPv4Protocol],
+		bgpPeerExportRejectSetV6: exportRejectCIDRs[v1core.IPv6Protocol],
+	} {
+		if err := nrc.syncNeighborDefinedSet(setName, cidrs, false); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	for family, extPeerSetName := range map[v1core.IPFamily]string{
-		v1core.IPv4Protocol: externalPeerSet,
-		v1core.IPv6Protocol: externalPeerSetV6} {
-		currentDefinedSet, err := nrc.getDefinedSetFromGoBGP(extPeerSetName, gobgpapi.DefinedType_NEIGHBOR)
-		if err != nil {
-			return externalBGPPeerCIDRs, err
+// peerAddressesToCIDRs converts peer addresses into the host CIDRs that neighbor defined sets consist of
+func peerAddressesToCIDRs(peers []string) map[v1core.IPFamily][]string {
+	cidrs := make(map[v1core.IPFamily][]string)
+	for _, peer := range peers {
+		ip := net.ParseIP(peer)
+		if ip == nil {
+			klog.Warningf("wasn't able to parse the IP of peer: %s - skipping!", peer)
+			continue
 		}
+		if ip.To4() != nil {
+			cidrs[v1core.IPv4Protocol] = append(cidrs[v1core.IPv4Protocol], peer+"/32")
+		} else if ip.To16() != nil {
+			cidrs[v1core.IPv6Protocol] = append(cidrs[v1core.IPv6Protocol], peer+"/128")
+		}
+	}
+	return cidrs
+}
 
-		for _, peer := range externalBgpPeers {
-			ip := net.ParseIP(peer)
-			if ip == nil {
-				klog.Warningf("wasn't able to parse the IP of peer: %s - skipping!", peer)
-				continue
-			}
-			if ip.To4() != nil {
-				// if we're not currently loading the IPv4 family, move on
-				if family != v1core.IPv4Protocol {
-					continue
-				}
-				externalBGPPeerCIDRs[family] = append(externalBGPPeerCIDRs[family], peer+"/32")
-			} else if ip.To16() != nil {
-				// if we're not currently loading the IPv6 family, move on
-				if family != v1core.IPv6Protocol {
-					continue
-				}
-				externalBGPPeerCIDRs[family] = append(externalBGPPeerCIDRs[family], peer+"/128")
-			}
+// syncNeighborDefinedSet ensures that the neighbor defined set with the given name contains exactly the given CIDRs.
+// A set that doesn't exist yet is only created without any CIDRs when createEmpty is set.
+func (nrc *NetworkRoutingController) syncNeighborDefinedSet(setName string, cidrs []string, createEmpty bool) error {
+	currentDefinedSet, err := nrc.getDefinedSetFromGoBGP(setName, gobgpapi.DefinedType_NEIGHBOR)
+	if err != nil {
+		return err
+	}
+	if currentDefinedSet == nil {
+		if len(cidrs) == 0 && !createEmpty {
+			return nil
 		}
-		if currentDefinedSet == nil {
-			eBGPPeerNS := &gobgpapi.DefinedSet{
+		return nrc.bgpServer.AddDefinedSet(context.Background(), &gobgpapi.AddDefinedSetRequest{
+			DefinedSet: &gobgpapi.DefinedSet{
 				DefinedType: gobgpapi.DefinedType_NEIGHBOR,
-				Name:        extPeerSetName,
-				List:        externalBGPPeerCIDRs[family],
-			}
-			err = nrc.bgpServer.AddDefinedSet(context.Background(),
-				&gobgpapi.AddDefinedSetRequest{DefinedSet: eBGPPeerNS})
-			if err != nil {
-				return externalBGPPeerCIDRs, err
-			}
+				Name:        setName,
+				List:        cidrs,
+			},
+		})
+	}
+
+	current := make(map[string]bool)
+	for _, cidr := range currentDefinedSet.List {
+		current[cidr] = true
+	}
+	wanted := make(map[string]bool)
+	toAdd := make([]string, 0)
+	for _, cidr := range cidrs {
+		wanted[cidr] = true
+		if !current[cidr] {
+			toAdd = append(toAdd, cidr)
+		}
+	}
+	toDelete := make([]string, 0)
+	for _, cidr := range currentDefinedSet.List {
+		if !wanted[cidr] {
+			toDelete = append(toDelete, cidr)
 		}
 	}
 
-	return externalBGPPeerCIDRs, nil
+	if len(toAdd) > 0 {
+		err = nrc.bgpServer.AddDefinedSet(context.Background(), &gobgpapi.AddDefinedSetRequest{
+			DefinedSet: &gobgpapi.DefinedSet{
+				DefinedType: gobgpapi.DefinedType_NEIGHBOR,
+				Name:        setName,
+				List:        toAdd,
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if len(toDelete) > 0 {
+		err = nrc.bgpServer.DeleteDefinedSet(context.Background(), &gobgpapi.DeleteDefinedSetRequest{
+			DefinedSet: &gobgpapi.DefinedSet{
+				DefinedType: gobgpapi.DefinedType_NEIGHBOR,
+				Name:        setName,
+				List:        toDelete,
+			},
+			All: false,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bgpPeerRejectStatements ensures that there is a statement that rejects all routes for each of the given neighbor
+// sets that isn't empty and returns the names of the statements
+func (nrc *NetworkRoutingController) bgpPeerRejectStatements(peerSets []string) ([]string, error) {
+	statementNames := make([]string, 0)
+	actions := gobgpapi.Actions{
+		RouteAction: gobgpapi.RouteAction_REJECT,
+	}
+	for _, peerSet := range peerSets {
+		// the sets are only created once there is a peer to put into them
+		currentDefinedSet, err := nrc.getDefinedSetFromGoBGP(peerSet, gobgpapi.DefinedType_NEIGHBOR)
+		if err != nil {
+			return nil, err
+		}
+		// if the set is empty, then skip it, so we don't have unintentional matches
+		if currentDefinedSet == nil || len(currentDefinedSet.List) == 0 {
+			continue
+		}
+
+		statement := gobgpapi.Statement{
+			Conditions: &gobgpapi.Conditions{
+				NeighborSet: &gobgpapi.MatchSet{
+					Type: gobgpapi.MatchSet_ANY,
+					Name: peerSet,
+				},
+			},
+			Actions: &actions,
+			Name:    peerSet,
+		}
+		if err = nrc.ensureStatementExists(&statement); err != nil {
+			return nil, fmt.Errorf("could not check or create statement: %s - %v", statement.Name, err)
+		}
+		statementNames = append(statementNames, statement.Name)
+	}
+	return statementNames, nil
 }
 
 // a slice of all peers is used as a match condition for reject statement of servicevipsdefinedset import policy
@@ -598,7 +723,11 @@ func (nrc *NetworkRoutingController) addAllBGPPeersDefinedSet(
 //     iBGP peers
 //   - an option to allow overriding the next-hop-address with the outgoing ip for external bgp peers
 func (nrc *NetworkRoutingController) addExportPolicies() error {
-	statementNames := make([]string, 0)
+	// peers whose BGPPeer resource rejects exports are matched first, so that no routes are advertised to them
+	statementNames, err := nrc.bgpPeerRejectStatements([]string{bgpPeerExportRejectSet, bgpPeerExportRejectSetV6})
+	if err != nil {
+		return err
+	}
 
 	var bgpActions gobgpapi.Actions
 	if nrc.pathPrepend {
@@ -653,20 +782,26 @@ func (nrc *NetworkRoutingController) addExportPolicies() error {
 		}
 	}
 
-	if len(nrc.globalPeerRouters) > 0 || len(nrc.nodePeerRouters) > 0 {
+	if len(nrc.externalBGPPeerAddresses()) > 0 {
 
 		bgpActions.RouteAction = gobgpapi.RouteAction_ACCEPT
 		if nrc.overrideNextHop {
 			bgpActions.Nexthop = &gobgpapi.NexthopAction{Self: true}
 		}
 
-		// set BGP communities for the routes advertised to peers for VIPs
+		// set BGP communities and large communities for the routes advertised to peers for VIPs and pod CIDRs
 		if len(nrc.nodeCommunities) > 0 {
 			bgpActions.Community = &gobgpapi.CommunityAction{
 				Type:        gobgpapi.CommunityAction_ADD,
 				Communities: nrc.nodeCommunities,
 			}
 		}
+		if len(nrc.nodeLargeCommunities) > 0 {
+			bgpActions.LargeCommunity = &gobgpapi.CommunityAction{
+				Type:        gobgpapi.CommunityAction_ADD,
+				Communities: nrc.nodeLargeCommunities,
+			}
+		}
 
 		for _, peerSet := range []string{externalPeerSet, externalPeerSetV6} {
 			peerSetEmpty, err := nrc.emptyCheckDefinedSets([]gobgpapi.ListDefinedSetRequest{
@@ -773,7 +908,7 @@ func (nrc *NetworkRoutingController) addExportPolicies() error {
 			currentPolicySameAsNewPolicy = statementsEqualByName(existingPolicy.Statements, newPolicy.Statements)
 		}
 	}
-	err := nrc.bgpServer.ListPolicy(context.Background(), &gobgpapi.ListPolicyRequest{}, checkExistingPolicy)
+	err = nrc.bgpServer.ListPolicy(context.Background(), &gobgpapi.ListPolicyRequest{}, checkExistingPolicy)
 	if err != nil {
 		return errors.New("Failed to verify if kube-router BGP export policy exists: " + err.Error())
 	}
@@ -819,7 +954,11 @@ func (nrc *NetworkRoutingController) addExportPolicies() error {
 //   - do not import Service VIPs advertised from any peers, instead each kube-router originates and injects
 //     Service VIPs into local rib.
 func (nrc *NetworkRoutingController) addImportPolicies() error {
-	statementNames := make([]string, 0)
+	// peers whose BGPPeer resource rejects imports don't need any further statements
+	statementNames, err := nrc.bgpPeerRejectStatements([]string{bgpPeerImportRejectSet, bgpPeerImportRejectSetV6})
+	if err != nil {
+		return err
+	}
 
 	actions := gobgpapi.Actions{
 		RouteAction: gobgpapi.RouteAction_REJECT,
@@ -930,7 +1069,7 @@ func (nrc *NetworkRoutingController) addImportPolicies() error {
 			currentPolicySameAsNewPolicy = statementsEqualByName(existingPolicy.Statements, newPolicy.Statements)
 		}
 	}
-	err := nrc.bgpServer.ListPolicy(context.Background(), &gobgpapi.ListPolicyRequest{}, checkExistingPolicy)
+	err = nrc.bgpServer.ListPolicy(context.Background(), &gobgpapi.ListPolicyRequest{}, checkExistingPolicy)
 	if err != nil {
 		return errors.New("Failed to verify if kube-router BGP export policy exists: " + err.Error())
 	}
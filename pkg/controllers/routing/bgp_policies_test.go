@@ -1439,6 +1439,229 @@ func Test_AddPolicies(t *testing.T) {
 			},
 			nil,
 		},
+		{
+			"has nodes with communities and large communities defined",
+			&NetworkRoutingController{
+				clientset:         fake.NewSimpleClientset(),
+				hostnameOverride:  "node-1",
+				routerID:          "10.6.0.1",
+				localAddressList:  []string{"0.0.0.0"},
+				bgpPort:           10000,
+				bgpFullMeshMode:   false,
+				bgpEnableInternal: false,
+				bgpServer:         gobgp.NewBgpServer(),
+				advertisePodCidr:  true,
+				activeNodes:       make(map[string]bool),
+				podCidr:           "172.26.0.0/24",
+				krNode:            ipv4CapableKRNode,
+				podIPv4CIDRs:      []string{"172.26.0.0/24"},
+				globalPeerRouters: []*gobgpapi.Peer{
+					{
+						Conf: &gobgpapi.PeerConf{
+							NeighborAddress: "10.16.0.1",
+						},
+						Transport: &gobgpapi.Transport{
+							LocalAddress: "10.6.0.1",
+						},
+					},
+					{
+						Conf: &gobgpapi.PeerConf{
+							NeighborAddress: "10.16.0.2",
+						},
+						Transport: &gobgpapi.Transport{
+							LocalAddress: "10.6.0.1",
+						},
+					},
+				},
+				nodeAsnNumber: 100,
+			},
+			[]*v1core.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "node-1",
+						Annotations: map[string]string{
+							"kube-router.io/node.asn":                   "100",
+							"kube-router.io/node.bgp.communities":       "no-export",
+							"kube-router.io/node.bgp.large-communities": "4200000000:1:2, invalid",
+						},
+					},
+					Status: v1core.NodeStatus{
+						Addresses: []v1core.NodeAddress{
+							{
+								Type:    v1core.NodeInternalIP,
+								Address: "10.6.0.2",
+							},
+						},
+					},
+					Spec: v1core.NodeSpec{
+						PodCIDR: "172.26.0.0/24",
+					},
+				},
+			},
+			[]*v1core.Service{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "svc-1",
+						Namespace: "default",
+					},
+					Spec: v1core.ServiceSpec{
+						Type:                  ClusterIPST,
+						ClusterIP:             "10.16.0.1",
+						ExternalIPs:           []string{"1.16.1.1"},
+						InternalTrafficPolicy: &testClusterIntTrafPol,
+						ExternalTrafficPolicy: testClusterExtTrafPol,
+					},
+				},
+			},
+			[]*v1core.Endpoints{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "svc-1",
+						Namespace: "default",
+					},
+					Subsets: []v1core.EndpointSubset{
+						{
+							Addresses: []v1core.EndpointAddress{
+								{
+									IP: testNodeIPv4,
+								},
+							},
+						},
+					},
+				},
+			},
+			&gobgpapi.DefinedSet{
+				DefinedType: gobgpapi.DefinedType_PREFIX,
+				Name:        podCIDRSet,
+				Prefixes: []*gobgpapi.Prefix{
+					{
+						IpPrefix:      "172.26.0.0/24",
+						MaskLengthMin: 24,
+						MaskLengthMax: 24,
+					},
+				},
+			},
+			&gobgpapi.DefinedSet{
+				DefinedType: gobgpapi.DefinedType_PREFIX,
+				Name:        serviceVIPsSet,
+				Prefixes: []*gobgpapi.Prefix{
+					{
+						IpPrefix:      "1.16.1.1/32",
+						MaskLengthMin: 32,
+						MaskLengthMax: 32,
+					},
+					{
+						IpPrefix:      "10.16.0.1/32",
+						MaskLengthMin: 32,
+						MaskLengthMax: 32,
+					},
+				},
+			},
+			&gobgpapi.DefinedSet{
+				DefinedType: gobgpapi.DefinedType_NEIGHBOR,
+				Name:        externalPeerSet,
+				List:        []string{"10.16.0.1/32", "10.16.0.2/32"},
+			},
+			&gobgpapi.DefinedSet{
+				DefinedType: gobgpapi.DefinedType_NEIGHBOR,
+				Name:        allPeerSet,
+				List:        []string{"10.16.0.1/32", "10.16.0.2/32"},
+			},
+			&gobgpapi.DefinedSet{
+				DefinedType: gobgpapi.DefinedType_PREFIX,
+				Name:        customImportRejectSet,
+				Prefixes:    []*gobgpapi.Prefix{},
+			},
+			[]*gobgpapi.Statement{
+				{
+					Name: "kube_router_export_stmt0",
+					Conditions: &gobgpapi.Conditions{
+						PrefixSet: &gobgpapi.MatchSet{
+							Type: gobgpapi.MatchSet_ANY,
+							Name: serviceVIPsSet,
+						},
+						NeighborSet: &gobgpapi.MatchSet{
+							Type: gobgpapi.MatchSet_ANY,
+							Name: externalPeerSet,
+						},
+						RpkiResult: -1,
+					},
+					Actions: &gobgpapi.Actions{
+						Community: &gobgpapi.CommunityAction{
+							Type:        gobgpapi.CommunityAction_ADD,
+							Communities: []string{"65535:65281"}, // corresponds to no-export
+						},
+						LargeCommunity: &gobgpapi.CommunityAction{
+							Type:        gobgpapi.CommunityAction_ADD,
+							Communities: []string{"4200000000:1:2"},
+						},
+						RouteAction: gobgpapi.RouteAction_ACCEPT,
+					},
+				},
+				{
+					Name: "kube_router_export_stmt1",
+					Conditions: &gobgpapi.Conditions{
+						PrefixSet: &gobgpapi.MatchSet{
+							Type: gobgpapi.MatchSet_ANY,
+							Name: podCIDRSet,
+						},
+						NeighborSet: &gobgpapi.MatchSet{
+							Type: gobgpapi.MatchSet_ANY,
+							Name: externalPeerSet,
+						},
+						RpkiResult: -1,
+					},
+					Actions: &gobgpapi.Actions{
+						Community: &gobgpapi.CommunityAction{
+							Type:        gobgpapi.CommunityAction_ADD,
+							Communities: []string{"65535:65281"}, // corresponds to no-export
+						},
+						LargeCommunity: &gobgpapi.CommunityAction{
+							Type:        gobgpapi.CommunityAction_ADD,
+							Communities: []string{"4200000000:1:2"},
+						},
+						RouteAction: gobgpapi.RouteAction_ACCEPT,
+					},
+				},
+			},
+			[]*gobgpapi.Statement{
+				{
+					Name: "kube_router_import_stmt0",
+					Conditions: &gobgpapi.Conditions{
+						PrefixSet: &gobgpapi.MatchSet{
+							Type: gobgpapi.MatchSet_ANY,
+							Name: serviceVIPsSet,
+						},
+						NeighborSet: &gobgpapi.MatchSet{
+							Type: gobgpapi.MatchSet_ANY,
+							Name: allPeerSet,
+						},
+						RpkiResult: -1,
+					},
+					Actions: &gobgpapi.Actions{
+						RouteAction: gobgpapi.RouteAction_REJECT,
+					},
+				},
+				{
+					Name: "kube_router_import_stmt1",
+					Conditions: &gobgpapi.Conditions{
+						PrefixSet: &gobgpapi.MatchSet{
+							Type: gobgpapi.MatchSet_ANY,
+							Name: defaultRouteSet,
+						},
+						NeighborSet: &gobgpapi.MatchSet{
+							Type: gobgpapi.MatchSet_ANY,
+							Name: allPeerSet,
+						},
+						RpkiResult: -1,
+					},
+					Actions: &gobgpapi.Actions{
+						RouteAction: gobgpapi.RouteAction_REJECT,
+					},
+				},
+			},
+			nil,
+		},
 	}
 
 	for _, testcase := range testcases {
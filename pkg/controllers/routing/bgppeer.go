@@ -0,0 +1,327 @@
+package routing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/cloudnativelabs/kube-router/v2/pkg/apis/kuberouter/v1alpha1"
+	gobgpapi "github.com/osrg/gobgp/v3/api"
+	"google.golang.org/protobuf/proto"
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+const (
+	maxBGPHoldTimeSeconds = 65535
+	maxMultihopTTL        = 255
+)
+
+// bgpPeerResource is an external BGP peer that was configured from a BGPPeer resource
+type bgpPeerResource struct {
+	// name of the BGPPeer resource
+	name string
+	// peer is the neighbor configuration as derived from the resource, before connectToExternalBGPPeers adds the
+	// graceful restart and multihop settings to it
+	peer         *gobgpapi.Peer
+	multihopTTL  uint8
+	bfd          *bool
+	importPolicy v1alpha1.BGPPolicyAction
+	exportPolicy v1alpha1.BGPPolicyAction
+}
+
+// sessionEqual checks whether the BGP session with the peer needs to be recreated to apply the other configuration,
+// which is the case for everything except the import and export policies
+func (r *bgpPeerResource) sessionEqual(other *bgpPeerResource) bool {
+	if !proto.Equal(r.peer, other.peer) || r.multihopTTL != other.multihopTTL {
+		return false
+	}
+	if r.bfd == nil || other.bfd == nil {
+		return r.bfd == nil && other.bfd == nil
+	}
+	return *r.bfd == *other.bfd
+}
+
+func (nrc *NetworkRoutingController) newBGPPeerEventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			nrc.OnBGPPeerUpdate(obj)
+		},
+		UpdateFunc: func(_, newObj interface{}) {
+			nrc.OnBGPPeerUpdate(newObj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			nrc.OnBGPPeerUpdate(obj)
+		},
+	}
+}
+
+// OnBGPPeerUpdate Handle updates from the BGPPeer watcher. Peers are reconciled with all BGPPeer resources whenever
+// one of them is added, changed or removed, so that changes are applied without restarting kube-router.
+func (nrc *NetworkRoutingController) OnBGPPeerUpdate(_ interface{}) {
+	if !nrc.bgpServerStarted {
+		return
+	}
+
+	nrc.syncBGPPeers()
+
+	// update policies so that the neighbor sets get updated with the new set of peers
+	if err := nrc.AddPolicies(); err != nil {
+		klog.Errorf("Error adding BGP policies: %s", err.Error())
+	}
+}
+
+// syncBGPPeers reconciles the external BGP peers of the node with the BGPPeer resources that select the node. Peers
+// whose resource was removed, or doesn't select the node any longer, are deleted and peers whose session
+// configuration changed are recreated.
+func (nrc *NetworkRoutingController) syncBGPPeers() {
+	if nrc.bgpPeerLister == nil {
+		return
+	}
+
+	nrc.bgpPeersMu.Lock()
+	defer nrc.bgpPeersMu.Unlock()
+
+	desired := nrc.desiredBGPPeerResources()
+
+	for address, current := range nrc.bgpPeerResources {
+		if want, ok := desired[address]; ok && current.sessionEqual(want) {
+			current.importPolicy = want.importPolicy
+			current.exportPolicy = want.exportPolicy
+			continue
+		}
+		klog.Infof("Removing BGP peer %s of BGPPeer %s", address, current.name)
+		if err := nrc.bgpServer.DeletePeer(context.Background(),
+			&gobgpapi.DeletePeerRequest{Address: address}); err != nil {
+			klog.Errorf("Failed to remove BGP peer %s of BGPPeer %s: %v", address, current.name, err)
+			continue
+		}
+		nrc.removeBFDSession(address)
+		nrc.setPeerBFD(address, nil)
+		delete(nrc.bgpPeerResources, address)
+	}
+
+	for address, want := range desired {
+		if _, ok := nrc.bgpPeerResources[address]; ok {
+			continue
+		}
+		klog.Infof("Adding BGP peer %s of BGPPeer %s", address, want.name)
+		nrc.setPeerBFD(address, want.bfd)
+		// connectToExternalBGPPeers adds graceful restart and multihop settings to the peer, so hand it a copy to
+		// keep the configuration that future syncs compare with
+		peer, _ := proto.Clone(want.peer).(*gobgpapi.Peer)
+		if err := nrc.connectToExternalBGPPeers(nrc.bgpServer, []*gobgpapi.Peer{peer}, nrc.bgpGracefulRestart,
+			nrc.bgpGracefulRestartDeferralTime, nrc.bgpGracefulRestartTime, want.multihopTTL); err != nil {
+			klog.Errorf("Failed to add BGP peer %s of BGPPeer %s: %v", address, want.name, err)
+			nrc.setPeerBFD(address, nil)
+			continue
+		}
+		nrc.bgpPeerResources[address] = want
+	}
+}
+
+// desiredBGPPeerResources returns the peers of all BGPPeer resources that select the node by peer address. Callers
+// must hold nrc.bgpPeersMu.
+func (nrc *NetworkRoutingController) desiredBGPPeerResources() map[string]*bgpPeerResource {
+	desired := make(map[string]*bgpPeerResource)
+
+	var nodeLabels labels.Set
+	if obj, exists, err := nrc.nodeLister.GetByKey(nrc.krNode.GetNodeName()); err == nil && exists {
+		if node, ok := obj.(*v1core.Node); ok {
+			nodeLabels = node.Labels
+		}
+	}
+
+	// peers configured through flags or node annotations take precedence over BGPPeer resources
+	configuredPeers := make(map[string]bool)
+	for _, peer := range nrc.globalPeerRouters {
+		configuredPeers[peer.Conf.NeighborAddress] = true
+	}
+
+	bgpPeers := make([]*v1alpha1.BGPPeer, 0)
+	for _, obj := range nrc.bgpPeerLister.List() {
+		bgpPeer, err := bgpPeerFromObject(obj)
+		if err != nil {
+			klog.Errorf("Failed to parse BGPPeer: %v", err)
+			continue
+		}
+		bgpPeers = append(bgpPeers, bgpPeer)
+	}
+	// sort the resources, so that the same one wins every time when several of them use the same peer address
+	sort.Slice(bgpPeers, func(i, j int) bool {
+		return bgpPeers[i].Name < bgpPeers[j].Name
+	})
+
+	for _, bgpPeer := range bgpPeers {
+		selected, err := bgpPeerSelectsNode(bgpPeer, nodeLabels)
+		if err != nil {
+			klog.Errorf("Invalid node selector in BGPPeer %s: %v", bgpPeer.Name, err)
+			continue
+		}
+		if !selected {
+			continue
+		}
+
+		resource, err := nrc.newBGPPeerResource(bgpPeer)
+		if err != nil {
+			klog.Errorf("Invalid BGPPeer %s: %v", bgpPeer.Name, err)
+			continue
+		}
+		address := resource.peer.Conf.NeighborAddress
+		if configuredPeers[address] {
+			klog.Warningf("Ignoring BGPPeer %s as peer %s is already configured through flags or node annotations",
+				bgpPeer.Name, address)
+			continue
+		}
+		if other, ok := desired[address]; ok {
+			klog.Warningf("Ignoring BGPPeer %s as peer %s is already configured by BGPPeer %s", bgpPeer.Name,
+				address, other.name)
+			continue
+		}
+		desired[address] = resource
+	}
+	return desired
+}
+
+// newBGPPeerResource validates a BGPPeer resource and derives the neighbor configuration from it
+func (nrc *NetworkRoutingController) newBGPPeerResource(bgpPeer *v1alpha1.BGPPeer) (*bgpPeerResource, error) {
+	spec := bgpPeer.Spec
+
+	ip := net.ParseIP(spec.PeerAddress)
+	if ip == nil {
+		return nil, fmt.Errorf("could not parse peer address \"%s\" as an IP", spec.PeerAddress)
+	}
+	var ports []uint32
+	if spec.PeerPort != 0 {
+		ports = []uint32{spec.PeerPort}
+	}
+	var localIPs []string
+	if spec.LocalAddress != "" {
+		if net.ParseIP(spec.LocalAddress) == nil {
+			return nil, fmt.Errorf("could not parse local address \"%s\" as an IP", spec.LocalAddress)
+		}
+		localIPs = []string{spec.LocalAddress}
+	}
+	var passwords []string
+	if spec.PasswordSecretRef != nil {
+		password, err := nrc.bgpPeerPassword(spec.PasswordSecretRef)
+		if err != nil {
+			return nil, err
+		}
+		passwords = []string{password}
+	}
+	if spec.EBGPMultihopTTL > maxMultihopTTL {
+		return nil, fmt.Errorf("ebgpMultihopTTL must not be greater than %d", maxMultihopTTL)
+	}
+	for _, policy := range []v1alpha1.BGPPolicyAction{spec.ImportPolicy, spec.ExportPolicy} {
+		if policy != "" && policy != v1alpha1.BGPPolicyAccept && policy != v1alpha1.BGPPolicyReject {
+			return nil, fmt.Errorf("policy must be %s or %s, not %s", v1alpha1.BGPPolicyAccept,
+				v1alpha1.BGPPolicyReject, policy)
+		}
+	}
+
+	peers, err := newGlobalPeers([]net.IP{ip}, ports, []uint32{spec.PeerASN}, passwords, localIPs, nrc.bgpHoldtime,
+		nrc.krNode.GetPrimaryNodeIP().String())
+	if err != nil {
+		return nil, err
+	}
+	peer := peers[0]
+
+	if spec.Timers != nil {
+		if spec.Timers.HoldTime != nil {
+			holdTime := spec.Timers.HoldTime.Seconds()
+			if holdTime < 3 || holdTime > maxBGPHoldTimeSeconds {
+				return nil, errors.New("holdTime must be in the range 3s to 18h12m15s")
+			}
+			peer.Timers.Config.HoldTime = uint64(holdTime)
+		}
+		if spec.Timers.KeepaliveInterval != nil {
+			peer.Timers.Config.KeepaliveInterval = uint64(spec.Timers.KeepaliveInterval.Seconds())
+		}
+		if spec.Timers.ConnectRetry != nil {
+			peer.Timers.Config.ConnectRetry = uint64(spec.Timers.ConnectRetry.Seconds())
+		}
+	}
+
+	return &bgpPeerResource{
+		name:         bgpPeer.Name,
+		peer:         peer,
+		multihopTTL:  uint8(spec.EBGPMultihopTTL),
+		bfd:          spec.BFD,
+		importPolicy: spec.ImportPolicy,
+		exportPolicy: spec.ExportPolicy,
+	}, nil
+}
+
+// bgpPeerPassword reads the password of a peer from the referenced secret
+func (nrc *NetworkRoutingController) bgpPeerPassword(ref *v1alpha1.SecretKeyReference) (string, error) {
+	if nrc.secretLister == nil {
+		return "", fmt.Errorf("password secret %s/%s can't be read, --bgp-peer-secrets-namespace is not set",
+			ref.Namespace, ref.Name)
+	}
+	obj, exists, err := nrc.secretLister.GetByKey(ref.Namespace + "/" + ref.Name)
+	if err != nil {
+		return "", fmt.Errorf("failed to get password secret %s/%s: %v", ref.Namespace, ref.Name, err)
+	}
+	secret, ok := obj.(*v1core.Secret)
+	if !exists || !ok {
+		return "", fmt.Errorf("password secret %s/%s not found, only secrets in the namespace given by "+
+			"--bgp-peer-secrets-namespace can be used", ref.Namespace, ref.Name)
+	}
+	password, ok := secret.Data[ref.Key]
+	if !ok {
+		return "", fmt.Errorf("password secret %s/%s has no key %s", ref.Namespace, ref.Name, ref.Key)
+	}
+	return string(password), nil
+}
+
+// bgpPeerSelectsNode checks whether the node selector of the BGPPeer matches the labels of the node, a BGPPeer
+// without a node selector selects all nodes
+func bgpPeerSelectsNode(bgpPeer *v1alpha1.BGPPeer, nodeLabels labels.Set) (bool, error) {
+	if bgpPeer.Spec.NodeSelector == nil {
+		return true, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(bgpPeer.Spec.NodeSelector)
+	if err != nil {
+		return false, err
+	}
+	return selector.Matches(nodeLabels), nil
+}
+
+// bgpPeerFromObject converts an object of the BGPPeer informer into a BGPPeer
+func bgpPeerFromObject(obj interface{}) (*v1alpha1.BGPPeer, error) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, fmt.Errorf("unexpected object type: %T", obj)
+	}
+	bgpPeer := &v1alpha1.BGPPeer{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, bgpPeer); err != nil {
+		return nil, fmt.Errorf("failed to convert %s: %v", u.GetName(), err)
+	}
+	return bgpPeer, nil
+}
+
+// bgpPeerResourceAddresses returns the addresses of the peers that were configured from BGPPeer resources, along with
+// the addresses of the peers whose import or export policy rejects all routes
+func (nrc *NetworkRoutingController) bgpPeerResourceAddresses() (all, importReject, exportReject []string) {
+	nrc.bgpPeersMu.Lock()
+	defer nrc.bgpPeersMu.Unlock()
+
+	for address, resource := range nrc.bgpPeerResources {
+		all = append(all, address)
+		if resource.importPolicy == v1alpha1.BGPPolicyReject {
+			importReject = append(importReject, address)
+		}
+		if resource.exportPolicy == v1alpha1.BGPPolicyReject {
+			exportReject = append(exportReject, address)
+		}
+	}
+	return all, importReject, exportReject
+}
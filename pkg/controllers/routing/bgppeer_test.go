@@ -0,0 +1,300 @@
+package routing
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/v2/pkg/apis/kuberouter/v1alpha1"
+	"github.com/cloudnativelabs/kube-router/v2/pkg/utils"
+	gobgpapi "github.com/osrg/gobgp/v3/api"
+	gobgp "github.com/osrg/gobgp/v3/pkg/server"
+	"github.com/stretchr/testify/assert"
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/cache"
+)
+
+func newTestBGPPeer(t *testing.T, name string, spec v1alpha1.BGPPeerSpec) *unstructured.Unstructured {
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&v1alpha1.BGPPeer{
+		TypeMeta:   metav1.TypeMeta{APIVersion: v1alpha1.SchemeGroupVersion.String(), Kind: v1alpha1.BGPPeerKind},
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       spec,
+	})
+	assert.NoError(t, err)
+	return &unstructured.Unstructured{Object: obj}
+}
+
+func newTestBGPPeerController(t *testing.T, nodeLabels map[string]string,
+	bgpPeers ...*unstructured.Unstructured) *NetworkRoutingController {
+	nodeLister := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	assert.NoError(t, nodeLister.Add(&v1core.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1", Labels: nodeLabels}}))
+	bgpPeerLister := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, bgpPeer := range bgpPeers {
+		assert.NoError(t, bgpPeerLister.Add(bgpPeer))
+	}
+
+	secretLister := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	assert.NoError(t, secretLister.Add(&v1core.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "bgp-passwords", Namespace: "kube-system"},
+		Data:       map[string][]byte{"router": []byte("secret")},
+	}))
+
+	return &NetworkRoutingController{
+		krNode: &utils.LocalKRNode{
+			KRNode: utils.KRNode{
+				NodeName:  "node-1",
+				PrimaryIP: net.ParseIP("10.0.0.10"),
+			},
+		},
+		bgpHoldtime:      90,
+		nodeLister:       nodeLister,
+		bgpPeerLister:    bgpPeerLister,
+		secretLister:     secretLister,
+		bgpPeerResources: make(map[string]*bgpPeerResource),
+		bfdPeers:         make(map[string]bool),
+	}
+}
+
+func Test_newBGPPeerResource(t *testing.T) {
+	nrc := newTestBGPPeerController(t, nil)
+
+	testcases := []struct {
+		name     string
+		spec     v1alpha1.BGPPeerSpec
+		validate func(t *testing.T, resource *bgpPeerResource)
+		err      bool
+	}{
+		{
+			name: "defaults are taken from the controller",
+			spec: v1alpha1.BGPPeerSpec{PeerAddress: "192.168.1.1", PeerASN: 64512},
+			validate: func(t *testing.T, resource *bgpPeerResource) {
+				assert.Equal(t, "192.168.1.1", resource.peer.Conf.NeighborAddress)
+				assert.Equal(t, uint32(64512), resource.peer.Conf.PeerAsn)
+				assert.Equal(t, uint64(90), resource.peer.Timers.Config.HoldTime)
+				assert.Equal(t, "10.0.0.10", resource.peer.Transport.LocalAddress)
+				assert.Empty(t, resource.peer.Conf.AuthPassword)
+			},
+		},
+		{
+			name: "password is read from the secret",
+			spec: v1alpha1.BGPPeerSpec{
+				PeerAddress: "192.168.1.1",
+				PeerASN:     64512,
+				PasswordSecretRef: &v1alpha1.SecretKeyReference{
+					Name: "bgp-passwords", Namespace: "kube-system", Key: "router",
+				},
+			},
+			validate: func(t *testing.T, resource *bgpPeerResource) {
+				assert.Equal(t, "secret", resource.peer.Conf.AuthPassword)
+			},
+		},
+		{
+			name: "timers override the defaults",
+			spec: v1alpha1.BGPPeerSpec{
+				PeerAddress:  "192.168.1.1",
+				PeerASN:      64512,
+				LocalAddress: "10.0.0.11",
+				PeerPort:     1790,
+				Timers: &v1alpha1.BGPTimers{
+					HoldTime:          &metav1.Duration{Duration: 9 * time.Second},
+					KeepaliveInterval: &metav1.Duration{Duration: 3 * time.Second},
+					ConnectRetry:      &metav1.Duration{Duration: 5 * time.Second},
+				},
+			},
+			validate: func(t *testing.T, resource *bgpPeerResource) {
+				assert.Equal(t, uint64(9), resource.peer.Timers.Config.HoldTime)
+				assert.Equal(t, uint64(3), resource.peer.Timers.Config.KeepaliveInterval)
+				assert.Equal(t, uint64(5), resource.peer.Timers.Config.ConnectRetry)
+				assert.Equal(t, "10.0.0.11", resource.peer.Transport.LocalAddress)
+				assert.Equal(t, uint32(1790), resource.peer.Transport.RemotePort)
+			},
+		},
+		{
+			name: "missing password key is an error",
+			spec: v1alpha1.BGPPeerSpec{
+				PeerAddress: "192.168.1.1",
+				PeerASN:     64512,
+				PasswordSecretRef: &v1alpha1.SecretKeyReference{
+					Name: "bgp-passwords", Namespace: "kube-system", Key: "missing",
+				},
+			},
+			err: true,
+		},
+		{
+			name: "secrets outside of the watched namespace are an error",
+			spec: v1alpha1.BGPPeerSpec{
+				PeerAddress: "192.168.1.1",
+				PeerASN:     64512,
+				PasswordSecretRef: &v1alpha1.SecretKeyReference{
+					Name: "bgp-passwords", Namespace: "default", Key: "router",
+				},
+			},
+			err: true,
+		},
+		{
+			name: "invalid peer address",
+			spec: v1alpha1.BGPPeerSpec{PeerAddress: "192.168.1", PeerASN: 64512},
+			err:  true,
+		},
+		{
+			name: "too short hold time",
+			spec: v1alpha1.BGPPeerSpec{
+				PeerAddress: "192.168.1.1",
+				PeerASN:     64512,
+				Timers:      &v1alpha1.BGPTimers{HoldTime: &metav1.Duration{Duration: time.Second}},
+			},
+			err: true,
+		},
+		{
+			name: "too large multihop TTL",
+			spec: v1alpha1.BGPPeerSpec{PeerAddress: "192.168.1.1", PeerASN: 64512, EBGPMultihopTTL: 256},
+			err:  true,
+		},
+		{
+			name: "unknown policy",
+			spec: v1alpha1.BGPPeerSpec{PeerAddress: "192.168.1.1", PeerASN: 64512, ImportPolicy: "Drop"},
+			err:  true,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			resource, err := nrc.newBGPPeerResource(&v1alpha1.BGPPeer{
+				ObjectMeta: metav1.ObjectMeta{Name: "peer"},
+				Spec:       tc.spec,
+			})
+			if tc.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			tc.validate(t, resource)
+		})
+	}
+}
+
+func Test_bgpPeerSelectsNode(t *testing.T) {
+	nodeLabels := labels.Set{"rack": "r1"}
+
+	selected, err := bgpPeerSelectsNode(&v1alpha1.BGPPeer{}, nodeLabels)
+	assert.NoError(t, err)
+	assert.True(t, selected, "BGPPeer without node selector selects all nodes")
+
+	selected, err = bgpPeerSelectsNode(&v1alpha1.BGPPeer{Spec: v1alpha1.BGPPeerSpec{
+		NodeSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"rack": "r1"}},
+	}}, nodeLabels)
+	assert.NoError(t, err)
+	assert.True(t, selected)
+
+	selected, err = bgpPeerSelectsNode(&v1alpha1.BGPPeer{Spec: v1alpha1.BGPPeerSpec{
+		NodeSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"rack": "r2"}},
+	}}, nodeLabels)
+	assert.NoError(t, err)
+	assert.False(t, selected)
+
+	_, err = bgpPeerSelectsNode(&v1alpha1.BGPPeer{Spec: v1alpha1.BGPPeerSpec{
+		NodeSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+			{Key: "rack", Operator: "Near"},
+		}},
+	}}, nodeLabels)
+	assert.Error(t, err)
+}
+
+func Test_desiredBGPPeerResources(t *testing.T) {
+	nrc := newTestBGPPeerController(t, map[string]string{"rack": "r1"},
+		newTestBGPPeer(t, "all-nodes", v1alpha1.BGPPeerSpec{PeerAddress: "192.168.1.1", PeerASN: 64512}),
+		newTestBGPPeer(t, "rack-r1", v1alpha1.BGPPeerSpec{
+			PeerAddress:  "192.168.1.2",
+			PeerASN:      64513,
+			NodeSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"rack": "r1"}},
+		}),
+		newTestBGPPeer(t, "rack-r2", v1alpha1.BGPPeerSpec{
+			PeerAddress:  "192.168.1.3",
+			PeerASN:      64514,
+			NodeSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"rack": "r2"}},
+		}),
+		newTestBGPPeer(t, "a-duplicate", v1alpha1.BGPPeerSpec{PeerAddress: "192.168.1.2", PeerASN: 64515}),
+		newTestBGPPeer(t, "flag-peer", v1alpha1.BGPPeerSpec{PeerAddress: "192.168.1.4", PeerASN: 64516}),
+		newTestBGPPeer(t, "invalid", v1alpha1.BGPPeerSpec{PeerAddress: "not-an-ip", PeerASN: 64517}),
+	)
+	nrc.globalPeerRouters = []*gobgpapi.Peer{{Conf: &gobgpapi.PeerConf{NeighborAddress: "192.168.1.4"}}}
+
+	desired := nrc.desiredBGPPeerResources()
+
+	assert.Len(t, desired, 2)
+	assert.Equal(t, "all-nodes", desired["192.168.1.1"].name)
+	assert.Equal(t, "a-duplicate", desired["192.168.1.2"].name, "first resource by name wins for duplicate peers")
+}
+
+func Test_bgpPeerResourceSessionEqual(t *testing.T) {
+	enabled, disabled := true, false
+	newResource := func(asn uint32, ttl uint8, bfd *bool, policy v1alpha1.BGPPolicyAction) *bgpPeerResource {
+		return &bgpPeerResource{
+			peer:         &gobgpapi.Peer{Conf: &gobgpapi.PeerConf{NeighborAddress: "192.168.1.1", PeerAsn: asn}},
+			multihopTTL:  ttl,
+			bfd:          bfd,
+			importPolicy: policy,
+		}
+	}
+
+	base := newResource(64512, 0, nil, "")
+	assert.True(t, base.sessionEqual(newResource(64512, 0, nil, v1alpha1.BGPPolicyReject)),
+		"policies are updated without recreating the session")
+	assert.False(t, base.sessionEqual(newResource(64513, 0, nil, "")))
+	assert.False(t, base.sessionEqual(newResource(64512, 2, nil, "")))
+	assert.False(t, base.sessionEqual(newResource(64512, 0, &enabled, "")))
+	assert.True(t, newResource(64512, 0, &disabled, "").sessionEqual(newResource(64512, 0, &disabled, "")))
+	assert.False(t, newResource(64512, 0, &disabled, "").sessionEqual(newResource(64512, 0, &enabled, "")))
+}
+
+func Test_syncBGPPeers(t *testing.T) {
+	nrc := newTestBGPPeerController(t, nil,
+		newTestBGPPeer(t, "router-1", v1alpha1.BGPPeerSpec{PeerAddress: "192.168.1.1", PeerASN: 64512}))
+	nrc.bgpServer = gobgp.NewBgpServer()
+	go nrc.bgpServer.Serve()
+	err := nrc.bgpServer.StartBgp(context.Background(), &gobgpapi.StartBgpRequest{
+		Global: &gobgpapi.Global{Asn: 64500, RouterId: "10.0.0.10", ListenPort: -1},
+	})
+	assert.NoError(t, err)
+	defer func() {
+		_ = nrc.bgpServer.StopBgp(context.Background(), &gobgpapi.StopBgpRequest{})
+	}()
+
+	listPeers := func() map[string]uint32 {
+		peers := make(map[string]uint32)
+		err := nrc.bgpServer.ListPeer(context.Background(), &gobgpapi.ListPeerRequest{}, func(p *gobgpapi.Peer) {
+			peers[p.Conf.NeighborAddress] = p.Conf.PeerAsn
+		})
+		assert.NoError(t, err)
+		return peers
+	}
+
+	nrc.syncBGPPeers()
+	assert.Equal(t, map[string]uint32{"192.168.1.1": 64512}, listPeers())
+
+	// changing the session configuration recreates the peer
+	assert.NoError(t, nrc.bgpPeerLister.Update(
+		newTestBGPPeer(t, "router-1", v1alpha1.BGPPeerSpec{PeerAddress: "192.168.1.1", PeerASN: 64513})))
+	nrc.syncBGPPeers()
+	assert.Equal(t, map[string]uint32{"192.168.1.1": 64513}, listPeers())
+
+	// changing the policy only updates the resource
+	assert.NoError(t, nrc.bgpPeerLister.Update(newTestBGPPeer(t, "router-1", v1alpha1.BGPPeerSpec{
+		PeerAddress: "192.168.1.1", PeerASN: 64513, ExportPolicy: v1alpha1.BGPPolicyReject,
+	})))
+	nrc.syncBGPPeers()
+	all, importReject, exportReject := nrc.bgpPeerResourceAddresses()
+	assert.Equal(t, []string{"192.168.1.1"}, all)
+	assert.Empty(t, importReject)
+	assert.Equal(t, []string{"192.168.1.1"}, exportReject)
+
+	// removing the resource removes the peer
+	assert.NoError(t, nrc.bgpPeerLister.Delete(newTestBGPPeer(t, "router-1", v1alpha1.BGPPeerSpec{})))
+	nrc.syncBGPPeers()
+	assert.Empty(t, listPeers())
+	assert.Empty(t, nrc.bgpPeerResources)
+}
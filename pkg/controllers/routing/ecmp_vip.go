@@ -1,13 +1,18 @@
 package routing
 
 import (
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
+	"slices"
 	"strconv"
+	"strings"
 
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/anypb"
 
+	"github.com/cloudnativelabs/kube-router/v2/pkg/bgp"
 	"github.com/cloudnativelabs/kube-router/v2/pkg/metrics"
 	"github.com/cloudnativelabs/kube-router/v2/pkg/utils"
 
@@ -18,8 +23,17 @@ import (
 	"k8s.io/klog/v2"
 )
 
-// bgpAdvertiseVIP advertises the service vip (cluster ip or load balancer ip or external IP) the configured peers
-func (nrc *NetworkRoutingController) bgpAdvertiseVIP(vip string) error {
+// vipPathAttributes holds the BGP path attributes that services add to the paths of their VIPs through the
+// kube-router.io/service.bgp.* annotations
+type vipPathAttributes struct {
+	communities      []uint32
+	largeCommunities []*gobgpapi.LargeCommunity
+	localPref        uint32
+}
+
+// bgpAdvertiseVIP advertises the service vip (cluster ip or load balancer ip or external IP) the configured peers,
+// pathAttrs may be nil when no service sets any path attributes for the vip
+func (nrc *NetworkRoutingController) bgpAdvertiseVIP(vip string, pathAttrs *vipPathAttributes) error {
 	subnet, nh, afiFamily, err := nrc.getBGPRouteInfoForVIP(vip)
 	if err != nil {
 		return fmt.Errorf("unable to advertise VIP because of: %v", err)
@@ -34,6 +48,9 @@ func (nrc *NetworkRoutingController) bgpAdvertiseVIP(vip string) error {
 		NextHop: nh,
 	})
 	attrs := []*anypb.Any{a1, a2}
+	if pathAttrs != nil {
+		attrs = append(attrs, pathAttrs.toAny()...)
+	}
 	nlri1, _ := anypb.New(&gobgpapi.IPAddressPrefix{
 		Prefix:    vip,
 		PrefixLen: subnet,
@@ -91,8 +108,12 @@ func (nrc *NetworkRoutingController) bgpWithdrawVIP(vip string) error {
 }
 
 func (nrc *NetworkRoutingController) advertiseVIPs(vips []string) {
+	if len(vips) == 0 {
+		return
+	}
+	pathAttrs := nrc.getVIPPathAttributes()
 	for _, vip := range vips {
-		err := nrc.bgpAdvertiseVIP(vip)
+		err := nrc.bgpAdvertiseVIP(vip, pathAttrs[vip])
 		if err != nil {
 			klog.Errorf("error advertising IP: %q, error: %v", vip, err)
 		}
@@ -108,6 +129,136 @@ func (nrc *NetworkRoutingController) withdrawVIPs(vips []string) {
 	}
 }
 
+// getVIPPathAttributes returns the path attributes of every VIP that belongs to a service that sets any of them. When
+// several services share a VIP, the paths get the communities of all of them and the highest local preference.
+func (nrc *NetworkRoutingController) getVIPPathAttributes() map[string]*vipPathAttributes {
+	vipAttrs := make(map[string]*vipPathAttributes)
+	for _, obj := range nrc.svcLister.List() {
+		svc, ok := obj.(*v1core.Service)
+		if !ok {
+			continue
+		}
+		svcAttrs := serviceVIPPathAttributes(svc)
+		if svcAttrs == nil {
+			continue
+		}
+
+		//nolint:gocritic // we understand that we're assigning to a new slice
+		vips := append(nrc.getClusterIP(svc), nrc.getExternalIPs(svc)...)
+		vips = append(vips, nrc.getLoadBalancerIPs(svc)...)
+		for _, vip := range vips {
+			if attrs, ok := vipAttrs[vip]; ok {
+				attrs.merge(svcAttrs)
+				continue
+			}
+			vipAttrs[vip] = svcAttrs.copy()
+		}
+	}
+	return vipAttrs
+}
+
+// serviceVIPPathAttributes parses the path attribute annotations of the service, values that can't be parsed are
+// skipped. It returns nil when the service doesn't set any path attributes.
+func serviceVIPPathAttributes(svc *v1core.Service) *vipPathAttributes {
+	attrs := &vipPathAttributes{}
+	if value, ok := svc.Annotations[svcCommunitiesAnnotation]; ok {
+		for _, communityStr := range stringToSlice(value, ",") {
+			communityStr = strings.TrimSpace(communityStr)
+			community, err := bgp.ParseCommunity(communityStr)
+			if err != nil {
+				klog.Warningf("cannot add BGP community '%s' from annotation of service %s/%s: %v", communityStr,
+					svc.Namespace, svc.Name, err)
+				continue
+			}
+			attrs.communities = append(attrs.communities, community)
+		}
+	}
+	if value, ok := svc.Annotations[svcLargeCommunitiesAnnotation]; ok {
+		for _, largeCommunityStr := range stringToSlice(value, ",") {
+			largeCommunityStr = strings.TrimSpace(largeCommunityStr)
+			largeCommunity, err := bgp.ParseLargeCommunity(largeCommunityStr)
+			if err != nil {
+				klog.Warningf("cannot add BGP large community '%s' from annotation of service %s/%s: %v",
+					largeCommunityStr, svc.Namespace, svc.Name, err)
+				continue
+			}
+			attrs.largeCommunities = append(attrs.largeCommunities, largeCommunity)
+		}
+	}
+	if value, ok := svc.Annotations[svcLocalPrefAnnotation]; ok {
+		localPref, err := strconv.ParseUint(strings.TrimSpace(value), 10, 32)
+		if err != nil {
+			klog.Warningf("cannot set BGP local preference '%s' from annotation of service %s/%s: %v", value,
+				svc.Namespace, svc.Name, err)
+		} else {
+			attrs.localPref = uint32(localPref)
+		}
+	}
+
+	if len(attrs.communities) == 0 && len(attrs.largeCommunities) == 0 && attrs.localPref == 0 {
+		return nil
+	}
+	return attrs
+}
+
+// merge adds the communities of other that aren't in attrs yet and keeps the highest local preference
+func (attrs *vipPathAttributes) merge(other *vipPathAttributes) {
+	for _, community := range other.communities {
+		if !slices.Contains(attrs.communities, community) {
+			attrs.communities = append(attrs.communities, community)
+		}
+	}
+	for _, largeCommunity := range other.largeCommunities {
+		if !slices.ContainsFunc(attrs.largeCommunities, func(lc *gobgpapi.LargeCommunity) bool {
+			return proto.Equal(lc, largeCommunity)
+		}) {
+			attrs.largeCommunities = append(attrs.largeCommunities, largeCommunity)
+		}
+	}
+	if other.localPref > attrs.localPref {
+		attrs.localPref = other.localPref
+	}
+}
+
+func (attrs *vipPathAttributes) copy() *vipPathAttributes {
+	return &vipPathAttributes{
+		communities:      slices.Clone(attrs.communities),
+		largeCommunities: slices.Clone(attrs.largeCommunities),
+		localPref:        attrs.localPref,
+	}
+}
+
+// toAny converts the path attributes into their gobgp API representation. The communities are sorted, so that the
+// path doesn't change between syncs when the services of a shared VIP are listed in a different order.
+func (attrs *vipPathAttributes) toAny() []*anypb.Any {
+	anyAttrs := make([]*anypb.Any, 0)
+	if len(attrs.communities) > 0 {
+		communities := slices.Clone(attrs.communities)
+		slices.Sort(communities)
+		a, _ := anypb.New(&gobgpapi.CommunitiesAttribute{Communities: communities})
+		anyAttrs = append(anyAttrs, a)
+	}
+	if len(attrs.largeCommunities) > 0 {
+		largeCommunities := slices.Clone(attrs.largeCommunities)
+		slices.SortFunc(largeCommunities, func(a, b *gobgpapi.LargeCommunity) int {
+			if c := cmp.Compare(a.GlobalAdmin, b.GlobalAdmin); c != 0 {
+				return c
+			}
+			if c := cmp.Compare(a.LocalData1, b.LocalData1); c != 0 {
+				return c
+			}
+			return cmp.Compare(a.LocalData2, b.LocalData2)
+		})
+		a, _ := anypb.New(&gobgpapi.LargeCommunitiesAttribute{Communities: largeCommunities})
+		anyAttrs = append(anyAttrs, a)
+	}
+	if attrs.localPref > 0 {
+		a, _ := anypb.New(&gobgpapi.LocalPrefAttribute{LocalPref: attrs.localPref})
+		anyAttrs = append(anyAttrs, a)
+	}
+	return anyAttrs
+}
+
 func (nrc *NetworkRoutingController) newServiceEventHandler() cache.ResourceEventHandler {
 	return cache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
@@ -7,9 +7,15 @@ import (
 	"time"
 
 	"github.com/cloudnativelabs/kube-router/v2/pkg/utils"
+	gobgpapi "github.com/osrg/gobgp/v3/api"
+	gobgp "github.com/osrg/gobgp/v3/pkg/server"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
 	v1core "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
 )
 
 // Compare 2 string slices by value.
@@ -951,6 +957,121 @@ func Test_getVIPsForService(t *testing.T) {
 	}
 }
 
+func Test_getVIPPathAttributes(t *testing.T) {
+	clusterSvc := getClusterSvc()
+	clusterSvc.Annotations = map[string]string{
+		svcCommunitiesAnnotation:      "65000:100, no-export, invalid",
+		svcLargeCommunitiesAnnotation: "4200000000:1:2",
+		svcLocalPrefAnnotation:        "200",
+	}
+	externalSvc := getExternalSvc()
+	externalSvc.Annotations = map[string]string{
+		svcCommunitiesAnnotation: "65000:100,65000:200",
+		svcLocalPrefAnnotation:   "150",
+	}
+	svcLister := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, svc := range []*v1core.Service{clusterSvc, externalSvc, getLoadBalancerSvc()} {
+		if err := svcLister.Add(svc); err != nil {
+			t.Fatalf("failed to add service to lister: %v", err)
+		}
+	}
+	nrc := &NetworkRoutingController{svcLister: svcLister}
+
+	pathAttrs := nrc.getVIPPathAttributes()
+
+	t.Run("VIPs of services without annotations have no path attributes", func(t *testing.T) {
+		if _, ok := pathAttrs["10.0.255.1"]; ok {
+			t.Errorf("expected no path attributes for the load balancer IP, got: %v", pathAttrs["10.0.255.1"])
+		}
+	})
+	t.Run("path attributes of services sharing a VIP are merged", func(t *testing.T) {
+		expected := &vipPathAttributes{
+			communities:      []uint32{65000<<16 | 100, 0xFFFFFF01, 65000<<16 | 200},
+			largeCommunities: []*gobgpapi.LargeCommunity{{GlobalAdmin: 4200000000, LocalData1: 1, LocalData2: 2}},
+			localPref:        200,
+		}
+		assert.ElementsMatch(t, expected.communities, pathAttrs["10.0.0.1"].communities)
+		assert.Len(t, pathAttrs["10.0.0.1"].largeCommunities, 1)
+		assert.True(t, proto.Equal(expected.largeCommunities[0], pathAttrs["10.0.0.1"].largeCommunities[0]))
+		assert.Equal(t, expected.localPref, pathAttrs["10.0.0.1"].localPref)
+	})
+	t.Run("external IPs only get the path attributes of their own service", func(t *testing.T) {
+		assert.ElementsMatch(t, []uint32{65000<<16 | 100, 65000<<16 | 200}, pathAttrs["1.1.1.1"].communities)
+		assert.Empty(t, pathAttrs["1.1.1.1"].largeCommunities)
+		assert.Equal(t, uint32(150), pathAttrs["1.1.1.1"].localPref)
+	})
+}
+
+func Test_bgpAdvertiseVIPWithPathAttributes(t *testing.T) {
+	nrc := &NetworkRoutingController{
+		bgpServer: gobgp.NewBgpServer(),
+		krNode: &utils.LocalKRNode{
+			KRNode: utils.KRNode{
+				NodeIPv4Addrs: map[v1core.NodeAddressType][]net.IP{v1core.NodeInternalIP: {net.ParseIP(testNodeIPv4)}},
+			},
+		},
+	}
+	go nrc.bgpServer.Serve()
+	err := nrc.bgpServer.StartBgp(context.Background(), &gobgpapi.StartBgpRequest{
+		Global: &gobgpapi.Global{Asn: 64512, RouterId: testNodeIPv4, ListenPort: -1},
+	})
+	if err != nil {
+		t.Fatalf("failed to start BGP server: %v", err)
+	}
+	defer func() {
+		_ = nrc.bgpServer.StopBgp(context.Background(), &gobgpapi.StopBgpRequest{})
+	}()
+
+	err = nrc.bgpAdvertiseVIP("1.1.1.1", &vipPathAttributes{
+		communities:      []uint32{65000<<16 | 100},
+		largeCommunities: []*gobgpapi.LargeCommunity{{GlobalAdmin: 4200000000, LocalData1: 1, LocalData2: 2}},
+		localPref:        200,
+	})
+	if err != nil {
+		t.Fatalf("failed to advertise VIP: %v", err)
+	}
+
+	var pattrs []*anypb.Any
+	err = nrc.bgpServer.ListPath(context.Background(), &gobgpapi.ListPathRequest{
+		TableType: gobgpapi.TableType_GLOBAL,
+		Family:    &gobgpapi.Family{Afi: gobgpapi.Family_AFI_IP, Safi: gobgpapi.Family_SAFI_UNICAST},
+	}, func(d *gobgpapi.Destination) {
+		for _, p := range d.Paths {
+			pattrs = append(pattrs, p.Pattrs...)
+		}
+	})
+	if err != nil {
+		t.Fatalf("failed to list paths: %v", err)
+	}
+
+	var communities *gobgpapi.CommunitiesAttribute
+	var largeCommunities *gobgpapi.LargeCommunitiesAttribute
+	var localPref *gobgpapi.LocalPrefAttribute
+	for _, pattr := range pattrs {
+		attr, err := pattr.UnmarshalNew()
+		if err != nil {
+			t.Fatalf("failed to unmarshal path attribute: %v", err)
+		}
+		switch a := attr.(type) {
+		case *gobgpapi.CommunitiesAttribute:
+			communities = a
+		case *gobgpapi.LargeCommunitiesAttribute:
+			largeCommunities = a
+		case *gobgpapi.LocalPrefAttribute:
+			localPref = a
+		}
+	}
+	if assert.NotNil(t, communities) {
+		assert.Equal(t, []uint32{65000<<16 | 100}, communities.Communities)
+	}
+	if assert.NotNil(t, largeCommunities) {
+		assert.Equal(t, uint32(4200000000), largeCommunities.Communities[0].GlobalAdmin)
+	}
+	if assert.NotNil(t, localPref) {
+		assert.Equal(t, uint32(200), localPref.LocalPref)
+	}
+}
+
 func getClusterSvc() *v1core.Service {
 	return &v1core.Service{
 		ObjectMeta: metav1.ObjectMeta{
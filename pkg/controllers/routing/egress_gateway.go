@@ -0,0 +1,594 @@
+package routing
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/cloudnativelabs/kube-router/v2/pkg/apis/kuberouter/v1alpha1"
+	"github.com/cloudnativelabs/kube-router/v2/pkg/routes"
+	"github.com/cloudnativelabs/kube-router/v2/pkg/utils"
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+const (
+	egressGatewayChain       = "KUBE-ROUTER-EGRESS"
+	egressGatewayIPSetPrefix = "kube-router-egress-"
+	egressGatewayComment     = "kube-router egress gateway"
+
+	// every egress gateway gets its own fwmark in the upper byte of the packet mark, which leaves the lower bits to
+	// the service proxy and the network policy controller, and its own routing table
+	egressGatewayMarkMask  = 0xff000000
+	egressGatewayMarkShift = 24
+	egressGatewayMaxCount  = 255
+	egressGatewayTableBase = 1000
+)
+
+// egressGateway is the state of an EgressGateway resource that is applied to the node
+type egressGateway struct {
+	// name of the EgressGateway resource
+	name string
+	// index of the gateway, which determines its fwmark, routing table and ipset
+	index         int
+	family        v1core.IPFamily
+	egressIP      net.IP
+	gatewayNode   string
+	gatewayNodeIP net.IP
+	// podIPs are the IPs of the selected pods of the gateway's family
+	podIPs []string
+}
+
+func (gw *egressGateway) fwmark() string {
+	return fmt.Sprintf("%#x/%#x", uint32(gw.index)<<egressGatewayMarkShift, uint32(egressGatewayMarkMask))
+}
+
+func (gw *egressGateway) tableID() string {
+	return strconv.Itoa(egressGatewayTableBase + gw.index)
+}
+
+func (gw *egressGateway) ipSetName() string {
+	return egressGatewayIPSetPrefix + strconv.Itoa(gw.index)
+}
+
+// egressGatewayState holds the egress gateways that were applied to the node, it is only used by the goroutine that
+// syncs egress gateways
+type egressGatewayState struct {
+	// gateways by index
+	gateways map[int]*egressGateway
+	// rules that were written to the egress gateway chains, by family and table
+	rules map[v1core.IPFamily]map[string][][]string
+	// fwmark rules of earlier runs of kube-router are removed by the first sync
+	initialized bool
+
+	// ipsMu protects ips, which is read when the BGP policies are updated
+	ipsMu sync.Mutex
+	// ips are the egress IPs that the node is the gateway for and that are advertised to BGP peers
+	ips []string
+}
+
+func (nrc *NetworkRoutingController) newEgressGatewayEventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			nrc.RequestEgressGatewaySync()
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			nrc.RequestEgressGatewaySync()
+		},
+		DeleteFunc: func(obj interface{}) {
+			nrc.RequestEgressGatewaySync()
+		},
+	}
+}
+
+// RequestEgressGatewaySync requests a sync of the egress gateways, it is used by the EgressGateway, pod and namespace
+// watchers, so that pods are redirected to their gateway as soon as they get an IP
+func (nrc *NetworkRoutingController) RequestEgressGatewaySync() {
+	select {
+	case nrc.egressGatewaySyncRequestChan <- struct{}{}:
+		klog.V(3).Info("Egress gateway sync request queue was empty so a sync request was successfully sent")
+	default: // Don't block if the buffered channel is full, return quickly so that we don't block callee execution
+		klog.V(3).Info("Egress gateway sync request queue was full, skipping...")
+	}
+}
+
+// runEgressGatewaySync syncs the egress gateways whenever a sync is requested until the stop channel is closed
+func (nrc *NetworkRoutingController) runEgressGatewaySync(stopCh <-chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for {
+		select {
+		case <-stopCh:
+			klog.Info("Shutting down egress gateway sync goroutine")
+			return
+		case <-nrc.egressGatewaySyncRequestChan:
+			klog.V(3).Info("Received request for an egress gateway sync, processing")
+			nrc.syncEgressGateways()
+		}
+	}
+}
+
+// syncEgressGateways sends the traffic of the pods that are selected by EgressGateway resources, and that leaves the
+// cluster, to their gateway node. Nodes mark the traffic of the selected pods, route it to the gateway through a
+// routing table per gateway and exclude it from pod egress masquerading. The gateway node SNATs it to the egress IP
+// and advertises the egress IP to its BGP peers, so that the replies are routed back to it.
+func (nrc *NetworkRoutingController) syncEgressGateways() {
+	desired := nrc.desiredEgressGateways()
+
+	if err := nrc.syncEgressGatewayIPSets(desired); err != nil {
+		klog.Errorf("Failed to sync egress gateway ipsets: %v", err)
+		return
+	}
+	if err := nrc.syncEgressGatewayRules(desired); err != nil {
+		klog.Errorf("Failed to sync egress gateway iptables rules: %v", err)
+		return
+	}
+	nrc.syncEgressGatewayRouting(desired)
+	nrc.destroyStaleEgressGatewayIPSets(desired)
+	nrc.egressGatewayState.gateways = desired
+
+	nrc.syncEgressGatewayAdvertisements(desired)
+}
+
+// desiredEgressGateways returns the gateways of all valid EgressGateway resources by index. Indexes are assigned in the
+// order of the resource names and pods that are selected by several gateways are only sent to the first one.
+func (nrc *NetworkRoutingController) desiredEgressGateways() map[int]*egressGateway {
+	egressGateways := make([]*v1alpha1.EgressGateway, 0)
+	for _, obj := range nrc.egressGatewayLister.List() {
+		egw, err := egressGatewayFromObject(obj)
+		if err != nil {
+			klog.Errorf("Failed to parse EgressGateway: %v", err)
+			continue
+		}
+		egressGateways = append(egressGateways, egw)
+	}
+	sort.Slice(egressGateways, func(i, j int) bool {
+		return egressGateways[i].Name < egressGateways[j].Name
+	})
+
+	desired := make(map[int]*egressGateway)
+	claimedPodIPs := make(map[string]string)
+	for _, egw := range egressGateways {
+		if len(desired) >= egressGatewayMaxCount {
+			klog.Errorf("Ignoring EgressGateway %s as only %d egress gateways are supported", egw.Name,
+				egressGatewayMaxCount)
+			continue
+		}
+		gw, err := nrc.newEgressGateway(egw)
+		if err != nil {
+			klog.Errorf("Invalid EgressGateway %s: %v", egw.Name, err)
+			continue
+		}
+		podIPs, err := nrc.egressGatewayPodIPs(egw, gw.family)
+		if err != nil {
+			klog.Errorf("Invalid EgressGateway %s: %v", egw.Name, err)
+			continue
+		}
+		for _, podIP := range podIPs {
+			if other, ok := claimedPodIPs[podIP]; ok {
+				klog.V(1).Infof("Pod IP %s is selected by EgressGateway %s and %s, using %s", podIP, other,
+					egw.Name, other)
+				continue
+			}
+			claimedPodIPs[podIP] = egw.Name
+			gw.podIPs = append(gw.podIPs, podIP)
+		}
+		gw.index = len(desired) + 1
+		desired[gw.index] = gw
+	}
+	return desired
+}
+
+// newEgressGateway validates an EgressGateway resource and looks up its gateway node
+func (nrc *NetworkRoutingController) newEgressGateway(egw *v1alpha1.EgressGateway) (*egressGateway, error) {
+	egressIP := net.ParseIP(egw.Spec.EgressIP)
+	if egressIP == nil {
+		return nil, fmt.Errorf("could not parse egress IP \"%s\" as an IP", egw.Spec.EgressIP)
+	}
+	if egw.Spec.NamespaceSelector == nil && egw.Spec.PodSelector == nil {
+		return nil, errors.New("either namespaceSelector or podSelector must be set")
+	}
+
+	gw := &egressGateway{
+		name:        egw.Name,
+		family:      v1core.IPv4Protocol,
+		egressIP:    egressIP,
+		gatewayNode: egw.Spec.GatewayNode,
+	}
+	if egressIP.To4() == nil {
+		gw.family = v1core.IPv6Protocol
+	}
+	if _, ok := nrc.iptablesCmdHandlers[gw.family]; !ok {
+		return nil, fmt.Errorf("egress IP %s is of family %s, which isn't enabled on this node", egressIP, gw.family)
+	}
+
+	obj, exists, err := nrc.nodeLister.GetByKey(egw.Spec.GatewayNode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get gateway node %s: %v", egw.Spec.GatewayNode, err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("gateway node %s doesn't exist", egw.Spec.GatewayNode)
+	}
+	node, ok := obj.(*v1core.Node)
+	if !ok {
+		return nil, fmt.Errorf("unexpected object type for node %s: %T", egw.Spec.GatewayNode, obj)
+	}
+	gatewayKRNode, err := utils.NewRemoteKRNode(node)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get addresses of gateway node %s: %v", egw.Spec.GatewayNode, err)
+	}
+	if gw.family == v1core.IPv4Protocol {
+		gw.gatewayNodeIP = gatewayKRNode.FindBestIPv4NodeAddress()
+	} else {
+		gw.gatewayNodeIP = gatewayKRNode.FindBestIPv6NodeAddress()
+	}
+	if gw.gatewayNodeIP == nil {
+		return nil, fmt.Errorf("gateway node %s has no %s address", egw.Spec.GatewayNode, gw.family)
+	}
+
+	return gw, nil
+}
+
+// egressGatewayPodIPs returns the IPs of the given family of the pods that are selected by the EgressGateway
+func (nrc *NetworkRoutingController) egressGatewayPodIPs(egw *v1alpha1.EgressGateway,
+	family v1core.IPFamily) ([]string, error) {
+	nsSelector := labels.Everything()
+	if egw.Spec.NamespaceSelector != nil {
+		var err error
+		if nsSelector, err = metav1.LabelSelectorAsSelector(egw.Spec.NamespaceSelector); err != nil {
+			return nil, fmt.Errorf("invalid namespaceSelector: %v", err)
+		}
+	}
+	podSelector := labels.Everything()
+	if egw.Spec.PodSelector != nil {
+		var err error
+		if podSelector, err = metav1.LabelSelectorAsSelector(egw.Spec.PodSelector); err != nil {
+			return nil, fmt.Errorf("invalid podSelector: %v", err)
+		}
+	}
+
+	namespaces := make(map[string]bool)
+	for _, obj := range nrc.nsLister.List() {
+		ns, ok := obj.(*v1core.Namespace)
+		if ok && nsSelector.Matches(labels.Set(ns.Labels)) {
+			namespaces[ns.Name] = true
+		}
+	}
+
+	podIPs := make([]string, 0)
+	for _, obj := range nrc.podLister.List() {
+		pod, ok := obj.(*v1core.Pod)
+		if !ok || pod.Spec.HostNetwork || !namespaces[pod.Namespace] {
+			continue
+		}
+		if pod.Status.Phase == v1core.PodSucceeded || pod.Status.Phase == v1core.PodFailed {
+			continue
+		}
+		if !podSelector.Matches(labels.Set(pod.Labels)) {
+			continue
+		}
+		for _, podIP := range pod.Status.PodIPs {
+			ip := net.ParseIP(podIP.IP)
+			if ip == nil {
+				continue
+			}
+			if (ip.To4() != nil) == (family == v1core.IPv4Protocol) {
+				podIPs = append(podIPs, ip.String())
+			}
+		}
+	}
+	sort.Strings(podIPs)
+	return podIPs, nil
+}
+
+// syncEgressGatewayIPSets writes the IPs of the selected pods of every gateway to its ipset
+func (nrc *NetworkRoutingController) syncEgressGatewayIPSets(desired map[int]*egressGateway) error {
+	nrc.ipsetMutex.Lock()
+	defer nrc.ipsetMutex.Unlock()
+
+	for family, ipSetHandler := range nrc.ipSetHandlers {
+		for _, gw := range desired {
+			if gw.family != family {
+				continue
+			}
+			entries := make([][]string, 0, len(gw.podIPs))
+			for _, podIP := range gw.podIPs {
+				entries = append(entries, []string{podIP, utils.OptionTimeout, "0"})
+			}
+			ipSetHandler.RefreshSet(gw.ipSetName(), entries, utils.TypeHashIP)
+		}
+		if err := ipSetHandler.Restore(); err != nil {
+			return fmt.Errorf("failed to restore %s ipsets: %v", family, err)
+		}
+	}
+	return nil
+}
+
+// destroyStaleEgressGatewayIPSets destroys the ipsets of gateways that were removed, which is only possible after the
+// iptables rules that reference them were removed
+func (nrc *NetworkRoutingController) destroyStaleEgressGatewayIPSets(desired map[int]*egressGateway) {
+	nrc.ipsetMutex.Lock()
+	defer nrc.ipsetMutex.Unlock()
+
+	for family, ipSetHandler := range nrc.ipSetHandlers {
+		for index, gw := range nrc.egressGatewayState.gateways {
+			if want, ok := desired[index]; ok && want.family == family {
+				continue
+			}
+			if gw.family != family {
+				continue
+			}
+			if err := ipSetHandler.Destroy(gw.ipSetName()); err != nil {
+				klog.Warningf("Failed to destroy ipset %s of removed egress gateway: %v", gw.ipSetName(), err)
+			}
+		}
+	}
+}
+
+// egressGatewayRules returns the rules of the egress gateway chains in the mangle and nat tables for the given family
+func (nrc *NetworkRoutingController) egressGatewayRules(desired map[int]*egressGateway,
+	family v1core.IPFamily) map[string][][]string {
+	isIPv6 := family == v1core.IPv6Protocol
+	podSubnets := utils.IPSetName(podSubnetsIPSetName, isIPv6)
+	nodeAddrs := utils.IPSetName(nodeAddrsIPSetName, isIPv6)
+
+	indexes := make([]int, 0, len(desired))
+	for index := range desired {
+		indexes = append(indexes, index)
+	}
+	slices.Sort(indexes)
+
+	rules := map[string][][]string{"mangle": {}, "nat": {}}
+	for _, index := range indexes {
+		gw := desired[index]
+		if gw.family != family {
+			continue
+		}
+		match := []string{"-m", "comment", "--comment", egressGatewayComment + " " + gw.name,
+			"-m", "set", "--match-set", utils.IPSetName(gw.ipSetName(), isIPv6), "src",
+			"-m", "set", "!", "--match-set", podSubnets, "dst",
+			"-m", "set", "!", "--match-set", nodeAddrs, "dst"}
+
+		if gw.gatewayNode == nrc.krNode.GetNodeName() {
+			rules["nat"] = append(rules["nat"],
+				append(slices.Clone(match), "-j", "SNAT", "--to-source", gw.egressIP.String()))
+			continue
+		}
+		rules["mangle"] = append(rules["mangle"],
+			append(slices.Clone(match), "-j", "MARK", "--set-xmark", gw.fwmark()))
+		// the traffic needs to reach the gateway with the pod's IP, so it must not be masqueraded to the node's IP
+		rules["nat"] = append(rules["nat"], []string{"-m", "comment", "--comment", egressGatewayComment + " " + gw.name,
+			"-m", "mark", "--mark", gw.fwmark(), "-j", "ACCEPT"})
+	}
+	return rules
+}
+
+// syncEgressGatewayRules writes the egress gateway chains, which are jumped to from the top of the mangle PREROUTING
+// and nat POSTROUTING chains, so that they take precedence over the pod egress masquerading rule
+func (nrc *NetworkRoutingController) syncEgressGatewayRules(desired map[int]*egressGateway) error {
+	if nrc.egressGatewayState.rules == nil {
+		nrc.egressGatewayState.rules = make(map[v1core.IPFamily]map[string][][]string)
+	}
+
+	for family, iptablesCmdHandler := range nrc.iptablesCmdHandlers {
+		rules := nrc.egressGatewayRules(desired, family)
+		for table, parentChain := range map[string]string{"mangle": "PREROUTING", "nat": "POSTROUTING"} {
+			if applied, ok := nrc.egressGatewayState.rules[family][table]; ok &&
+				slices.EqualFunc(applied, rules[table], slices.Equal[[]string]) {
+				continue
+			}
+			if err := writeEgressGatewayChain(iptablesCmdHandler, table, parentChain, rules[table]); err != nil {
+				return err
+			}
+		}
+		nrc.egressGatewayState.rules[family] = rules
+	}
+	return nil
+}
+
+func writeEgressGatewayChain(iptablesCmdHandler utils.IPTablesHandler, table, parentChain string,
+	rules [][]string) error {
+	exists, err := iptablesCmdHandler.ChainExists(table, egressGatewayChain)
+	if err != nil {
+		return fmt.Errorf("failed to check for chain %s in table %s: %v", egressGatewayChain, table, err)
+	}
+	if !exists {
+		if err = iptablesCmdHandler.NewChain(table, egressGatewayChain); err != nil {
+			return fmt.Errorf("failed to create chain %s in table %s: %v", egressGatewayChain, table, err)
+		}
+	}
+	if err = iptablesCmdHandler.ClearChain(table, egressGatewayChain); err != nil {
+		return fmt.Errorf("failed to flush chain %s in table %s: %v", egressGatewayChain, table, err)
+	}
+	for _, rule := range rules {
+		if err = iptablesCmdHandler.Append(table, egressGatewayChain, rule...); err != nil {
+			return fmt.Errorf("failed to add rule to chain %s in table %s: %v", egressGatewayChain, table, err)
+		}
+	}
+
+	jumpArgs := []string{"-m", "comment", "--comment", egressGatewayComment, "-j", egressGatewayChain}
+	exists, err = iptablesCmdHandler.Exists(table, parentChain, jumpArgs...)
+	if err != nil {
+		return fmt.Errorf("failed to check for jump to %s in %s chain: %v", egressGatewayChain, parentChain, err)
+	}
+	if !exists {
+		if err = iptablesCmdHandler.Insert(table, parentChain, 1, jumpArgs...); err != nil {
+			return fmt.Errorf("failed to add jump to %s in %s chain: %v", egressGatewayChain, parentChain, err)
+		}
+	}
+	return nil
+}
+
+// syncEgressGatewayRouting routes the marked traffic of every gateway that is hosted on another node to that node and
+// removes the routing of gateways that were removed or moved to this node
+func (nrc *NetworkRoutingController) syncEgressGatewayRouting(desired map[int]*egressGateway) {
+	isRemote := func(gw *egressGateway) bool {
+		return gw.gatewayNode != nrc.krNode.GetNodeName()
+	}
+
+	for index, gw := range nrc.egressGatewayState.gateways {
+		if want, ok := desired[index]; ok && isRemote(want) && want.family == gw.family {
+			continue
+		}
+		if !isRemote(gw) {
+			continue
+		}
+		if err := nrc.pbr.DisableFwmarkRouting(gw.fwmark(), gw.tableID(),
+			gw.family == v1core.IPv6Protocol); err != nil {
+			klog.Errorf("Failed to remove routing of egress gateway %s: %v", gw.name, err)
+		}
+	}
+
+	if !nrc.egressGatewayState.initialized {
+		nrc.removeStaleEgressGatewayRouting(desired)
+		nrc.egressGatewayState.initialized = true
+	}
+
+	for _, gw := range desired {
+		if !isRemote(gw) {
+			continue
+		}
+		if err := nrc.pbr.EnableFwmarkRouting(gw.fwmark(), gw.tableID(), gw.gatewayNodeIP); err != nil {
+			klog.Errorf("Failed to route traffic of egress gateway %s to node %s: %v", gw.name, gw.gatewayNode,
+				err)
+		}
+	}
+}
+
+// removeStaleEgressGatewayRouting removes fwmark rules for egress gateway routing tables that were left behind by
+// earlier runs of kube-router
+func (nrc *NetworkRoutingController) removeStaleEgressGatewayRouting(desired map[int]*egressGateway) {
+	for family := range nrc.iptablesCmdHandlers {
+		isIPv6 := family == v1core.IPv6Protocol
+		fwmarkRules, err := nrc.pbr.ListFwmarkRules(isIPv6)
+		if err != nil {
+			klog.Errorf("Failed to list fwmark rules: %v", err)
+			continue
+		}
+		for fwmark, tableID := range fwmarkRules {
+			index, ok := egressGatewayTableIndex(tableID)
+			if !ok {
+				continue
+			}
+			if gw, ok := desired[index]; ok && gw.family == family && gw.fwmark() == fwmark {
+				continue
+			}
+			if err = nrc.pbr.DisableFwmarkRouting(fwmark, tableID, isIPv6); err != nil {
+				klog.Errorf("Failed to remove stale egress gateway routing table %s: %v", tableID, err)
+			}
+		}
+	}
+}
+
+// egressGatewayTableIndex returns the index of the egress gateway that the routing table belongs to, it returns false
+// when the table isn't an egress gateway routing table
+func egressGatewayTableIndex(tableID string) (int, bool) {
+	table, err := strconv.Atoi(tableID)
+	if err != nil || table <= egressGatewayTableBase || table > egressGatewayTableBase+egressGatewayMaxCount {
+		return 0, false
+	}
+	return table - egressGatewayTableBase, true
+}
+
+// syncEgressGatewayAdvertisements advertises the egress IPs that the node is the gateway for and withdraws the ones it
+// isn't the gateway for any longer
+func (nrc *NetworkRoutingController) syncEgressGatewayAdvertisements(desired map[int]*egressGateway) {
+	ips := make([]string, 0)
+	for _, gw := range desired {
+		if gw.gatewayNode == nrc.krNode.GetNodeName() && !slices.Contains(ips, gw.egressIP.String()) {
+			ips = append(ips, gw.egressIP.String())
+		}
+	}
+	sort.Strings(ips)
+
+	nrc.egressGatewayState.ipsMu.Lock()
+	previousIPs := nrc.egressGatewayState.ips
+	nrc.egressGatewayState.ips = ips
+	nrc.egressGatewayState.ipsMu.Unlock()
+
+	if slices.Equal(previousIPs, ips) {
+		return
+	}
+
+	// update the policies first, so that the export policy allows the egress IPs to be advertised
+	if err := nrc.AddPolicies(); err != nil {
+		klog.Errorf("Error adding BGP policies: %s", err.Error())
+	}
+
+	toWithdraw := make([]string, 0)
+	for _, ip := range previousIPs {
+		if !slices.Contains(ips, ip) {
+			toWithdraw = append(toWithdraw, ip)
+		}
+	}
+	nrc.withdrawVIPs(toWithdraw)
+	for _, ip := range ips {
+		if err := nrc.bgpAdvertiseVIP(ip, nil); err != nil {
+			klog.Errorf("error advertising egress IP: %q, error: %v", ip, err)
+		}
+	}
+}
+
+// egressGatewayIPs returns the egress IPs that the node is the gateway for
+func (nrc *NetworkRoutingController) egressGatewayIPs() []string {
+	nrc.egressGatewayState.ipsMu.Lock()
+	defer nrc.egressGatewayState.ipsMu.Unlock()
+	return slices.Clone(nrc.egressGatewayState.ips)
+}
+
+// cleanupEgressGateways removes the egress gateway chains and routing tables
+func (nrc *NetworkRoutingController) cleanupEgressGateways() {
+	for family, iptablesCmdHandler := range nrc.iptablesCmdHandlers {
+		for table, parentChain := range map[string]string{"mangle": "PREROUTING", "nat": "POSTROUTING"} {
+			exists, err := iptablesCmdHandler.ChainExists(table, egressGatewayChain)
+			if err != nil || !exists {
+				continue
+			}
+			err = iptablesCmdHandler.DeleteIfExists(table, parentChain,
+				"-m", "comment", "--comment", egressGatewayComment, "-j", egressGatewayChain)
+			if err != nil {
+				klog.Errorf("Failed to delete jump to %s in %s chain: %v", egressGatewayChain, parentChain, err)
+			}
+			if err = iptablesCmdHandler.ClearAndDeleteChain(table, egressGatewayChain); err != nil {
+				klog.Errorf("Failed to delete chain %s in table %s: %v", egressGatewayChain, table, err)
+			}
+		}
+
+		pbr := routes.NewPolicyBasedRules(nil, nil, nil)
+		isIPv6 := family == v1core.IPv6Protocol
+		fwmarkRules, err := pbr.ListFwmarkRules(isIPv6)
+		if err != nil {
+			klog.Errorf("Failed to list fwmark rules: %v", err)
+			continue
+		}
+		for fwmark, tableID := range fwmarkRules {
+			if _, ok := egressGatewayTableIndex(tableID); !ok {
+				continue
+			}
+			if err = pbr.DisableFwmarkRouting(fwmark, tableID, isIPv6); err != nil {
+				klog.Errorf("Failed to remove egress gateway routing table %s: %v", tableID, err)
+			}
+		}
+	}
+}
+
+// egressGatewayFromObject converts an object of the EgressGateway informer into an EgressGateway
+func egressGatewayFromObject(obj interface{}) (*v1alpha1.EgressGateway, error) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, fmt.Errorf("unexpected object type: %T", obj)
+	}
+	egw := &v1alpha1.EgressGateway{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, egw); err != nil {
+		return nil, fmt.Errorf("failed to convert %s: %v", u.GetName(), err)
+	}
+	return egw, nil
+}
@@ -0,0 +1,235 @@
+package routing
+
+import (
+	"net"
+	"testing"
+
+	"github.com/cloudnativelabs/kube-router/v2/pkg/apis/kuberouter/v1alpha1"
+	"github.com/cloudnativelabs/kube-router/v2/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/cache"
+)
+
+func newTestEgressGateway(t *testing.T, name string, spec v1alpha1.EgressGatewaySpec) *unstructured.Unstructured {
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&v1alpha1.EgressGateway{
+		TypeMeta:   metav1.TypeMeta{APIVersion: v1alpha1.SchemeGroupVersion.String(), Kind: v1alpha1.EgressGatewayKind},
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       spec,
+	})
+	assert.NoError(t, err)
+	return &unstructured.Unstructured{Object: obj}
+}
+
+func newTestEgressGatewayPod(namespace, name string, labels map[string]string, podIPs ...string) *v1core.Pod {
+	pod := &v1core.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Labels: labels},
+		Status:     v1core.PodStatus{Phase: v1core.PodRunning},
+	}
+	for _, podIP := range podIPs {
+		pod.Status.PodIPs = append(pod.Status.PodIPs, v1core.PodIP{IP: podIP})
+	}
+	return pod
+}
+
+func newTestEgressGatewayController(t *testing.T, objs ...interface{}) *NetworkRoutingController {
+	nodeLister := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for name, nodeIP := range map[string]string{"node-1": "10.0.0.10", "node-2": "10.0.0.11"} {
+		assert.NoError(t, nodeLister.Add(&v1core.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status: v1core.NodeStatus{
+				Addresses: []v1core.NodeAddress{{Type: v1core.NodeInternalIP, Address: nodeIP}},
+			},
+		}))
+	}
+	nsLister := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for name, labels := range map[string]map[string]string{
+		"default":  {"team": "a"},
+		"payments": {"team": "b"},
+	} {
+		assert.NoError(t, nsLister.Add(&v1core.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}))
+	}
+	podLister := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	egressGatewayLister := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, obj := range objs {
+		switch o := obj.(type) {
+		case *v1core.Pod:
+			assert.NoError(t, podLister.Add(o))
+		case *unstructured.Unstructured:
+			assert.NoError(t, egressGatewayLister.Add(o))
+		}
+	}
+
+	return &NetworkRoutingController{
+		krNode: &utils.LocalKRNode{
+			KRNode: utils.KRNode{
+				NodeName:  "node-1",
+				PrimaryIP: net.ParseIP("10.0.0.10"),
+			},
+		},
+		iptablesCmdHandlers: map[v1core.IPFamily]utils.IPTablesHandler{v1core.IPv4Protocol: nil},
+		nodeLister:          nodeLister,
+		nsLister:            nsLister,
+		podLister:           podLister,
+		egressGatewayLister: egressGatewayLister,
+	}
+}
+
+func Test_newEgressGateway(t *testing.T) {
+	nrc := newTestEgressGatewayController(t)
+	paymentsSelector := &metav1.LabelSelector{MatchLabels: map[string]string{"team": "b"}}
+
+	testcases := []struct {
+		name          string
+		spec          v1alpha1.EgressGatewaySpec
+		gatewayNodeIP string
+		err           bool
+	}{
+		{
+			name: "gateway on a remote node",
+			spec: v1alpha1.EgressGatewaySpec{EgressIP: "203.0.113.10", GatewayNode: "node-2",
+				NamespaceSelector: paymentsSelector},
+			gatewayNodeIP: "10.0.0.11",
+		},
+		{
+			name: "invalid egress IP",
+			spec: v1alpha1.EgressGatewaySpec{EgressIP: "203.0.113", GatewayNode: "node-2",
+				NamespaceSelector: paymentsSelector},
+			err: true,
+		},
+		{
+			name: "without selectors",
+			spec: v1alpha1.EgressGatewaySpec{EgressIP: "203.0.113.10", GatewayNode: "node-2"},
+			err:  true,
+		},
+		{
+			name: "egress IP of a family that isn't enabled",
+			spec: v1alpha1.EgressGatewaySpec{EgressIP: "2001:db8::10", GatewayNode: "node-2",
+				NamespaceSelector: paymentsSelector},
+			err: true,
+		},
+		{
+			name: "gateway node doesn't exist",
+			spec: v1alpha1.EgressGatewaySpec{EgressIP: "203.0.113.10", GatewayNode: "node-3",
+				NamespaceSelector: paymentsSelector},
+			err: true,
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			gw, err := nrc.newEgressGateway(&v1alpha1.EgressGateway{
+				ObjectMeta: metav1.ObjectMeta{Name: "test"},
+				Spec:       testcase.spec,
+			})
+			if testcase.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, v1core.IPv4Protocol, gw.family)
+			assert.Equal(t, testcase.gatewayNodeIP, gw.gatewayNodeIP.String())
+		})
+	}
+}
+
+func Test_desiredEgressGateways(t *testing.T) {
+	nrc := newTestEgressGatewayController(t,
+		newTestEgressGateway(t, "b-payments", v1alpha1.EgressGatewaySpec{
+			EgressIP:          "203.0.113.20",
+			GatewayNode:       "node-2",
+			NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "b"}},
+		}),
+		newTestEgressGateway(t, "a-billing", v1alpha1.EgressGatewaySpec{
+			EgressIP:    "203.0.113.10",
+			GatewayNode: "node-1",
+			PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "billing"}},
+		}),
+		newTestEgressGateway(t, "c-invalid", v1alpha1.EgressGatewaySpec{
+			EgressIP:    "203.0.113.30",
+			GatewayNode: "node-3",
+			PodSelector: &metav1.LabelSelector{},
+		}),
+		newTestEgressGatewayPod("default", "billing", map[string]string{"app": "billing"}, "10.242.0.2", "fd00::2"),
+		newTestEgressGatewayPod("default", "web", map[string]string{"app": "web"}, "10.242.0.3"),
+		newTestEgressGatewayPod("payments", "billing", map[string]string{"app": "billing"}, "10.242.1.2"),
+		newTestEgressGatewayPod("payments", "api", nil, "10.242.1.3"),
+		&v1core.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "payments", Name: "host"},
+			Spec:       v1core.PodSpec{HostNetwork: true},
+			Status:     v1core.PodStatus{Phase: v1core.PodRunning, PodIPs: []v1core.PodIP{{IP: "10.0.0.10"}}},
+		},
+		&v1core.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "payments", Name: "job"},
+			Status:     v1core.PodStatus{Phase: v1core.PodSucceeded, PodIPs: []v1core.PodIP{{IP: "10.242.1.4"}}},
+		},
+	)
+
+	desired := nrc.desiredEgressGateways()
+	assert.Len(t, desired, 2)
+
+	billing := desired[1]
+	assert.Equal(t, "a-billing", billing.name)
+	assert.Equal(t, []string{"10.242.0.2", "10.242.1.2"}, billing.podIPs)
+	assert.Equal(t, "0x1000000/0xff000000", billing.fwmark())
+	assert.Equal(t, "1001", billing.tableID())
+	assert.Equal(t, "kube-router-egress-1", billing.ipSetName())
+
+	payments := desired[2]
+	assert.Equal(t, "b-payments", payments.name)
+	assert.Equal(t, []string{"10.242.1.3"}, payments.podIPs)
+	assert.Equal(t, "10.0.0.11", payments.gatewayNodeIP.String())
+	assert.Equal(t, "0x2000000/0xff000000", payments.fwmark())
+	assert.Equal(t, "1002", payments.tableID())
+}
+
+func Test_egressGatewayRules(t *testing.T) {
+	nrc := newTestEgressGatewayController(t)
+	desired := map[int]*egressGateway{
+		1: {name: "local", index: 1, family: v1core.IPv4Protocol, egressIP: net.ParseIP("203.0.113.10"),
+			gatewayNode: "node-1"},
+		2: {name: "remote", index: 2, family: v1core.IPv4Protocol, egressIP: net.ParseIP("203.0.113.20"),
+			gatewayNode: "node-2", gatewayNodeIP: net.ParseIP("10.0.0.11")},
+		3: {name: "ipv6", index: 3, family: v1core.IPv6Protocol, egressIP: net.ParseIP("2001:db8::10"),
+			gatewayNode: "node-1"},
+	}
+	match := func(name, ipSet string) []string {
+		return []string{"-m", "comment", "--comment", "kube-router egress gateway " + name,
+			"-m", "set", "--match-set", ipSet, "src",
+			"-m", "set", "!", "--match-set", "kube-router-pod-subnets", "dst",
+			"-m", "set", "!", "--match-set", "kube-router-node-ips", "dst"}
+	}
+
+	rules := nrc.egressGatewayRules(desired, v1core.IPv4Protocol)
+	assert.Equal(t, [][]string{
+		append(match("remote", "kube-router-egress-2"), "-j", "MARK", "--set-xmark", "0x2000000/0xff000000"),
+	}, rules["mangle"])
+	assert.Equal(t, [][]string{
+		append(match("local", "kube-router-egress-1"), "-j", "SNAT", "--to-source", "203.0.113.10"),
+		{"-m", "comment", "--comment", "kube-router egress gateway remote",
+			"-m", "mark", "--mark", "0x2000000/0xff000000", "-j", "ACCEPT"},
+	}, rules["nat"])
+}
+
+func Test_egressGatewayTableIndex(t *testing.T) {
+	testcases := []struct {
+		tableID string
+		index   int
+		ok      bool
+	}{
+		{"1001", 1, true},
+		{"1255", 255, true},
+		{"1000", 0, false},
+		{"1256", 0, false},
+		{"77", 0, false},
+		{"kube-router", 0, false},
+	}
+	for _, testcase := range testcases {
+		index, ok := egressGatewayTableIndex(testcase.tableID)
+		assert.Equal(t, testcase.ok, ok, testcase.tableID)
+		assert.Equal(t, testcase.index, index, testcase.tableID)
+	}
+}
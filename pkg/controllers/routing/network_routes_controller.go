@@ -15,6 +15,7 @@ import (
 	"google.golang.org/protobuf/types/known/anypb"
 
 	"github.com/ccoveille/go-safecast"
+	"github.com/cloudnativelabs/kube-router/v2/pkg/bfd"
 	"github.com/cloudnativelabs/kube-router/v2/pkg/bgp"
 	"github.com/cloudnativelabs/kube-router/v2/pkg/healthcheck"
 	"github.com/cloudnativelabs/kube-router/v2/pkg/metrics"
@@ -41,9 +42,11 @@ const (
 	nodeASNAnnotation                = "kube-router.io/node.asn"
 	nodeCommunitiesAnnotation        = "kube-router.io/node.bgp.communities"
 	nodeCustomImportRejectAnnotation = "kube-router.io/node.bgp.customimportreject"
+	nodeLargeCommunitiesAnnotation   = "kube-router.io/node.bgp.large-communities"
 	pathPrependASNAnnotation         = "kube-router.io/path-prepend.as"
 	pathPrependRepeatNAnnotation     = "kube-router.io/path-prepend.repeat-n"
 	peerASNAnnotation                = "kube-router.io/peer.asns"
+	peerBFDAnnotation                = "kube-router.io/peer.bfd"
 	peerIPAnnotation                 = "kube-router.io/peer.ips"
 	peerLocalIPAnnotation            = "kube-router.io/peer.localips"
 	//nolint:gosec // this is not a hardcoded password
@@ -56,6 +59,9 @@ const (
 	svcAdvertiseClusterAnnotation      = "kube-router.io/service.advertise.clusterip"
 	svcAdvertiseExternalAnnotation     = "kube-router.io/service.advertise.externalip"
 	svcAdvertiseLoadBalancerAnnotation = "kube-router.io/service.advertise.loadbalancerip"
+	svcCommunitiesAnnotation           = "kube-router.io/service.bgp.communities"
+	svcLargeCommunitiesAnnotation      = "kube-router.io/service.bgp.large-communities"
+	svcLocalPrefAnnotation             = "kube-router.io/service.bgp.local-pref"
 
 	// Deprecated: use kube-router.io/service.advertise.loadbalancer instead
 	svcSkipLbIpsAnnotation = "kube-router.io/service.skiplbips"
@@ -82,6 +88,9 @@ type RouteSyncer interface {
 type PolicyBasedRouter interface {
 	Enable() error
 	Disable() error
+	EnableFwmarkRouting(fwmark, tableID string, gateway net.IP) error
+	DisableFwmarkRouting(fwmark, tableID string, isIPv6 bool) error
+	ListFwmarkRules(isIPv6 bool) (map[string]string, error)
 }
 
 // NetworkRoutingController is struct to hold necessary information required by controller
@@ -105,6 +114,7 @@ type NetworkRoutingController struct {
 	nodeAsnNumber                  uint32
 	nodeCustomImportRejectIPNets   []net.IPNet
 	nodeCommunities                []string
+	nodeLargeCommunities           []string
 	globalPeerRouters              []*gobgpapi.Peer
 	nodePeerRouters                []string
 	enableCNI                      bool
@@ -143,14 +153,32 @@ type NetworkRoutingController struct {
 	routeSyncer                    RouteSyncer
 	pbr                            PolicyBasedRouter
 	tunneler                       tunnels.Tunneler
-
-	nodeLister cache.Indexer
-	svcLister  cache.Indexer
-	epLister   cache.Indexer
-
-	NodeEventHandler      cache.ResourceEventHandler
-	ServiceEventHandler   cache.ResourceEventHandler
-	EndpointsEventHandler cache.ResourceEventHandler
+	wireGuard                      *tunnels.WireGuard
+	bfdEnabled                     bool
+	bfdManager                     *bfd.Manager
+	bfdPeers                       map[string]bool
+	bfdPeersMu                     sync.RWMutex
+	bfdDisabledPeers               map[string]bool
+	bfdMu                          sync.Mutex
+	bgpPeerResources               map[string]*bgpPeerResource
+	bgpPeersMu                     sync.Mutex
+	egressGatewayState             egressGatewayState
+	egressGatewaySyncRequestChan   chan struct{}
+
+	nodeLister          cache.Indexer
+	svcLister           cache.Indexer
+	epLister            cache.Indexer
+	bgpPeerLister       cache.Indexer
+	secretLister        cache.Indexer
+	egressGatewayLister cache.Indexer
+	podLister           cache.Indexer
+	nsLister            cache.Indexer
+
+	NodeEventHandler          cache.ResourceEventHandler
+	ServiceEventHandler       cache.ResourceEventHandler
+	EndpointsEventHandler     cache.ResourceEventHandler
+	BGPPeerEventHandler       cache.ResourceEventHandler
+	EgressGatewayEventHandler cache.ResourceEventHandler
 }
 
 // Run runs forever until we are notified on stop channel
@@ -200,6 +228,12 @@ func (nrc *NetworkRoutingController) Run(healthChan chan<- *healthcheck.Controll
 				klog.Errorf("Failed to enable FoU6 for tunnel overlay: %s", err.Error())
 			}
 		}
+		if nrc.wireGuard != nil {
+			// other nodes need our public key before they can send overlay traffic to us
+			if err := nrc.publishWireGuardPublicKey(); err != nil {
+				klog.Errorf("Failed to publish wireguard public key for tunnel overlay: %s", err.Error())
+			}
+		}
 	} else {
 		klog.V(1).Info("Tunnel Overlay disabled in configuration.")
 		klog.V(1).Info("Cleaning up old overlay networking if needed.")
@@ -333,6 +367,12 @@ func (nrc *NetworkRoutingController) Run(healthChan chan<- *healthcheck.Controll
 	}
 
 	nrc.bgpServerStarted = true
+	defer nrc.bfdManager.Stop()
+
+	if nrc.egressGatewayLister != nil {
+		wg.Add(1)
+		go nrc.runEgressGatewaySync(stopCh, wg)
+	}
 	if !nrc.bgpGracefulRestart {
 		defer func() {
 			err := nrc.bgpServer.StopBgp(context.Background(), &gobgpapi.StopBgpRequest{})
@@ -353,7 +393,7 @@ func (nrc *NetworkRoutingController) Run(healthChan chan<- *healthcheck.Controll
 		}
 
 		// Update ipset entries
-		if nrc.enablePodEgress || nrc.enableOverlays {
+		if nrc.enablePodEgress || nrc.enableOverlays || nrc.egressGatewayLister != nil {
 			klog.V(1).Info("Syncing ipsets")
 			err = nrc.syncNodeIPSets()
 			if err != nil {
@@ -361,6 +401,14 @@ func (nrc *NetworkRoutingController) Run(healthChan chan<- *healthcheck.Controll
 			}
 		}
 
+		// repair overlay tunnels that drifted from what was set up for the BGP next-hops
+		if netlinkTunnel, ok := nrc.tunneler.(*tunnels.NetlinkTunnel); ok && nrc.enableOverlays {
+			klog.V(1).Info("Reconciling overlay tunnels")
+			if err := netlinkTunnel.Reconcile(); err != nil {
+				klog.Errorf("Error reconciling overlay tunnels: %s", err.Error())
+			}
+		}
+
 		// enable IP forwarding for the packets coming in/out from the pods
 		err = nrc.enableForwarding()
 		if err != nil {
@@ -383,6 +431,13 @@ func (nrc *NetworkRoutingController) Run(healthChan chan<- *healthcheck.Controll
 			klog.Errorf("Error advertising route: %s", err.Error())
 		}
 
+		// reconcile the peers of BGPPeer resources, which also picks up changes to node labels and password secrets
+		nrc.syncBGPPeers()
+
+		if nrc.egressGatewayLister != nil {
+			nrc.RequestEgressGatewaySync()
+		}
+
 		err = nrc.AddPolicies()
 		if err != nil {
 			klog.Errorf("Error adding BGP policies: %s", err.Error())
@@ -628,7 +683,7 @@ func (nrc *NetworkRoutingController) injectRoute(path *gobgpapi.Path) error {
 				nextHop.String())
 			// Also delete route from state map so that it doesn't get re-synced after deletion
 			nrc.routeSyncer.DelInjectedRoute(dst)
-			tunnels.CleanupTunnel(dst, tunnelName)
+			nrc.tunneler.CleanupOverlayTunnel(tunnelName, nextHop, dst)
 			return nil
 		}
 
@@ -662,7 +717,7 @@ func (nrc *NetworkRoutingController) injectRoute(path *gobgpapi.Path) error {
 		// knowing that a tunnel shouldn't exist for this route, check to see if there are any lingering tunnels /
 		// routes that need to be cleaned up.
 		nrc.routeSyncer.DelInjectedRoute(dst)
-		tunnels.CleanupTunnel(dst, tunnelName)
+		nrc.tunneler.CleanupOverlayTunnel(tunnelName, nextHop, dst)
 	}
 
 	switch {
@@ -685,6 +740,11 @@ func (nrc *NetworkRoutingController) injectRoute(path *gobgpapi.Path) error {
 			Dst:       dst,
 			Protocol:  routes.ZebraOriginator,
 		}
+		if nrc.tunneler.EncapType() == tunnels.EncapTypeVXLAN {
+			// vxlan devices are layer 2, so the next-hop needs to be resolved on the device to reach the remote node
+			route.Gw = nextHop
+			route.Flags = int(netlink.FLAG_ONLINK)
+		}
 	case sameSubnet:
 		// if the nextHop is within the same subnet, add a route for the destination so that traffic can bet routed
 		// at layer 2 and minimize the need to traverse a router
@@ -759,6 +819,13 @@ func (nrc *NetworkRoutingController) Cleanup() {
 		klog.V(1).Infof("Error deleting Pod egress iptables rule: %s", err.Error())
 	}
 
+	// the egress gateway rules reference ipsets, so they need to be removed before the ipsets below
+	nrc.cleanupEgressGateways()
+
+	// the wireguard interface holds the node's private key, so don't leave it behind
+	tunnels.CleanupWireGuard()
+	tunnels.CleanupVXLAN()
+
 	// For some reason, if we go too fast into the ipset logic below it causes the system to think that the above
 	// iptables rules are still referencing the ipsets below, and we get errors
 	time.Sleep(1 * time.Second)
@@ -978,27 +1045,10 @@ func (nrc *NetworkRoutingController) startBgpServer(grpcServer bool) error {
 		nrc.pathPrependCount = uint8(repeatN)
 	}
 
-	var nodeCommunities []string
-	nodeBGPCommunitiesAnnotation, ok := node.Annotations[nodeCommunitiesAnnotation]
-	if !ok {
-		klog.V(1).Info("Did not find any BGP communities on current node's annotations. " +
-			"Not exporting communities.")
-	} else {
-		nodeCommunities = stringToSlice(nodeBGPCommunitiesAnnotation, ",")
-		for _, nodeCommunity := range nodeCommunities {
-			if err = bgp.ValidateCommunity(nodeCommunity); err != nil {
-				klog.Warningf("cannot add BGP community '%s' from node annotation as it does not appear "+
-					"to be a valid community identifier", nodeCommunity)
-				continue
-			}
-			klog.V(1).Infof("Adding the node community found from node annotation: %s", nodeCommunity)
-			nrc.nodeCommunities = append(nrc.nodeCommunities, nodeCommunity)
-		}
-		if len(nrc.nodeCommunities) < 1 {
-			klog.Warningf("Found a community specified via annotation %s with value %s but none could be "+
-				"validated", nodeCommunitiesAnnotation, nodeBGPCommunitiesAnnotation)
-		}
-	}
+	nrc.nodeCommunities = getNodeCommunitiesFromAnnotation(node, nodeCommunitiesAnnotation, "community",
+		bgp.ValidateCommunity)
+	nrc.nodeLargeCommunities = getNodeCommunitiesFromAnnotation(node, nodeLargeCommunitiesAnnotation,
+		"large community", bgp.ValidateLargeCommunity)
 
 	// Get Custom Import Reject CIDRs from annotations
 	nodeBGPCustomImportRejectAnnotation, ok := node.Annotations[nodeCustomImportRejectAnnotation]
@@ -1182,6 +1232,25 @@ func (nrc *NetworkRoutingController) startBgpServer(grpcServer bool) error {
 			}
 		}
 
+		// Get Global Peer Router BFD configs
+		nodeBGPPeerBFDAnnotation, ok := node.Annotations[peerBFDAnnotation]
+		if ok {
+			bfdStrings := stringToSlice(nodeBGPPeerBFDAnnotation, ",")
+			var bfdPeers map[string]bool
+			bfdPeers, err = parsePeerBFD(peerIPs, bfdStrings)
+			if err != nil {
+				err2 := nrc.bgpServer.StopBgp(context.Background(), &gobgpapi.StopBgpRequest{})
+				if err2 != nil {
+					klog.Errorf("Failed to stop bgpServer: %s", err2)
+				}
+
+				return fmt.Errorf("failed to parse node's Peer BFD Annotation: %s", err)
+			}
+			nrc.bfdPeersMu.Lock()
+			nrc.bfdPeers = bfdPeers
+			nrc.bfdPeersMu.Unlock()
+		}
+
 		// Create and set Global Peer Router complete configs
 		nrc.globalPeerRouters, err = newGlobalPeers(peerIPs, peerPorts, peerASNs, peerPasswords, peerLocalIPs,
 			nrc.bgpHoldtime, nrc.krNode.GetPrimaryNodeIP().String())
@@ -1270,7 +1339,10 @@ func (nrc *NetworkRoutingController) setupHandlers(node *v1core.Node) error {
 func NewNetworkRoutingController(clientset kubernetes.Interface,
 	kubeRouterConfig *options.KubeRouterConfig,
 	nodeInformer cache.SharedIndexInformer, svcInformer cache.SharedIndexInformer,
-	epInformer cache.SharedIndexInformer, ipsetMutex *sync.Mutex) (*NetworkRoutingController, error) {
+	epInformer cache.SharedIndexInformer, bgpPeerInformer cache.SharedIndexInformer,
+	secretInformer cache.SharedIndexInformer, egressGatewayInformer cache.SharedIndexInformer,
+	podInformer cache.SharedIndexInformer, nsInformer cache.SharedIndexInformer,
+	ipsetMutex *sync.Mutex) (*NetworkRoutingController, error) {
 
 	var err error
 
@@ -1282,6 +1354,8 @@ func NewNetworkRoutingController(clientset kubernetes.Interface,
 		metrics.DefaultRegisterer.MustRegister(metrics.ControllerBGPInternalPeersSyncTime)
 		metrics.DefaultRegisterer.MustRegister(metrics.ControllerBPGpeers)
 		metrics.DefaultRegisterer.MustRegister(metrics.ControllerRoutesSyncTime)
+		metrics.DefaultRegisterer.MustRegister(metrics.ControllerBFDSessionState)
+		metrics.DefaultRegisterer.MustRegister(metrics.ControllerBFDSessionStateChanges)
 		nrc.MetricsEnabled = true
 	}
 
@@ -1292,11 +1366,24 @@ func NewNetworkRoutingController(clientset kubernetes.Interface,
 	nrc.bgpGracefulRestartDeferralTime = kubeRouterConfig.BGPGracefulRestartDeferralTime
 	nrc.bgpGracefulRestartTime = kubeRouterConfig.BGPGracefulRestartTime
 	nrc.peerMultihopTTL = kubeRouterConfig.PeerMultihopTTL
+
+	if kubeRouterConfig.BFDInterval <= 0 {
+		return nil, errors.New("BFD interval must be greater than 0")
+	}
+	if kubeRouterConfig.BFDMultiplier == 0 {
+		return nil, errors.New("BFD multiplier must be greater than 0")
+	}
+	nrc.bfdEnabled = kubeRouterConfig.EnableBFD
+	nrc.bfdPeers = make(map[string]bool)
+	nrc.bfdDisabledPeers = make(map[string]bool)
+	// sessions are only started for peers that have BFD enabled, so this doesn't open any sockets otherwise
+	nrc.bfdManager = bfd.NewManager(kubeRouterConfig.BFDInterval, kubeRouterConfig.BFDMultiplier, nrc.onBFDStateChange)
 	nrc.enablePodEgress = kubeRouterConfig.EnablePodEgress
 	nrc.syncPeriod = kubeRouterConfig.RoutesSyncPeriod
 	nrc.overrideNextHop = kubeRouterConfig.OverrideNextHop
 	nrc.clientset = clientset
 	nrc.activeNodes = make(map[string]bool)
+	nrc.bgpPeerResources = make(map[string]*bgpPeerResource)
 	nrc.bgpRRClient = false
 	nrc.bgpRRServer = false
 	nrc.bgpServerStarted = false
@@ -1402,7 +1489,31 @@ func NewNetworkRoutingController(clientset kubernetes.Interface,
 		return nil, fmt.Errorf("unknown --overlay-encap-port option '%d' selected, unable to continue, err: %v",
 			overlayEncapPort, err)
 	}
-	nrc.tunneler = tunnels.NewOverlayTunnel(nrc.krNode, overlayEncap, overlayEncapPort)
+	switch kubeRouterConfig.OverlayTunnelBackend {
+	case options.OverlayTunnelBackendIPRoute2, "":
+	case options.OverlayTunnelBackendNetlink:
+	default:
+		return nil, fmt.Errorf("unknown --overlay-tunnel-backend option '%s' selected, valid values are %s or %s",
+			kubeRouterConfig.OverlayTunnelBackend, options.OverlayTunnelBackendIPRoute2,
+			options.OverlayTunnelBackendNetlink)
+	}
+	// vxlan and wireguard tunnels don't make use of the ip command, so the tunnel backend only applies to ipip and fou
+	if kubeRouterConfig.OverlayTunnelBackend == options.OverlayTunnelBackendNetlink &&
+		(overlayEncap == tunnels.EncapTypeIPIP || overlayEncap == tunnels.EncapTypeFOU) {
+		nrc.tunneler, err = tunnels.NewNetlinkTunnel(nrc.krNode, nil, overlayEncap, overlayEncapPort)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create netlink overlay tunneler: %v", err)
+		}
+	} else {
+		overlayTunnel := tunnels.NewOverlayTunnel(nrc.krNode, overlayEncap, overlayEncapPort)
+		if overlayEncap == tunnels.EncapTypeWireGuard {
+			nrc.wireGuard, err = overlayTunnel.EnableWireGuard(nrc.wireGuardPublicKeyForNextHop)
+			if err != nil {
+				return nil, fmt.Errorf("failed to enable wireguard overlay encapsulation: %v", err)
+			}
+		}
+		nrc.tunneler = overlayTunnel
+	}
 	nrc.CNIFirewallSetup = sync.NewCond(&sync.Mutex{})
 
 	nrc.bgpPort = kubeRouterConfig.BGPPort
@@ -1494,5 +1605,26 @@ func NewNetworkRoutingController(clientset kubernetes.Interface,
 	nrc.nodeLister = nodeInformer.GetIndexer()
 	nrc.NodeEventHandler = nrc.newNodeEventHandler()
 
+	// the BGPPeer informer is only available when the BGPPeer custom resource definition is installed
+	if bgpPeerInformer != nil {
+		nrc.bgpPeerLister = bgpPeerInformer.GetIndexer()
+		nrc.BGPPeerEventHandler = nrc.newBGPPeerEventHandler()
+	}
+
+	// the Secret informer only watches the namespace given by --bgp-peer-secrets-namespace, when it is set
+	if secretInformer != nil {
+		nrc.secretLister = secretInformer.GetIndexer()
+	}
+
+	// the EgressGateway informer is only available when egress gateways are enabled and the EgressGateway custom
+	// resource definition is installed
+	nrc.egressGatewaySyncRequestChan = make(chan struct{}, 1)
+	if egressGatewayInformer != nil {
+		nrc.egressGatewayLister = egressGatewayInformer.GetIndexer()
+		nrc.podLister = podInformer.GetIndexer()
+		nrc.nsLister = nsInformer.GetIndexer()
+		nrc.EgressGatewayEventHandler = nrc.newEgressGatewayEventHandler()
+	}
+
 	return &nrc, nil
 }
@@ -151,6 +151,35 @@ func getPodCIDRsFromAllNodeSources(node *v1core.Node) (podCIDRs []string) {
 	return node.Spec.PodCIDRs
 }
 
+// getNodeCommunitiesFromAnnotation returns the communities of the comma separated list in the given annotation of the
+// node that the validate func accepts, kind names the type of community in the log messages
+func getNodeCommunitiesFromAnnotation(node *v1core.Node, annotation, kind string,
+	validate func(string) error) []string {
+	value, ok := node.Annotations[annotation]
+	if !ok {
+		klog.V(1).Infof("Did not find %s on current node's annotations. Not exporting any BGP %s.", annotation,
+			kind)
+		return nil
+	}
+
+	var communities []string
+	for _, community := range stringToSlice(value, ",") {
+		community = strings.TrimSpace(community)
+		if err := validate(community); err != nil {
+			klog.Warningf("cannot add BGP %s '%s' from node annotation as it does not appear to be a valid %s "+
+				"identifier", kind, community, kind)
+			continue
+		}
+		klog.V(1).Infof("Adding the node %s found from node annotation: %s", kind, community)
+		communities = append(communities, community)
+	}
+	if len(communities) < 1 {
+		klog.Warningf("Found a %s specified via annotation %s with value %s but none could be validated", kind,
+			annotation, value)
+	}
+	return communities
+}
+
 // getBGPRouteInfoForVIP attempt to automatically find the subnet, BGP AFI/SAFI Family, and nexthop for a given VIP
 // based upon whether it is an IPv4 address or an IPv6 address. Returns slash notation subnet as uint32 suitable for
 // sending to GoBGP and an error if it is unable to determine the subnet automatically
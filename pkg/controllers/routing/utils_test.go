@@ -4,7 +4,10 @@ import (
 	"net"
 	"testing"
 
+	"github.com/cloudnativelabs/kube-router/v2/pkg/bgp"
 	"github.com/stretchr/testify/assert"
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 func Test_stringSliceToIPs(t *testing.T) {
@@ -50,3 +53,23 @@ func Test_stringSliceToIPNets(t *testing.T) {
 		assert.Nil(t, ips)
 	})
 }
+
+func Test_getNodeCommunitiesFromAnnotation(t *testing.T) {
+	node := &v1core.Node{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+		nodeCommunitiesAnnotation:      "100:200, no-export,invalid",
+		nodeLargeCommunitiesAnnotation: "65000:1:2, 65000:1",
+	}}}
+
+	t.Run("When the annotation is missing it returns no communities", func(t *testing.T) {
+		assert.Nil(t, getNodeCommunitiesFromAnnotation(&v1core.Node{}, nodeCommunitiesAnnotation, "community",
+			bgp.ValidateCommunity))
+	})
+	t.Run("When receive communities it returns the valid ones ignoring spaces", func(t *testing.T) {
+		assert.Equal(t, []string{"100:200", "no-export"},
+			getNodeCommunitiesFromAnnotation(node, nodeCommunitiesAnnotation, "community", bgp.ValidateCommunity))
+	})
+	t.Run("When receive large communities it returns the valid ones", func(t *testing.T) {
+		assert.Equal(t, []string{"65000:1:2"}, getNodeCommunitiesFromAnnotation(node, nodeLargeCommunitiesAnnotation,
+			"large community", bgp.ValidateLargeCommunity))
+	})
+}
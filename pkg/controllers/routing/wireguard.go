@@ -0,0 +1,97 @@
+package routing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+
+	"github.com/cloudnativelabs/kube-router/v2/pkg/tunnels"
+	"github.com/cloudnativelabs/kube-router/v2/pkg/utils"
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+)
+
+// publishWireGuardPublicKey annotates this node with its wireguard public key so that the other nodes can add it as a
+// peer of their wireguard interface
+func (nrc *NetworkRoutingController) publishWireGuardPublicKey() error {
+	node, err := utils.GetNodeObject(nrc.clientset, nrc.hostnameOverride)
+	if err != nil {
+		return fmt.Errorf("failed to get node object from api server: %v", err)
+	}
+
+	publicKey := nrc.wireGuard.PublicKey()
+	if node.Annotations[tunnels.WireGuardPublicKeyAnnotation] == publicKey {
+		return nil
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{tunnels.WireGuardPublicKeyAnnotation: publicKey},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to build node annotation patch: %v", err)
+	}
+
+	klog.Infof("Publishing wireguard public key %s in annotation %s of node %s", publicKey,
+		tunnels.WireGuardPublicKeyAnnotation, node.Name)
+	_, err = nrc.clientset.CoreV1().Nodes().Patch(context.Background(), node.Name, types.MergePatchType, patch,
+		metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to annotate node %s with its wireguard public key: %v", node.Name, err)
+	}
+	return nil
+}
+
+// wireGuardPublicKeyForNextHop returns the wireguard public key that was published by the node owning the given BGP
+// next-hop
+func (nrc *NetworkRoutingController) wireGuardPublicKeyForNextHop(nextHop net.IP) (string, error) {
+	for _, obj := range nrc.nodeLister.List() {
+		node := obj.(*v1core.Node)
+		krNode, err := utils.NewRemoteKRNode(node)
+		if err != nil {
+			klog.V(2).Infof("skipping node %s while looking up next-hop %s: %v", node.Name, nextHop, err)
+			continue
+		}
+		for _, nodeIP := range krNode.GetNodeIPAddrs() {
+			if !nodeIP.Equal(nextHop) {
+				continue
+			}
+			publicKey, ok := node.Annotations[tunnels.WireGuardPublicKeyAnnotation]
+			if !ok || publicKey == "" {
+				return "", fmt.Errorf("node %s hasn't published its wireguard public key in annotation %s yet",
+					node.Name, tunnels.WireGuardPublicKeyAnnotation)
+			}
+			return publicKey, nil
+		}
+	}
+	return "", fmt.Errorf("could not find a node for next-hop %s", nextHop)
+}
+
+// onWireGuardPublicKeyUpdate updates the wireguard peers when a node publishes a new public key, this is needed as
+// routes to a node may have been injected before the node published its key
+func (nrc *NetworkRoutingController) onWireGuardPublicKeyUpdate(oldObj, newObj interface{}) {
+	if nrc.wireGuard == nil {
+		return
+	}
+	oldNode, ok := oldObj.(*v1core.Node)
+	if !ok {
+		return
+	}
+	newNode, ok := newObj.(*v1core.Node)
+	if !ok {
+		return
+	}
+	if oldNode.Annotations[tunnels.WireGuardPublicKeyAnnotation] ==
+		newNode.Annotations[tunnels.WireGuardPublicKeyAnnotation] {
+		return
+	}
+
+	klog.V(1).Infof("wireguard public key of node %s changed, refreshing wireguard peers", newNode.Name)
+	if err := nrc.wireGuard.RefreshPeers(); err != nil {
+		klog.Errorf("failed to refresh wireguard peers: %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+package routing
+
+import (
+	"net"
+	"testing"
+
+	"github.com/cloudnativelabs/kube-router/v2/pkg/tunnels"
+	"github.com/stretchr/testify/assert"
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func Test_wireGuardPublicKeyForNextHop(t *testing.T) {
+	nodeLister := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, node := range []*v1core.Node{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "node-1",
+				Annotations: map[string]string{tunnels.WireGuardPublicKeyAnnotation: "node-1-key"},
+			},
+			Status: v1core.NodeStatus{Addresses: []v1core.NodeAddress{
+				{Type: v1core.NodeInternalIP, Address: "10.0.0.1"},
+				{Type: v1core.NodeInternalIP, Address: "2001:db8::1"},
+			}},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "node-2"},
+			Status: v1core.NodeStatus{Addresses: []v1core.NodeAddress{
+				{Type: v1core.NodeInternalIP, Address: "10.0.0.2"},
+			}},
+		},
+	} {
+		assert.NoError(t, nodeLister.Add(node))
+	}
+	nrc := &NetworkRoutingController{nodeLister: nodeLister}
+
+	testcases := []struct {
+		name        string
+		nextHop     string
+		expectedKey string
+		expectErr   bool
+	}{
+		{"IPv4 next-hop of a node with a published key", "10.0.0.1", "node-1-key", false},
+		{"IPv6 next-hop of a node with a published key", "2001:db8::1", "node-1-key", false},
+		{"next-hop of a node without a published key", "10.0.0.2", "", true},
+		{"next-hop that doesn't belong to any node", "10.0.0.3", "", true},
+	}
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			key, err := nrc.wireGuardPublicKeyForNextHop(net.ParseIP(testcase.nextHop))
+			if testcase.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, testcase.expectedKey, key)
+		})
+	}
+}
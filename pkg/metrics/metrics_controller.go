@@ -98,6 +98,18 @@ var (
 		Name:      "service_bps_out",
 		Help:      "Outgoing bytes per second",
 	}, []string{"svc_namespace", "service_name", "service_vip", "protocol", "port"})
+	// ServiceEndpointProbeHealthy Whether the active health probes of a service endpoint succeed
+	ServiceEndpointProbeHealthy = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "service_endpoint_probe_healthy",
+		Help:      "Whether the active health probes of a service endpoint succeed (1) or fail (0)",
+	}, []string{"svc_namespace", "service_name", "endpoint", "probe"})
+	// ServiceEndpointProbeFailures Number of failed active health probes of a service endpoint
+	ServiceEndpointProbeFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "service_endpoint_probe_failures_total",
+		Help:      "Number of failed active health probes of a service endpoint",
+	}, []string{"svc_namespace", "service_name", "endpoint", "probe"})
 	// ControllerIpvsServices Number of ipvs services in the instance
 	ControllerIpvsServices = prometheus.NewGauge(prometheus.GaugeOpts{
 		Namespace: namespace,
@@ -140,6 +152,12 @@ var (
 		Name:      "controller_ipvs_services_sync_time",
 		Help:      "Time it took for controller to sync ipvs services",
 	})
+	// ControllerIpvsServicesIncrementalSyncTime Time it took for controller to sync the ipvs services of changed services
+	ControllerIpvsServicesIncrementalSyncTime = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "controller_ipvs_services_incremental_sync_time",
+		Help:      "Time it took for controller to sync the ipvs services of changed services",
+	})
 	// ControllerRoutesSyncTime Time it took for controller to sync ipvs services
 	ControllerRoutesSyncTime = prometheus.NewHistogram(prometheus.HistogramOpts{
 		Namespace: namespace,
@@ -173,6 +191,24 @@ var (
 		},
 		[]string{"type"},
 	)
+	// ControllerBFDSessionState State of the BFD session with each BGP peer
+	ControllerBFDSessionState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "controller_bfd_session_state",
+		Help:      "State of the BFD session with a BGP peer (0 = AdminDown, 1 = Down, 2 = Init, 3 = Up)",
+	}, []string{"peer"})
+	// ControllerBFDSessionStateChanges Number of state changes of the BFD session with each BGP peer
+	ControllerBFDSessionStateChanges = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "controller_bfd_session_state_changes_total",
+		Help:      "Number of state changes of the BFD session with a BGP peer",
+	}, []string{"peer", "state"})
+	// ControllerIpvsConntrackEntriesRemoved Number of conntrack entries removed for stale IPVS services and destinations
+	ControllerIpvsConntrackEntriesRemoved = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "controller_ipvs_conntrack_entries_removed_total",
+		Help:      "Number of conntrack entries removed for stale IPVS services and destinations",
+	}, []string{"reason"})
 	// ControllerIpvsMetricsExportTime Time it took to export metrics
 	ControllerIpvsMetricsExportTime = prometheus.NewHistogram(prometheus.HistogramOpts{
 		Namespace: namespace,
@@ -209,6 +245,19 @@ var (
 		Name:      "controller_policy_ipsets",
 		Help:      "Active policy ipsets",
 	})
+	// ControllerPolicyDroppedPackets Number of packets dropped by network policies that were logged to NFLOG
+	ControllerPolicyDroppedPackets = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "controller_policy_dropped_packets_total",
+		Help:      "Number of packets dropped by network policies that were logged to NFLOG",
+	}, []string{"namespace", "pod", "direction"})
+	// ControllerPolicyAuditedPackets Number of packets that network policies in audit mode would drop that were logged
+	// to NFLOG
+	ControllerPolicyAuditedPackets = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "controller_policy_audited_packets_total",
+		Help:      "Number of packets that network policies in audit mode would drop that were logged to NFLOG",
+	}, []string{"namespace", "pod", "direction"})
 	// ControllerHostRoutesSyncTime Time it took for the host routes controller to sync to the system
 	ControllerHostRoutesSyncTime = prometheus.NewHistogram(prometheus.HistogramOpts{
 		Namespace: namespace,
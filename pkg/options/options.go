@@ -14,6 +14,16 @@ const (
 	defaultHealthCheckPort               = 20244
 	defaultOverlayTunnelEncapPort uint16 = 5555
 	defaultGoBGPAdminPort         uint16 = 50051
+
+	// FirewallBackendIPTables renders network policies with iptables-save/restore and ipsets
+	FirewallBackendIPTables = "iptables"
+	// FirewallBackendNFTables renders network policies as a single nftables table using nft sets
+	FirewallBackendNFTables = "nftables"
+
+	// OverlayTunnelBackendIPRoute2 sets up overlay tunnels by running the ip command of iproute2
+	OverlayTunnelBackendIPRoute2 = "iproute2"
+	// OverlayTunnelBackendNetlink sets up overlay tunnels directly via netlink
+	OverlayTunnelBackendNetlink = "netlink"
 )
 
 type KubeRouterConfig struct {
@@ -22,17 +32,24 @@ type KubeRouterConfig struct {
 	AdvertiseLoadBalancerIP        bool
 	AdvertiseNodePodCidr           bool
 	AutoMTU                        bool
+	BFDInterval                    time.Duration
+	BFDMultiplier                  uint8
 	BGPGracefulRestart             bool
 	BGPGracefulRestartDeferralTime time.Duration
 	BGPGracefulRestartTime         time.Duration
 	BGPHoldTime                    time.Duration
+	BGPPeerSecretsNamespace        string
 	BGPPort                        uint32
 	CacheSyncTimeout               time.Duration
 	CleanupConfig                  bool
 	ClusterAsn                     uint
 	ClusterIPCIDRs                 []string
 	DisableSrcDstCheck             bool
+	EnableAdminNetworkPolicy       bool
+	EnableBFD                      bool
 	EnableCNI                      bool
+	EnableEgressGateway            bool
+	EnableHostPort                 bool
 	EnableiBGP                     bool
 	EnableIPv4                     bool
 	EnableIPv6                     bool
@@ -41,6 +58,7 @@ type KubeRouterConfig struct {
 	EnablePprof                    bool
 	ExcludedCidrs                  []string
 	ExternalIPCIDRs                []string
+	FirewallBackend                string
 	FullMeshMode                   bool
 	GlobalHairpinMode              bool
 	GoBGPAdminPort                 uint16
@@ -63,11 +81,15 @@ type KubeRouterConfig struct {
 	MetricsPath                    string
 	MetricsPort                    uint16
 	MetricsAddr                    string
+	NetpolDropLog                  bool
+	NetpolDropLogRate              float64
+	NodePortAddresses              []string
 	NodePortBindOnAllIP            bool
 	NodePortRange                  string
 	OverlayType                    string
 	OverlayEncap                   string
 	OverlayEncapPort               uint16
+	OverlayTunnelBackend           string
 	OverrideNextHop                bool
 	PeerASNs                       []uint
 	PeerMultihopTTL                uint8
@@ -93,18 +115,22 @@ type KubeRouterConfig struct {
 func NewKubeRouterConfig() *KubeRouterConfig {
 	//nolint:mnd // Here we are specifying the names of the literals which is very similar to constant behavior
 	return &KubeRouterConfig{
+		BFDInterval:                    300 * time.Millisecond,
+		BFDMultiplier:                  3,
 		BGPGracefulRestartDeferralTime: 360 * time.Second,
 		BGPGracefulRestartTime:         90 * time.Second,
 		BGPHoldTime:                    90 * time.Second,
 		CacheSyncTimeout:               1 * time.Minute,
 		ClusterIPCIDRs:                 []string{"10.96.0.0/12"},
 		EnableOverlay:                  true,
+		FirewallBackend:                FirewallBackendIPTables,
 		IPTablesSyncPeriod:             5 * time.Minute,
 		InjectedRoutesSyncPeriod:       60 * time.Second,
 		IpvsGracefulPeriod:             30 * time.Second,
 		IpvsSyncPeriod:                 5 * time.Minute,
 		LoadBalancerSyncPeriod:         time.Minute,
 		NodePortRange:                  "30000-32767",
+		OverlayTunnelBackend:           OverlayTunnelBackendIPRoute2,
 		OverlayType:                    "subnet",
 		RoutesSyncPeriod:               5 * time.Minute,
 		ServiceTCPTimeout:              0 * time.Second,
@@ -126,6 +152,10 @@ func (s *KubeRouterConfig) AddFlags(fs *pflag.FlagSet) {
 	fs.BoolVar(&s.AutoMTU, "auto-mtu", true,
 		"Auto detect and set the largest possible MTU for kube-bridge and pod interfaces (also accounts for "+
 			"IPIP overlay network when enabled).")
+	fs.DurationVar(&s.BFDInterval, "bfd-interval", s.BFDInterval,
+		"Desired transmit and required receive interval of BFD control packets.")
+	fs.Uint8Var(&s.BFDMultiplier, "bfd-multiplier", s.BFDMultiplier,
+		"Number of BFD control packets that can be missed before a BGP peer is declared down.")
 	fs.BoolVar(&s.BGPGracefulRestart, "bgp-graceful-restart", false,
 		"Enables the BGP Graceful Restart capability so that routes are preserved on unexpected restarts")
 	fs.DurationVar(&s.BGPGracefulRestartDeferralTime, "bgp-graceful-restart-deferral-time",
@@ -137,6 +167,9 @@ func (s *KubeRouterConfig) AddFlags(fs *pflag.FlagSet) {
 		"This parameter is mainly used to modify the holdtime declared to BGP peer. When Kube-router goes down "+
 			"abnormally, the local saving time of BGP route will be affected. "+
 			"Holdtime must be in the range 3s to 18h12m16s.")
+	fs.StringVar(&s.BGPPeerSecretsNamespace, "bgp-peer-secrets-namespace", "",
+		"Namespace of the Secrets that BGPPeer resources reference for peer passwords, kube-router needs to get, "+
+			"list and watch Secrets in it. BGPPeer passwords are not supported when not set.")
 	fs.Uint32Var(&s.BGPPort, "bgp-port", DefaultBgpPort,
 		"The port open for incoming BGP connections and to use for connecting with other BGP peers.")
 	fs.DurationVar(&s.CacheSyncTimeout, "cache-sync-timeout", s.CacheSyncTimeout,
@@ -148,8 +181,20 @@ func (s *KubeRouterConfig) AddFlags(fs *pflag.FlagSet) {
 	fs.BoolVar(&s.DisableSrcDstCheck, "disable-source-dest-check", true,
 		"Disable the source-dest-check attribute for AWS EC2 instances. When this option is false, it must be "+
 			"set some other way.")
+	fs.BoolVar(&s.EnableAdminNetworkPolicy, "enable-admin-network-policy", false,
+		"Enables the enforcement of the AdminNetworkPolicy and BaselineAdminNetworkPolicy resources of the "+
+			"policy.networking.k8s.io API group by the network policy firewall.")
+	fs.BoolVar(&s.EnableBFD, "enable-bfd", false,
+		"Enables BFD for all BGP peers, so that peers are shut down as soon as they are unreachable. Can be "+
+			"overridden per peer with the kube-router.io/peer.bfd annotation.")
 	fs.BoolVar(&s.EnableCNI, "enable-cni", true,
 		"Enable CNI plugin. Disable if you want to use kube-router features alongside another CNI plugin.")
+	fs.BoolVar(&s.EnableEgressGateway, "enable-egress-gateway", false,
+		"Enables EgressGateway resources, which SNAT the traffic of the selected pods that leaves the cluster to a "+
+			"fixed egress IP on a gateway node.")
+	fs.BoolVar(&s.EnableHostPort, "enable-hostport", false,
+		"Enables the service proxy to DNAT the hostPorts of the pods on the node to the pods, so that the portmap CNI "+
+			"plugin isn't needed for hostPort support.")
 	fs.BoolVar(&s.EnableiBGP, "enable-ibgp", true,
 		"Enables peering with nodes with the same ASN, if disabled will only peer with external BGP peers")
 	fs.BoolVar(&s.EnableIPv4, "enable-ipv4", true, "Enables IPv4 support")
@@ -164,6 +209,9 @@ func (s *KubeRouterConfig) AddFlags(fs *pflag.FlagSet) {
 		"Enables pprof for debugging performance and memory leak issues.")
 	fs.StringSliceVar(&s.ExcludedCidrs, "excluded-cidrs", s.ExcludedCidrs,
 		"Excluded CIDRs are used to exclude IPVS rules from deletion.")
+	fs.StringVar(&s.FirewallBackend, "firewall-backend", s.FirewallBackend,
+		"Backend used by the network policy and service proxy controllers to program firewall rules. Valid values are "+
+			"\""+FirewallBackendIPTables+"\" or \""+FirewallBackendNFTables+"\".")
 	fs.BoolVar(&s.GlobalHairpinMode, "hairpin-mode", false,
 		"Add iptables rules for every Service Endpoint to support hairpin traffic.")
 	fs.Uint16Var(&s.GoBGPAdminPort, "gobgp-admin-port", defaultGoBGPAdminPort,
@@ -203,15 +251,28 @@ func (s *KubeRouterConfig) AddFlags(fs *pflag.FlagSet) {
 	fs.Uint16Var(&s.MetricsPort, "metrics-port", 0, "Prometheus metrics port, (Default 0, Disabled)")
 	fs.StringVar(&s.MetricsAddr, "metrics-addr", "", "Prometheus metrics address to listen on, (Default: all "+
 		"interfaces)")
+	fs.BoolVar(&s.NetpolDropLog, "netpol-drop-log", false,
+		"Listens on NFLOG group 100 for the packets that are dropped by network policies and logs each of them as a "+
+			"JSON line on stdout, attributed to the pod and the network policies that apply to it.")
+	fs.Float64Var(&s.NetpolDropLogRate, "netpol-drop-log-rate", 1,
+		"The maximum number of dropped packets per second that are logged by --netpol-drop-log for each pod and "+
+			"direction, dropped packets beyond the rate are only counted.")
+	fs.StringSliceVar(&s.NodePortAddresses, "nodeport-addresses", s.NodePortAddresses,
+		"Comma-separated list of CIDRs and interface names, for service of NodePort type create IPVS services "+
+			"only on the IPs of the node that are in one of the CIDRs or on one of the interfaces. Overrides "+
+			"--nodeport-bindon-all-ip.")
 	fs.BoolVar(&s.NodePortBindOnAllIP, "nodeport-bindon-all-ip", false,
 		"For service of NodePort type create IPVS service that listens on all IP's of the node.")
 	fs.BoolVar(&s.FullMeshMode, "nodes-full-mesh", true,
 		"Each node in the cluster will setup BGP peering with rest of the nodes.")
 	fs.StringVar(&s.OverlayEncap, "overlay-encap", "ipip",
-		"Valid encapsulation types are \"ipip\" or \"fou\" "+
-			"(if set to \"fou\", the udp port can be specified via \"overlay-encap-port\")")
+		"Valid encapsulation types are \"ipip\", \"fou\", \"vxlan\" or \"wireguard\" "+
+			"(if set to \"fou\", \"vxlan\" or \"wireguard\", the udp port can be specified via \"overlay-encap-port\")")
 	fs.Uint16Var(&s.OverlayEncapPort, "overlay-encap-port", defaultOverlayTunnelEncapPort,
-		"Overlay tunnel encapsulation port (only used for \"fou\" encapsulation)")
+		"Overlay tunnel encapsulation port (only used for \"fou\", \"vxlan\" and \"wireguard\" encapsulation)")
+	fs.StringVar(&s.OverlayTunnelBackend, "overlay-tunnel-backend", s.OverlayTunnelBackend,
+		"Backend used to set up \"ipip\" and \"fou\" overlay tunnels. Valid values are "+
+			"\""+OverlayTunnelBackendIPRoute2+"\" or \""+OverlayTunnelBackendNetlink+"\".")
 	fs.StringVar(&s.OverlayType, "overlay-type", s.OverlayType,
 		"Possible values: subnet,full - "+
 			"When set to \"subnet\", the default, default \"--enable-overlay=true\" behavior is used. "+
@@ -2,6 +2,7 @@ package routes
 
 import (
 	"fmt"
+	"net"
 	"os/exec"
 	"strings"
 
@@ -106,3 +107,99 @@ func (pbr *PolicyBasedRules) Disable() error {
 
 	return nil
 }
+
+func ipProtocolForIP(ip net.IP) string {
+	if ip.To4() != nil {
+		return "-4"
+	}
+	return "-6"
+}
+
+// EnableFwmarkRouting sets up a routing table that sends packets to the given gateway and a rule that looks up packets
+// carrying the fwmark in it. The fwmark is specified as <mark>/<mask> in hexadecimal the same way iproute2 lists it,
+// e.g. 0x1000000/0xff000000.
+func (pbr *PolicyBasedRules) EnableFwmarkRouting(fwmark, tableID string, gateway net.IP) error {
+	ipProtocol := ipProtocolForIP(gateway)
+
+	err := exec.Command("ip", ipProtocol, "route", "replace", "default", "via", gateway.String(),
+		"table", tableID).Run()
+	if err != nil {
+		return fmt.Errorf("failed to add route via %s to table %s due to: %s", gateway, tableID, err.Error())
+	}
+
+	out, err := exec.Command("ip", ipProtocol, "rule", "list").Output()
+	if err != nil {
+		return fmt.Errorf("failed to verify if `ip rule` exists: %s", err.Error())
+	}
+	if !strings.Contains(string(out), fmt.Sprintf("fwmark %s lookup %s", fwmark, tableID)) {
+		err = exec.Command("ip", ipProtocol, "rule", "add", "fwmark", fwmark, "lookup", tableID).Run()
+		if err != nil {
+			return fmt.Errorf("failed to add ip rule due to: %s", err.Error())
+		}
+	}
+
+	return nil
+}
+
+// DisableFwmarkRouting removes the rule that looks up packets carrying the fwmark in the routing table and flushes the
+// routing table
+func (pbr *PolicyBasedRules) DisableFwmarkRouting(fwmark, tableID string, isIPv6 bool) error {
+	ipProtocol := "-4"
+	if isIPv6 {
+		ipProtocol = "-6"
+	}
+
+	out, err := exec.Command("ip", ipProtocol, "rule", "list").Output()
+	if err != nil {
+		return fmt.Errorf("failed to verify if `ip rule` exists: %s", err.Error())
+	}
+	if strings.Contains(string(out), fmt.Sprintf("fwmark %s lookup %s", fwmark, tableID)) {
+		err = exec.Command("ip", ipProtocol, "rule", "del", "fwmark", fwmark, "lookup", tableID).Run()
+		if err != nil {
+			return fmt.Errorf("failed to delete ip rule due to: %s", err.Error())
+		}
+	}
+
+	err = exec.Command("ip", ipProtocol, "route", "flush", "table", tableID).Run()
+	if err != nil {
+		return fmt.Errorf("failed to flush routing table %s due to: %s", tableID, err.Error())
+	}
+
+	return nil
+}
+
+// ListFwmarkRules returns the routing tables of all rules that look up packets by fwmark, keyed by the fwmark
+func (pbr *PolicyBasedRules) ListFwmarkRules(isIPv6 bool) (map[string]string, error) {
+	ipProtocol := "-4"
+	if isIPv6 {
+		ipProtocol = "-6"
+	}
+
+	out, err := exec.Command("ip", ipProtocol, "rule", "list").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ip rules: %s", err.Error())
+	}
+	return parseFwmarkRules(string(out)), nil
+}
+
+// parseFwmarkRules parses the fwmark rules out of `ip rule list` output, which looks like:
+// 32765:	from all fwmark 0x1000000/0xff000000 lookup 1001
+func parseFwmarkRules(ipRuleList string) map[string]string {
+	rules := make(map[string]string)
+	for _, line := range strings.Split(ipRuleList, "\n") {
+		fields := strings.Fields(line)
+		var fwmark, table string
+		for i := 0; i < len(fields)-1; i++ {
+			switch fields[i] {
+			case "fwmark":
+				fwmark = fields[i+1]
+			case "lookup":
+				table = fields[i+1]
+			}
+		}
+		if fwmark != "" && table != "" {
+			rules[fwmark] = table
+		}
+	}
+	return rules
+}
@@ -0,0 +1,21 @@
+package routes
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseFwmarkRules(t *testing.T) {
+	ipRuleList := `0:	from all lookup local
+32763:	from all fwmark 0x2000000/0xff000000 lookup 1002
+32764:	from all fwmark 0x1000000/0xff000000 lookup 1001
+32765:	from 10.242.0.0/24 lookup kube-router
+32766:	from all lookup main
+32767:	from all lookup default
+`
+	assert.Equal(t, map[string]string{
+		"0x1000000/0xff000000": "1001",
+		"0x2000000/0xff000000": "1002",
+	}, parseFwmarkRules(ipRuleList))
+}
@@ -17,8 +17,10 @@ import (
 )
 
 const (
-	EncapTypeFOU  = EncapType("fou")
-	EncapTypeIPIP = EncapType("ipip")
+	EncapTypeFOU       = EncapType("fou")
+	EncapTypeIPIP      = EncapType("ipip")
+	EncapTypeVXLAN     = EncapType("vxlan")
+	EncapTypeWireGuard = EncapType("wireguard")
 
 	// FOU modes used for the iproute2 tooling
 	fouIPv4LinkMode = "ipip"
@@ -34,7 +36,7 @@ const (
 )
 
 var (
-	validEncapTypes = []EncapType{EncapTypeFOU, EncapTypeIPIP}
+	validEncapTypes = []EncapType{EncapTypeFOU, EncapTypeIPIP, EncapTypeVXLAN, EncapTypeWireGuard}
 )
 
 // EncapType represents the type of encapsulation used for an overlay tunnel in kube-router.
@@ -77,6 +79,7 @@ func ParseEncapPort(encapPort uint16) (EncapPort, error) {
 
 type Tunneler interface {
 	SetupOverlayTunnel(tunnelName string, nextHop net.IP, nextHopSubnet *net.IPNet) (netlink.Link, error)
+	CleanupOverlayTunnel(tunnelName string, nextHop net.IP, nextHopSubnet *net.IPNet)
 	EncapType() EncapType
 	EncapPort() EncapPort
 }
@@ -85,14 +88,20 @@ type OverlayTunnel struct {
 	krNode    utils.NodeIPAware
 	encapPort EncapPort
 	encapType EncapType
+	vxlan     *VXLAN
+	wireGuard *WireGuard
 }
 
 func NewOverlayTunnel(krNode utils.NodeIPAware, encapType EncapType, encapPort EncapPort) *OverlayTunnel {
-	return &OverlayTunnel{
+	o := &OverlayTunnel{
 		krNode:    krNode,
 		encapPort: encapPort,
 		encapType: encapType,
 	}
+	if encapType == EncapTypeVXLAN {
+		o.vxlan = NewVXLAN(krNode, encapPort)
+	}
+	return o
 }
 
 func (o *OverlayTunnel) EncapType() EncapType {
@@ -103,9 +112,38 @@ func (o *OverlayTunnel) EncapPort() EncapPort {
 	return o.encapPort
 }
 
+// EnableWireGuard creates the WireGuard that is used to set up overlay tunnels when the wireguard encapsulation is
+// configured, peerKeyFunc is used to look up the public key of the node behind a BGP next-hop
+func (o *OverlayTunnel) EnableWireGuard(peerKeyFunc WireGuardPublicKeyFunc) (*WireGuard, error) {
+	wg, err := NewWireGuard(o.encapPort, peerKeyFunc)
+	if err != nil {
+		return nil, err
+	}
+	o.wireGuard = wg
+	return wg, nil
+}
+
 // setupOverlayTunnel attempts to create a tunnel link and corresponding routes for IPIP based overlay networks
 func (o *OverlayTunnel) SetupOverlayTunnel(tunnelName string, nextHop net.IP,
 	nextHopSubnet *net.IPNet) (netlink.Link, error) {
+	switch o.encapType {
+	case EncapTypeWireGuard, EncapTypeVXLAN:
+		// All wireguard and vxlan traffic goes through a single interface, clean up the per node tunnel in case the
+		// node was previously configured with ipip or fou tunnels
+		if _, err := netlink.LinkByName(tunnelName); err == nil {
+			klog.Infof("Was configured to use %s, but found existing tunnel %s in place, cleaning up",
+				o.encapType, tunnelName)
+			CleanupTunnel(nextHopSubnet, tunnelName)
+		}
+		if o.encapType == EncapTypeVXLAN {
+			return o.vxlan.SetupPeer(nextHop, nextHopSubnet)
+		}
+		if o.wireGuard == nil {
+			return nil, fmt.Errorf("wireguard encapsulation was selected, but wireguard was not enabled")
+		}
+		return o.wireGuard.SetupPeer(nextHop, nextHopSubnet)
+	}
+
 	var out []byte
 	link, err := netlink.LinkByName(tunnelName)
 
@@ -244,6 +282,23 @@ func (o *OverlayTunnel) SetupOverlayTunnel(tunnelName string, nextHop net.IP,
 	return link, nil
 }
 
+// CleanupOverlayTunnel removes the tunnel and routes for the given next-hop that were set up by SetupOverlayTunnel,
+// for wireguard and vxlan only the peer configuration of the next-hop is removed as the interface is shared by all
+// next-hops
+func (o *OverlayTunnel) CleanupOverlayTunnel(tunnelName string, nextHop net.IP, nextHopSubnet *net.IPNet) {
+	CleanupTunnel(nextHopSubnet, tunnelName)
+	if o.vxlan != nil {
+		if err := o.vxlan.RemovePeer(nextHop, nextHopSubnet); err != nil {
+			klog.Errorf("failed to remove vxlan entries for next-hop %s: %v", nextHop, err)
+		}
+	}
+	if o.wireGuard != nil {
+		if err := o.wireGuard.RemovePeer(nextHop, nextHopSubnet); err != nil {
+			klog.Errorf("failed to remove wireguard peer for next-hop %s: %v", nextHop, err)
+		}
+	}
+}
+
 // cleanupTunnel removes any traces of tunnels / routes that were setup by nrc.setupOverlayTunnel() and are no longer
 // needed. All errors are logged only, as we want to attempt to perform all cleanup actions regardless of their success
 func CleanupTunnel(destinationSubnet *net.IPNet, tunnelName string) {
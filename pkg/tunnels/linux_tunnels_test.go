@@ -38,3 +38,25 @@ func Test_GenerateTunnelName(t *testing.T) {
 		})
 	}
 }
+
+func Test_ParseEncapType(t *testing.T) {
+	testcases := []struct {
+		encapType string
+		expected  EncapType
+		valid     bool
+	}{
+		{"ipip", EncapTypeIPIP, true},
+		{"fou", EncapTypeFOU, true},
+		{"vxlan", EncapTypeVXLAN, true},
+		{"wireguard", EncapTypeWireGuard, true},
+		{"gre", "", false},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.encapType, func(t *testing.T) {
+			encap, ok := ParseEncapType(testcase.encapType)
+			assert.Equal(t, testcase.valid, ok)
+			assert.Equal(t, testcase.expected, encap)
+		})
+	}
+}
@@ -0,0 +1,314 @@
+package tunnels
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/cloudnativelabs/kube-router/v2/pkg/routes"
+	"github.com/cloudnativelabs/kube-router/v2/pkg/utils"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// tunnelEncapTypeGUE is the kernel's TUNNEL_ENCAP_GUE encapsulation type of ipip and ip6tnl links
+	tunnelEncapTypeGUE = 2
+	// the TTL that is used for FoU tunnels, matches the TTL that the iproute2 based tunnels are created with
+	fouTunnelTTL = 225
+	// the hop limit and encapsulation limit that iproute2 uses by default for ip6tnl links
+	ip6tnlDefaultHopLimit   = 64
+	ip6tnlDefaultEncapLimit = 4
+	// PMTU discovery is always enabled for the tunnels, as it is by iproute2
+	iptunPMTUDiscEnabled = 1
+)
+
+// TunnelNetlinkHandle is the subset of netlink operations that are used by NetlinkTunnel, it is implemented by
+// *netlink.Handle and by FakeTunnelNetlinkHandle for testing
+type TunnelNetlinkHandle interface {
+	LinkByName(name string) (netlink.Link, error)
+	LinkAdd(link netlink.Link) error
+	LinkDel(link netlink.Link) error
+	LinkSetUp(link netlink.Link) error
+	FouList(fam int) ([]netlink.Fou, error)
+	FouAdd(f netlink.Fou) error
+	FouDel(f netlink.Fou) error
+	RouteListFiltered(family int, filter *netlink.Route, filterMask uint64) ([]netlink.Route, error)
+	RouteReplace(route *netlink.Route) error
+	RouteDel(route *netlink.Route) error
+}
+
+// desiredTunnel is a tunnel that NetlinkTunnel set up for a BGP next-hop
+type desiredTunnel struct {
+	link    netlink.Link
+	nextHop net.IP
+}
+
+// NetlinkTunnel is a Tunneler for ipip and fou tunnels that is built entirely on netlink instead of parsing the output
+// of the ip command. It keeps track of the tunnels that it set up, so that Reconcile can diff them against the links,
+// FoU ports and routes that actually exist on the node and repair any drift.
+type NetlinkTunnel struct {
+	krNode    utils.NodeIPAware
+	nlHandle  TunnelNetlinkHandle
+	encapPort EncapPort
+	encapType EncapType
+
+	mu      sync.Mutex
+	desired map[string]*desiredTunnel
+}
+
+// NewNetlinkTunnel returns a NetlinkTunnel for the given encapsulation, which has to be ipip or fou. If nlHandle is
+// nil, the netlink handle of the current network namespace is used.
+func NewNetlinkTunnel(krNode utils.NodeIPAware, nlHandle TunnelNetlinkHandle, encapType EncapType,
+	encapPort EncapPort) (*NetlinkTunnel, error) {
+	if encapType != EncapTypeIPIP && encapType != EncapTypeFOU {
+		return nil, fmt.Errorf("netlink tunnels only support %s and %s encapsulation, not %s", EncapTypeIPIP,
+			EncapTypeFOU, encapType)
+	}
+	if nlHandle == nil {
+		nlHandle = &netlink.Handle{}
+	}
+	return &NetlinkTunnel{
+		krNode:    krNode,
+		nlHandle:  nlHandle,
+		encapPort: encapPort,
+		encapType: encapType,
+		desired:   make(map[string]*desiredTunnel),
+	}, nil
+}
+
+func (n *NetlinkTunnel) EncapType() EncapType {
+	return n.encapType
+}
+
+func (n *NetlinkTunnel) EncapPort() EncapPort {
+	return n.encapPort
+}
+
+// SetupOverlayTunnel ensures that a tunnel link to nextHop with the configured encapsulation and the route for nextHop
+// in the custom routing table exist, and returns the link
+func (n *NetlinkTunnel) SetupOverlayTunnel(tunnelName string, nextHop net.IP,
+	nextHopSubnet *net.IPNet) (netlink.Link, error) {
+	desired, err := n.desiredTunnelLink(tunnelName, nextHop)
+	if err != nil {
+		return nil, err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	link, err := n.reconcileTunnel(desired, nextHop)
+	if err != nil {
+		return nil, fmt.Errorf("route not injected for the route advertised by the node %s: %v", nextHop, err)
+	}
+	n.desired[tunnelName] = &desiredTunnel{link: desired, nextHop: nextHop}
+	klog.V(2).Infof("Tunnel %s to %s is in place for destination %s", tunnelName, nextHop, nextHopSubnet)
+	return link, nil
+}
+
+// CleanupOverlayTunnel removes the routes to nextHopSubnet and the tunnel link that were set up by
+// SetupOverlayTunnel. All errors are logged only, as we want to attempt to perform all cleanup actions regardless of
+// their success.
+func (n *NetlinkTunnel) CleanupOverlayTunnel(tunnelName string, nextHop net.IP, nextHopSubnet *net.IPNet) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	delete(n.desired, tunnelName)
+
+	klog.V(1).Infof("Cleaning up old routes for %s if there are any", nextHopSubnet.String())
+	staleRoutes, err := n.nlHandle.RouteListFiltered(netlink.FAMILY_ALL, &netlink.Route{
+		Dst: nextHopSubnet, Protocol: routes.ZebraOriginator,
+	}, netlink.RT_FILTER_DST|netlink.RT_FILTER_PROTOCOL)
+	if err != nil {
+		klog.Errorf("Failed to get routes for %s from netlink: %v", nextHopSubnet, err)
+	}
+	for i := range staleRoutes {
+		if err = n.nlHandle.RouteDel(&staleRoutes[i]); err != nil {
+			klog.Errorf("Failed to cleanup route %s: %v", staleRoutes[i].String(), err)
+		}
+	}
+
+	klog.V(1).Infof("Cleaning up any lingering tunnel interfaces named: %s", tunnelName)
+	if link, err := n.nlHandle.LinkByName(tunnelName); err == nil {
+		// routes in the custom table that point at the link are removed by the kernel along with it
+		if err = n.nlHandle.LinkDel(link); err != nil {
+			klog.Errorf("failed to delete tunnel link %s for the node %s due to %v", tunnelName, nextHop, err)
+		}
+	}
+}
+
+// Reconcile diffs all tunnels that were set up by SetupOverlayTunnel against the actual state of the node and
+// recreates links, FoU ports and custom table routes that went missing or no longer match
+func (n *NetlinkTunnel) Reconcile() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	var errs []error
+	for name, tunnel := range n.desired {
+		if _, err := n.reconcileTunnel(tunnel.link, tunnel.nextHop); err != nil {
+			errs = append(errs, fmt.Errorf("failed to reconcile tunnel %s: %v", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// desiredTunnelLink returns the ipip or ip6tnl link that should exist for the given next-hop
+func (n *NetlinkTunnel) desiredTunnelLink(tunnelName string, nextHop net.IP) (netlink.Link, error) {
+	var localIP net.IP
+	if nextHop.To4() != nil {
+		localIP = n.krNode.FindBestIPv4NodeAddress()
+	} else {
+		localIP = n.krNode.FindBestIPv6NodeAddress()
+	}
+	if localIP == nil {
+		return nil, fmt.Errorf("not able to find an appropriate configured IP address on node for destination "+
+			"IP family: %s", nextHop.String())
+	}
+
+	if nextHop.To4() != nil {
+		link := &netlink.Iptun{
+			LinkAttrs: netlink.LinkAttrs{Name: tunnelName},
+			Local:     localIP,
+			Remote:    nextHop,
+			PMtuDisc:  iptunPMTUDiscEnabled,
+			Proto:     unix.IPPROTO_IPIP,
+		}
+		if n.encapType == EncapTypeFOU {
+			link.Ttl = fouTunnelTTL
+			link.EncapType = tunnelEncapTypeGUE
+			link.EncapDport = uint16(n.encapPort)
+		}
+		return link, nil
+	}
+
+	link := &netlink.Ip6tnl{
+		LinkAttrs:  netlink.LinkAttrs{Name: tunnelName},
+		Local:      localIP,
+		Remote:     nextHop,
+		Ttl:        ip6tnlDefaultHopLimit,
+		EncapLimit: ip6tnlDefaultEncapLimit,
+		Proto:      unix.IPPROTO_IPV6,
+	}
+	if n.encapType == EncapTypeFOU {
+		link.Ttl = fouTunnelTTL
+		link.EncapType = tunnelEncapTypeGUE
+		link.EncapDport = uint16(n.encapPort)
+	}
+	return link, nil
+}
+
+// reconcileTunnel makes the actual tunnel link, its FoU port and its custom table route match the desired link.
+// Callers must hold n.mu.
+func (n *NetlinkTunnel) reconcileTunnel(desired netlink.Link, nextHop net.IP) (netlink.Link, error) {
+	family := netlink.FAMILY_V4
+	if nextHop.To4() == nil {
+		family = netlink.FAMILY_V6
+	}
+	if err := n.reconcileFOUPort(family); err != nil {
+		return nil, err
+	}
+
+	name := desired.Attrs().Name
+	link, err := n.nlHandle.LinkByName(name)
+	if err == nil && !tunnelLinkMatches(link, desired) {
+		klog.Infof("Tunnel interface %s doesn't match the desired %s tunnel to %s anymore, recreating it", name,
+			n.encapType, nextHop)
+		if err = n.nlHandle.LinkDel(link); err != nil {
+			return nil, fmt.Errorf("failed to delete tunnel interface %s: %v", name, err)
+		}
+		link = nil
+	}
+	if link == nil {
+		klog.Infof("Creating tunnel %s of type %s with encap %s for destination %s", name, desired.Type(),
+			n.encapType, nextHop)
+		// netlink records the index of the created link in the desired link, which mustn't be reused when the link
+		// needs to be created again
+		desired.Attrs().Index = 0
+		if err = n.nlHandle.LinkAdd(desired); err != nil {
+			return nil, fmt.Errorf("failed to create tunnel interface %s: %v", name, err)
+		}
+		if link, err = n.nlHandle.LinkByName(name); err != nil {
+			return nil, fmt.Errorf("failed to get tunnel interface %s by name: %v", name, err)
+		}
+	}
+	if link.Attrs().Flags&net.FlagUp == 0 {
+		if err = n.nlHandle.LinkSetUp(link); err != nil {
+			return nil, fmt.Errorf("failed to bring tunnel interface %s up due to: %v", name, err)
+		}
+	}
+
+	// Now that the tunnel link exists, we need to add a route to it, so the node knows where to send traffic bound
+	// for this interface
+	table, err := strconv.Atoi(routes.CustomTableID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid custom route table %s: %v", routes.CustomTableID, err)
+	}
+	bits := 128
+	if family == netlink.FAMILY_V4 {
+		bits = 32
+	}
+	route := &netlink.Route{
+		LinkIndex: link.Attrs().Index,
+		Dst:       &net.IPNet{IP: nextHop, Mask: net.CIDRMask(bits, bits)},
+		Table:     table,
+	}
+	if err = n.nlHandle.RouteReplace(route); err != nil {
+		return nil, fmt.Errorf("failed to add route in custom route table, err: %v", err)
+	}
+	return link, nil
+}
+
+// reconcileFOUPort ensures that the GUE port for the given family exists when using FoU encapsulation, and that it
+// doesn't when using ipip encapsulation. Callers must hold n.mu.
+func (n *NetlinkTunnel) reconcileFOUPort(family int) error {
+	fous, err := n.nlHandle.FouList(family)
+	if err != nil {
+		// the fou generic netlink family doesn't exist until the fou module was loaded, which means there are no ports
+		if n.encapType != EncapTypeFOU {
+			return nil
+		}
+		fous = nil
+	}
+
+	fou := netlink.Fou{Family: family, Port: int(n.encapPort), EncapType: netlink.FOU_ENCAP_GUE}
+	exists := false
+	for _, existing := range fous {
+		if existing.Port == fou.Port && existing.EncapType == netlink.FOU_ENCAP_GUE {
+			exists = true
+			break
+		}
+	}
+
+	switch {
+	case n.encapType == EncapTypeFOU && !exists:
+		if err = n.nlHandle.FouAdd(fou); err != nil {
+			return fmt.Errorf("failed to set FoU tunnel port %d: %v", fou.Port, err)
+		}
+	case n.encapType != EncapTypeFOU && exists:
+		// If we are transitioning from FoU to IPIP we also need to clean up the old FoU port
+		if err = n.nlHandle.FouDel(fou); err != nil {
+			klog.Warningf("failed to clean up previous FoU tunnel port (this is only a warning because it won't "+
+				"stop kube-router from working for now, but still shouldn't have happened) - error: %v", err)
+		}
+	}
+	return nil
+}
+
+// tunnelLinkMatches compares the attributes of the actual tunnel link that kube-router cares about with the desired
+// one
+func tunnelLinkMatches(actual, desired netlink.Link) bool {
+	switch d := desired.(type) {
+	case *netlink.Iptun:
+		a, ok := actual.(*netlink.Iptun)
+		return ok && a.Local.Equal(d.Local) && a.Remote.Equal(d.Remote) && a.EncapType == d.EncapType &&
+			a.EncapDport == d.EncapDport
+	case *netlink.Ip6tnl:
+		a, ok := actual.(*netlink.Ip6tnl)
+		return ok && a.Local.Equal(d.Local) && a.Remote.Equal(d.Remote) && a.EncapType == d.EncapType &&
+			a.EncapDport == d.EncapDport && a.Proto == d.Proto
+	}
+	return false
+}
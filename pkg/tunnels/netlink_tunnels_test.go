@@ -0,0 +1,116 @@
+package tunnels
+
+import (
+	"net"
+	"testing"
+
+	"github.com/cloudnativelabs/kube-router/v2/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+	v1core "k8s.io/api/core/v1"
+)
+
+func newTestNetlinkTunnel(t *testing.T, encapType EncapType) (*NetlinkTunnel, *FakeTunnelNetlinkHandle) {
+	krNode := &utils.KRNode{
+		PrimaryIP: net.ParseIP("10.0.0.1"),
+		NodeIPv4Addrs: map[v1core.NodeAddressType][]net.IP{
+			v1core.NodeInternalIP: {net.ParseIP("10.0.0.1")},
+		},
+		NodeIPv6Addrs: map[v1core.NodeAddressType][]net.IP{
+			v1core.NodeInternalIP: {net.ParseIP("2001:db8::1")},
+		},
+	}
+	nlHandle := NewFakeTunnelNetlinkHandle()
+	tunnel, err := NewNetlinkTunnel(krNode, nlHandle, encapType, EncapPort(5555))
+	assert.NoError(t, err)
+	return tunnel, nlHandle
+}
+
+func Test_NetlinkTunnel_SetupOverlayTunnel(t *testing.T) {
+	t.Run("IPIP tunnel is created with a route in the custom table", func(t *testing.T) {
+		tunnel, nlHandle := newTestNetlinkTunnel(t, EncapTypeIPIP)
+		_, subnet, _ := net.ParseCIDR("10.1.1.0/24")
+
+		link, err := tunnel.SetupOverlayTunnel("tun-test", net.ParseIP("10.0.0.2"), subnet)
+		assert.NoError(t, err)
+		iptun, ok := link.(*netlink.Iptun)
+		assert.True(t, ok, "expected an ipip link")
+		assert.Equal(t, "10.0.0.1", iptun.Local.String())
+		assert.Equal(t, "10.0.0.2", iptun.Remote.String())
+		assert.Equal(t, uint16(0), iptun.EncapType)
+		assert.NotZero(t, iptun.Flags&net.FlagUp, "tunnel should be up")
+		assert.Empty(t, nlHandle.fous)
+		assert.Len(t, nlHandle.routes, 1)
+		assert.Equal(t, "10.0.0.2/32", nlHandle.routes[0].Dst.String())
+		assert.Equal(t, 77, nlHandle.routes[0].Table)
+		assert.Equal(t, link.Attrs().Index, nlHandle.routes[0].LinkIndex)
+
+		// setting up the same tunnel again doesn't touch the existing link
+		_, err = tunnel.SetupOverlayTunnel("tun-test", net.ParseIP("10.0.0.2"), subnet)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, nlHandle.linkAdds)
+	})
+
+	t.Run("IPv6 FoU tunnel is created with a GUE port", func(t *testing.T) {
+		tunnel, nlHandle := newTestNetlinkTunnel(t, EncapTypeFOU)
+		_, subnet, _ := net.ParseCIDR("2001:db8:42::/64")
+
+		link, err := tunnel.SetupOverlayTunnel("tun-test6", net.ParseIP("2001:db8::2"), subnet)
+		assert.NoError(t, err)
+		ip6tnl, ok := link.(*netlink.Ip6tnl)
+		assert.True(t, ok, "expected an ip6tnl link")
+		assert.Equal(t, uint16(tunnelEncapTypeGUE), ip6tnl.EncapType)
+		assert.Equal(t, uint16(5555), ip6tnl.EncapDport)
+		assert.Equal(t, []netlink.Fou{{Family: netlink.FAMILY_V6, Port: 5555, EncapType: netlink.FOU_ENCAP_GUE}},
+			nlHandle.fous)
+		assert.Equal(t, "2001:db8::2/128", nlHandle.routes[0].Dst.String())
+	})
+
+	t.Run("tunnel with a different encapsulation is recreated and stale FoU port removed", func(t *testing.T) {
+		fouTunnel, nlHandle := newTestNetlinkTunnel(t, EncapTypeFOU)
+		_, subnet, _ := net.ParseCIDR("10.1.1.0/24")
+		_, err := fouTunnel.SetupOverlayTunnel("tun-test", net.ParseIP("10.0.0.2"), subnet)
+		assert.NoError(t, err)
+		assert.Len(t, nlHandle.fous, 1)
+
+		ipipTunnel, err := NewNetlinkTunnel(fouTunnel.krNode, nlHandle, EncapTypeIPIP, EncapPort(5555))
+		assert.NoError(t, err)
+		link, err := ipipTunnel.SetupOverlayTunnel("tun-test", net.ParseIP("10.0.0.2"), subnet)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, nlHandle.linkAdds)
+		assert.Equal(t, uint16(0), link.(*netlink.Iptun).EncapType)
+		assert.Empty(t, nlHandle.fous)
+		assert.Len(t, nlHandle.routes, 1)
+		assert.Equal(t, link.Attrs().Index, nlHandle.routes[0].LinkIndex)
+	})
+
+	t.Run("unsupported encapsulation types are rejected", func(t *testing.T) {
+		_, err := NewNetlinkTunnel(nil, NewFakeTunnelNetlinkHandle(), EncapTypeVXLAN, EncapPort(5555))
+		assert.Error(t, err)
+	})
+}
+
+func Test_NetlinkTunnel_Reconcile(t *testing.T) {
+	tunnel, nlHandle := newTestNetlinkTunnel(t, EncapTypeIPIP)
+	_, subnet, _ := net.ParseCIDR("10.1.1.0/24")
+	_, err := tunnel.SetupOverlayTunnel("tun-test", net.ParseIP("10.0.0.2"), subnet)
+	assert.NoError(t, err)
+
+	// a link that was removed behind our back is recreated along with its route
+	link, _ := nlHandle.LinkByName("tun-test")
+	assert.NoError(t, nlHandle.LinkDel(link))
+	assert.Empty(t, nlHandle.routes)
+	assert.NoError(t, tunnel.Reconcile())
+	link, err = nlHandle.LinkByName("tun-test")
+	assert.NoError(t, err)
+	assert.Len(t, nlHandle.routes, 1)
+	assert.Equal(t, link.Attrs().Index, nlHandle.routes[0].LinkIndex)
+
+	// cleaned up tunnels are no longer desired
+	tunnel.CleanupOverlayTunnel("tun-test", net.ParseIP("10.0.0.2"), subnet)
+	_, err = nlHandle.LinkByName("tun-test")
+	assert.Error(t, err)
+	assert.NoError(t, tunnel.Reconcile())
+	_, err = nlHandle.LinkByName("tun-test")
+	assert.Error(t, err, "cleaned up tunnel shouldn't be recreated")
+}
@@ -0,0 +1,144 @@
+package tunnels
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/vishvananda/netlink"
+)
+
+// FakeTunnelNetlinkHandle is an in-memory TunnelNetlinkHandle that can be used to test NetlinkTunnel without
+// touching the network configuration of the host
+type FakeTunnelNetlinkHandle struct {
+	links     map[string]netlink.Link
+	fous      []netlink.Fou
+	routes    []netlink.Route
+	nextIndex int
+	linkAdds  int
+}
+
+func NewFakeTunnelNetlinkHandle() *FakeTunnelNetlinkHandle {
+	return &FakeTunnelNetlinkHandle{
+		links:     make(map[string]netlink.Link),
+		nextIndex: 1,
+	}
+}
+
+func (f *FakeTunnelNetlinkHandle) LinkByName(name string) (netlink.Link, error) {
+	link, ok := f.links[name]
+	if !ok {
+		return nil, fmt.Errorf("Link not found")
+	}
+	return link, nil
+}
+
+func (f *FakeTunnelNetlinkHandle) LinkAdd(link netlink.Link) error {
+	if _, ok := f.links[link.Attrs().Name]; ok {
+		return fmt.Errorf("file exists")
+	}
+	var added netlink.Link
+	switch l := link.(type) {
+	case *netlink.Iptun:
+		c := *l
+		added = &c
+	case *netlink.Ip6tnl:
+		c := *l
+		added = &c
+	default:
+		return fmt.Errorf("link type %s is not supported by the fake", link.Type())
+	}
+	added.Attrs().Index = f.nextIndex
+	f.nextIndex++
+	f.linkAdds++
+	f.links[link.Attrs().Name] = added
+	return nil
+}
+
+func (f *FakeTunnelNetlinkHandle) LinkDel(link netlink.Link) error {
+	if _, ok := f.links[link.Attrs().Name]; !ok {
+		return fmt.Errorf("Link not found")
+	}
+	delete(f.links, link.Attrs().Name)
+	// like the kernel, remove all routes that point at the deleted link
+	routes := make([]netlink.Route, 0, len(f.routes))
+	for _, route := range f.routes {
+		if route.LinkIndex != link.Attrs().Index {
+			routes = append(routes, route)
+		}
+	}
+	f.routes = routes
+	return nil
+}
+
+func (f *FakeTunnelNetlinkHandle) LinkSetUp(link netlink.Link) error {
+	existing, ok := f.links[link.Attrs().Name]
+	if !ok {
+		return fmt.Errorf("Link not found")
+	}
+	existing.Attrs().Flags |= net.FlagUp
+	return nil
+}
+
+func (f *FakeTunnelNetlinkHandle) FouList(fam int) ([]netlink.Fou, error) {
+	fous := make([]netlink.Fou, 0)
+	for _, fou := range f.fous {
+		if fou.Family == fam {
+			fous = append(fous, fou)
+		}
+	}
+	return fous, nil
+}
+
+func (f *FakeTunnelNetlinkHandle) FouAdd(fou netlink.Fou) error {
+	f.fous = append(f.fous, fou)
+	return nil
+}
+
+func (f *FakeTunnelNetlinkHandle) FouDel(fou netlink.Fou) error {
+	for idx, existing := range f.fous {
+		if existing.Family == fou.Family && existing.Port == fou.Port {
+			f.fous = append(f.fous[:idx], f.fous[idx+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("no such file or directory")
+}
+
+func (f *FakeTunnelNetlinkHandle) RouteListFiltered(_ int, filter *netlink.Route,
+	filterMask uint64) ([]netlink.Route, error) {
+	routes := make([]netlink.Route, 0)
+	for _, route := range f.routes {
+		if filterMask&netlink.RT_FILTER_DST != 0 && route.Dst.String() != filter.Dst.String() {
+			continue
+		}
+		if filterMask&netlink.RT_FILTER_PROTOCOL != 0 && route.Protocol != filter.Protocol {
+			continue
+		}
+		if filterMask&netlink.RT_FILTER_TABLE != 0 && route.Table != filter.Table {
+			continue
+		}
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
+
+func (f *FakeTunnelNetlinkHandle) RouteReplace(route *netlink.Route) error {
+	for idx, existing := range f.routes {
+		if existing.Table == route.Table && existing.Dst.String() == route.Dst.String() {
+			f.routes[idx] = *route
+			return nil
+		}
+	}
+	f.routes = append(f.routes, *route)
+	return nil
+}
+
+func (f *FakeTunnelNetlinkHandle) RouteDel(route *netlink.Route) error {
+	for idx, existing := range f.routes {
+		if existing.Table == route.Table && existing.Dst.String() == route.Dst.String() {
+			f.routes = append(f.routes[:idx], f.routes[idx+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("no such process")
+}
@@ -0,0 +1,268 @@
+package tunnels
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/cloudnativelabs/kube-router/v2/pkg/routes"
+	"github.com/cloudnativelabs/kube-router/v2/pkg/utils"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// VXLANInterfaceName is the name of the VXLAN device that carries the IPv4 overlay traffic to all other nodes when
+	// the vxlan encapsulation is used
+	VXLANInterfaceName = "kube-vxlan"
+	// VXLANv6InterfaceName is the IPv6 counterpart of VXLANInterfaceName, a VXLAN device can only use a single IP
+	// family for its underlay
+	VXLANv6InterfaceName = "kube-vxlan-v6"
+
+	// vxlanID is the VXLAN network identifier used by all nodes
+	vxlanID = 1
+)
+
+// VXLAN manages the VXLAN devices that are used by the vxlan encapsulation. Instead of one tunnel per node, all overlay
+// traffic of an IP family is sent through a single device. Routes point at the BGP next-hop on that device, and static
+// neighbor and FDB entries map each next-hop to the MAC address of the remote node's device and to the remote VTEP.
+type VXLAN struct {
+	krNode utils.NodeIPAware
+	port   EncapPort
+
+	mu sync.Mutex
+	// routes holds the destinations that were routed through each BGP next-hop
+	routes map[string]map[string]bool
+}
+
+// NewVXLAN returns a VXLAN that uses the given UDP port as destination port for the encapsulated traffic
+func NewVXLAN(krNode utils.NodeIPAware, port EncapPort) *VXLAN {
+	return &VXLAN{
+		krNode: krNode,
+		port:   port,
+		routes: make(map[string]map[string]bool),
+	}
+}
+
+// VXLANHardwareAddr returns the MAC address of the VXLAN device of the node with the given VTEP address. Every node
+// derives it from its own address, which allows nodes to program the neighbor entries for each other without having
+// to exchange their MAC addresses.
+func VXLANHardwareAddr(vtep net.IP) net.HardwareAddr {
+	// locally administered unicast addresses have the second least significant bit of the first octet set
+	if ip4 := vtep.To4(); ip4 != nil {
+		return net.HardwareAddr{0x02, 0x00, ip4[0], ip4[1], ip4[2], ip4[3]}
+	}
+	sum := sha256.Sum256(vtep.To16())
+	return net.HardwareAddr{0x02, sum[0], sum[1], sum[2], sum[3], sum[4]}
+}
+
+// ensureInterface creates the VXLAN device for the IP family of nextHop, recreating it if its configuration doesn't
+// match, and brings it up
+func (v *VXLAN) ensureInterface(nextHop net.IP) (netlink.Link, error) {
+	name := VXLANInterfaceName
+	family := netlink.FAMILY_V4
+	localIP := v.krNode.FindBestIPv4NodeAddress()
+	if nextHop.To4() == nil {
+		name = VXLANv6InterfaceName
+		family = netlink.FAMILY_V6
+		localIP = v.krNode.FindBestIPv6NodeAddress()
+	}
+	if localIP == nil {
+		return nil, fmt.Errorf("not able to find an appropriate configured IP address on node for destination "+
+			"IP family: %s", nextHop.String())
+	}
+
+	link, err := netlink.LinkByName(name)
+	if err == nil {
+		if vxlanLinkMatches(link, localIP, v.port) {
+			if link.Attrs().Flags&net.FlagUp == 0 {
+				if err = netlink.LinkSetUp(link); err != nil {
+					return nil, fmt.Errorf("failed to bring vxlan interface %s up due to: %v", name, err)
+				}
+			}
+			return link, nil
+		}
+		klog.Infof("Configuration of vxlan interface %s changed, recreating it", name)
+		if err = netlink.LinkDel(link); err != nil {
+			return nil, fmt.Errorf("failed to delete vxlan interface %s due to: %v", name, err)
+		}
+	}
+
+	vxlan := &netlink.Vxlan{
+		LinkAttrs: netlink.LinkAttrs{Name: name, HardwareAddr: VXLANHardwareAddr(localIP)},
+		VxlanId:   vxlanID,
+		SrcAddr:   localIP,
+		Port:      int(v.port),
+		Learning:  false,
+	}
+	// when the VXLAN device is bound to the underlay device, the kernel derives the device's MTU from it
+	addrs, err := netlink.AddrList(nil, family)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list addresses of the node: %v", err)
+	}
+	for _, addr := range addrs {
+		if addr.IP.Equal(localIP) {
+			vxlan.VtepDevIndex = addr.LinkIndex
+			break
+		}
+	}
+
+	klog.Infof("Creating vxlan interface %s with local address %s and port %d", name, localIP, v.port)
+	if err = netlink.LinkAdd(vxlan); err != nil {
+		return nil, fmt.Errorf("failed to create vxlan interface %s: %v", name, err)
+	}
+	if link, err = netlink.LinkByName(name); err != nil {
+		return nil, fmt.Errorf("failed to get vxlan interface %s by name: %v", name, err)
+	}
+	if err = netlink.LinkSetUp(link); err != nil {
+		return nil, fmt.Errorf("failed to bring vxlan interface %s up due to: %v", name, err)
+	}
+	return link, nil
+}
+
+// vxlanLinkMatches checks whether the existing link is a VXLAN device with the configuration that kube-router expects
+func vxlanLinkMatches(link netlink.Link, localIP net.IP, port EncapPort) bool {
+	vxlan, ok := link.(*netlink.Vxlan)
+	if !ok {
+		return false
+	}
+	return vxlan.VxlanId == vxlanID && vxlan.SrcAddr.Equal(localIP) && vxlan.Port == int(port) &&
+		vxlan.HardwareAddr.String() == VXLANHardwareAddr(localIP).String()
+}
+
+// SetupPeer ensures that traffic to nextHopSubnet can be sent to the node behind the given BGP next-hop through the
+// VXLAN device and returns the device. Routes to nextHopSubnet need to use nextHop as an on-link gateway.
+func (v *VXLAN) SetupPeer(nextHop net.IP, nextHopSubnet *net.IPNet) (netlink.Link, error) {
+	link, err := v.ensureInterface(nextHop)
+	if err != nil {
+		return nil, err
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	family := netlink.FAMILY_V4
+	if nextHop.To4() == nil {
+		family = netlink.FAMILY_V6
+	}
+	for _, neigh := range vxlanNeighs(link, nextHop, family) {
+		if err = netlink.NeighSet(neigh); err != nil {
+			return nil, fmt.Errorf("failed to add vxlan neighbor entry for next-hop %s: %v", nextHop, err)
+		}
+	}
+
+	// the custom routing table routes traffic to the remote node itself through the overlay as well
+	route, err := vxlanCustomTableRoute(link, nextHop)
+	if err != nil {
+		return nil, err
+	}
+	if err = netlink.RouteReplace(route); err != nil {
+		return nil, fmt.Errorf("failed to add route in custom route table, err: %v", err)
+	}
+
+	if _, ok := v.routes[nextHop.String()]; !ok {
+		v.routes[nextHop.String()] = make(map[string]bool)
+	}
+	v.routes[nextHop.String()][nextHopSubnet.String()] = true
+	return link, nil
+}
+
+// RemovePeer removes the route to nextHopSubnet from the given next-hop, and the neighbor and FDB entries of the
+// next-hop once it doesn't have any routes left
+func (v *VXLAN) RemovePeer(nextHop net.IP, nextHopSubnet *net.IPNet) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if subnets, ok := v.routes[nextHop.String()]; ok {
+		delete(subnets, nextHopSubnet.String())
+		if len(subnets) > 0 {
+			return nil
+		}
+		delete(v.routes, nextHop.String())
+	}
+
+	name := VXLANInterfaceName
+	family := netlink.FAMILY_V4
+	if nextHop.To4() == nil {
+		name = VXLANv6InterfaceName
+		family = netlink.FAMILY_V6
+	}
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		// without the device there are no entries left to remove
+		return nil
+	}
+
+	var errs []error
+	route, err := vxlanCustomTableRoute(link, nextHop)
+	if err != nil {
+		return err
+	}
+	if err = netlink.RouteDel(route); err != nil && !errors.Is(err, unix.ESRCH) {
+		errs = append(errs, fmt.Errorf("failed to delete route for next-hop %s from custom route table: %v",
+			nextHop, err))
+	}
+	for _, neigh := range vxlanNeighs(link, nextHop, family) {
+		if err = netlink.NeighDel(neigh); err != nil && !errors.Is(err, unix.ENOENT) {
+			errs = append(errs, fmt.Errorf("failed to delete vxlan neighbor entry for next-hop %s: %v", nextHop,
+				err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// vxlanNeighs returns the entries that are needed to reach nextHop through the VXLAN device: the neighbor entry
+// resolves the next-hop to the MAC address of the remote device, and the FDB entry sends frames for that MAC address
+// to the remote VTEP
+func vxlanNeighs(link netlink.Link, nextHop net.IP, family int) []*netlink.Neigh {
+	mac := VXLANHardwareAddr(nextHop)
+	return []*netlink.Neigh{
+		{
+			LinkIndex:    link.Attrs().Index,
+			Family:       family,
+			State:        netlink.NUD_PERMANENT,
+			IP:           nextHop,
+			HardwareAddr: mac,
+		},
+		{
+			LinkIndex:    link.Attrs().Index,
+			Family:       unix.AF_BRIDGE,
+			State:        netlink.NUD_PERMANENT,
+			Flags:        netlink.NTF_SELF,
+			IP:           nextHop,
+			HardwareAddr: mac,
+		},
+	}
+}
+
+// vxlanCustomTableRoute returns the host route for nextHop through the VXLAN device in kube-router's custom table
+func vxlanCustomTableRoute(link netlink.Link, nextHop net.IP) (*netlink.Route, error) {
+	table, err := strconv.Atoi(routes.CustomTableID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid custom route table %s: %v", routes.CustomTableID, err)
+	}
+	bits := 32
+	if nextHop.To4() == nil {
+		bits = 128
+	}
+	return &netlink.Route{
+		LinkIndex: link.Attrs().Index,
+		Dst:       &net.IPNet{IP: nextHop, Mask: net.CIDRMask(bits, bits)},
+		Table:     table,
+	}, nil
+}
+
+// CleanupVXLAN removes the VXLAN devices along with their neighbor and FDB entries
+func CleanupVXLAN() {
+	for _, name := range []string{VXLANInterfaceName, VXLANv6InterfaceName} {
+		if link, err := netlink.LinkByName(name); err == nil {
+			if err = netlink.LinkDel(link); err != nil {
+				klog.Errorf("failed to delete vxlan interface %s due to %v", name, err)
+			}
+		}
+	}
+}
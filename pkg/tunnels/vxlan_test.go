@@ -0,0 +1,51 @@
+package tunnels
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+func Test_VXLANHardwareAddr(t *testing.T) {
+	t.Run("IPv4 addresses are embedded in the MAC address", func(t *testing.T) {
+		assert.Equal(t, "02:00:0a:00:00:01", VXLANHardwareAddr(net.ParseIP("10.0.0.1")).String())
+	})
+
+	t.Run("IPv6 MAC addresses are locally administered, unicast and consistent", func(t *testing.T) {
+		mac := VXLANHardwareAddr(net.ParseIP("2001:db8::1"))
+		assert.Len(t, mac, 6)
+		assert.Equal(t, byte(0x02), mac[0])
+		assert.Equal(t, mac, VXLANHardwareAddr(net.ParseIP("2001:db8::1")))
+		assert.NotEqual(t, mac, VXLANHardwareAddr(net.ParseIP("2001:db8::2")))
+	})
+}
+
+func Test_vxlanNeighs(t *testing.T) {
+	link := &netlink.Vxlan{LinkAttrs: netlink.LinkAttrs{Name: VXLANInterfaceName, Index: 42}}
+	nextHop := net.ParseIP("10.0.0.2")
+
+	neighs := vxlanNeighs(link, nextHop, netlink.FAMILY_V4)
+	assert.Len(t, neighs, 2)
+	for _, neigh := range neighs {
+		assert.Equal(t, 42, neigh.LinkIndex)
+		assert.Equal(t, netlink.NUD_PERMANENT, neigh.State)
+		assert.True(t, neigh.IP.Equal(nextHop))
+		assert.Equal(t, VXLANHardwareAddr(nextHop), neigh.HardwareAddr)
+	}
+	assert.Equal(t, netlink.FAMILY_V4, neighs[0].Family, "next-hop needs to resolve to the remote MAC address")
+	assert.Equal(t, unix.AF_BRIDGE, neighs[1].Family, "remote MAC address needs to be forwarded to the remote VTEP")
+	assert.Equal(t, netlink.NTF_SELF, neighs[1].Flags)
+}
+
+func Test_vxlanCustomTableRoute(t *testing.T) {
+	link := &netlink.Vxlan{LinkAttrs: netlink.LinkAttrs{Name: VXLANv6InterfaceName, Index: 42}}
+
+	route, err := vxlanCustomTableRoute(link, net.ParseIP("2001:db8::2"))
+	assert.NoError(t, err)
+	assert.Equal(t, 42, route.LinkIndex)
+	assert.Equal(t, 77, route.Table)
+	assert.Equal(t, "2001:db8::2/128", route.Dst.String())
+}
@@ -0,0 +1,298 @@
+package tunnels
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/vishvananda/netlink"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// WireGuardInterfaceName is the name of the single wireguard interface that carries the overlay traffic to all
+	// other nodes when the wireguard encapsulation is used
+	WireGuardInterfaceName = "kube-wg"
+	// WireGuardPublicKeyAnnotation is the node annotation in which every node publishes its wireguard public key
+	WireGuardPublicKeyAnnotation = "kube-router.io/wireguard.public-key"
+
+	// keepalive interval in seconds, keeps the NAT / conntrack state between nodes alive while there is no traffic
+	wireGuardPersistentKeepalive = "25"
+)
+
+// WireGuardPublicKeyFunc returns the wireguard public key of the node that the given BGP next-hop belongs to
+type WireGuardPublicKeyFunc func(nextHop net.IP) (string, error)
+
+// WireGuard manages the single wireguard interface that is used by the wireguard encapsulation. Instead of one tunnel
+// per node, all overlay traffic is sent through this interface and wireguard selects the peer by the destination
+// pod CIDR that was advertised via BGP.
+type WireGuard struct {
+	port        EncapPort
+	privateKey  string
+	publicKey   string
+	peerKeyFunc WireGuardPublicKeyFunc
+
+	mu sync.Mutex
+	// peers holds the public keys of the configured peers, a peer is the node behind one or more BGP next-hops (e.g. an
+	// IPv4 and an IPv6 next-hop of a dual-stack node) as wireguard only allows a public key to be used by one peer
+	peers map[string]bool
+	// routes holds the destinations that were routed through each BGP next-hop and nextHopKeys the public key that
+	// each next-hop was last resolved to (empty if the node didn't publish a key yet)
+	routes      map[string]map[string]bool
+	nextHopKeys map[string]string
+}
+
+// NewWireGuard returns a WireGuard for the given listen port. The private key of an already existing wireguard
+// interface is re-used so that restarting kube-router doesn't change the node's public key, otherwise a new keypair is
+// generated.
+func NewWireGuard(port EncapPort, peerKeyFunc WireGuardPublicKeyFunc) (*WireGuard, error) {
+	privateKey := ""
+	if _, err := netlink.LinkByName(WireGuardInterfaceName); err == nil {
+		out, err := runWG(nil, "show", WireGuardInterfaceName, "private-key")
+		if err != nil {
+			klog.Warningf("failed to read private key of existing wireguard interface %s, generating a new one: %v",
+				WireGuardInterfaceName, err)
+		} else if strings.TrimSpace(out) != "(none)" {
+			privateKey = strings.TrimSpace(out)
+		}
+	}
+
+	if privateKey == "" {
+		var err error
+		if privateKey, err = GenerateWireGuardPrivateKey(); err != nil {
+			return nil, err
+		}
+	}
+
+	publicKey, err := WireGuardPublicKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &WireGuard{
+		port:        port,
+		privateKey:  privateKey,
+		publicKey:   publicKey,
+		peerKeyFunc: peerKeyFunc,
+		peers:       make(map[string]bool),
+		routes:      make(map[string]map[string]bool),
+		nextHopKeys: make(map[string]string),
+	}, nil
+}
+
+// PublicKey returns the public key of this node that other nodes need to add it as a peer
+func (w *WireGuard) PublicKey() string {
+	return w.publicKey
+}
+
+// GenerateWireGuardPrivateKey generates a new base64 encoded curve25519 private key as used by wireguard
+func GenerateWireGuardPrivateKey() (string, error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate wireguard private key: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(key.Bytes()), nil
+}
+
+// WireGuardPublicKey derives the base64 encoded public key from the given base64 encoded wireguard private key
+func WireGuardPublicKey(privateKey string) (string, error) {
+	raw, err := base64.StdEncoding.DecodeString(privateKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode wireguard private key: %v", err)
+	}
+	key, err := ecdh.X25519().NewPrivateKey(raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid wireguard private key: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(key.PublicKey().Bytes()), nil
+}
+
+// ensureInterface creates the wireguard interface if it doesn't exist yet, configures its private key and listen port
+// and brings it up
+func (w *WireGuard) ensureInterface() (netlink.Link, error) {
+	link, err := netlink.LinkByName(WireGuardInterfaceName)
+	if err != nil {
+		klog.Infof("Creating wireguard interface %s", WireGuardInterfaceName)
+		err = netlink.LinkAdd(&netlink.Wireguard{LinkAttrs: netlink.LinkAttrs{Name: WireGuardInterfaceName}})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create wireguard interface %s: %v", WireGuardInterfaceName, err)
+		}
+		if link, err = netlink.LinkByName(WireGuardInterfaceName); err != nil {
+			return nil, fmt.Errorf("failed to get wireguard interface %s by name: %v", WireGuardInterfaceName, err)
+		}
+	}
+
+	_, err = runWG([]byte(w.privateKey), "set", WireGuardInterfaceName,
+		"listen-port", strconv.FormatUint(uint64(w.port), 10), "private-key", "/dev/stdin")
+	if err != nil {
+		return nil, err
+	}
+
+	if err = netlink.LinkSetUp(link); err != nil {
+		return nil, fmt.Errorf("failed to bring wireguard interface %s up due to: %v", WireGuardInterfaceName, err)
+	}
+	return link, nil
+}
+
+// SetupPeer ensures that traffic to nextHopSubnet is sent to the node behind the given BGP next-hop through the
+// wireguard interface and returns the interface so that the route can be pointed at it. If the node hasn't published
+// its public key yet, the interface is returned anyway and the peer is configured by RefreshPeers once the key is
+// known.
+func (w *WireGuard) SetupPeer(nextHop net.IP, nextHopSubnet *net.IPNet) (netlink.Link, error) {
+	link, err := w.ensureInterface()
+	if err != nil {
+		return nil, err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, ok := w.routes[nextHop.String()]; !ok {
+		w.routes[nextHop.String()] = make(map[string]bool)
+	}
+	w.routes[nextHop.String()][nextHopSubnet.String()] = true
+
+	if err = w.syncNextHop(nextHop); err != nil {
+		klog.Warningf("wireguard peer for next-hop %s isn't configured yet: %v", nextHop, err)
+	}
+	return link, nil
+}
+
+// RemovePeer removes the route to nextHopSubnet from the peer behind the given next-hop, and the peer itself once it
+// doesn't have any routes left
+func (w *WireGuard) RemovePeer(nextHop net.IP, nextHopSubnet *net.IPNet) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	routes, ok := w.routes[nextHop.String()]
+	if !ok {
+		return nil
+	}
+	delete(routes, nextHopSubnet.String())
+	if len(routes) == 0 {
+		delete(w.routes, nextHop.String())
+	}
+	return w.syncNextHop(nextHop)
+}
+
+// RefreshPeers resolves the public keys of all next-hops again and updates the peers whose key changed, this needs to
+// be called whenever a node publishes a new public key
+func (w *WireGuard) RefreshPeers() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var errs []error
+	for nextHop := range w.routes {
+		if err := w.syncNextHop(net.ParseIP(nextHop)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// syncNextHop moves the routes of the given next-hop to the peer of its current public key, removes it from the peer
+// of its previous key, and applies all affected peers. Callers must hold w.mu.
+func (w *WireGuard) syncNextHop(nextHop net.IP) error {
+	oldKey := w.nextHopKeys[nextHop.String()]
+	newKey := ""
+	if _, ok := w.routes[nextHop.String()]; ok {
+		var err error
+		if newKey, err = w.peerKeyFunc(nextHop); err != nil {
+			delete(w.nextHopKeys, nextHop.String())
+			if oldKey != "" {
+				return errors.Join(err, w.applyPeer(oldKey))
+			}
+			return err
+		}
+		w.nextHopKeys[nextHop.String()] = newKey
+	} else {
+		delete(w.nextHopKeys, nextHop.String())
+	}
+
+	if oldKey != "" && oldKey != newKey {
+		if err := w.applyPeer(oldKey); err != nil {
+			return err
+		}
+	}
+	if newKey != "" {
+		return w.applyPeer(newKey)
+	}
+	return nil
+}
+
+// applyPeer configures the peer with the given public key on the wireguard interface with the union of the routes of
+// all next-hops that resolve to it, or removes it if there are none left. Callers must hold w.mu.
+func (w *WireGuard) applyPeer(publicKey string) error {
+	var endpoint net.IP
+	allowedIPs := make([]string, 0)
+	nextHops := make([]string, 0)
+	for nextHop, key := range w.nextHopKeys {
+		if key == publicKey {
+			nextHops = append(nextHops, nextHop)
+		}
+	}
+	sort.Strings(nextHops)
+	for _, nextHop := range nextHops {
+		if endpoint == nil {
+			endpoint = net.ParseIP(nextHop)
+		}
+		for cidr := range w.routes[nextHop] {
+			allowedIPs = append(allowedIPs, cidr)
+		}
+	}
+	sort.Strings(allowedIPs)
+
+	if len(allowedIPs) == 0 {
+		if _, ok := w.peers[publicKey]; !ok {
+			return nil
+		}
+		klog.V(1).Infof("Removing wireguard peer %s", publicKey)
+		if _, err := runWG(nil, "set", WireGuardInterfaceName, "peer", publicKey, "remove"); err != nil {
+			return err
+		}
+		delete(w.peers, publicKey)
+		return nil
+	}
+
+	klog.V(2).Infof("Setting wireguard peer %s with endpoint %s and allowed IPs %s", publicKey, endpoint,
+		allowedIPs)
+	_, err := runWG(nil, "set", WireGuardInterfaceName, "peer", publicKey,
+		"endpoint", net.JoinHostPort(endpoint.String(), strconv.FormatUint(uint64(w.port), 10)),
+		"allowed-ips", strings.Join(allowedIPs, ","), "persistent-keepalive", wireGuardPersistentKeepalive)
+	if err != nil {
+		return err
+	}
+	w.peers[publicKey] = true
+	return nil
+}
+
+// CleanupWireGuard removes the wireguard interface along with all of its peers
+func CleanupWireGuard() {
+	if link, err := netlink.LinkByName(WireGuardInterfaceName); err == nil {
+		if err = netlink.LinkDel(link); err != nil {
+			klog.Errorf("failed to delete wireguard interface %s due to %v", WireGuardInterfaceName, err)
+		}
+	}
+}
+
+// runWG runs the wg utility with the given arguments, stdin is used to pass keys as wg only reads them from files
+func runWG(stdin []byte, args ...string) (string, error) {
+	cmd := exec.Command("wg", args...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("failed to run wg %s: %v, output: %s", strings.Join(args, " "), err, string(out))
+	}
+	return string(out), nil
+}
@@ -0,0 +1,36 @@
+package tunnels
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_WireGuardPublicKey(t *testing.T) {
+	t.Run("public key is derived according to RFC 7748", func(t *testing.T) {
+		privateKey, _ := hex.DecodeString("77076d0a7318a57d3c16c17251b26645df4c2f87ebc0992ab177fba51db92c2a")
+		publicKey, _ := hex.DecodeString("8520f0098930a754748b7ddcb43ef75a0dbf3a0d26381af4eba4a98eaa9b4e6a")
+
+		derived, err := WireGuardPublicKey(base64.StdEncoding.EncodeToString(privateKey))
+		assert.NoError(t, err)
+		assert.Equal(t, base64.StdEncoding.EncodeToString(publicKey), derived)
+	})
+
+	t.Run("generated private keys have a public key", func(t *testing.T) {
+		privateKey, err := GenerateWireGuardPrivateKey()
+		assert.NoError(t, err)
+		publicKey, err := WireGuardPublicKey(privateKey)
+		assert.NoError(t, err)
+		assert.Len(t, publicKey, 44, "wireguard keys are 32 bytes encoded as base64")
+		assert.NotEqual(t, privateKey, publicKey)
+	})
+
+	t.Run("invalid private keys are rejected", func(t *testing.T) {
+		_, err := WireGuardPublicKey("not-a-key")
+		assert.Error(t, err)
+		_, err = WireGuardPublicKey(base64.StdEncoding.EncodeToString([]byte("too short")))
+		assert.Error(t, err)
+	})
+}
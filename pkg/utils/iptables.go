@@ -128,6 +128,7 @@ func Append(buffer *bytes.Buffer, chain string, rule []string) {
 type IPTablesSaveRestorer interface {
 	SaveInto(table string, buffer *bytes.Buffer) error
 	Restore(table string, data []byte) error
+	RestoreNoFlush(table string, data []byte) error
 }
 
 // IPTablesSaveRestore struct stores shell commands to save and restore iptables state
@@ -196,6 +197,15 @@ func (i *IPTablesSaveRestore) Restore(table string, data []byte) error {
 	return i.exec(i.restoreCmd, args, data, nil)
 }
 
+// RestoreNoFlush updates the chains of table that data declares, leaving the other chains of the table untouched
+func (i *IPTablesSaveRestore) RestoreNoFlush(table string, data []byte) error {
+	args := []string{"--noflush", "-T", table}
+	if hasWait {
+		args = append([]string{"--wait"}, args...)
+	}
+	return i.exec(i.restoreCmd, args, data, nil)
+}
+
 // CommonICMPRules returns a list of common ICMP rules that should always be allowed for given IP family
 func CommonICMPRules(family v1core.IPFamily) []ICMPRule {
 	// Allow various types of ICMP that are important for routing
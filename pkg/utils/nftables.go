@@ -0,0 +1,380 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+
+	v1core "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// NFTablesFamilyIPv4 nftables address family that only sees IPv4 traffic
+	NFTablesFamilyIPv4 = "ip"
+	// NFTablesFamilyIPv6 nftables address family that only sees IPv6 traffic
+	NFTablesFamilyIPv6 = "ip6"
+	// NFTablesFamilyInet nftables address family that sees both IPv4 and IPv6 traffic
+	NFTablesFamilyInet = "inet"
+
+	// KubeRouterNFTable is the name of the inet table that the controllers render their rules into with the nftables
+	// firewall backend
+	KubeRouterNFTable = "kube-router"
+)
+
+var (
+	// Error returned when nft binary is not found.
+	errNftNotFound = errors.New("nft utility not found")
+)
+
+// NFTablesHandler interface that defines functions to atomically load and remove nftables tables
+type NFTablesHandler interface {
+	Apply(script []byte) error
+	TableExists(family, table string) (bool, error)
+	DeleteTable(family, table string) error
+}
+
+// NFTables struct stores the path of the nft binary which is used to apply nftables scripts
+type NFTables struct {
+	nftPath string
+}
+
+// NewNFTables returns an NFTables handler or an error if the nft binary can't be found
+func NewNFTables() (*NFTables, error) {
+	path, err := exec.LookPath("nft")
+	if err != nil {
+		return nil, errNftNotFound
+	}
+	return &NFTables{nftPath: path}, nil
+}
+
+func (nft *NFTables) run(stdin []byte, args ...string) (string, error) {
+	var stderr bytes.Buffer
+	var stdout bytes.Buffer
+	klog.V(9).Infof("running nft command: path=`%s` args=%+v", nft.nftPath, args)
+	cmd := exec.Cmd{
+		Path:   nft.nftPath,
+		Args:   append([]string{nft.nftPath}, args...),
+		Stderr: &stderr,
+		Stdout: &stdout,
+	}
+	if stdin != nil {
+		cmd.Stdin = bytes.NewBuffer(stdin)
+	}
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("failed to call nft %s: %v (%s)", strings.Join(args, " "), err, stderr.String())
+	}
+
+	return stdout.String(), nil
+}
+
+// Apply loads the given nft script, nft processes everything within a single script as one atomic transaction
+func (nft *NFTables) Apply(script []byte) error {
+	_, err := nft.run(script, "-f", "-")
+	return err
+}
+
+// TableExists checks whether the given table is currently present in the kernel
+func (nft *NFTables) TableExists(family, table string) (bool, error) {
+	out, err := nft.run(nil, "list", "tables", family)
+	if err != nil {
+		return false, err
+	}
+	for _, line := range strings.Split(out, "\n") {
+		if strings.TrimSpace(line) == "table "+family+" "+table {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// DeleteTable removes the given table along with all of its chains and sets if it exists
+func (nft *NFTables) DeleteTable(family, table string) error {
+	exists, err := nft.TableExists(family, table)
+	if err != nil || !exists {
+		return err
+	}
+	_, err = nft.run(nil, "delete", "table", family, table)
+	return err
+}
+
+// NFTablesAddrFamily returns the nftables address family keyword (ip or ip6) used in rule matches for the given IP
+// family
+func NFTablesAddrFamily(ipFamily v1core.IPFamily) string {
+	if ipFamily == v1core.IPv6Protocol {
+		return NFTablesFamilyIPv6
+	}
+	return NFTablesFamilyIPv4
+}
+
+// NFTablesAddrType returns the nftables set data type that stores addresses of the given IP family
+func NFTablesAddrType(ipFamily v1core.IPFamily) string {
+	if ipFamily == v1core.IPv6Protocol {
+		return "ipv6_addr"
+	}
+	return "ipv4_addr"
+}
+
+// NFTablesComment returns a rule comment statement, nft comments are limited to 128 characters and can't contain
+// quotes
+func NFTablesComment(comment string) string {
+	const maxCommentLen = 128
+	comment = strings.ReplaceAll(comment, "\"", "")
+	if len(comment) > maxCommentLen {
+		comment = comment[:maxCommentLen]
+	}
+	return "comment \"" + comment + "\""
+}
+
+// NFTablesICMPMatch translates one of the common ICMP rules into an nft match expression
+func NFTablesICMPMatch(icmpRule ICMPRule) string {
+	if icmpRule.IPTablesProto == ICMPv6Proto {
+		icmpType := icmpRule.ICMPType
+		switch icmpType {
+		case "neighbor-solicitation":
+			icmpType = "nd-neighbor-solicit"
+		case "neighbor-advertisement":
+			icmpType = "nd-neighbor-advert"
+		}
+		return "icmpv6 type " + icmpType
+	}
+	return "icmp type " + icmpRule.ICMPType
+}
+
+// NFTablesSet represents a named set within an nftables table
+type NFTablesSet struct {
+	Name     string
+	Type     string
+	Interval bool
+	Elements []string
+}
+
+// NFTablesChain represents a chain within an nftables table, base chains have a Hook set
+type NFTablesChain struct {
+	Name     string
+	Type     string
+	Hook     string
+	Priority string
+	Rules    []string
+}
+
+// NFTablesTable is an in-memory representation of an nftables table that is always rendered as a whole and applied
+// declaratively, which makes it the nftables equivalent of a full iptables-restore of a table
+type NFTablesTable struct {
+	Family string
+	Name   string
+
+	sets       map[string]*NFTablesSet
+	chains     map[string]*NFTablesChain
+	chainOrder []string
+}
+
+// NewNFTablesTable returns an empty NFTablesTable
+func NewNFTablesTable(family, name string) *NFTablesTable {
+	return &NFTablesTable{
+		Family: family,
+		Name:   name,
+		sets:   make(map[string]*NFTablesSet),
+		chains: make(map[string]*NFTablesChain),
+	}
+}
+
+// AddSet adds (or replaces) a named set in the table
+func (t *NFTablesTable) AddSet(name, setType string, interval bool, elements []string) {
+	t.sets[name] = &NFTablesSet{Name: name, Type: setType, Interval: interval, Elements: elements}
+}
+
+// HasSet returns true if a set with the given name was added to the table
+func (t *NFTablesTable) HasSet(name string) bool {
+	_, ok := t.sets[name]
+	return ok
+}
+
+// Chain returns the chain with the given name creating it if it doesn't exist yet
+func (t *NFTablesTable) Chain(name string) *NFTablesChain {
+	if chain, ok := t.chains[name]; ok {
+		return chain
+	}
+	chain := &NFTablesChain{Name: name}
+	t.chains[name] = chain
+	t.chainOrder = append(t.chainOrder, name)
+	return chain
+}
+
+// BaseChain returns the chain with the given name creating it if it doesn't exist yet and attaches it to the given
+// netfilter hook
+func (t *NFTablesTable) BaseChain(name, chainType, hook, priority string) *NFTablesChain {
+	chain := t.Chain(name)
+	chain.Type, chain.Hook, chain.Priority = chainType, hook, priority
+	return chain
+}
+
+// HasChain returns true if a chain with the given name was added to the table
+func (t *NFTablesTable) HasChain(name string) bool {
+	_, ok := t.chains[name]
+	return ok
+}
+
+// Chains returns the names of the chains of the table in the order they were created
+func (t *NFTablesTable) Chains() []string {
+	return t.chainOrder
+}
+
+// Sets returns the names of the sets of the table in sorted order
+func (t *NFTablesTable) Sets() []string {
+	names := make([]string, 0, len(t.sets))
+	for name := range t.sets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Append adds a rule to the end of the chain
+func (c *NFTablesChain) Append(rule ...string) {
+	c.Rules = append(c.Rules, strings.Join(rule, " "))
+}
+
+// Insert adds a rule to the beginning of the chain
+func (c *NFTablesChain) Insert(rule ...string) {
+	c.Rules = append([]string{strings.Join(rule, " ")}, c.Rules...)
+}
+
+// merge adds the sets and chains of the other table to this one, it fails rather than mixing the rules of two owners
+// in a set or chain of the same name
+func (t *NFTablesTable) merge(other *NFTablesTable) error {
+	for _, name := range other.Sets() {
+		if t.HasSet(name) {
+			return fmt.Errorf("set %s is already part of table %s %s", name, t.Family, t.Name)
+		}
+		t.sets[name] = other.sets[name]
+	}
+	for _, name := range other.chainOrder {
+		if t.HasChain(name) {
+			return fmt.Errorf("chain %s is already part of table %s %s", name, t.Family, t.Name)
+		}
+		t.chains[name] = other.chains[name]
+		t.chainOrder = append(t.chainOrder, name)
+	}
+	return nil
+}
+
+// Render writes the table to the buffer in nft script syntax. The table is created (in case it doesn't exist yet),
+// deleted and then re-declared so that loading the script atomically replaces whatever was in the table before.
+func (t *NFTablesTable) Render(buf *bytes.Buffer) {
+	fmt.Fprintf(buf, "table %s %s\n", t.Family, t.Name)
+	fmt.Fprintf(buf, "delete table %s %s\n", t.Family, t.Name)
+	fmt.Fprintf(buf, "table %s %s {\n", t.Family, t.Name)
+
+	for _, name := range t.Sets() {
+		set := t.sets[name]
+		fmt.Fprintf(buf, "\tset %s {\n", set.Name)
+		fmt.Fprintf(buf, "\t\ttype %s\n", set.Type)
+		if set.Interval {
+			buf.WriteString("\t\tflags interval\n")
+			buf.WriteString("\t\tauto-merge\n")
+		}
+		if elements := uniqueSortedElements(set.Elements); len(elements) > 0 {
+			fmt.Fprintf(buf, "\t\telements = { %s }\n", strings.Join(elements, ", "))
+		}
+		buf.WriteString("\t}\n")
+	}
+
+	for _, name := range t.chainOrder {
+		chain := t.chains[name]
+		fmt.Fprintf(buf, "\tchain %s {\n", chain.Name)
+		if chain.Hook != "" {
+			fmt.Fprintf(buf, "\t\ttype %s hook %s priority %s; policy accept;\n", chain.Type, chain.Hook,
+				chain.Priority)
+		}
+		for _, rule := range chain.Rules {
+			fmt.Fprintf(buf, "\t\t%s\n", rule)
+		}
+		buf.WriteString("\t}\n")
+	}
+	buf.WriteString("}\n")
+}
+
+func uniqueSortedElements(elements []string) []string {
+	seen := make(map[string]bool, len(elements))
+	unique := make([]string, 0, len(elements))
+	for _, element := range elements {
+		if seen[element] {
+			continue
+		}
+		seen[element] = true
+		unique = append(unique, element)
+	}
+	sort.Strings(unique)
+	return unique
+}
+
+// NFTablesSharedTable is an nftables table that several controllers render their rules into. Each controller owns a
+// part of the table, a set of chains and sets with names of its own, and replaces only that part, while the table
+// is still loaded as a whole so that every change is applied in one atomic transaction.
+type NFTablesSharedTable struct {
+	nft    NFTablesHandler
+	family string
+	name   string
+
+	mu    sync.Mutex
+	parts map[string]*NFTablesTable
+}
+
+// NewNFTablesSharedTable returns an NFTablesSharedTable that no controller has rendered a part of yet
+func NewNFTablesSharedTable(nft NFTablesHandler, family, name string) *NFTablesSharedTable {
+	return &NFTablesSharedTable{
+		nft:    nft,
+		family: family,
+		name:   name,
+		parts:  make(map[string]*NFTablesTable),
+	}
+}
+
+// Apply replaces the part of the table that belongs to the given owner and loads the resulting table. If the table
+// can't be loaded, the previous part of the owner is kept so that the parts of the other owners can still be applied.
+func (s *NFTablesSharedTable) Apply(owner string, part *NFTablesTable) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous, hadPrevious := s.parts[owner]
+	s.parts[owner] = part
+	script, err := s.render()
+	if err == nil {
+		err = s.nft.Apply(script)
+	}
+	if err != nil {
+		if hadPrevious {
+			s.parts[owner] = previous
+		} else {
+			delete(s.parts, owner)
+		}
+		return fmt.Errorf("failed to apply nftables table %s %s: %v", s.family, s.name, err)
+	}
+	return nil
+}
+
+// render merges the parts of all owners, in the order of their names, and renders them as one table
+func (s *NFTablesSharedTable) render() ([]byte, error) {
+	owners := make([]string, 0, len(s.parts))
+	for owner := range s.parts {
+		owners = append(owners, owner)
+	}
+	sort.Strings(owners)
+
+	table := NewNFTablesTable(s.family, s.name)
+	for _, owner := range owners {
+		if err := table.merge(s.parts[owner]); err != nil {
+			return nil, fmt.Errorf("failed to add the rules of %s: %v", owner, err)
+		}
+	}
+
+	var buf bytes.Buffer
+	table.Render(&buf)
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,141 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNFTablesTable_Render(t *testing.T) {
+	table := NewNFTablesTable(NFTablesFamilyInet, "kube-router-test")
+	input := table.BaseChain("INPUT", "filter", "input", "filter")
+	input.Append("ip daddr", "10.96.0.0/12", "return")
+	input.Insert("ct state related,established accept")
+	table.Chain("POLICY").Append("ip saddr @SRC", "accept", NFTablesComment("allow \"src\""))
+	table.AddSet("SRC", "ipv4_addr", false, []string{"10.1.0.2", "10.1.0.1", "10.1.0.2"})
+	table.AddSet("BLOCK", "ipv4_addr", true, []string{"192.168.0.0/16"})
+	table.AddSet("EMPTY", "ipv6_addr", false, nil)
+
+	var buf bytes.Buffer
+	table.Render(&buf)
+
+	expected := "table inet kube-router-test\n" +
+		"delete table inet kube-router-test\n" +
+		"table inet kube-router-test {\n" +
+		"\tset BLOCK {\n" +
+		"\t\ttype ipv4_addr\n" +
+		"\t\tflags interval\n" +
+		"\t\tauto-merge\n" +
+		"\t\telements = { 192.168.0.0/16 }\n" +
+		"\t}\n" +
+		"\tset EMPTY {\n" +
+		"\t\ttype ipv6_addr\n" +
+		"\t}\n" +
+		"\tset SRC {\n" +
+		"\t\ttype ipv4_addr\n" +
+		"\t\telements = { 10.1.0.1, 10.1.0.2 }\n" +
+		"\t}\n" +
+		"\tchain INPUT {\n" +
+		"\t\ttype filter hook input priority filter; policy accept;\n" +
+		"\t\tct state related,established accept\n" +
+		"\t\tip daddr 10.96.0.0/12 return\n" +
+		"\t}\n" +
+		"\tchain POLICY {\n" +
+		"\t\tip saddr @SRC accept comment \"allow src\"\n" +
+		"\t}\n" +
+		"}\n"
+	assert.Equal(t, expected, buf.String())
+	assert.True(t, table.HasSet("SRC"))
+	assert.False(t, table.HasSet("DST"))
+	assert.True(t, table.HasChain("POLICY"))
+	assert.Equal(t, []string{"INPUT", "POLICY"}, table.Chains())
+}
+
+type fakeNFTables struct {
+	scripts []string
+	err     error
+}
+
+func (f *fakeNFTables) Apply(script []byte) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.scripts = append(f.scripts, string(script))
+	return nil
+}
+
+func (f *fakeNFTables) TableExists(_, _ string) (bool, error) {
+	return len(f.scripts) > 0, nil
+}
+
+func (f *fakeNFTables) DeleteTable(_, _ string) error {
+	f.scripts = nil
+	return nil
+}
+
+func TestNFTablesSharedTable_Apply(t *testing.T) {
+	nft := &fakeNFTables{}
+	shared := NewNFTablesSharedTable(nft, NFTablesFamilyInet, KubeRouterNFTable)
+
+	proxy := NewNFTablesTable(NFTablesFamilyInet, KubeRouterNFTable)
+	proxy.BaseChain("PROXY-INPUT", "filter", "input", "filter").Append("ip daddr @SVC accept")
+	proxy.AddSet("SVC", "ipv4_addr", false, []string{"10.96.0.10"})
+	assert.NoError(t, shared.Apply("proxy", proxy))
+
+	netpol := NewNFTablesTable(NFTablesFamilyInet, KubeRouterNFTable)
+	netpol.BaseChain("NETPOL-INPUT", "filter", "input", "filter").Append("ip saddr @SRC drop")
+	netpol.AddSet("SRC", "ipv4_addr", false, []string{"10.1.0.1"})
+	assert.NoError(t, shared.Apply("netpol", netpol))
+
+	// every apply loads the whole table, with the parts of all owners
+	assert.Len(t, nft.scripts, 2)
+	assert.NotContains(t, nft.scripts[0], "NETPOL-INPUT")
+	expected := "table inet kube-router\n" +
+		"delete table inet kube-router\n" +
+		"table inet kube-router {\n" +
+		"\tset SRC {\n" +
+		"\t\ttype ipv4_addr\n" +
+		"\t\telements = { 10.1.0.1 }\n" +
+		"\t}\n" +
+		"\tset SVC {\n" +
+		"\t\ttype ipv4_addr\n" +
+		"\t\telements = { 10.96.0.10 }\n" +
+		"\t}\n" +
+		"\tchain NETPOL-INPUT {\n" +
+		"\t\ttype filter hook input priority filter; policy accept;\n" +
+		"\t\tip saddr @SRC drop\n" +
+		"\t}\n" +
+		"\tchain PROXY-INPUT {\n" +
+		"\t\ttype filter hook input priority filter; policy accept;\n" +
+		"\t\tip daddr @SVC accept\n" +
+		"\t}\n" +
+		"}\n"
+	assert.Equal(t, expected, nft.scripts[1])
+
+	// replacing a part leaves the part of the other owner alone
+	proxy = NewNFTablesTable(NFTablesFamilyInet, KubeRouterNFTable)
+	proxy.BaseChain("PROXY-INPUT", "filter", "input", "filter")
+	assert.NoError(t, shared.Apply("proxy", proxy))
+	assert.Len(t, nft.scripts, 3)
+	assert.Contains(t, nft.scripts[2], "ip saddr @SRC drop")
+	assert.NotContains(t, nft.scripts[2], "ip daddr @SVC accept")
+
+	// a part that reuses the name of a chain of another owner is rejected, and the previous part is kept
+	conflicting := NewNFTablesTable(NFTablesFamilyInet, KubeRouterNFTable)
+	conflicting.Chain("NETPOL-INPUT")
+	assert.Error(t, shared.Apply("proxy", conflicting))
+	assert.Len(t, nft.scripts, 3)
+
+	// a part that the kernel didn't accept isn't loaded along with the next part of another owner
+	nft.err = errors.New("syntax error")
+	broken := NewNFTablesTable(NFTablesFamilyInet, KubeRouterNFTable)
+	broken.Chain("PROXY-BROKEN").Append("invalid")
+	assert.Error(t, shared.Apply("proxy", broken))
+	nft.err = nil
+	assert.NoError(t, shared.Apply("netpol", netpol))
+	assert.Len(t, nft.scripts, 4)
+	assert.Contains(t, nft.scripts[3], "chain PROXY-INPUT")
+	assert.NotContains(t, nft.scripts[3], "PROXY-BROKEN")
+}
@@ -2,10 +2,13 @@ package utils
 
 import (
 	"fmt"
+	"net"
+	"sort"
 	"strings"
 
 	v1core "k8s.io/api/core/v1"
 	discovery "k8s.io/api/discovery/v1"
+	networkingv1beta1 "k8s.io/api/networking/v1beta1"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/klog/v2"
 )
@@ -140,6 +143,37 @@ func ClusterIPIsNoneOrBlank(clusterIP string) bool {
 	return ClusterIPIsNone(clusterIP) || clusterIP == ""
 }
 
+// ServiceCIDRs returns the CIDRs of all of the ServiceCIDR objects in the given lister sorted and without duplicates.
+// ServiceCIDRs allow the cluster IP ranges of a cluster to be extended at runtime in addition to the ranges that the
+// API server was started with.
+func ServiceCIDRs(lister cache.Indexer) []net.IPNet {
+	if lister == nil {
+		return nil
+	}
+	seen := make(map[string]bool)
+	var cidrs []net.IPNet
+	for _, obj := range lister.List() {
+		serviceCIDR, ok := obj.(*networkingv1beta1.ServiceCIDR)
+		if !ok {
+			continue
+		}
+		for _, cidr := range serviceCIDR.Spec.CIDRs {
+			_, ipNet, err := net.ParseCIDR(strings.TrimSpace(cidr))
+			if err != nil {
+				klog.Warningf("ignoring invalid CIDR %q of ServiceCIDR %s: %v", cidr, serviceCIDR.Name, err)
+				continue
+			}
+			if seen[ipNet.String()] {
+				continue
+			}
+			seen[ipNet.String()] = true
+			cidrs = append(cidrs, *ipNet)
+		}
+	}
+	sort.Slice(cidrs, func(i, j int) bool { return cidrs[i].String() < cidrs[j].String() })
+	return cidrs
+}
+
 func containsOnlyNone(clusterIPs []string) bool {
 	for _, clusterIP := range clusterIPs {
 		if !ClusterIPIsNone(clusterIP) {